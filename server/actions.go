@@ -9,7 +9,11 @@ import (
 	"time"
 
 	"github.com/a-voronkov/mattermost-bugsnag/server/bugsnag"
+	"github.com/a-voronkov/mattermost-bugsnag/server/connection"
 	"github.com/a-voronkov/mattermost-bugsnag/server/formatter"
+	"github.com/a-voronkov/mattermost-bugsnag/server/metrics"
+	"github.com/a-voronkov/mattermost-bugsnag/server/outbound"
+	"github.com/a-voronkov/mattermost-bugsnag/server/store"
 	"github.com/mattermost/mattermost/server/public/model"
 )
 
@@ -25,6 +29,13 @@ func (p *Plugin) handleActions(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "invalid interactive action payload", http.StatusBadRequest)
 		return
 	}
+	// The server sets the header on actions it routes to the plugin. The
+	// body's user ID picks the personal Bugsnag token, so a request must not
+	// act as anyone else.
+	if userID := r.Header.Get("Mattermost-User-ID"); userID == "" || userID != payload.UserId {
+		http.Error(w, "not authorized", http.StatusUnauthorized)
+		return
+	}
 
 	// Extract values from context
 	action, _ := payload.Context["action"].(string)
@@ -40,15 +51,38 @@ func (p *Plugin) handleActions(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	switch action {
+	case actionPostCard:
+		p.handlePostCardAction(w, r, payload)
+		return
+	case actionStartPlaybook:
+		p.handleStartPlaybookAction(w, payload)
+		return
+	case actionCreateTicket:
+		p.handleCreateTicketAction(w, payload)
+		return
+	}
+
 	cfg := p.getConfiguration()
 	mm := newMMClient(p.API, cfg.EnableDebugLog, p.kvNS(), p.botUserID)
 
-	bugsnagClient, err := bugsnag.NewDefaultClient(cfg.BugsnagAPIToken)
+	mappingKey := errorPostKVKey(projectID, errorID)
+	var postMapping ErrorPostMapping
+	found, appErr := mm.LoadJSON(mappingKey, &postMapping)
+	if appErr != nil {
+		p.API.LogDebug("interactive action missing card mapping", "error_id", errorID, "project_id", projectID, "err", appErr.Error())
+	}
+
+	// Act on the connection the card was posted for, with the user's own
+	// Bugsnag token when they linked one so Bugsnag attributes the change.
+	conn, _ := connection.Find(cfg.AllConnections(), postMapping.ConnectionID)
+	token, personalToken := p.actionToken(cfg, payload.UserId, conn)
+	bugsnagClient, err := p.bugsnagClient(token, personalToken)
 	if err != nil {
 		p.API.LogError("bugsnag client init failed", "err", err.Error())
 	}
 	if bugsnagClient == nil {
-		p.API.LogWarn("bugsnag client is nil, API token may be missing or invalid", "token_length", len(cfg.BugsnagAPIToken))
+		p.API.LogWarn("bugsnag client is nil, API token may be missing or invalid", "token_length", len(token))
 	}
 
 	user, appErr := mm.GetUser(payload.UserId)
@@ -57,19 +91,31 @@ func (p *Plugin) handleActions(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if token == "" && cfg.RequireUserTokens {
+		metrics.Actions.Inc(action, metrics.ActionFailure)
+		p.recordAudit(store.AuditRecord{
+			Source:    store.AuditSourceCard,
+			Action:    action,
+			UserID:    user.Id,
+			Username:  user.Username,
+			ProjectID: projectID,
+			ErrorID:   errorID,
+			Response:  "no personal Bugsnag token linked",
+		})
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(model.PostActionIntegrationResponse{
+			EphemeralText: "Link your Bugsnag token with `/bugsnag token set <token>` to act on Bugsnag errors.",
+		})
+		return
+	}
+
 	mappings, err := loadUserMappings(mm)
 	if err != nil {
 		p.API.LogError("failed to load user mappings", "err", err.Error())
 	}
 
-	bugsnagUser, mapped := mapUserToBugsnag(mappings, user)
-
-	mappingKey := errorPostKVKey(projectID, errorID)
-	var postMapping ErrorPostMapping
-	found, appErr := mm.LoadJSON(mappingKey, &postMapping)
-	if appErr != nil {
-		p.API.LogDebug("interactive action missing card mapping", "error_id", errorID, "project_id", projectID, "err", appErr.Error())
-	}
+	bugsnagUser, mapped := mapUserToBugsnag(userMappingsFor(mappings, conn.ID), user)
 
 	mention := fmt.Sprintf("@%s", user.Username)
 	msgParts := []string{fmt.Sprintf("%s requested action \"%s\"", mention, action)}
@@ -85,6 +131,9 @@ func (p *Plugin) handleActions(w http.ResponseWriter, r *http.Request) {
 	if errorURL != "" {
 		msgParts = append(msgParts, fmt.Sprintf("source: %s", errorURL))
 	}
+	if personalToken {
+		msgParts = append(msgParts, "using their own Bugsnag token")
+	}
 
 	ctx, cancel := context.WithTimeout(r.Context(), 8*time.Second)
 	defer cancel()
@@ -206,8 +255,20 @@ func (p *Plugin) handleActions(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Update the card if action was successful
+	var card *formatter.ErrorData
+	var previousStatus string
 	if actionSuccess && found {
+		// Without the templates the card falls back to the built-in layout;
+		// the status change still has to show.
+		templates, err := loadCardTemplates(mm)
+		if err != nil {
+			mm.LogDebug("failed to load card templates", "err", err.Error())
+		}
 		if post, appErr := mm.GetPost(postMapping.PostID); appErr == nil {
+			if data, ok := formatter.CardData(post); ok {
+				card = &data
+			}
+			previousStatus = formatter.CardStatus(post)
 			mapping := formatter.ErrorPostMapping{
 				ChannelID: postMapping.ChannelID,
 				ProjectID: projectID,
@@ -219,6 +280,7 @@ func (p *Plugin) handleActions(w http.ResponseWriter, r *http.Request) {
 				Mapping:          mapping,
 				ErrorURL:         errorURL,
 				AssignedUsername: assignedUsername,
+				Templates:        templates,
 			})
 			if _, appErr := mm.UpdatePost(updatedPost); appErr != nil {
 				mm.LogDebug("failed to update card", "err", appErr.Error())
@@ -226,10 +288,55 @@ func (p *Plugin) handleActions(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	if actionSuccess && found {
+		postMapping = p.acknowledgeEscalation(mm, postMapping, "acknowledged by @"+user.Username)
+	}
+
+	// Remember who resolved the error so a regression can mention them.
+	if actionSuccess && found && newStatus == "fixed" {
+		postMapping.ResolvedBy = user.Id
+		if err := mm.StoreJSON(mappingKey, postMapping); err != nil {
+			mm.LogDebug("failed to record resolver", "err", err.Error())
+		}
+		postMapping = p.errorStatusChanged(mm, postMapping, newStatus)
+	}
+
 	note := strings.Join(msgParts, " · ")
 
 	p.API.LogInfo("action completed", "action", action, "success", actionSuccess, "response", note)
 
+	outcome := metrics.ActionFailure
+	if actionSuccess {
+		outcome = metrics.ActionSuccess
+	}
+	metrics.Actions.Inc(action, outcome)
+
+	// Every case above appends its Bugsnag outcome last.
+	p.recordAudit(store.AuditRecord{
+		Source:    store.AuditSourceCard,
+		Action:    action,
+		UserID:    user.Id,
+		Username:  user.Username,
+		ProjectID: projectID,
+		ErrorID:   errorID,
+		ToStatus:  newStatus,
+		Success:   actionSuccess,
+		Response:  msgParts[len(msgParts)-1],
+	})
+
+	if actionSuccess {
+		if !found {
+			postMapping = ErrorPostMapping{ProjectID: projectID, ErrorID: errorID}
+		}
+		actor := &outbound.Actor{UserID: user.Id, Username: user.Username}
+		switch {
+		case newStatus != "" && newStatus != previousStatus:
+			p.emitEvent(mm, outbound.Event{Type: outbound.EventStatusChanged, Source: outbound.SourceCard, Status: newStatus, PreviousStatus: previousStatus, Actor: actor}, postMapping, card)
+		case assignedUsername != "":
+			p.emitEvent(mm, outbound.Event{Type: outbound.EventAssigned, Source: outbound.SourceCard, Assignee: assignedUsername, Actor: actor}, postMapping, card)
+		}
+	}
+
 	// Post human-readable reply in thread
 	if found && replyMessage != "" {
 		if _, appErr := mm.CreateReply(postMapping.ChannelID, postMapping.PostID, replyMessage); appErr != nil {
@@ -7,6 +7,8 @@ import (
 	"net/http/httptest"
 	"testing"
 
+	"github.com/a-voronkov/mattermost-bugsnag/server/formatter"
+	"github.com/a-voronkov/mattermost-bugsnag/server/store"
 	"github.com/mattermost/mattermost/server/public/model"
 	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
 	"github.com/stretchr/testify/mock"
@@ -43,6 +45,25 @@ func TestHandleActionsInvalidPayload(t *testing.T) {
 	}
 }
 
+func TestHandleActionsRejectsForgedUser(t *testing.T) {
+	api := &plugintest.API{}
+	p := &Plugin{}
+	p.SetAPI(api)
+
+	body, _ := json.Marshal(model.PostActionIntegrationRequest{
+		UserId:  "victim",
+		Context: map[string]any{"action": "resolve", "error_id": "err-123", "project_id": "proj-1"},
+	})
+	for _, userID := range []string{"", "attacker"} {
+		rr := httptest.NewRecorder()
+		p.handleActions(rr, actionRequest(body, userID))
+
+		if rr.Code != http.StatusUnauthorized {
+			t.Errorf("header user %q: expected status %d, got %d", userID, http.StatusUnauthorized, rr.Code)
+		}
+	}
+}
+
 func TestHandleActionsMissingAction(t *testing.T) {
 	api := &plugintest.API{}
 	api.On("LogInfo", "received interactive action", "user_id", "user-123", "action", "", "error_id", "", "project_id", "").Return()
@@ -56,7 +77,7 @@ func TestHandleActionsMissingAction(t *testing.T) {
 	}
 	body, _ := json.Marshal(payload)
 
-	req := httptest.NewRequest(http.MethodPost, "/actions", bytes.NewReader(body))
+	req := actionRequest(body, "user-123")
 	rr := httptest.NewRecorder()
 
 	p.handleActions(rr, req)
@@ -90,7 +111,7 @@ func TestHandleActionsInvalidUser(t *testing.T) {
 	}
 	body, _ := json.Marshal(payload)
 
-	req := httptest.NewRequest(http.MethodPost, "/actions", bytes.NewReader(body))
+	req := actionRequest(body, "unknown-user")
 	rr := httptest.NewRecorder()
 
 	p.handleActions(rr, req)
@@ -114,6 +135,15 @@ func TestHandleActionsResolveNoClient(t *testing.T) {
 	// Return nil for error post mapping (not found)
 	api.On("KVGet", mock.Anything).Return(nil, (*model.AppError)(nil)).Maybe()
 	api.On("GetUser", userID).Return(&model.User{Id: userID, Username: "testuser", Email: "test@example.com"}, (*model.AppError)(nil))
+	api.On("KVSet", pluginID+":"+KVKeyAuditLog, mock.MatchedBy(func(data []byte) bool {
+		var records []store.AuditRecord
+		if err := json.Unmarshal(data, &records); err != nil || len(records) != 1 {
+			return false
+		}
+		r := records[0]
+		return r.Source == store.AuditSourceCard && r.Action == "resolve" && r.Username == "testuser" &&
+			r.ErrorID == "err-123" && !r.Success && r.Response == "Bugsnag client unavailable, resolve skipped"
+	})).Return((*model.AppError)(nil)).Once()
 
 	p := &Plugin{}
 	p.SetAPI(api)
@@ -130,7 +160,7 @@ func TestHandleActionsResolveNoClient(t *testing.T) {
 	}
 	body, _ := json.Marshal(payload)
 
-	req := httptest.NewRequest(http.MethodPost, "/actions", bytes.NewReader(body))
+	req := actionRequest(body, userID)
 	rr := httptest.NewRecorder()
 
 	p.handleActions(rr, req)
@@ -147,6 +177,8 @@ func TestHandleActionsResolveNoClient(t *testing.T) {
 	if resp["text"] == "" {
 		t.Fatal("expected non-empty text in response")
 	}
+
+	api.AssertCalled(t, "KVSet", pluginID+":"+KVKeyAuditLog, mock.Anything)
 }
 
 func TestHandleActionsUnsupportedAction(t *testing.T) {
@@ -173,7 +205,7 @@ func TestHandleActionsUnsupportedAction(t *testing.T) {
 	}
 	body, _ := json.Marshal(payload)
 
-	req := httptest.NewRequest(http.MethodPost, "/actions", bytes.NewReader(body))
+	req := actionRequest(body, userID)
 	rr := httptest.NewRecorder()
 
 	p.handleActions(rr, req)
@@ -182,3 +214,76 @@ func TestHandleActionsUnsupportedAction(t *testing.T) {
 		t.Fatalf("expected status %d, got %d", http.StatusBadRequest, rr.Code)
 	}
 }
+
+func TestHandleActionsUpdatesCardWithoutTemplates(t *testing.T) {
+	clients := newBugsnagServer(t, map[string]string{"/projects/proj-1/errors/err-1": `{}`})
+
+	card := formatter.BuildErrorPost(formatter.ErrorData{ID: "err-1", ProjectID: "proj-1", ExceptionClass: "NoMethodError", Status: "open"},
+		formatter.ErrorPostMapping{ChannelID: "chan-1", ProjectID: "proj-1", ErrorID: "err-1"})
+	card.Id = "post-1"
+	stored, _ := json.Marshal(ErrorPostMapping{ProjectID: "proj-1", ErrorID: "err-1", ChannelID: "chan-1", PostID: "post-1"})
+
+	api := &plugintest.API{}
+	api.On("KVGet", pluginID+":"+errorPostKVKey("proj-1", "err-1")).Return(stored, nil)
+	api.On("KVGet", pluginID+":"+KVKeyCardTemplates).Return(nil, model.NewAppError("KVGet", "store_error", nil, "", http.StatusInternalServerError))
+	api.On("KVGet", mock.Anything).Return(nil, nil)
+	api.On("KVSet", mock.Anything, mock.Anything).Return(nil)
+	api.On("GetUser", "user-1").Return(&model.User{Id: "user-1", Username: "jane"}, nil)
+	api.On("GetPost", "post-1").Return(card, nil)
+	api.On("GetConfig").Return(&model.Config{}).Maybe()
+	api.On("UpdatePost", mock.MatchedBy(func(post *model.Post) bool {
+		return formatter.CardStatus(post) == "ignored"
+	})).Return(&model.Post{Id: "post-1"}, nil).Once()
+	api.On("CreatePost", mock.Anything).Return(&model.Post{Id: "reply-1"}, nil).Once()
+	api.On("LogInfo", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return().Maybe()
+
+	p := &Plugin{}
+	p.SetAPI(api)
+	p.kvNamespace = pluginID
+	p.configuration.Store(&Configuration{BugsnagAPIToken: "shared-token"})
+	p.clients.Store(clients)
+
+	body, _ := json.Marshal(model.PostActionIntegrationRequest{
+		UserId:  "user-1",
+		Context: map[string]any{"action": "ignore", "error_id": "err-1", "project_id": "proj-1"},
+	})
+	rr := httptest.NewRecorder()
+	p.handleActions(rr, actionRequest(body, "user-1"))
+
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("unexpected status %d: %s", rr.Code, rr.Body.String())
+	}
+	api.AssertExpectations(t)
+}
+
+// cardAPI mocks card post-1 for err-1 in chan-1, tracked by mapping and
+// routed by rule. The card offers every action.
+func cardAPI(t *testing.T, mapping ErrorPostMapping, rule ChannelRule) *plugintest.API {
+	t.Helper()
+
+	siteURL := "https://mm.example.com"
+	card := formatter.BuildErrorPost(formatter.ErrorData{ID: "err-1", ProjectID: "proj-1", ExceptionClass: "NoMethodError", Message: "undefined method `name' for nil",
+		Severity: "error", Status: "open", ErrorURL: "https://app.bugsnag.com/acme/app/errors/err-1", TicketAvailable: true, PlaybookAvailable: true},
+		formatter.ErrorPostMapping{ChannelID: "chan-1", ProjectID: "proj-1", ErrorID: "err-1"})
+	card.Id = "post-1"
+	stored, _ := json.Marshal(mapping)
+	rules, _ := json.Marshal([]ChannelRule{rule})
+
+	api := &plugintest.API{}
+	api.On("GetConfig").Return(&model.Config{ServiceSettings: model.ServiceSettings{SiteURL: &siteURL}}).Maybe()
+	api.On("KVGet", pluginID+":"+errorPostKVKey("proj-1", "err-1")).Return(stored, nil).Maybe()
+	api.On("KVGet", pluginID+":"+KVKeyProjectChannelMappings).Return(rules, nil).Maybe()
+	api.On("KVGet", pluginID+":"+KVKeyCardTemplates).Return(nil, nil).Maybe()
+	api.On("GetPost", "post-1").Return(card, nil)
+	api.On("LogInfo", "playbook run started", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return().Maybe()
+	api.On("LogInfo", "received interactive action", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return().Maybe()
+	return api
+}
+
+// actionRequest returns an /actions request as the server routes it for
+// userID.
+func actionRequest(body []byte, userID string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/actions", bytes.NewReader(body))
+	req.Header.Set("Mattermost-User-ID", userID)
+	return req
+}
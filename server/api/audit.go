@@ -0,0 +1,109 @@
+package api
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/a-voronkov/mattermost-bugsnag/server/store"
+)
+
+// auditCSVHeader lists the columns of the CSV export, in order.
+var auditCSVHeader = []string{"time", "source", "action", "user_id", "username", "project_id", "error_id", "from_status", "to_status", "success", "response"}
+
+func (r *Router) handleAudit(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	if r.config.KVStore == nil {
+		writeError(w, http.StatusInternalServerError, "storage not configured")
+		return
+	}
+
+	query := req.URL.Query()
+	filter, err := parseAuditFilter(query)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	records, err := store.New(r.config.KVStore).ListAudit(filter)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to load audit log: "+err.Error())
+		return
+	}
+
+	switch format := strings.ToLower(strings.TrimSpace(query.Get("format"))); format {
+	case "", "json":
+		writeJSON(w, http.StatusOK, map[string]any{
+			"records": records,
+		})
+	case "csv":
+		writeAuditCSV(w, records)
+	default:
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("unsupported format %q, use json or csv", format))
+	}
+}
+
+func parseAuditFilter(query url.Values) (store.AuditFilter, error) {
+	filter := store.AuditFilter{
+		ProjectID: strings.TrimSpace(query.Get("project_id")),
+		ErrorID:   strings.TrimSpace(query.Get("error_id")),
+		UserID:    strings.TrimSpace(query.Get("user_id")),
+		Action:    strings.TrimSpace(query.Get("action")),
+		Source:    strings.TrimSpace(query.Get("source")),
+	}
+
+	for name, target := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		value := strings.TrimSpace(query.Get(name))
+		if value == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return filter, fmt.Errorf("%s must be an RFC 3339 timestamp", name)
+		}
+		*target = parsed
+	}
+
+	if value := strings.TrimSpace(query.Get("limit")); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 0 {
+			return filter, fmt.Errorf("limit must be a non-negative integer")
+		}
+		filter.Limit = limit
+	}
+
+	return filter, nil
+}
+
+func writeAuditCSV(w http.ResponseWriter, records []store.AuditRecord) {
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", `attachment; filename="bugsnag-audit.csv"`)
+	w.WriteHeader(http.StatusOK)
+
+	cw := csv.NewWriter(w)
+	_ = cw.Write(auditCSVHeader)
+	for _, record := range records {
+		_ = cw.Write([]string{
+			record.Time.UTC().Format(time.RFC3339),
+			record.Source,
+			record.Action,
+			record.UserID,
+			record.Username,
+			record.ProjectID,
+			record.ErrorID,
+			record.FromStatus,
+			record.ToStatus,
+			strconv.FormatBool(record.Success),
+			record.Response,
+		})
+	}
+	cw.Flush()
+}
@@ -0,0 +1,95 @@
+package api
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/a-voronkov/mattermost-bugsnag/server/store"
+)
+
+func newAuditRouter(t *testing.T) *Router {
+	t.Helper()
+
+	kv := newMemoryKVStore()
+	s := store.New(kv)
+	base := time.Date(2025, 11, 28, 10, 0, 0, 0, time.UTC)
+	for _, record := range []store.AuditRecord{
+		{Time: base, Source: store.AuditSourceCard, Action: "resolve", Username: "alice", ProjectID: "p1", ErrorID: "e1", ToStatus: "fixed", Success: true, Response: "status set to fixed in Bugsnag"},
+		{Time: base.Add(time.Hour), Source: store.AuditSourceSync, Action: "status_change", ProjectID: "p2", ErrorID: "e2", FromStatus: "open", ToStatus: "ignored", Success: true},
+	} {
+		if err := s.AppendAudit(record); err != nil {
+			t.Fatalf("AppendAudit() error = %v", err)
+		}
+	}
+
+	return newTestRouter(Config{KVStore: kv})
+}
+
+func TestAuditJSON(t *testing.T) {
+	router := newAuditRouter(t)
+
+	req := adminRequest(http.MethodGet, "/api/v1/audit?project_id=p1", nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	var resp struct {
+		Records []store.AuditRecord `json:"records"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if len(resp.Records) != 1 || resp.Records[0].Username != "alice" {
+		t.Fatalf("expected alice's record only, got %+v", resp.Records)
+	}
+}
+
+func TestAuditCSV(t *testing.T) {
+	router := newAuditRouter(t)
+
+	req := adminRequest(http.MethodGet, "/api/v1/audit?format=csv&since=2025-11-28T10:30:00Z", nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != "text/csv" {
+		t.Errorf("Content-Type = %q, want text/csv", ct)
+	}
+
+	rows, err := csv.NewReader(rr.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("parse CSV: %v", err)
+	}
+	want := []string{"2025-11-28T11:00:00Z", "sync", "status_change", "", "", "p2", "e2", "open", "ignored", "true", ""}
+	if len(rows) != 2 || len(rows[1]) != len(want) {
+		t.Fatalf("expected header and one row, got %v", rows)
+	}
+	for i := range want {
+		if rows[1][i] != want[i] {
+			t.Errorf("column %s = %q, want %q", rows[0][i], rows[1][i], want[i])
+		}
+	}
+}
+
+func TestAuditRejectsInvalidFilters(t *testing.T) {
+	router := newAuditRouter(t)
+
+	for _, query := range []string{"since=yesterday", "limit=-1", "format=xml"} {
+		req := adminRequest(http.MethodGet, "/api/v1/audit?"+query, nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status %d, got %d", query, http.StatusBadRequest, rr.Code)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/a-voronkov/mattermost-bugsnag/server/bugsnag"
+)
+
+// Backfill modes: post cards for the imported errors, or seed them as tracked
+// errors for the status sync without posting.
+const (
+	BackfillModePost = "post"
+	BackfillModeSeed = "seed"
+)
+
+// DefaultBackfillLimit is how many errors a backfill imports when no limit is
+// given.
+const DefaultBackfillLimit = 10
+
+// BackfillOptions controls how a channel rule imports its project's open errors.
+type BackfillOptions struct {
+	// Mode is BackfillModePost (the default) or BackfillModeSeed.
+	Mode string `json:"mode,omitempty"`
+	// Limit caps how many errors, most events first, are imported.
+	Limit int `json:"limit,omitempty"`
+}
+
+// Normalize applies the defaults and rejects unknown modes and limits.
+func (o BackfillOptions) Normalize() (BackfillOptions, error) {
+	switch o.Mode {
+	case "":
+		o.Mode = BackfillModePost
+	case BackfillModePost, BackfillModeSeed:
+	default:
+		return o, fmt.Errorf("unknown backfill mode %q: use %s or %s", o.Mode, BackfillModePost, BackfillModeSeed)
+	}
+
+	if o.Limit == 0 {
+		o.Limit = DefaultBackfillLimit
+	}
+	if o.Limit < 1 || o.Limit > bugsnag.MaxErrorLimit {
+		return o, fmt.Errorf("backfill limit must be between 1 and %d", bugsnag.MaxErrorLimit)
+	}
+
+	return o, nil
+}
+
+// BackfillRequest asks to backfill channel rules. When it accompanies a save
+// of the channel rules and RuleIDs is empty, the rules the save creates are
+// backfilled.
+type BackfillRequest struct {
+	BackfillOptions
+	RuleIDs []string `json:"rule_ids,omitempty"`
+}
+
+// BackfillResult reports what a backfill imported for one rule.
+type BackfillResult struct {
+	RuleID string `json:"rule_id"`
+	Mode   string `json:"mode"`
+	// Found counts the open errors matching the rule's filters.
+	Found    int `json:"found"`
+	Imported int `json:"imported"`
+	// Skipped counts matching errors that already have a card.
+	Skipped int    `json:"skipped"`
+	Error   string `json:"error,omitempty"`
+}
+
+func (r *Router) handleBackfill(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	var payload BackfillRequest
+	if err := json.NewDecoder(req.Body).Decode(&payload); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON payload: "+err.Error())
+		return
+	}
+	if len(payload.RuleIDs) == 0 {
+		writeError(w, http.StatusBadRequest, "rule_ids is required")
+		return
+	}
+
+	results, status, err := r.backfill(payload.BackfillOptions, payload.RuleIDs)
+	if err != nil {
+		writeError(w, status, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"backfill": results,
+	})
+}
+
+// backfill runs the configured backfill for each rule. A rule that fails is
+// reported in its result rather than failing the others.
+func (r *Router) backfill(options BackfillOptions, ruleIDs []string) ([]BackfillResult, int, error) {
+	if r.config.Backfill == nil {
+		return nil, http.StatusNotImplemented, fmt.Errorf("backfill is not available")
+	}
+
+	options, err := options.Normalize()
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+
+	results := make([]BackfillResult, 0, len(ruleIDs))
+	for _, id := range ruleIDs {
+		result, err := r.config.Backfill(id, options)
+		result.RuleID = id
+		result.Mode = options.Mode
+		if err != nil {
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+	}
+
+	return results, http.StatusOK, nil
+}
@@ -0,0 +1,83 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBackfill(t *testing.T) {
+	kv := newMemoryKVStore()
+	existing, _ := json.Marshal([]ChannelRule{{ID: "r1", ProjectID: "p1", ChannelID: "c1"}})
+	_ = kv.Set(kvKeyChannelRules, existing)
+
+	var calls []string
+	router := newTestRouter(Config{
+		KVStore: kv,
+		Backfill: func(ruleID string, options BackfillOptions) (BackfillResult, error) {
+			calls = append(calls, ruleID+":"+options.Mode)
+			if ruleID == "broken" {
+				return BackfillResult{}, errors.New("no Bugsnag API token configured")
+			}
+			return BackfillResult{Found: 3, Imported: options.Limit}, nil
+		},
+	})
+
+	serve := func(path, body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, adminRequest(http.MethodPost, path, strings.NewReader(body)))
+		return rr
+	}
+	decode := func(rr *httptest.ResponseRecorder) []BackfillResult {
+		t.Helper()
+		var resp struct {
+			Backfill []BackfillResult `json:"backfill"`
+		}
+		if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+			t.Fatalf("decode response: %v", err)
+		}
+		return resp.Backfill
+	}
+
+	rr := serve("/api/v1/channel-rules/backfill", `{"rule_ids":["r1","broken"],"mode":"seed","limit":2}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	results := decode(rr)
+	if len(results) != 2 || results[0].Imported != 2 || results[0].Mode != BackfillModeSeed || results[1].Error == "" {
+		t.Fatalf("unexpected results %+v", results)
+	}
+
+	// Saving rules with a backfill imports errors for the new rules only.
+	calls = nil
+	rr = serve("/api/v1/channel-rules", `{"rules":[{"id":"r1","project_id":"p1","channel_id":"c1"},{"id":"r2","project_id":"p2","channel_id":"c2"}],"backfill":{}}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	results = decode(rr)
+	if len(results) != 1 || results[0].RuleID != "r2" || results[0].Imported != DefaultBackfillLimit {
+		t.Fatalf("unexpected results %+v", results)
+	}
+	if len(calls) != 1 || calls[0] != "r2:"+BackfillModePost {
+		t.Fatalf("unexpected backfill calls %v", calls)
+	}
+
+	for _, body := range []string{`{}`, `{"rule_ids":["r1"],"mode":"replay"}`, `{"rule_ids":["r1"],"limit":100}`} {
+		if rr := serve("/api/v1/channel-rules/backfill", body); rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status %d, got %d", body, http.StatusBadRequest, rr.Code)
+		}
+	}
+}
+
+func TestBackfillNotConfigured(t *testing.T) {
+	router := newTestRouter(Config{KVStore: newMemoryKVStore()})
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, adminRequest(http.MethodPost, "/api/v1/channel-rules/backfill", strings.NewReader(`{"rule_ids":["r1"]}`)))
+	if rr.Code != http.StatusNotImplemented {
+		t.Fatalf("expected status %d, got %d", http.StatusNotImplemented, rr.Code)
+	}
+}
@@ -0,0 +1,211 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/a-voronkov/mattermost-bugsnag/server/bugsnag"
+	"github.com/a-voronkov/mattermost-bugsnag/server/connection"
+	"github.com/a-voronkov/mattermost-bugsnag/server/kvkeys"
+	"github.com/a-voronkov/mattermost-bugsnag/server/tracker"
+)
+
+func newConnectionsRouter(connections ...connection.Connection) *Router {
+	return newTestRouter(Config{
+		KVStore:     newMemoryKVStore(),
+		Connections: func() []connection.Connection { return connections },
+	})
+}
+
+func TestConnectionsHidesTokens(t *testing.T) {
+	router := newConnectionsRouter(
+		connection.Connection{ID: connection.DefaultID, Name: "Default", APIToken: "t1"},
+		connection.Connection{ID: "mobile", APIToken: "t2", OrganizationID: "org-2", WebhookToken: "w2"},
+	)
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, adminRequest(http.MethodGet, "/api/v1/connections", nil))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	if body := rr.Body.String(); strings.Contains(body, "t2") || strings.Contains(body, "w2") {
+		t.Fatalf("expected credentials to be hidden, got %s", body)
+	}
+
+	var resp struct {
+		Connections []connection.Connection `json:"connections"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if len(resp.Connections) != 2 || resp.Connections[1].Name != "mobile" || resp.Connections[1].OrganizationID != "org-2" {
+		t.Errorf("unexpected connections %+v", resp.Connections)
+	}
+}
+
+func TestConnectionSelection(t *testing.T) {
+	tests := []struct {
+		name        string
+		connections []connection.Connection
+		path        string
+		want        int
+	}{
+		{"no connections", nil, "/api/v1/projects", http.StatusUnauthorized},
+		{"unknown connection", []connection.Connection{{ID: "mobile", APIToken: "t"}}, "/api/v1/organizations?connection_id=web", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			newConnectionsRouter(tt.connections...).ServeHTTP(rr, adminRequest(http.MethodGet, tt.path, nil))
+			if rr.Code != tt.want {
+				t.Errorf("expected status %d, got %d: %s", tt.want, rr.Code, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestSaveChannelRulesRejectsUnknownConnection(t *testing.T) {
+	router := newConnectionsRouter(connection.Connection{ID: "mobile", APIToken: "t"})
+
+	for body, want := range map[string]int{
+		`{"rules":[{"id":"r1","connection_id":"mobile","project_id":"p1","channel_id":"c1"}]}`: http.StatusOK,
+		`{"rules":[{"id":"r1","connection_id":"web","project_id":"p1","channel_id":"c1"}]}`:    http.StatusBadRequest,
+		`{"mappings":[{"connection_id":"web","mattermost_user_id":"u1"}]}`:                     http.StatusBadRequest,
+	} {
+		path := "/api/v1/channel-rules"
+		if strings.Contains(body, "mappings") {
+			path = "/api/v1/user-mappings"
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, adminRequest(http.MethodPost, path, bytes.NewReader([]byte(body))))
+		if rr.Code != want {
+			t.Errorf("POST %s %s: expected status %d, got %d: %s", path, body, want, rr.Code, rr.Body.String())
+		}
+	}
+}
+
+func TestSaveChannelRulesValidatesEscalation(t *testing.T) {
+	router := newConnectionsRouter()
+
+	for escalation, want := range map[string]int{
+		`{"levels":[{"after_minutes":15,"mentions":["oncall"]},{"after_minutes":45,"direct_messages":["jane"]}]}`: http.StatusOK,
+		`{"levels":[]}`: http.StatusBadRequest,
+		`{"levels":[{"after_minutes":30,"mentions":["oncall"]},{"after_minutes":15,"mentions":["cto"]}]}`: http.StatusBadRequest,
+		`{"levels":[{"after_minutes":15}]}`: http.StatusBadRequest,
+	} {
+		body := `{"rules":[{"id":"r1","project_id":"p1","channel_id":"c1","escalation":` + escalation + `}]}`
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, adminRequest(http.MethodPost, "/api/v1/channel-rules", bytes.NewReader([]byte(body))))
+		if rr.Code != want {
+			t.Errorf("escalation %s: expected status %d, got %d: %s", escalation, want, rr.Code, rr.Body.String())
+		}
+	}
+}
+
+func TestSaveChannelRulesNormalizesPlaybookSeverities(t *testing.T) {
+	kv := newMemoryKVStore()
+	router := newTestRouter(Config{KVStore: kv, Connections: func() []connection.Connection { return nil }})
+
+	save := func(bySeverity string) *httptest.ResponseRecorder {
+		body := `{"rules":[{"id":"r1","project_id":"p1","channel_id":"c1","playbook":{"by_severity":` + bySeverity + `}}]}`
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, adminRequest(http.MethodPost, "/api/v1/channel-rules", bytes.NewReader([]byte(body))))
+		return rr
+	}
+
+	if rr := save(`{"Error":"pb-1","error":"pb-2"}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected case duplicates to be rejected, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := save(`{" Warning ":"pb-1"}`); rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	if stored := string(kv.data[kvKeyChannelRules]); !strings.Contains(stored, `"by_severity":{"warning":"pb-1"}`) {
+		t.Errorf("expected normalized severities, got %s", stored)
+	}
+}
+
+func TestSaveChannelRulesValidatesResurface(t *testing.T) {
+	router := newConnectionsRouter()
+
+	for resurface, want := range map[string]int{
+		"":          http.StatusOK,
+		"new_card":  http.StatusOK,
+		"broadcast": http.StatusOK,
+		"newcard":   http.StatusBadRequest,
+	} {
+		body := `{"rules":[{"id":"r1","project_id":"p1","channel_id":"c1","resurface":"` + resurface + `"}]}`
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, adminRequest(http.MethodPost, "/api/v1/channel-rules", bytes.NewReader([]byte(body))))
+		if rr.Code != want {
+			t.Errorf("resurface %q: expected status %d, got %d: %s", resurface, want, rr.Code, rr.Body.String())
+		}
+	}
+}
+
+func TestSaveChannelRulesValidatesOptions(t *testing.T) {
+	kv := newMemoryKVStore()
+	if err := kv.Set(kvkeys.CardTemplates, []byte(`[{"id":"compact","name":"Compact"}]`)); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	router := newTestRouter(Config{
+		KVStore:       kv,
+		Connections:   func() []connection.Connection { return nil },
+		IssueTrackers: func() []tracker.Tracker { return []tracker.Tracker{{ID: "jira"}} },
+	})
+
+	for options, want := range map[string]int{
+		`"show_context":"thread"`:                    http.StatusOK,
+		`"show_context":"inline"`:                    http.StatusBadRequest,
+		`"redact_fields":["url_query","user_email"]`: http.StatusOK,
+		`"redact_fields":["password"]`:               http.StatusBadRequest,
+		`"template_id":"compact"`:                    http.StatusOK,
+		`"template_id":"missing"`:                    http.StatusBadRequest,
+		`"issue_tracker":"jira"`:                     http.StatusOK,
+		`"issue_tracker":"github"`:                   http.StatusBadRequest,
+	} {
+		body := `{"rules":[{"id":"r1","project_id":"p1","channel_id":"c1",` + options + `}]}`
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, adminRequest(http.MethodPost, "/api/v1/channel-rules", bytes.NewReader([]byte(body))))
+		if rr.Code != want {
+			t.Errorf("%s: expected status %d, got %d: %s", options, want, rr.Code, rr.Body.String())
+		}
+	}
+}
+
+func TestCollaboratorsCachedUntilRefresh(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		_, _ = w.Write([]byte(`[{"id":"user-1","name":"Jane","email":"jane@example.com"}]`))
+	}))
+	t.Cleanup(server.Close)
+
+	clients, err := bugsnag.NewFactory(bugsnag.Settings{APIURL: server.URL})
+	if err != nil {
+		t.Fatalf("NewFactory() error = %v", err)
+	}
+	router := newTestRouter(Config{
+		Connections: func() []connection.Connection {
+			return []connection.Connection{{ID: connection.DefaultID, APIToken: "t1", OrganizationID: "org-1"}}
+		},
+		Clients: func() *bugsnag.Factory { return clients },
+	})
+
+	for _, path := range []string{"/api/v1/collaborators", "/api/v1/collaborators", "/api/v1/collaborators?refresh=true"} {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, adminRequest(http.MethodGet, path, nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("%s: expected status %d, got %d: %s", path, http.StatusOK, rr.Code, rr.Body.String())
+		}
+	}
+
+	if requests != 2 {
+		t.Errorf("expected one cached and one refreshed request to Bugsnag, got %d", requests)
+	}
+}
@@ -0,0 +1,92 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/a-voronkov/mattermost-bugsnag/server/store"
+)
+
+// handleDeliveries serves the webhook delivery log:
+//
+//	GET  /deliveries              list, newest first
+//	GET  /deliveries/{id}         one delivery
+//	POST /deliveries/{id}/replay  run a delivery through the pipeline again
+func (r *Router) handleDeliveries(w http.ResponseWriter, req *http.Request, rest string) {
+	if r.config.KVStore == nil {
+		writeError(w, http.StatusInternalServerError, "storage not configured")
+		return
+	}
+
+	parts := strings.Split(strings.Trim(rest, "/"), "/")
+	switch {
+	case parts[0] == "":
+		r.listDeliveries(w, req)
+	case len(parts) == 1:
+		r.getDelivery(w, req, parts[0])
+	case len(parts) == 2 && parts[1] == "replay":
+		r.replayDelivery(w, req, parts[0])
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+func (r *Router) listDeliveries(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	deliveries, err := store.New(r.config.KVStore).ListDeliveries()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to load delivery log: "+err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"deliveries": deliveries,
+	})
+}
+
+func (r *Router) getDelivery(w http.ResponseWriter, req *http.Request, id string) {
+	if req.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	delivery, err := store.New(r.config.KVStore).GetDelivery(id)
+	if errors.Is(err, store.ErrDeliveryNotFound) {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	} else if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to load delivery log: "+err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, delivery)
+}
+
+func (r *Router) replayDelivery(w http.ResponseWriter, req *http.Request, id string) {
+	if req.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	if r.config.ReplayDelivery == nil {
+		writeError(w, http.StatusNotImplemented, "replay not available")
+		return
+	}
+
+	replay, err := r.config.ReplayDelivery(id)
+	switch {
+	case errors.Is(err, store.ErrDeliveryNotFound):
+		writeError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, store.ErrDeliveryTruncated):
+		writeError(w, http.StatusConflict, err.Error())
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, "replay failed: "+err.Error())
+	default:
+		writeJSON(w, http.StatusOK, replay)
+	}
+}
@@ -0,0 +1,84 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/a-voronkov/mattermost-bugsnag/server/store"
+)
+
+func TestDeliveries(t *testing.T) {
+	kv := newMemoryKVStore()
+	s := store.New(kv)
+	for _, delivery := range []store.Delivery{
+		{ID: "d1", Validation: "ok", Outcome: "processed", MatchedRules: []string{"r1"}, Body: `{}`},
+		{ID: "d2", Validation: "invalid webhook token", Outcome: "rejected"},
+		{ID: "d3", Validation: "ok", Outcome: "unmatched", Truncated: true},
+	} {
+		if err := s.AppendDelivery(delivery, 10); err != nil {
+			t.Fatalf("AppendDelivery() error = %v", err)
+		}
+	}
+
+	var replayed []string
+	router := newTestRouter(Config{
+		KVStore: kv,
+		ReplayDelivery: func(id string) (store.Delivery, error) {
+			original, err := s.GetDelivery(id)
+			if err != nil {
+				return store.Delivery{}, err
+			}
+			if original.Truncated {
+				return store.Delivery{}, store.ErrDeliveryTruncated
+			}
+			replayed = append(replayed, id)
+			return store.Delivery{ID: "replay", ReplayOf: id, Outcome: "processed"}, nil
+		},
+	})
+
+	serve := func(method, path string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, adminRequest(method, path, nil))
+		return rr
+	}
+
+	rr := serve(http.MethodGet, "/api/v1/deliveries")
+	var list struct {
+		Deliveries []store.Delivery `json:"deliveries"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&list); err != nil {
+		t.Fatalf("decode list: %v", err)
+	}
+	if len(list.Deliveries) != 3 || list.Deliveries[0].ID != "d3" {
+		t.Fatalf("expected 3 deliveries newest first, got %+v", list.Deliveries)
+	}
+
+	rr = serve(http.MethodGet, "/api/v1/deliveries/d2")
+	var delivery store.Delivery
+	if err := json.NewDecoder(rr.Body).Decode(&delivery); err != nil || delivery.Validation != "invalid webhook token" {
+		t.Fatalf("unexpected delivery %+v (%v)", delivery, err)
+	}
+
+	tests := []struct {
+		method, path string
+		want         int
+	}{
+		{http.MethodGet, "/api/v1/deliveries/missing", http.StatusNotFound},
+		{http.MethodPost, "/api/v1/deliveries/d1/replay", http.StatusOK},
+		{http.MethodGet, "/api/v1/deliveries/d1/replay", http.StatusMethodNotAllowed},
+		{http.MethodPost, "/api/v1/deliveries/d3/replay", http.StatusConflict},
+		{http.MethodPost, "/api/v1/deliveries/missing/replay", http.StatusNotFound},
+		{http.MethodGet, "/api/v1/deliveries/d1/other", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		if rr := serve(tt.method, tt.path); rr.Code != tt.want {
+			t.Errorf("%s %s: expected status %d, got %d: %s", tt.method, tt.path, tt.want, rr.Code, rr.Body.String())
+		}
+	}
+
+	if len(replayed) != 1 || replayed[0] != "d1" {
+		t.Errorf("expected only d1 to be replayed, got %v", replayed)
+	}
+}
@@ -0,0 +1,48 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/a-voronkov/mattermost-bugsnag/server/store"
+)
+
+func (r *Router) handleRotateEncryptionKey(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	if r.config.RotateEncryptionKey == nil {
+		writeError(w, http.StatusNotImplemented, "key rotation not available")
+		return
+	}
+
+	rotation, err := r.config.RotateEncryptionKey()
+	if err != nil {
+		// The new key is in place but old keys were kept; report how far
+		// re-sealing got so the rotation can be retried.
+		writeJSON(w, http.StatusInternalServerError, map[string]any{
+			"error":    "rotation incomplete: " + err.Error(),
+			"rotation": rotation,
+		})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, rotation)
+}
+
+// redactingWriter replaces configured secrets in response bodies.
+type redactingWriter struct {
+	http.ResponseWriter
+	secrets []string
+}
+
+func (w *redactingWriter) Write(data []byte) (int, error) {
+	redacted := store.RedactSecrets(string(data), w.secrets)
+	if _, err := w.ResponseWriter.Write([]byte(redacted)); err != nil {
+		return 0, err
+	}
+	// Report the caller's length so encoders do not treat a shorter redacted
+	// body as a short write.
+	return len(data), nil
+}
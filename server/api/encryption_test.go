@@ -0,0 +1,68 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/a-voronkov/mattermost-bugsnag/server/connection"
+	"github.com/a-voronkov/mattermost-bugsnag/server/store"
+)
+
+func TestRotateEncryptionKey(t *testing.T) {
+	rotateErr := error(nil)
+	router := newTestRouter(Config{
+		RotateEncryptionKey: func() (store.KeyRotation, error) {
+			return store.KeyRotation{KeyID: "abcd1234", ResealedSettings: 2, RetiredOldKeys: rotateErr == nil}, rotateErr
+		},
+	})
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, adminRequest(http.MethodGet, "/api/v1/encryption/rotate", nil))
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected 405 for GET, got %d", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, adminRequest(http.MethodPost, "/api/v1/encryption/rotate", nil))
+	var rotation store.KeyRotation
+	if err := json.NewDecoder(rr.Body).Decode(&rotation); err != nil || rr.Code != http.StatusOK {
+		t.Fatalf("rotate = %d, %v", rr.Code, err)
+	}
+	if rotation.KeyID != "abcd1234" || !rotation.RetiredOldKeys {
+		t.Errorf("unexpected rotation %+v", rotation)
+	}
+
+	rotateErr = errors.New("save plugin settings: read-only config")
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, adminRequest(http.MethodPost, "/api/v1/encryption/rotate", nil))
+	if rr.Code != http.StatusInternalServerError || !strings.Contains(rr.Body.String(), "read-only config") {
+		t.Errorf("expected a 500 with the failure, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestResponsesRedactSecrets(t *testing.T) {
+	router := newTestRouter(Config{
+		Connections: func() []connection.Connection {
+			return []connection.Connection{{ID: connection.DefaultID, Name: "Default", APIToken: "api-token-123"}}
+		},
+		KVStore: newMemoryKVStore(),
+		ReplayDelivery: func(id string) (store.Delivery, error) {
+			return store.Delivery{}, errors.New("bugsnag rejected api-token-123")
+		},
+		Secrets: func() []string { return []string{"api-token-123"} },
+	})
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, adminRequest(http.MethodPost, "/api/v1/deliveries/d1/replay", nil))
+
+	if strings.Contains(rr.Body.String(), "api-token-123") {
+		t.Fatalf("secret leaked in response: %s", rr.Body.String())
+	}
+	if !strings.Contains(rr.Body.String(), "bugsnag rejected [redacted]") {
+		t.Errorf("expected the redacted error, got %s", rr.Body.String())
+	}
+}
@@ -0,0 +1,102 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/a-voronkov/mattermost-bugsnag/server/bugsnag"
+)
+
+func (r *Router) handleErrors(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	query := req.URL.Query()
+	projectID := strings.TrimSpace(query.Get("project_id"))
+	if projectID == "" {
+		writeError(w, http.StatusBadRequest, "project_id is required")
+		return
+	}
+
+	filter, err := parseErrorFilter(query)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	conn, status, err := r.connection(req)
+	if err != nil {
+		writeError(w, status, err.Error())
+		return
+	}
+
+	client, err := r.client(req, conn.APIToken)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to create Bugsnag client: "+err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), 15*time.Second)
+	defer cancel()
+
+	errors, err := client.ListErrors(ctx, projectID, filter)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, "failed to search errors: "+err.Error())
+		return
+	}
+
+	clients := r.clients()
+	for i := range errors {
+		errors[i].URL = clients.DashboardURL(errors[i].URL)
+	}
+	if errors == nil {
+		errors = []bugsnag.ErrorDetails{}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"project_id": projectID,
+		"errors":     errors,
+	})
+}
+
+func parseErrorFilter(query url.Values) (bugsnag.ErrorFilter, error) {
+	filter := bugsnag.ErrorFilter{
+		Status:       strings.ToLower(strings.TrimSpace(query.Get("status"))),
+		Severity:     strings.ToLower(strings.TrimSpace(query.Get("severity"))),
+		ReleaseStage: strings.TrimSpace(query.Get("release_stage")),
+		AssigneeID:   strings.TrimSpace(query.Get("assignee_id")),
+		Query:        strings.TrimSpace(query.Get("q")),
+	}
+	if err := filter.Validate(); err != nil {
+		return filter, err
+	}
+
+	for name, target := range map[string]*time.Time{"first_seen_after": &filter.FirstSeenAfter, "last_seen_after": &filter.LastSeenAfter} {
+		value := strings.TrimSpace(query.Get(name))
+		if value == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return filter, fmt.Errorf("%s must be an RFC 3339 timestamp", name)
+		}
+		*target = parsed
+	}
+
+	if value := strings.TrimSpace(query.Get("limit")); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > bugsnag.MaxErrorLimit {
+			return filter, fmt.Errorf("limit must be between 1 and %d", bugsnag.MaxErrorLimit)
+		}
+		filter.Limit = limit
+	}
+
+	return filter, nil
+}
@@ -0,0 +1,69 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/a-voronkov/mattermost-bugsnag/server/bugsnag"
+	"github.com/a-voronkov/mattermost-bugsnag/server/connection"
+)
+
+func TestErrorsSearch(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/projects/proj-1/errors" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		if got := r.URL.Query().Get("filters[error.status][][value]"); got != "open" {
+			t.Errorf("expected the status filter, got %q", got)
+		}
+		_, _ = w.Write([]byte(`[{"id":"err-1","error_class":"NoMethodError","status":"open","url":"https://app.bugsnag.com/acme/api/errors/err-1"}]`))
+	}))
+	t.Cleanup(server.Close)
+
+	clients, err := bugsnag.NewFactory(bugsnag.Settings{APIURL: server.URL, DashboardURL: "https://bugsnag.example.com"})
+	if err != nil {
+		t.Fatalf("NewFactory() error = %v", err)
+	}
+	router := newTestRouter(Config{
+		Connections: func() []connection.Connection {
+			return []connection.Connection{{ID: connection.DefaultID, APIToken: "t1"}}
+		},
+		Clients: func() *bugsnag.Factory { return clients },
+	})
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, adminRequest(http.MethodGet, "/api/v1/errors?project_id=proj-1&status=open", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	var resp struct {
+		Errors []bugsnag.ErrorDetails `json:"errors"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if len(resp.Errors) != 1 || resp.Errors[0].URL != "https://bugsnag.example.com/acme/api/errors/err-1" {
+		t.Fatalf("unexpected errors %+v", resp.Errors)
+	}
+}
+
+func TestErrorsSearchRejectsInvalidFilters(t *testing.T) {
+	router := newConnectionsRouter(connection.Connection{ID: connection.DefaultID, APIToken: "t1"})
+
+	for _, path := range []string{
+		"/api/v1/errors",
+		"/api/v1/errors?project_id=p1&status=closed",
+		"/api/v1/errors?project_id=p1&severity=fatal",
+		"/api/v1/errors?project_id=p1&first_seen_after=yesterday",
+		"/api/v1/errors?project_id=p1&limit=500",
+	} {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, adminRequest(http.MethodGet, path, nil))
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status %d, got %d: %s", path, http.StatusBadRequest, rr.Code, rr.Body.String())
+		}
+	}
+}
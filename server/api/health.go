@@ -0,0 +1,204 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/a-voronkov/mattermost-bugsnag/server/connection"
+	"github.com/a-voronkov/mattermost-bugsnag/server/kvkeys"
+	"github.com/a-voronkov/mattermost-bugsnag/server/store"
+)
+
+// healthKVKeys are the fixed KV keys whose sizes the health report includes.
+// Per-error and per-release keys are not listed because the KV store cannot
+// enumerate them.
+var healthKVKeys = []string{
+	kvkeys.ProjectChannelMappings,
+	kvkeys.UserMappings,
+	kvkeys.ActiveErrors,
+	kvkeys.CardTemplates,
+	kvkeys.Repositories,
+	kvkeys.AuditLog,
+	kvkeys.Health,
+	kvkeys.Deliveries,
+}
+
+// TokenHealth reports whether a connection's Bugsnag API token works.
+type TokenHealth struct {
+	Connection   string   `json:"connection,omitempty"`
+	Name         string   `json:"name,omitempty"`
+	Configured   bool     `json:"configured"`
+	Valid        bool     `json:"valid"`
+	Scopes       []string `json:"scopes"`
+	Organization string   `json:"organization,omitempty"`
+	ProjectCount int      `json:"project_count"`
+	Error        string   `json:"error,omitempty"`
+}
+
+// HealthReport is the response of /api/v1/health.
+type HealthReport struct {
+	Status string `json:"status"`
+	// Token is the default connection's token; Connections lists every
+	// configured connection, the default one first.
+	Token       TokenHealth          `json:"token"`
+	Connections []TokenHealth        `json:"connections"`
+	LastWebhook map[string]time.Time `json:"last_webhook"`
+	LastSync    *store.SyncStatus    `json:"last_sync"`
+	QueueDepth  int                  `json:"queue_depth"`
+	KVSizes     map[string]int       `json:"kv_sizes"`
+	Problems    []string             `json:"problems"`
+}
+
+func (r *Router) handleHealth(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	if r.config.KVStore == nil {
+		writeError(w, http.StatusInternalServerError, "storage not configured")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), 10*time.Second)
+	defer cancel()
+
+	report := HealthReport{
+		Token:       TokenHealth{Scopes: []string{}},
+		Connections: []TokenHealth{},
+		KVSizes:     map[string]int{},
+		Problems:    []string{},
+	}
+	for _, conn := range r.connections() {
+		token := r.tokenHealth(ctx, req, conn)
+		if !token.Valid {
+			report.Problems = append(report.Problems, fmt.Sprintf("Bugsnag API token check failed for connection %s: %s", conn.DisplayName(), token.Error))
+		}
+		report.Connections = append(report.Connections, token)
+	}
+	if len(report.Connections) == 0 {
+		report.Problems = append(report.Problems, "Bugsnag API token is not configured")
+	} else {
+		report.Token = report.Connections[0]
+	}
+
+	s := store.New(r.config.KVStore)
+
+	health, err := s.GetHealth()
+	if err != nil {
+		report.Problems = append(report.Problems, "failed to load health state: "+err.Error())
+	}
+	report.LastWebhook = health.LastWebhook
+	report.LastSync = health.LastSync
+	if report.LastSync != nil && report.LastSync.Error != "" {
+		report.Problems = append(report.Problems, "last sync reported an error: "+report.LastSync.Error)
+	}
+
+	activeErrors, err := s.ListActiveErrors()
+	if err != nil {
+		report.Problems = append(report.Problems, "failed to load active errors: "+err.Error())
+	}
+	report.QueueDepth = len(activeErrors)
+
+	for _, key := range healthKVKeys {
+		data, err := r.config.KVStore.Get(key)
+		if err != nil {
+			report.Problems = append(report.Problems, fmt.Sprintf("failed to read %s: %s", key, err.Error()))
+			continue
+		}
+		report.KVSizes[key] = len(data)
+	}
+
+	report.Problems = append(report.Problems, r.configurationProblems()...)
+
+	report.Status = "ok"
+	if len(report.Problems) > 0 {
+		report.Status = "degraded"
+	}
+
+	writeJSON(w, http.StatusOK, report)
+}
+
+// tokenHealth checks a connection's token through its shared client, so a
+// token check answered from the cache is only as fresh as the cache TTL
+// unless the request asks for ?refresh=true.
+func (r *Router) tokenHealth(ctx context.Context, req *http.Request, conn connection.Connection) TokenHealth {
+	result := TokenHealth{Connection: conn.ID, Name: conn.DisplayName(), Scopes: []string{}}
+
+	token := strings.TrimSpace(conn.APIToken)
+	if token == "" {
+		return result
+	}
+	result.Configured = true
+
+	client, err := r.client(req, token)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	check, err := checkToken(ctx, client, conn.OrganizationID)
+	if check.Scopes != nil {
+		result.Scopes = check.Scopes
+	}
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	result.Valid = true
+	result.Organization = check.Organization
+	result.ProjectCount = len(check.Projects)
+	return result
+}
+
+// configurationProblems lists rules pointing at missing or archived channels
+// and user mappings pointing at missing or deactivated users.
+func (r *Router) configurationProblems() []string {
+	var problems []string
+
+	if r.config.ChannelExists != nil {
+		var rules []ChannelRule
+		if err := r.loadJSON(kvkeys.ProjectChannelMappings, &rules); err != nil {
+			problems = append(problems, "failed to load channel rules: "+err.Error())
+		}
+		for _, rule := range rules {
+			if !r.config.ChannelExists(rule.ChannelID) {
+				problems = append(problems, fmt.Sprintf("channel rule %q for project %s points at a missing or archived channel (%s)", rule.ID, rule.ProjectID, rule.ChannelID))
+			}
+		}
+	}
+
+	if r.config.UserActive != nil {
+		var mappings []UserMapping
+		if err := r.loadJSON(kvkeys.UserMappings, &mappings); err != nil {
+			problems = append(problems, "failed to load user mappings: "+err.Error())
+		}
+		for _, mapping := range mappings {
+			if !r.config.UserActive(mapping.MattermostUserID) {
+				name := mapping.MattermostUsername
+				if name == "" {
+					name = mapping.MattermostUserID
+				}
+				problems = append(problems, fmt.Sprintf("user mapping for %s points at a missing or deactivated user", name))
+			}
+		}
+	}
+
+	return problems
+}
+
+func (r *Router) loadJSON(key string, dest any) error {
+	data, err := r.config.KVStore.Get(key)
+	if err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, dest)
+}
@@ -0,0 +1,97 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/a-voronkov/mattermost-bugsnag/server/kvkeys"
+	"github.com/a-voronkov/mattermost-bugsnag/server/store"
+)
+
+func TestHealthReportsProblems(t *testing.T) {
+	kv := newMemoryKVStore()
+	rules, _ := json.Marshal([]ChannelRule{
+		{ID: "r1", ProjectID: "p1", ChannelID: "live"},
+		{ID: "r2", ProjectID: "p2", ChannelID: "archived"},
+	})
+	mappings, _ := json.Marshal([]UserMapping{
+		{MattermostUserID: "u1", MattermostUsername: "alice"},
+		{MattermostUserID: "u2", MattermostUsername: "bob"},
+	})
+	_ = kv.Set(kvkeys.ProjectChannelMappings, rules)
+	_ = kv.Set(kvkeys.UserMappings, mappings)
+
+	s := store.New(kv)
+	webhookAt := time.Date(2025, 11, 28, 10, 0, 0, 0, time.UTC)
+	_ = s.RecordWebhook("p1", webhookAt)
+	_ = s.RecordSync(store.SyncStatus{At: webhookAt, ActiveErrors: 2, Failures: 1, Error: "fetch e1: timeout"})
+	_ = s.UpsertActiveError(store.ActiveError{ProjectID: "p1", ErrorID: "e1"})
+	_ = s.UpsertActiveError(store.ActiveError{ProjectID: "p1", ErrorID: "e2"})
+
+	router := newTestRouter(Config{
+		KVStore:       kv,
+		ChannelExists: func(channelID string) bool { return channelID == "live" },
+		UserActive:    func(userID string) bool { return userID == "u1" },
+	})
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, adminRequest(http.MethodGet, "/api/v1/health", nil))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	var report HealthReport
+	if err := json.NewDecoder(rr.Body).Decode(&report); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+
+	if report.Status != "degraded" {
+		t.Errorf("Status = %q, want degraded", report.Status)
+	}
+	if report.Token.Configured || report.Token.Valid {
+		t.Errorf("expected an unconfigured token, got %+v", report.Token)
+	}
+	if !report.LastWebhook["p1"].Equal(webhookAt) {
+		t.Errorf("LastWebhook[p1] = %v, want %v", report.LastWebhook["p1"], webhookAt)
+	}
+	if report.LastSync == nil || report.LastSync.Failures != 1 {
+		t.Errorf("expected the recorded sync, got %+v", report.LastSync)
+	}
+	if report.QueueDepth != 2 {
+		t.Errorf("QueueDepth = %d, want 2", report.QueueDepth)
+	}
+	if report.KVSizes[kvkeys.ProjectChannelMappings] != len(rules) {
+		t.Errorf("KVSizes[rules] = %d, want %d", report.KVSizes[kvkeys.ProjectChannelMappings], len(rules))
+	}
+
+	problems := strings.Join(report.Problems, "\n")
+	for _, want := range []string{
+		"Bugsnag API token is not configured",
+		"last sync reported an error: fetch e1: timeout",
+		`channel rule "r2" for project p2 points at a missing or archived channel (archived)`,
+		"user mapping for bob points at a missing or deactivated user",
+	} {
+		if !strings.Contains(problems, want) {
+			t.Errorf("expected problem %q, got:\n%s", want, problems)
+		}
+	}
+	if strings.Contains(problems, "alice") || strings.Contains(problems, `"r1"`) {
+		t.Errorf("healthy rules and mappings should not be reported, got:\n%s", problems)
+	}
+}
+
+func TestHealthMethodNotAllowed(t *testing.T) {
+	router := newTestRouter(Config{KVStore: newMemoryKVStore()})
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, adminRequest(http.MethodPost, "/api/v1/health", nil))
+
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected status %d, got %d", http.StatusMethodNotAllowed, rr.Code)
+	}
+}
@@ -0,0 +1,108 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/a-voronkov/mattermost-bugsnag/server/kvkeys"
+	"github.com/a-voronkov/mattermost-bugsnag/server/oncall"
+)
+
+func (r *Router) handleRotations(w http.ResponseWriter, req *http.Request) {
+	if r.config.KVStore == nil {
+		writeError(w, http.StatusInternalServerError, "storage not configured")
+		return
+	}
+
+	switch req.Method {
+	case http.MethodGet:
+		r.getRotations(w)
+	case http.MethodPost, http.MethodPut:
+		r.saveRotations(w, req)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (r *Router) loadRotations() ([]oncall.Rotation, error) {
+	data, err := r.config.KVStore.Get(kvkeys.OnCallRotations)
+	if err != nil {
+		return nil, err
+	}
+
+	var rotations []oncall.Rotation
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &rotations); err != nil {
+			return nil, err
+		}
+	}
+	if rotations == nil {
+		rotations = []oncall.Rotation{}
+	}
+	return rotations, nil
+}
+
+// getRotations lists the rotations along with who is on call in each right
+// now.
+func (r *Router) getRotations(w http.ResponseWriter) {
+	rotations, err := r.loadRotations()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to load on-call rotations: "+err.Error())
+		return
+	}
+
+	now := time.Now()
+	onCall := map[string]oncall.Shift{}
+	for _, rotation := range rotations {
+		if shift, ok := rotation.Current(now); ok {
+			onCall[rotation.ID] = shift
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"rotations": rotations,
+		"on_call":   onCall,
+	})
+}
+
+func (r *Router) saveRotations(w http.ResponseWriter, req *http.Request) {
+	var payload struct {
+		Rotations []oncall.Rotation `json:"rotations"`
+	}
+
+	if err := json.NewDecoder(req.Body).Decode(&payload); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON payload: "+err.Error())
+		return
+	}
+
+	seen := map[string]bool{}
+	for i, rotation := range payload.Rotations {
+		if err := rotation.Validate(); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid rotation %d: %s", i+1, err.Error()))
+			return
+		}
+		if seen[rotation.ID] {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("duplicate rotation %q", rotation.ID))
+			return
+		}
+		seen[rotation.ID] = true
+	}
+
+	data, err := json.Marshal(payload.Rotations)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to encode on-call rotations: "+err.Error())
+		return
+	}
+
+	if err := r.config.KVStore.Set(kvkeys.OnCallRotations, data); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to save on-call rotations: "+err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"status":    "ok",
+		"rotations": payload.Rotations,
+	})
+}
@@ -0,0 +1,72 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRotationsSaveAndLoad(t *testing.T) {
+	router := newTestRouter(Config{KVStore: newMemoryKVStore()})
+
+	body := `{"rotations":[{"id":"backend","users":["jane","bob"],"handoff":"weekly","start":"2024-05-06"}]}`
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, adminRequest(http.MethodPost, "/api/v1/oncall-rotations", strings.NewReader(body)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, adminRequest(http.MethodGet, "/api/v1/oncall-rotations", nil))
+
+	var resp struct {
+		Rotations []struct {
+			ID string `json:"id"`
+		} `json:"rotations"`
+		OnCall map[string]struct {
+			User string `json:"user"`
+		} `json:"on_call"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if len(resp.Rotations) != 1 || resp.Rotations[0].ID != "backend" {
+		t.Fatalf("unexpected rotations: %+v", resp.Rotations)
+	}
+	if user := resp.OnCall["backend"].User; user != "jane" && user != "bob" {
+		t.Errorf("unexpected on-call user %q", user)
+	}
+}
+
+func TestRotationsRejectInvalid(t *testing.T) {
+	router := newTestRouter(Config{KVStore: newMemoryKVStore()})
+
+	for _, body := range []string{
+		`{"rotations":[{"id":"backend","users":[],"handoff":"weekly","start":"2024-05-06"}]}`,
+		`{"rotations":[{"id":"backend","users":["jane"],"handoff":"weekly","timezone":"Nowhere/City","start":"2024-05-06"}]}`,
+		`{"rotations":[{"id":"a","users":["jane"],"handoff":"daily","start":"2024-05-06"},{"id":"a","users":["bob"],"handoff":"daily","start":"2024-05-06"}]}`,
+	} {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, adminRequest(http.MethodPost, "/api/v1/oncall-rotations", strings.NewReader(body)))
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status %d, got %d", body, http.StatusBadRequest, rr.Code)
+		}
+	}
+}
+
+func TestSaveChannelRulesChecksRotation(t *testing.T) {
+	kv := newMemoryKVStore()
+	kv.data["bugsnag:oncall-rotations"] = []byte(`[{"id":"backend","users":["jane"],"handoff":"daily","start":"2024-05-06"}]`)
+	router := newTestRouter(Config{KVStore: kv})
+
+	for rotation, want := range map[string]int{"backend": http.StatusOK, "frontend": http.StatusBadRequest} {
+		body := `{"rules":[{"id":"r1","project_id":"p1","channel_id":"c1","oncall_rotation":"` + rotation + `"}]}`
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, adminRequest(http.MethodPost, "/api/v1/channel-rules", strings.NewReader(body)))
+		if rr.Code != want {
+			t.Errorf("rotation %s: expected status %d, got %d: %s", rotation, want, rr.Code, rr.Body.String())
+		}
+	}
+}
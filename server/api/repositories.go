@@ -0,0 +1,90 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/a-voronkov/mattermost-bugsnag/server/kvkeys"
+	"github.com/a-voronkov/mattermost-bugsnag/server/sourcelink"
+)
+
+func (r *Router) handleRepositories(w http.ResponseWriter, req *http.Request) {
+	if r.config.KVStore == nil {
+		writeError(w, http.StatusInternalServerError, "storage not configured")
+		return
+	}
+
+	switch req.Method {
+	case http.MethodGet:
+		r.getRepositories(w)
+	case http.MethodPost, http.MethodPut:
+		r.saveRepositories(w, req)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (r *Router) getRepositories(w http.ResponseWriter) {
+	data, err := r.config.KVStore.Get(kvkeys.Repositories)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to load repositories: "+err.Error())
+		return
+	}
+
+	var repositories []sourcelink.Repository
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &repositories); err != nil {
+			writeError(w, http.StatusInternalServerError, "failed to parse repositories: "+err.Error())
+			return
+		}
+	}
+
+	if repositories == nil {
+		repositories = []sourcelink.Repository{}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"repositories": repositories,
+	})
+}
+
+func (r *Router) saveRepositories(w http.ResponseWriter, req *http.Request) {
+	var payload struct {
+		Repositories []sourcelink.Repository `json:"repositories"`
+	}
+
+	if err := json.NewDecoder(req.Body).Decode(&payload); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON payload: "+err.Error())
+		return
+	}
+
+	seen := map[string]bool{}
+	for i, repo := range payload.Repositories {
+		if err := repo.Validate(); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid repository %d: %s", i+1, err.Error()))
+			return
+		}
+		if seen[repo.ProjectID] {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("duplicate repository for project %q", repo.ProjectID))
+			return
+		}
+		seen[repo.ProjectID] = true
+	}
+
+	data, err := json.Marshal(payload.Repositories)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to encode repositories: "+err.Error())
+		return
+	}
+
+	if err := r.config.KVStore.Set(kvkeys.Repositories, data); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to save repositories: "+err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"status":       "ok",
+		"repositories": payload.Repositories,
+	})
+}
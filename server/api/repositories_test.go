@@ -0,0 +1,94 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRepositoriesSaveAndLoad(t *testing.T) {
+	router := newTestRouter(Config{KVStore: newMemoryKVStore()})
+
+	body := `{"repositories":[{"project_id":"p1","provider":"github","url":"https://github.com/acme/api","ref_template":"v{version}","path_prefixes":[{"from":"/app/","to":"src/"}]}]}`
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, adminRequest(http.MethodPost, "/api/v1/repositories", strings.NewReader(body)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, adminRequest(http.MethodGet, "/api/v1/repositories", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	var resp struct {
+		Repositories []struct {
+			ProjectID    string `json:"project_id"`
+			RefTemplate  string `json:"ref_template"`
+			PathPrefixes []struct {
+				From string `json:"from"`
+				To   string `json:"to"`
+			} `json:"path_prefixes"`
+		} `json:"repositories"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if len(resp.Repositories) != 1 {
+		t.Fatalf("unexpected repositories: %+v", resp.Repositories)
+	}
+	repo := resp.Repositories[0]
+	if repo.ProjectID != "p1" || repo.RefTemplate != "v{version}" || len(repo.PathPrefixes) != 1 || repo.PathPrefixes[0].To != "src/" {
+		t.Fatalf("repository not round-tripped: %+v", repo)
+	}
+}
+
+func TestRepositoriesLoadEmpty(t *testing.T) {
+	router := newTestRouter(Config{KVStore: newMemoryKVStore()})
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, adminRequest(http.MethodGet, "/api/v1/repositories", nil))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	if got := strings.TrimSpace(rr.Body.String()); got != `{"repositories":[]}` {
+		t.Fatalf("unexpected body: %s", got)
+	}
+}
+
+func TestRepositoriesRejectInvalid(t *testing.T) {
+	kv := newMemoryKVStore()
+	router := newTestRouter(Config{KVStore: kv})
+
+	tests := []struct {
+		name string
+		body string
+	}{
+		{name: "missing project", body: `{"repositories":[{"provider":"github","url":"https://github.com/acme/api"}]}`},
+		{name: "relative url", body: `{"repositories":[{"project_id":"p1","provider":"github","url":"github.com/acme/api"}]}`},
+		{name: "unknown provider", body: `{"repositories":[{"project_id":"p1","provider":"svn","url":"https://svn.example.com/api"}]}`},
+		{name: "custom without template", body: `{"repositories":[{"project_id":"p1","provider":"custom","url":"https://src.example.com/api"}]}`},
+		{name: "template without path", body: `{"repositories":[{"project_id":"p1","provider":"custom","url":"https://src.example.com/api","url_template":"{repo}/{ref}"}]}`},
+		{name: "duplicate project", body: `{"repositories":[{"project_id":"p1","provider":"github","url":"https://github.com/acme/a"},{"project_id":"p1","provider":"github","url":"https://github.com/acme/b"}]}`},
+		{name: "invalid json", body: `{"repositories":`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, adminRequest(http.MethodPost, "/api/v1/repositories", strings.NewReader(tt.body)))
+
+			if rr.Code != http.StatusBadRequest {
+				t.Fatalf("expected status %d, got %d: %s", http.StatusBadRequest, rr.Code, rr.Body.String())
+			}
+		})
+	}
+
+	if len(kv.data) != 0 {
+		t.Fatalf("invalid repositories must not be stored: %v", kv.data)
+	}
+}
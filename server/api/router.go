@@ -3,15 +3,24 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/a-voronkov/mattermost-bugsnag/server/bugsnag"
+	"github.com/a-voronkov/mattermost-bugsnag/server/connection"
+	"github.com/a-voronkov/mattermost-bugsnag/server/formatter"
+	"github.com/a-voronkov/mattermost-bugsnag/server/oncall"
+	"github.com/a-voronkov/mattermost-bugsnag/server/store"
+	"github.com/a-voronkov/mattermost-bugsnag/server/tracker"
 )
 
 // UserMapping connects a Mattermost user to a Bugsnag user.
 type UserMapping struct {
+	ConnectionID       string `json:"connection_id,omitempty"`
 	MattermostUserID   string `json:"mattermost_user_id"`
 	MattermostUsername string `json:"mattermost_username,omitempty"`
 	BugsnagUserID      string `json:"bugsnag_user_id,omitempty"`
@@ -20,14 +29,126 @@ type UserMapping struct {
 
 // ChannelRule describes where to send a Bugsnag event for a given project.
 type ChannelRule struct {
-	ID           string   `json:"id"`
-	ProjectID    string   `json:"project_id"`
-	ProjectName  string   `json:"project_name,omitempty"`
-	ChannelID    string   `json:"channel_id"`
-	ChannelName  string   `json:"channel_name,omitempty"`
-	Environments []string `json:"environments,omitempty"`
-	Severities   []string `json:"severities,omitempty"`
-	Events       []string `json:"events,omitempty"`
+	ID             string            `json:"id"`
+	ConnectionID   string            `json:"connection_id,omitempty"`
+	ProjectID      string            `json:"project_id"`
+	ProjectName    string            `json:"project_name,omitempty"`
+	ChannelID      string            `json:"channel_id"`
+	ChannelName    string            `json:"channel_name,omitempty"`
+	Environments   []string          `json:"environments,omitempty"`
+	Severities     []string          `json:"severities,omitempty"`
+	Events         []string          `json:"events,omitempty"`
+	TemplateID     string            `json:"template_id,omitempty"`
+	ShowContext    string            `json:"show_context,omitempty"`
+	RedactFields   []string          `json:"redact_fields,omitempty"`
+	Resurface      string            `json:"resurface,omitempty"`
+	Incident       *IncidentPolicy   `json:"incident,omitempty"`
+	Playbook       *PlaybookPolicy   `json:"playbook,omitempty"`
+	IssueTracker   string            `json:"issue_tracker,omitempty"`
+	Escalation     *EscalationPolicy `json:"escalation,omitempty"`
+	OnCallRotation string            `json:"oncall_rotation,omitempty"`
+}
+
+// resurfaceModes are the values ChannelRule.Resurface accepts besides empty,
+// which updates the card in place.
+var resurfaceModes = []string{"new_card", "broadcast"}
+
+// contextModes are the values ChannelRule.ShowContext accepts besides empty,
+// which leaves the event context out.
+var contextModes = []string{"card", "thread"}
+
+// redactFields are the event fields ChannelRule.RedactFields can hide.
+var redactFields = []string{"url", "url_query", "browser", "os", "hostname", "user_id", "user_name", "user_email"}
+
+// validateOptions checks the rule's options that name a mode or field, which
+// the plugin could otherwise only reject when an error arrives.
+func (c ChannelRule) validateOptions() error {
+	if c.ShowContext != "" && !slices.Contains(contextModes, c.ShowContext) {
+		return fmt.Errorf("show_context must be one of %s", strings.Join(contextModes, ", "))
+	}
+	for _, field := range c.RedactFields {
+		if !slices.Contains(redactFields, field) {
+			return fmt.Errorf("unknown redact field %q, must be one of %s", field, strings.Join(redactFields, ", "))
+		}
+	}
+	if c.Resurface != "" && !slices.Contains(resurfaceModes, c.Resurface) {
+		return fmt.Errorf("resurface must be one of %s", strings.Join(resurfaceModes, ", "))
+	}
+	return nil
+}
+
+// EscalationPolicy configures how a channel rule escalates unacknowledged
+// cards.
+type EscalationPolicy struct {
+	Severities []string          `json:"severities,omitempty"`
+	Levels     []EscalationLevel `json:"levels"`
+}
+
+// EscalationLevel is one step of an escalation policy.
+type EscalationLevel struct {
+	AfterMinutes   int      `json:"after_minutes"`
+	Mentions       []string `json:"mentions,omitempty"`
+	DirectMessages []string `json:"direct_messages,omitempty"`
+}
+
+// validate checks that levels notify someone, in increasing order of time.
+func (e *EscalationPolicy) validate() error {
+	if e == nil {
+		return nil
+	}
+	if len(e.Levels) == 0 {
+		return fmt.Errorf("escalation needs at least one level")
+	}
+	previous := 0
+	for i, level := range e.Levels {
+		if level.AfterMinutes <= previous {
+			return fmt.Errorf("escalation level %d: after_minutes must be greater than %d", i+1, previous)
+		}
+		if len(level.Mentions) == 0 && len(level.DirectMessages) == 0 {
+			return fmt.Errorf("escalation level %d: set mentions or direct_messages", i+1)
+		}
+		previous = level.AfterMinutes
+	}
+	return nil
+}
+
+// PlaybookPolicy configures the Playbooks runs of a channel rule.
+type PlaybookPolicy struct {
+	PlaybookID      string            `json:"playbook_id,omitempty"`
+	BySeverity      map[string]string `json:"by_severity,omitempty"`
+	AutoStart       bool              `json:"auto_start,omitempty"`
+	FinishOnResolve bool              `json:"finish_on_resolve,omitempty"`
+}
+
+// normalize lower-cases the by_severity keys so they can be looked up
+// directly, rejecting severities that differ only in case.
+func (p *PlaybookPolicy) normalize() error {
+	if p == nil || len(p.BySeverity) == 0 {
+		return nil
+	}
+	normalized := make(map[string]string, len(p.BySeverity))
+	for severity, playbookID := range p.BySeverity {
+		key := strings.ToLower(strings.TrimSpace(severity))
+		if key == "" {
+			return fmt.Errorf("playbook by_severity keys must not be empty")
+		}
+		if _, ok := normalized[key]; ok {
+			return fmt.Errorf("playbook by_severity lists %q more than once", key)
+		}
+		normalized[key] = playbookID
+	}
+	p.BySeverity = normalized
+	return nil
+}
+
+// IncidentPolicy configures the incident channels of a channel rule.
+type IncidentPolicy struct {
+	Environments  []string `json:"environments,omitempty"`
+	Unhandled     bool     `json:"unhandled,omitempty"`
+	Spiking       bool     `json:"spiking,omitempty"`
+	MinEvents     int      `json:"min_events,omitempty"`
+	OnCallUserIDs []string `json:"on_call_user_ids,omitempty"`
+	Private       bool     `json:"private,omitempty"`
 }
 
 // KVStore defines the minimal operations needed for API storage.
@@ -38,11 +159,43 @@ type KVStore interface {
 
 // Config holds the configuration providers for the API router.
 type Config struct {
-	TokenProvider func() string
-	OrgIDProvider func() string
-	KVStore       KVStore
+	// Connections returns the configured Bugsnag connections, the default
+	// one first. Requests pick one with the connection_id query parameter.
+	Connections func() []connection.Connection
+	KVStore     KVStore
+	// Clients returns the factory that builds Bugsnag API clients for the
+	// configured instance. When nil the hosted bugsnag.com service is used.
+	Clients func() *bugsnag.Factory
+	// ChannelExists reports whether a channel exists and is not archived. It
+	// is used by /health to flag stale channel rules.
+	ChannelExists func(channelID string) bool
+	// UserActive reports whether a Mattermost user exists and is active. It is
+	// used by /health to flag stale user mappings.
+	UserActive func(userID string) bool
+	// ReplayDelivery runs a logged webhook delivery through the pipeline again
+	// and returns the log entry of the replay.
+	ReplayDelivery func(id string) (store.Delivery, error)
+	// Backfill imports the open errors of a channel rule's project, posting
+	// cards for them or seeding them for the status sync.
+	Backfill func(ruleID string, options BackfillOptions) (BackfillResult, error)
+	// RotateEncryptionKey replaces the master secret and re-seals every stored
+	// secret with it.
+	RotateEncryptionKey func() (store.KeyRotation, error)
+	// Secrets returns the configured secret values, which are redacted from
+	// every response.
+	Secrets func() []string
+	// IssueTrackers returns the configured issue trackers, which channel
+	// rules can name as their default.
+	IssueTrackers func() []tracker.Tracker
+	// IsSystemAdmin reports whether a Mattermost user may manage the system.
+	// Every endpoint is limited to system admins; when nil, every request is
+	// refused.
+	IsSystemAdmin func(userID string) bool
 }
 
+// headerUserID carries the ID of the Mattermost user making a plugin request.
+const headerUserID = "Mattermost-User-ID"
+
 // Router handles all /api/v1/* endpoints.
 type Router struct {
 	config Config
@@ -54,28 +207,139 @@ func NewRouter(config Config) *Router {
 }
 
 func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	// Mattermost sets the header for requests with a valid session and
+	// drops it from anything else.
+	userID := req.Header.Get(headerUserID)
+	if userID == "" {
+		writeError(w, http.StatusUnauthorized, "not authenticated")
+		return
+	}
+	if r.config.IsSystemAdmin == nil || !r.config.IsSystemAdmin(userID) {
+		writeError(w, http.StatusForbidden, "only system admins can use this API")
+		return
+	}
+
 	path := strings.TrimPrefix(req.URL.Path, "/api/v1")
+	if r.config.Secrets != nil {
+		w = &redactingWriter{ResponseWriter: w, secrets: r.config.Secrets()}
+	}
 
 	switch {
 	case path == "/test":
 		r.handleTest(w, req)
 	case path == "/projects":
 		r.handleProjects(w, req)
+	case path == "/connections":
+		r.handleConnections(w, req)
 	case path == "/organizations":
 		r.handleOrganizations(w, req)
 	case path == "/collaborators":
 		r.handleCollaborators(w, req)
+	case path == "/errors":
+		r.handleErrors(w, req)
 	case path == "/user-mappings":
 		r.handleUserMappings(w, req)
 	case path == "/channel-rules":
 		r.handleChannelRules(w, req)
+	case path == "/channel-rules/backfill":
+		r.handleBackfill(w, req)
+	case path == "/card-templates":
+		r.handleCardTemplates(w, req)
+	case path == "/card-templates/preview":
+		r.handleCardTemplatePreview(w, req)
+	case path == "/repositories":
+		r.handleRepositories(w, req)
+	case path == "/oncall-rotations":
+		r.handleRotations(w, req)
+	case path == "/audit":
+		r.handleAudit(w, req)
+	case path == "/health":
+		r.handleHealth(w, req)
+	case path == "/deliveries" || strings.HasPrefix(path, "/deliveries/"):
+		r.handleDeliveries(w, req, strings.TrimPrefix(path, "/deliveries"))
+	case path == "/encryption/rotate":
+		r.handleRotateEncryptionKey(w, req)
 	default:
 		writeError(w, http.StatusNotFound, "not found")
 	}
 }
 
+// connections returns the configured Bugsnag connections.
+func (r *Router) connections() []connection.Connection {
+	if r.config.Connections == nil {
+		return nil
+	}
+	return r.config.Connections()
+}
+
+// clients returns the configured client factory.
+func (r *Router) clients() *bugsnag.Factory {
+	if r.config.Clients == nil {
+		return defaultClients
+	}
+	return r.config.Clients()
+}
+
+// defaultClients serves routers configured without a factory, so their shared
+// clients live as long as the process.
+var defaultClients = bugsnag.DefaultFactory()
+
+// client returns the shared Bugsnag client for token. When the request asks
+// for ?refresh=true its cached organizations, projects and collaborators are
+// dropped first.
+func (r *Router) client(req *http.Request, token string) (*bugsnag.Client, error) {
+	client, err := r.clients().Client(token)
+	if err != nil {
+		return nil, err
+	}
+	if refresh, _ := strconv.ParseBool(req.URL.Query().Get("refresh")); refresh {
+		client.Refresh()
+	}
+	return client, nil
+}
+
+// connection returns the Bugsnag connection selected by the connection_id
+// query parameter, or the default connection when none is given.
+func (r *Router) connection(req *http.Request) (connection.Connection, int, error) {
+	id := strings.TrimSpace(req.URL.Query().Get("connection_id"))
+	conn, ok := connection.Find(r.connections(), id)
+	switch {
+	case !ok && id != "":
+		return conn, http.StatusNotFound, fmt.Errorf("unknown connection %q", id)
+	case !ok || conn.APIToken == "":
+		return conn, http.StatusUnauthorized, fmt.Errorf("missing Bugsnag API token")
+	}
+	return conn, http.StatusOK, nil
+}
+
+func (r *Router) handleConnections(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	connections := []connection.Connection{}
+	for _, conn := range r.connections() {
+		connections = append(connections, conn.Public())
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"connections": connections,
+	})
+}
+
 func (r *Router) handleTest(w http.ResponseWriter, req *http.Request) {
-	handler := NewHandlerWithOrgID(r.config.TokenProvider, r.config.OrgIDProvider)
+	conn, status, err := r.connection(req)
+	if err != nil {
+		writeError(w, status, err.Error())
+		return
+	}
+
+	handler := &TestHandler{
+		tokenProvider: func() string { return conn.APIToken },
+		orgIDProvider: func() string { return conn.OrganizationID },
+		clients:       r.clients(),
+	}
 	handler.ServeHTTP(w, req)
 }
 
@@ -85,13 +349,13 @@ func (r *Router) handleProjects(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	token := strings.TrimSpace(r.config.TokenProvider())
-	if token == "" {
-		writeError(w, http.StatusUnauthorized, "missing Bugsnag API token")
+	conn, status, err := r.connection(req)
+	if err != nil {
+		writeError(w, status, err.Error())
 		return
 	}
 
-	client, err := bugsnag.NewDefaultClient(token)
+	client, err := r.client(req, conn.APIToken)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, "failed to create Bugsnag client: "+err.Error())
 		return
@@ -100,10 +364,10 @@ func (r *Router) handleProjects(w http.ResponseWriter, req *http.Request) {
 	ctx, cancel := context.WithTimeout(req.Context(), 15*time.Second)
 	defer cancel()
 
-	// Get organization ID from query or config
+	// Get organization ID from query or the connection
 	orgID := req.URL.Query().Get("organization_id")
 	if orgID == "" {
-		orgID = strings.TrimSpace(r.config.OrgIDProvider())
+		orgID = conn.OrganizationID
 	}
 
 	if orgID == "" {
@@ -151,13 +415,13 @@ func (r *Router) handleOrganizations(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	token := strings.TrimSpace(r.config.TokenProvider())
-	if token == "" {
-		writeError(w, http.StatusUnauthorized, "missing Bugsnag API token")
+	conn, status, err := r.connection(req)
+	if err != nil {
+		writeError(w, status, err.Error())
 		return
 	}
 
-	client, err := bugsnag.NewDefaultClient(token)
+	client, err := r.client(req, conn.APIToken)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, "failed to create Bugsnag client: "+err.Error())
 		return
@@ -183,13 +447,13 @@ func (r *Router) handleCollaborators(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	token := r.config.TokenProvider()
-	if token == "" {
-		writeError(w, http.StatusUnauthorized, "Bugsnag API token not configured")
+	conn, status, err := r.connection(req)
+	if err != nil {
+		writeError(w, status, err.Error())
 		return
 	}
 
-	client, err := bugsnag.NewDefaultClient(token)
+	client, err := r.client(req, conn.APIToken)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, "failed to create Bugsnag client: "+err.Error())
 		return
@@ -198,11 +462,8 @@ func (r *Router) handleCollaborators(w http.ResponseWriter, req *http.Request) {
 	ctx, cancel := context.WithTimeout(req.Context(), 10*time.Second)
 	defer cancel()
 
-	// Get organization ID from config or fetch first org
-	orgID := ""
-	if r.config.OrgIDProvider != nil {
-		orgID = strings.TrimSpace(r.config.OrgIDProvider())
-	}
+	// Get organization ID from the connection or fetch first org
+	orgID := conn.OrganizationID
 
 	if orgID == "" {
 		orgs, err := client.GetOrganizations(ctx)
@@ -284,6 +545,13 @@ func (r *Router) saveUserMappings(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	for _, mapping := range payload.Mappings {
+		if err := r.checkConnectionID(mapping.ConnectionID); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
 	data, err := json.Marshal(payload.Mappings)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, "failed to encode mappings: "+err.Error())
@@ -346,6 +614,8 @@ func (r *Router) getChannelRules(w http.ResponseWriter) {
 func (r *Router) saveChannelRules(w http.ResponseWriter, req *http.Request) {
 	var payload struct {
 		Rules []ChannelRule `json:"rules"`
+		// Backfill optionally imports open errors for new rules once saved.
+		Backfill *BackfillRequest `json:"backfill,omitempty"`
 	}
 
 	if err := json.NewDecoder(req.Body).Decode(&payload); err != nil {
@@ -353,6 +623,72 @@ func (r *Router) saveChannelRules(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	for _, rule := range payload.Rules {
+		if err := r.checkConnectionID(rule.ConnectionID); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("rule %q: %s", rule.ID, err.Error()))
+			return
+		}
+		if err := rule.validateOptions(); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("rule %q: %s", rule.ID, err.Error()))
+			return
+		}
+		if rule.Incident != nil && rule.Incident.MinEvents < 0 {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("rule %q: incident min_events cannot be negative", rule.ID))
+			return
+		}
+		if err := rule.Escalation.validate(); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("rule %q: %s", rule.ID, err.Error()))
+			return
+		}
+		if err := rule.Playbook.normalize(); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("rule %q: %s", rule.ID, err.Error()))
+			return
+		}
+		if rule.TemplateID != "" {
+			templates, err := r.loadCardTemplates()
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, "failed to load card templates: "+err.Error())
+				return
+			}
+			if !slices.ContainsFunc(templates, func(t formatter.CardTemplate) bool { return t.ID == rule.TemplateID }) {
+				writeError(w, http.StatusBadRequest, fmt.Sprintf("rule %q: unknown card template %q", rule.ID, rule.TemplateID))
+				return
+			}
+		}
+		if rule.IssueTracker != "" && !r.hasIssueTracker(rule.IssueTracker) {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("rule %q: unknown issue tracker %q", rule.ID, rule.IssueTracker))
+			return
+		}
+		if rule.OnCallRotation != "" {
+			rotations, err := r.loadRotations()
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, "failed to load on-call rotations: "+err.Error())
+				return
+			}
+			if _, ok := oncall.Find(rotations, rule.OnCallRotation); !ok {
+				writeError(w, http.StatusBadRequest, fmt.Sprintf("rule %q: unknown on-call rotation %q", rule.ID, rule.OnCallRotation))
+				return
+			}
+		}
+	}
+
+	var backfillIDs []string
+	if payload.Backfill != nil {
+		if _, err := payload.Backfill.Normalize(); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		backfillIDs = payload.Backfill.RuleIDs
+		if len(backfillIDs) == 0 {
+			ids, err := r.newRuleIDs(payload.Rules)
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, "failed to load channel rules: "+err.Error())
+				return
+			}
+			backfillIDs = ids
+		}
+	}
+
 	data, err := json.Marshal(payload.Rules)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, "failed to encode channel rules: "+err.Error())
@@ -364,10 +700,69 @@ func (r *Router) saveChannelRules(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	writeJSON(w, http.StatusOK, map[string]any{
+	response := map[string]any{
 		"status": "ok",
 		"rules":  payload.Rules,
-	})
+	}
+	if len(backfillIDs) > 0 {
+		results, status, err := r.backfill(payload.Backfill.BackfillOptions, backfillIDs)
+		if err != nil {
+			writeError(w, status, "rules saved, but backfill failed: "+err.Error())
+			return
+		}
+		response["backfill"] = results
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+// newRuleIDs returns the IDs of rules that are not stored yet.
+func (r *Router) newRuleIDs(rules []ChannelRule) ([]string, error) {
+	data, err := r.config.KVStore.Get(kvKeyChannelRules)
+	if err != nil {
+		return nil, err
+	}
+
+	var existing []ChannelRule
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &existing); err != nil {
+			return nil, err
+		}
+	}
+
+	known := make(map[string]bool, len(existing))
+	for _, rule := range existing {
+		known[rule.ID] = true
+	}
+
+	var ids []string
+	for _, rule := range rules {
+		if !known[rule.ID] {
+			ids = append(ids, rule.ID)
+		}
+	}
+	return ids, nil
+}
+
+// checkConnectionID rejects references to connections that are not configured.
+// An empty ID means the default connection.
+func (r *Router) checkConnectionID(id string) error {
+	if id == "" {
+		return nil
+	}
+	if _, ok := connection.Find(r.connections(), id); !ok {
+		return fmt.Errorf("unknown connection %q", id)
+	}
+	return nil
+}
+
+// hasIssueTracker reports whether an issue tracker with the ID is configured.
+func (r *Router) hasIssueTracker(id string) bool {
+	if r.config.IssueTrackers == nil {
+		return false
+	}
+	_, ok := tracker.Find(r.config.IssueTrackers(), id)
+	return ok
 }
 
 func writeError(w http.ResponseWriter, status int, message string) {
@@ -0,0 +1,34 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRouterRequiresSystemAdmin(t *testing.T) {
+	router := NewRouter(Config{
+		KVStore:       newMemoryKVStore(),
+		IsSystemAdmin: func(userID string) bool { return userID == "admin-1" },
+	})
+
+	for userID, want := range map[string]int{"": http.StatusUnauthorized, "user-1": http.StatusForbidden, "admin-1": http.StatusOK} {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/repositories", nil)
+		if userID != "" {
+			req.Header.Set(headerUserID, userID)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != want {
+			t.Errorf("user %q: expected status %d, got %d", userID, want, rr.Code)
+		}
+	}
+
+	// Without a permission check nobody gets in.
+	req := adminRequest(http.MethodPost, "/api/v1/encryption/rotate", nil)
+	rr := httptest.NewRecorder()
+	NewRouter(Config{}).ServeHTTP(rr, req)
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("expected status %d without a permission check, got %d", http.StatusForbidden, rr.Code)
+	}
+}
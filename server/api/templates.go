@@ -0,0 +1,154 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/a-voronkov/mattermost-bugsnag/server/formatter"
+	"github.com/a-voronkov/mattermost-bugsnag/server/kvkeys"
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+func (r *Router) handleCardTemplates(w http.ResponseWriter, req *http.Request) {
+	if r.config.KVStore == nil {
+		writeError(w, http.StatusInternalServerError, "storage not configured")
+		return
+	}
+
+	switch req.Method {
+	case http.MethodGet:
+		r.getCardTemplates(w)
+	case http.MethodPost, http.MethodPut:
+		r.saveCardTemplates(w, req)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (r *Router) loadCardTemplates() ([]formatter.CardTemplate, error) {
+	data, err := r.config.KVStore.Get(kvkeys.CardTemplates)
+	if err != nil {
+		return nil, err
+	}
+
+	var templates []formatter.CardTemplate
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &templates); err != nil {
+			return nil, err
+		}
+	}
+	if templates == nil {
+		templates = []formatter.CardTemplate{}
+	}
+	return templates, nil
+}
+
+func (r *Router) getCardTemplates(w http.ResponseWriter) {
+	templates, err := r.loadCardTemplates()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to load card templates: "+err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"templates": templates,
+	})
+}
+
+func (r *Router) saveCardTemplates(w http.ResponseWriter, req *http.Request) {
+	var payload struct {
+		Templates []formatter.CardTemplate `json:"templates"`
+	}
+
+	if err := json.NewDecoder(req.Body).Decode(&payload); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON payload: "+err.Error())
+		return
+	}
+
+	if err := validateCardTemplates(payload.Templates); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	data, err := json.Marshal(payload.Templates)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to encode card templates: "+err.Error())
+		return
+	}
+
+	if err := r.config.KVStore.Set(kvkeys.CardTemplates, data); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to save card templates: "+err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"status":    "ok",
+		"templates": payload.Templates,
+	})
+}
+
+func validateCardTemplates(templates []formatter.CardTemplate) error {
+	seen := map[string]bool{}
+	for _, tmpl := range templates {
+		id := strings.TrimSpace(tmpl.ID)
+		if seen[id] {
+			return fmt.Errorf("duplicate template id %q", id)
+		}
+		seen[id] = true
+
+		if err := tmpl.Validate(); err != nil {
+			if id == "" {
+				return fmt.Errorf("invalid template: %w", err)
+			}
+			return fmt.Errorf("invalid template %q: %w", id, err)
+		}
+	}
+	return nil
+}
+
+// handleCardTemplatePreview renders a template against sample data (or the
+// supplied error data) without saving it.
+func (r *Router) handleCardTemplatePreview(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	var payload struct {
+		Template formatter.CardTemplate `json:"template"`
+		Data     *formatter.ErrorData   `json:"data,omitempty"`
+	}
+
+	if err := json.NewDecoder(req.Body).Decode(&payload); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON payload: "+err.Error())
+		return
+	}
+
+	if strings.TrimSpace(payload.Template.ID) == "" {
+		payload.Template.ID = "preview"
+	}
+
+	if err := payload.Template.Validate(); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid template: "+err.Error())
+		return
+	}
+
+	data := formatter.SampleErrorData()
+	if payload.Data != nil {
+		data = *payload.Data
+	}
+
+	post := &model.Post{}
+	mapping := formatter.ErrorPostMapping{ProjectID: data.ProjectID, ErrorID: data.ID}
+	if err := formatter.ApplyTemplatedCard(post, data, mapping, &payload.Template); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"message":     post.Message,
+		"attachments": post.Props["attachments"],
+	})
+}
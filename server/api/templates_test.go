@@ -0,0 +1,125 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type memoryKVStore struct {
+	data map[string][]byte
+}
+
+func newMemoryKVStore() *memoryKVStore {
+	return &memoryKVStore{data: map[string][]byte{}}
+}
+
+func (kv *memoryKVStore) Get(key string) ([]byte, error) {
+	return kv.data[key], nil
+}
+
+func (kv *memoryKVStore) Set(key string, value []byte) error {
+	kv.data[key] = append([]byte(nil), value...)
+	return nil
+}
+
+// newTestRouter builds a router that treats every user as a system admin.
+func newTestRouter(config Config) *Router {
+	config.IsSystemAdmin = func(string) bool { return true }
+	return NewRouter(config)
+}
+
+// adminRequest builds a request made by a signed-in user.
+func adminRequest(method, target string, body io.Reader) *http.Request {
+	req := httptest.NewRequest(method, target, body)
+	req.Header.Set(headerUserID, "admin-1")
+	return req
+}
+
+func TestCardTemplatesSaveAndLoad(t *testing.T) {
+	router := newTestRouter(Config{KVStore: newMemoryKVStore()})
+
+	body := `{"templates":[{"id":"mobile","title":"{{.ExceptionClass}}","fields":[{"title":"OS","value":"{{.Environment}}"}]}]}`
+	req := adminRequest(http.MethodPost, "/api/v1/card-templates", strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	req = adminRequest(http.MethodGet, "/api/v1/card-templates", nil)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	var resp struct {
+		Templates []struct {
+			ID string `json:"id"`
+		} `json:"templates"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if len(resp.Templates) != 1 || resp.Templates[0].ID != "mobile" {
+		t.Fatalf("unexpected templates: %+v", resp.Templates)
+	}
+}
+
+func TestCardTemplatesRejectInvalid(t *testing.T) {
+	kv := newMemoryKVStore()
+	router := newTestRouter(Config{KVStore: kv})
+
+	tests := []struct {
+		name string
+		body string
+	}{
+		{name: "parse error", body: `{"templates":[{"id":"t","title":"{{.ExceptionClass"}]}`},
+		{name: "unknown field", body: `{"templates":[{"id":"t","text":"{{.Nope}}"}]}`},
+		{name: "duplicate id", body: `{"templates":[{"id":"t"},{"id":"t"}]}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := adminRequest(http.MethodPost, "/api/v1/card-templates", strings.NewReader(tt.body))
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			if rr.Code != http.StatusBadRequest {
+				t.Fatalf("expected status %d, got %d: %s", http.StatusBadRequest, rr.Code, rr.Body.String())
+			}
+		})
+	}
+
+	if len(kv.data) != 0 {
+		t.Fatalf("invalid templates must not be stored: %v", kv.data)
+	}
+}
+
+func TestCardTemplatePreview(t *testing.T) {
+	router := newTestRouter(Config{})
+
+	payload, _ := json.Marshal(map[string]any{
+		"template": map[string]any{"title": "{{upper .Environment}}: {{.ExceptionClass}}"},
+	})
+	req := adminRequest(http.MethodPost, "/api/v1/card-templates/preview", bytes.NewReader(payload))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	var resp struct {
+		Message string `json:"message"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if resp.Message != "PRODUCTION: NullReferenceException" {
+		t.Fatalf("unexpected preview message: %q", resp.Message)
+	}
+}
@@ -2,6 +2,7 @@ package api
 
 import (
 	"context"
+	"fmt"
 	"net/http"
 	"strings"
 	"time"
@@ -12,6 +13,7 @@ import (
 type TestHandler struct {
 	tokenProvider func() string
 	orgIDProvider func() string
+	clients       *bugsnag.Factory
 }
 
 // NewHandler creates a new test endpoint handler.
@@ -21,7 +23,7 @@ func NewHandler(tokenProvider func() string) http.Handler {
 
 // NewHandlerWithOrgID creates a new test endpoint handler with organization ID support.
 func NewHandlerWithOrgID(tokenProvider, orgIDProvider func() string) http.Handler {
-	return &TestHandler{tokenProvider: tokenProvider, orgIDProvider: orgIDProvider}
+	return &TestHandler{tokenProvider: tokenProvider, orgIDProvider: orgIDProvider, clients: defaultClients}
 }
 
 func (h *TestHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -41,46 +43,36 @@ func (h *TestHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	client, err := bugsnag.NewDefaultClient(token)
+	client, err := h.clients.Client(token)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, "failed to create Bugsnag client: "+err.Error())
 		return
 	}
+	// A connection test must reach Bugsnag; it also refreshes the cache.
+	client.Refresh()
 
 	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
 	defer cancel()
 
-	// If org ID is provided, fetch projects for that org directly
 	orgID := strings.TrimSpace(h.orgIDProvider())
-	if orgID != "" {
-		projects, err := client.GetProjects(ctx, orgID)
-		if err != nil {
-			writeError(w, http.StatusBadGateway, "failed to fetch projects: "+err.Error())
-			return
-		}
-
-		projectNames := make([]string, len(projects))
-		for i, p := range projects {
-			projectNames[i] = p.Name
-		}
+	check, err := checkToken(ctx, client, orgID)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err.Error())
+		return
+	}
 
+	// If org ID is provided, projects were fetched for that org directly
+	if orgID != "" {
 		writeJSON(w, http.StatusOK, map[string]any{
 			"status":        "ok",
 			"organization":  orgID,
-			"project_count": len(projects),
-			"projects":      projectNames,
+			"project_count": len(check.Projects),
+			"projects":      check.Projects,
 		})
 		return
 	}
 
-	// Otherwise, fetch organizations first
-	orgs, err := client.GetOrganizations(ctx)
-	if err != nil {
-		writeError(w, http.StatusBadGateway, "failed to fetch organizations: "+err.Error())
-		return
-	}
-
-	if len(orgs) == 0 {
+	if check.OrganizationCount == 0 {
 		writeJSON(w, http.StatusOK, map[string]any{
 			"status":  "ok",
 			"message": "No organizations found. Check API token permissions.",
@@ -88,23 +80,58 @@ func (h *TestHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Fetch projects for the first organization
-	projects, err := client.GetProjects(ctx, orgs[0].ID)
+	writeJSON(w, http.StatusOK, map[string]any{
+		"status":             "ok",
+		"organization_count": check.OrganizationCount,
+		"organization":       check.Organization,
+		"project_count":      len(check.Projects),
+		"projects":           check.Projects,
+	})
+}
+
+// tokenCheck summarizes what the configured token can read.
+type tokenCheck struct {
+	OrganizationCount int
+	// Organization is the configured organization ID, or the name of the first
+	// organization the token can see.
+	Organization string
+	Projects     []string
+	// Scopes lists the read permissions the token proved to have. Bugsnag does
+	// not report token scopes, so they are inferred from the calls that worked.
+	Scopes []string
+}
+
+// checkToken verifies the token by listing organizations (unless orgID is set)
+// and the projects of the configured or first organization.
+func checkToken(ctx context.Context, client *bugsnag.Client, orgID string) (tokenCheck, error) {
+	var check tokenCheck
+
+	if orgID == "" {
+		orgs, err := client.GetOrganizations(ctx)
+		if err != nil {
+			return check, fmt.Errorf("failed to fetch organizations: %w", err)
+		}
+		check.Scopes = append(check.Scopes, "organizations:read")
+		check.OrganizationCount = len(orgs)
+		if len(orgs) == 0 {
+			return check, nil
+		}
+		orgID = orgs[0].ID
+		check.Organization = orgs[0].Name
+	} else {
+		check.Organization = orgID
+	}
+
+	projects, err := client.GetProjects(ctx, orgID)
 	if err != nil {
-		writeError(w, http.StatusBadGateway, "failed to fetch projects: "+err.Error())
-		return
+		return check, fmt.Errorf("failed to fetch projects: %w", err)
 	}
+	check.Scopes = append(check.Scopes, "projects:read")
 
-	projectNames := make([]string, len(projects))
+	check.Projects = make([]string, len(projects))
 	for i, p := range projects {
-		projectNames[i] = p.Name
+		check.Projects[i] = p.Name
 	}
 
-	writeJSON(w, http.StatusOK, map[string]any{
-		"status":             "ok",
-		"organization_count": len(orgs),
-		"organization":       orgs[0].Name,
-		"project_count":      len(projects),
-		"projects":           projectNames,
-	})
+	return check, nil
 }
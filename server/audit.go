@@ -0,0 +1,14 @@
+package main
+
+import (
+	"github.com/a-voronkov/mattermost-bugsnag/server/store"
+)
+
+// recordAudit appends a record to the persistent audit log. Failures are only
+// logged so auditing never blocks the action itself.
+func (p *Plugin) recordAudit(record store.AuditRecord) {
+	s := store.New(&pluginKVAdapter{api: p.API, namespace: p.kvNS()})
+	if err := s.AppendAudit(record); err != nil {
+		p.API.LogWarn("failed to record audit entry", "action", record.Action, "error_id", record.ErrorID, "err", err.Error())
+	}
+}
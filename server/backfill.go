@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/a-voronkov/mattermost-bugsnag/server/api"
+	"github.com/a-voronkov/mattermost-bugsnag/server/bugsnag"
+	"github.com/a-voronkov/mattermost-bugsnag/server/connection"
+	"github.com/a-voronkov/mattermost-bugsnag/server/formatter"
+	"github.com/a-voronkov/mattermost-bugsnag/server/outbound"
+	"github.com/a-voronkov/mattermost-bugsnag/server/store"
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+const backfillCommandUsage = "Usage: `/bugsnag backfill [rule] [post|seed] [limit]`.\n\n" +
+	"Imports the open errors of this channel's project, most events first. `post` (the default) posts their cards; " +
+	"`seed` tracks them for the status sync without posting. The rule ID is only needed when several rules post to this channel."
+
+// backfillRule imports the open errors of a channel rule's project that match
+// its filters. Errors that already have a card are skipped.
+func (p *Plugin) backfillRule(ruleID string, options api.BackfillOptions) (api.BackfillResult, error) {
+	result := api.BackfillResult{RuleID: ruleID, Mode: options.Mode}
+
+	cfg := p.getConfiguration()
+	mm := newMMClient(p.API, cfg.EnableDebugLog, p.kvNS(), p.botUserID)
+
+	rules, err := loadChannelRules(mm)
+	if err != nil {
+		return result, fmt.Errorf("load channel rules: %w", err)
+	}
+	var rule ChannelRule
+	for _, candidate := range rules {
+		if candidate.ID == ruleID {
+			rule = candidate
+			break
+		}
+	}
+	if rule.ID == "" {
+		return result, fmt.Errorf("unknown channel rule %q", ruleID)
+	}
+
+	conn, ok := connection.Find(cfg.AllConnections(), rule.ConnectionID)
+	if !ok || conn.APIToken == "" {
+		return result, fmt.Errorf("no Bugsnag API token configured for connection %q", rule.ConnectionID)
+	}
+	client, err := p.bugsnagClients().Client(conn.APIToken)
+	if err != nil {
+		return result, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	candidates, err := client.ListErrors(ctx, rule.ProjectID, backfillFilter(rule))
+	if err != nil {
+		return result, fmt.Errorf("list open errors: %w", err)
+	}
+
+	var userMappings []UserMapping
+	if options.Mode == api.BackfillModePost {
+		mappings, err := loadUserMappings(mm)
+		if err != nil {
+			mm.LogDebug("failed to load user mappings", "err", err.Error())
+		}
+		userMappings = userMappingsFor(mappings, rule.ConnectionID)
+	}
+
+	for _, details := range candidates {
+		if !matchesBackfill(rule, details) {
+			continue
+		}
+		result.Found++
+		if result.Imported >= options.Limit {
+			continue
+		}
+
+		key := errorPostKVKey(rule.ProjectID, details.ID)
+		var existing ErrorPostMapping
+		if found, _ := mm.LoadJSON(key, &existing); found {
+			result.Skipped++
+			continue
+		}
+
+		if details.ProjectID == "" {
+			details.ProjectID = rule.ProjectID
+		}
+
+		if options.Mode == api.BackfillModeSeed {
+			if err := p.kvStore().UpsertActiveError(store.ActiveError{
+				ConnectionID: rule.ConnectionID,
+				ProjectID:    rule.ProjectID,
+				ErrorID:      details.ID,
+				ChannelID:    rule.ChannelID,
+				LastSyncedAt: time.Now().UTC(),
+			}); err != nil {
+				return result, fmt.Errorf("seed error %s: %w", details.ID, err)
+			}
+			result.Imported++
+			continue
+		}
+
+		if err := p.postBackfilledCard(mm, rule, details, userMappings); err != nil {
+			return result, fmt.Errorf("post card for error %s: %w", details.ID, err)
+		}
+		result.Imported++
+	}
+
+	p.API.LogInfo("channel rule backfilled", "rule_id", rule.ID, "project_id", rule.ProjectID, "mode", options.Mode, "found", result.Found, "imported", result.Imported, "skipped", result.Skipped)
+	return result, nil
+}
+
+// backfillFilter asks Bugsnag for the open errors with the most events. Single
+// environment or severity filters are applied by Bugsnag; matchesBackfill
+// applies the rule's filters in full.
+func backfillFilter(rule ChannelRule) bugsnag.ErrorFilter {
+	filter := bugsnag.ErrorFilter{Status: "open", Sort: "events", Limit: bugsnag.MaxErrorLimit}
+	if len(rule.Environments) == 1 {
+		filter.ReleaseStage = rule.Environments[0]
+	}
+	if len(rule.Severities) == 1 {
+		filter.Severity = strings.ToLower(rule.Severities[0])
+	}
+	return filter
+}
+
+// matchesBackfill applies the rule's environment and severity filters to an
+// error. Event filters describe webhook triggers and do not apply.
+func matchesBackfill(rule ChannelRule, details bugsnag.ErrorDetails) bool {
+	if len(rule.Severities) > 0 && !containsValue(rule.Severities, details.Severity) {
+		return false
+	}
+
+	if len(rule.Environments) > 0 && len(details.ReleaseStages) > 0 {
+		for _, stage := range details.ReleaseStages {
+			if containsValue(rule.Environments, stage) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return true
+}
+
+// postBackfilledCard posts an error's card to the rule's channel and tracks it
+// like a card created by a webhook.
+func (p *Plugin) postBackfilledCard(mm *MMClient, rule ChannelRule, details bugsnag.ErrorDetails, userMappings []UserMapping) error {
+	data := errorDetailsCard(details, rule.ProjectName, userMappings, mm, p.bugsnagClients())
+	data.PlaybookAvailable = rule.Playbook.playbookFor(data.Severity) != ""
+	data.TicketAvailable = len(p.getConfiguration().issueTrackers()) > 0
+
+	templates, err := loadCardTemplates(mm)
+	if err != nil {
+		mm.LogDebug("failed to load card templates", "err", err.Error())
+	}
+
+	card := &model.Post{ChannelId: rule.ChannelID}
+	if err := formatter.ApplyTemplatedCard(card, data, formatter.ErrorPostMapping{
+		ChannelID: rule.ChannelID,
+		ProjectID: rule.ProjectID,
+		ErrorID:   details.ID,
+	}, formatter.FindTemplate(templates, rule.TemplateID)); err != nil {
+		p.API.LogWarn("card template failed, using built-in layout", "err", err.Error())
+	}
+	post, appErr := mm.CreateCardPost(card)
+	if appErr != nil {
+		return appErr
+	}
+
+	mapping := ErrorPostMapping{
+		ConnectionID: rule.ConnectionID,
+		ProjectID:    rule.ProjectID,
+		ErrorID:      details.ID,
+		ChannelID:    rule.ChannelID,
+		PostID:       post.Id,
+	}
+	if err := mm.StoreJSON(errorPostKVKey(rule.ProjectID, details.ID), mapping); err != nil {
+		mm.LogDebug("failed to store error→post mapping", "err", err.Error())
+	}
+	p.registerActiveError(mm, mapping)
+	p.emitEvent(mm, outbound.Event{Type: outbound.EventCardCreated, Source: outbound.SourceBackfill}, mapping, &data)
+
+	return nil
+}
+
+// executeBackfillCommand handles /bugsnag backfill.
+func (p *Plugin) executeBackfillCommand(args *model.CommandArgs, params []string) string {
+	if !p.API.HasPermissionTo(args.UserId, model.PermissionManageSystem) {
+		return "Only system admins can backfill channel rules."
+	}
+
+	var ruleID string
+	var options api.BackfillOptions
+	for _, param := range params {
+		switch {
+		case param == api.BackfillModePost || param == api.BackfillModeSeed:
+			options.Mode = param
+		case isNumber(param):
+			options.Limit, _ = strconv.Atoi(param)
+		case ruleID == "":
+			ruleID = param
+		default:
+			return backfillCommandUsage
+		}
+	}
+
+	options, err := options.Normalize()
+	if err != nil {
+		return fmt.Sprintf("%s.\n\n%s", err.Error(), backfillCommandUsage)
+	}
+
+	mm := newMMClient(p.API, p.getConfiguration().EnableDebugLog, p.kvNS(), p.botUserID)
+	rules, err := loadChannelRules(mm)
+	if err != nil {
+		p.API.LogWarn("failed to load channel rules", "err", err.Error())
+		return "Could not load the channel rules."
+	}
+
+	var channelRules []ChannelRule
+	for _, rule := range rules {
+		if rule.ChannelID == args.ChannelId && (ruleID == "" || rule.ID == ruleID) {
+			channelRules = append(channelRules, rule)
+		}
+	}
+	switch {
+	case len(channelRules) == 0 && ruleID != "":
+		return fmt.Sprintf("No channel rule `%s` posts to this channel.", ruleID)
+	case len(channelRules) == 0:
+		return "No channel rule posts to this channel."
+	case len(channelRules) > 1:
+		ids := make([]string, 0, len(channelRules))
+		for _, rule := range channelRules {
+			ids = append(ids, "`"+rule.ID+"`")
+		}
+		return fmt.Sprintf("Several rules post to this channel: %s. Pick one with `/bugsnag backfill <rule>`.", strings.Join(ids, ", "))
+	}
+
+	rule := channelRules[0]
+	result, err := p.backfillRule(rule.ID, options)
+	if err != nil {
+		p.API.LogWarn("channel rule backfill failed", "rule_id", rule.ID, "err", err.Error())
+		return fmt.Sprintf("Backfill stopped after importing %d errors: %s", result.Imported, err.Error())
+	}
+
+	project := rule.ProjectName
+	if project == "" {
+		project = rule.ProjectID
+	}
+	verb := "Posted cards for"
+	if options.Mode == api.BackfillModeSeed {
+		verb = "Started tracking"
+	}
+	return fmt.Sprintf("%s %d of %d open errors in **%s** (%d already had a card).", verb, result.Imported, result.Found, project, result.Skipped)
+}
+
+func isNumber(value string) bool {
+	_, err := strconv.Atoi(value)
+	return err == nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/a-voronkov/mattermost-bugsnag/server/api"
+	"github.com/a-voronkov/mattermost-bugsnag/server/bugsnag"
+	"github.com/a-voronkov/mattermost-bugsnag/server/store"
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/mock"
+)
+
+const backfillErrors = `[
+	{"id":"err-1","error_class":"NoMethodError","status":"open","severity":"error","release_stages":["production"],"events":90},
+	{"id":"err-2","error_class":"Timeout","status":"open","severity":"warning","release_stages":["production"],"events":50},
+	{"id":"err-3","error_class":"KeyError","status":"open","severity":"error","release_stages":["staging"],"events":20},
+	{"id":"err-4","error_class":"IOError","status":"open","severity":"error","release_stages":["production"],"events":10}
+]`
+
+func TestMatchesBackfill(t *testing.T) {
+	rule := ChannelRule{Severities: []string{"error"}, Environments: []string{"production"}}
+
+	tests := []struct {
+		name    string
+		details bugsnag.ErrorDetails
+		want    bool
+	}{
+		{name: "matching", details: bugsnag.ErrorDetails{Severity: "error", ReleaseStages: []string{"staging", "production"}}, want: true},
+		{name: "other severity", details: bugsnag.ErrorDetails{Severity: "warning", ReleaseStages: []string{"production"}}},
+		{name: "other environment", details: bugsnag.ErrorDetails{Severity: "error", ReleaseStages: []string{"staging"}}},
+		{name: "unknown environment", details: bugsnag.ErrorDetails{Severity: "error"}, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := matchesBackfill(rule, tt.details); got != tt.want {
+				t.Errorf("matchesBackfill() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func newBackfillPlugin(t *testing.T, pluginAPI *plugintest.API) *Plugin {
+	t.Helper()
+
+	rules, _ := json.Marshal([]ChannelRule{
+		{ID: "r1", ProjectID: "proj-1", ProjectName: "Backend", ChannelID: "chan-1", Severities: []string{"error"}, Environments: []string{"production"}},
+	})
+	pluginAPI.On("KVGet", pluginID+":"+KVKeyProjectChannelMappings).Return(rules, nil)
+	pluginAPI.On("LogInfo", "channel rule backfilled", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return().Maybe()
+
+	p := &Plugin{}
+	p.SetAPI(pluginAPI)
+	p.kvNamespace = pluginID
+	p.configuration.Store(&Configuration{BugsnagAPIToken: "shared-token"})
+	p.clients.Store(newBugsnagServer(t, map[string]string{"/projects/proj-1/errors": backfillErrors}))
+	return p
+}
+
+func TestBackfillRuleSeedsMatchingErrors(t *testing.T) {
+	pluginAPI := &plugintest.API{}
+	p := newBackfillPlugin(t, pluginAPI)
+	existing, _ := json.Marshal(ErrorPostMapping{ProjectID: "proj-1", ErrorID: "err-4", PostID: "post-4"})
+	pluginAPI.On("KVGet", pluginID+":"+errorPostKVKey("proj-1", "err-4")).Return(existing, nil)
+	pluginAPI.On("KVGet", mock.Anything).Return(nil, nil)
+
+	var seeded []store.ActiveError
+	pluginAPI.On("KVSet", pluginID+":"+KVKeyActiveErrors, mock.Anything).Run(func(args mock.Arguments) {
+		_ = json.Unmarshal(args.Get(1).([]byte), &seeded)
+	}).Return(nil)
+
+	result, err := p.backfillRule("r1", api.BackfillOptions{Mode: api.BackfillModeSeed, Limit: 5})
+	if err != nil {
+		t.Fatalf("backfillRule() error = %v", err)
+	}
+	if result.Found != 2 || result.Imported != 1 || result.Skipped != 1 {
+		t.Fatalf("unexpected result %+v", result)
+	}
+	if len(seeded) != 1 || seeded[0].ErrorID != "err-1" || seeded[0].ChannelID != "chan-1" || seeded[0].PostID != "" {
+		t.Fatalf("unexpected seeded errors %+v", seeded)
+	}
+}
+
+func TestBackfillRulePostsCards(t *testing.T) {
+	pluginAPI := &plugintest.API{}
+	p := newBackfillPlugin(t, pluginAPI)
+	pluginAPI.On("KVGet", mock.Anything).Return(nil, nil)
+	pluginAPI.On("CreatePost", mock.MatchedBy(func(post *model.Post) bool {
+		return post.ChannelId == "chan-1" && strings.Contains(post.Message, "NoMethodError")
+	})).Return(&model.Post{Id: "post-1", ChannelId: "chan-1"}, nil).Once()
+	pluginAPI.On("KVSet", pluginID+":"+errorPostKVKey("proj-1", "err-1"), mock.MatchedBy(func(data []byte) bool {
+		var mapping ErrorPostMapping
+		return json.Unmarshal(data, &mapping) == nil && mapping.PostID == "post-1"
+	})).Return(nil).Once()
+	pluginAPI.On("KVSet", pluginID+":"+KVKeyActiveErrors, mock.Anything).Return(nil)
+
+	result, err := p.backfillRule("r1", api.BackfillOptions{Mode: api.BackfillModePost, Limit: 1})
+	if err != nil {
+		t.Fatalf("backfillRule() error = %v", err)
+	}
+	if result.Found != 2 || result.Imported != 1 {
+		t.Fatalf("unexpected result %+v", result)
+	}
+
+	pluginAPI.AssertExpectations(t)
+}
+
+func TestExecuteBackfillCommandNeedsSystemAdmin(t *testing.T) {
+	pluginAPI := &plugintest.API{}
+	pluginAPI.On("HasPermissionTo", "user-1", model.PermissionManageSystem).Return(false)
+
+	p := &Plugin{}
+	p.SetAPI(pluginAPI)
+
+	resp, _ := p.ExecuteCommand(nil, &model.CommandArgs{UserId: "user-1", ChannelId: "chan-1", Command: "/bugsnag backfill seed"})
+	if resp.Text != "Only system admins can backfill channel rules." {
+		t.Errorf("unexpected response %q", resp.Text)
+	}
+}
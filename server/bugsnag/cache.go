@@ -0,0 +1,72 @@
+package bugsnag
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultCacheTTL is how long organizations, projects and collaborators
+// fetched by a shared client are reused before Bugsnag is asked again.
+const DefaultCacheTTL = 5 * time.Minute
+
+// responseCache keeps read-only API responses of one client for a TTL.
+type responseCache struct {
+	ttl time.Duration
+	now func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+}
+
+type cacheEntry struct {
+	value   any
+	expires time.Time
+}
+
+func newResponseCache(ttl time.Duration) *responseCache {
+	return &responseCache{ttl: ttl, now: time.Now, entries: map[string]cacheEntry{}}
+}
+
+func (c *responseCache) get(key string) (any, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok || !c.now().Before(entry.expires) {
+		delete(c.entries, key)
+		return nil, false
+	}
+	return entry.value, true
+}
+
+func (c *responseCache) set(key string, value any) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[key] = cacheEntry{value: value, expires: c.now().Add(c.ttl)}
+}
+
+func (c *responseCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries = map[string]cacheEntry{}
+}
+
+// cached returns the cached response under key, calling fetch and caching its
+// result on a miss. Errors are not cached. Clients without a cache always fetch.
+func cached[T any](c *Client, key string, fetch func() (T, error)) (T, error) {
+	if c.cache == nil {
+		return fetch()
+	}
+	if value, ok := c.cache.get(key); ok {
+		return value.(T), nil
+	}
+
+	value, err := fetch()
+	if err != nil {
+		return value, err
+	}
+	c.cache.set(key, value)
+	return value, nil
+}
@@ -0,0 +1,93 @@
+package bugsnag
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSharedClientCachesResponses(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		_, _ = w.Write([]byte(`[{"id":"org-1","name":"Acme"}]`))
+	}))
+	t.Cleanup(server.Close)
+
+	factory, err := NewFactory(Settings{APIURL: server.URL})
+	if err != nil {
+		t.Fatalf("NewFactory() error = %v", err)
+	}
+
+	client, err := factory.Client("token-value")
+	if err != nil {
+		t.Fatalf("Client() error = %v", err)
+	}
+	if again, _ := factory.Client("token-value"); again != client {
+		t.Fatal("expected the same client for the same token")
+	}
+
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	client.cache.now = func() time.Time { return now }
+
+	ctx := context.Background()
+	fetch := func() {
+		t.Helper()
+		orgs, err := client.GetOrganizations(ctx)
+		if err != nil || len(orgs) != 1 || orgs[0].ID != "org-1" {
+			t.Fatalf("GetOrganizations() = %+v, %v", orgs, err)
+		}
+	}
+
+	fetch()
+	fetch()
+	if requests != 1 {
+		t.Fatalf("expected a cached response, got %d requests", requests)
+	}
+
+	client.Refresh()
+	fetch()
+	if requests != 2 {
+		t.Fatalf("expected Refresh to refetch, got %d requests", requests)
+	}
+
+	now = now.Add(DefaultCacheTTL)
+	fetch()
+	if requests != 3 {
+		t.Fatalf("expected an expired entry to be refetched, got %d requests", requests)
+	}
+
+	uncached, _ := factory.NewClient("token-value")
+	if _, err := uncached.GetOrganizations(ctx); err != nil {
+		t.Fatalf("GetOrganizations() error = %v", err)
+	}
+	if requests != 4 {
+		t.Fatalf("expected NewClient not to share the cache, got %d requests", requests)
+	}
+}
+
+func TestSharedClientDoesNotCacheErrors(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			http.Error(w, "rate limited", http.StatusTooManyRequests)
+			return
+		}
+		_, _ = w.Write([]byte(`[{"id":"proj-1","name":"API"}]`))
+	}))
+	t.Cleanup(server.Close)
+
+	factory, _ := NewFactory(Settings{APIURL: server.URL})
+	client, _ := factory.Client("token-value")
+
+	if _, err := client.GetProjects(context.Background(), "org-1"); err == nil {
+		t.Fatal("expected the first request to fail")
+	}
+	projects, err := client.GetProjects(context.Background(), "org-1")
+	if err != nil || len(projects) != 1 {
+		t.Fatalf("GetProjects() = %+v, %v", projects, err)
+	}
+}
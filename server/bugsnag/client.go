@@ -9,8 +9,11 @@ import (
 	"net/http"
 	"net/url"
 	"path"
+	"strconv"
 	"strings"
 	"time"
+
+	"github.com/a-voronkov/mattermost-bugsnag/server/metrics"
 )
 
 // DefaultBaseURL is the standard Bugsnag API endpoint.
@@ -20,10 +23,14 @@ const DefaultBaseURL = "https://api.bugsnag.com"
 const DefaultTimeout = 10 * time.Second
 
 // Client wraps authenticated access to the Bugsnag REST API.
+// Clients from Factory.Client cache organizations, projects and collaborators;
+// callers must treat the returned slices as read-only.
 type Client struct {
 	BaseURL    *url.URL
 	Token      string
 	HTTPClient *http.Client
+
+	cache *responseCache
 }
 
 // Organization represents a Bugsnag organization.
@@ -62,6 +69,8 @@ type ErrorDetails struct {
 	LastSeen      string `json:"last_seen"`
 	AssigneeID    string `json:"assignee_id,omitempty"`
 	URL           string `json:"url,omitempty"`
+	// ReleaseStages lists the release stages the error occurred in.
+	ReleaseStages []string `json:"release_stages,omitempty"`
 }
 
 // Collaborator represents a user with access to a Bugsnag organization.
@@ -71,6 +80,13 @@ type Collaborator struct {
 	Email string `json:"email"`
 }
 
+// User is the Bugsnag account that owns an API token.
+type User struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
 // NewClient constructs a Client instance.
 func NewClient(rawBaseURL, token string, httpClient *http.Client) (*Client, error) {
 	if rawBaseURL == "" {
@@ -93,38 +109,59 @@ func NewClient(rawBaseURL, token string, httpClient *http.Client) (*Client, erro
 	return &Client{BaseURL: baseURL, Token: token, HTTPClient: httpClient}, nil
 }
 
-// GetOrganizations retrieves all organizations accessible by the current user.
-func (c *Client) GetOrganizations(ctx context.Context) ([]Organization, error) {
-	var orgs []Organization
-	if err := c.do(ctx, http.MethodGet, "/user/organizations", nil, &orgs); err != nil {
+// GetCurrentUser retrieves the Bugsnag user the token belongs to.
+func (c *Client) GetCurrentUser(ctx context.Context) (*User, error) {
+	var user User
+	if err := c.do(ctx, http.MethodGet, "/user", nil, &user); err != nil {
 		return nil, err
 	}
 
-	return orgs, nil
+	return &user, nil
+}
+
+// GetOrganizations retrieves all organizations accessible by the current user.
+func (c *Client) GetOrganizations(ctx context.Context) ([]Organization, error) {
+	return cached(c, "/user/organizations", func() ([]Organization, error) {
+		var orgs []Organization
+		if err := c.do(ctx, http.MethodGet, "/user/organizations", nil, &orgs); err != nil {
+			return nil, err
+		}
+		return orgs, nil
+	})
 }
 
 // GetProjects retrieves all projects for the given organization.
 func (c *Client) GetProjects(ctx context.Context, orgID string) ([]Project, error) {
 	endpoint := fmt.Sprintf("/organizations/%s/projects", url.PathEscape(orgID))
 
-	var projects []Project
-	if err := c.do(ctx, http.MethodGet, endpoint, nil, &projects); err != nil {
-		return nil, err
-	}
-
-	return projects, nil
+	return cached(c, endpoint, func() ([]Project, error) {
+		var projects []Project
+		if err := c.do(ctx, http.MethodGet, endpoint, nil, &projects); err != nil {
+			return nil, err
+		}
+		return projects, nil
+	})
 }
 
 // GetCollaborators retrieves all users (collaborators) for the given organization.
 func (c *Client) GetCollaborators(ctx context.Context, orgID string) ([]Collaborator, error) {
 	endpoint := fmt.Sprintf("/organizations/%s/collaborators", url.PathEscape(orgID))
 
-	var collaborators []Collaborator
-	if err := c.do(ctx, http.MethodGet, endpoint, nil, &collaborators); err != nil {
-		return nil, err
-	}
+	return cached(c, endpoint, func() ([]Collaborator, error) {
+		var collaborators []Collaborator
+		if err := c.do(ctx, http.MethodGet, endpoint, nil, &collaborators); err != nil {
+			return nil, err
+		}
+		return collaborators, nil
+	})
+}
 
-	return collaborators, nil
+// Refresh drops cached organizations, projects and collaborators so the next
+// calls fetch them from Bugsnag again. It does nothing on uncached clients.
+func (c *Client) Refresh() {
+	if c.cache != nil {
+		c.cache.clear()
+	}
 }
 
 // GetError retrieves detailed information about a specific error.
@@ -199,8 +236,11 @@ func (c *Client) do(ctx context.Context, method, endpoint string, body any, out
 		return fmt.Errorf("http client is not configured")
 	}
 
-	relPath := path.Clean(endpoint)
-	resolved := c.BaseURL.ResolveReference(&url.URL{Path: relPath})
+	// Join rather than resolve so a base path such as https://host/api is kept.
+	endpoint, query, _ := strings.Cut(endpoint, "?")
+	resolved := *c.BaseURL
+	resolved.Path = strings.TrimRight(c.BaseURL.Path, "/") + path.Clean(endpoint)
+	resolved.RawQuery = query
 
 	var buf bytes.Buffer
 	if body != nil {
@@ -220,11 +260,14 @@ func (c *Client) do(ctx context.Context, method, endpoint string, body any, out
 		req.Header.Set("Content-Type", "application/json")
 	}
 
+	start := time.Now()
 	resp, err := c.HTTPClient.Do(req)
 	if err != nil {
+		metrics.BugsnagRequests.ObserveSince(start, method, "error")
 		return fmt.Errorf("execute request: %w", err)
 	}
 	defer resp.Body.Close()
+	metrics.BugsnagRequests.ObserveSince(start, method, strconv.Itoa(resp.StatusCode))
 
 	if resp.StatusCode >= http.StatusBadRequest {
 		// Read response body to understand the error
@@ -241,11 +284,6 @@ func (c *Client) do(ctx context.Context, method, endpoint string, body any, out
 	return nil
 }
 
-// NewDefaultClient creates a client with the default Bugsnag API URL and timeout.
-func NewDefaultClient(token string) (*Client, error) {
-	return NewClient(DefaultBaseURL, token, &http.Client{Timeout: DefaultTimeout})
-}
-
 // UserMapping connects a Mattermost user to a Bugsnag user record.
 type UserMapping struct {
 	BugsnagUserID string
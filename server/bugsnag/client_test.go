@@ -9,6 +9,7 @@ import (
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 )
 
 func mustReadFixture(t *testing.T, name string) []byte {
@@ -99,3 +100,126 @@ func TestUpdateErrorStatusParsesResponse(t *testing.T) {
 		t.Fatalf("unexpected assignee: %s", status.AssigneeID)
 	}
 }
+
+func TestGetCurrentUserParsesResponse(t *testing.T) {
+	fixture := mustReadFixture(t, "user.json")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/user" {
+			t.Fatalf("unexpected path: %s", r.URL.Path)
+		}
+		if got := r.Header.Get("Authorization"); got != "token personal-token" {
+			t.Fatalf("unexpected authorization header: %q", got)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(fixture)
+	}))
+	t.Cleanup(server.Close)
+
+	client, err := NewClient(server.URL, "personal-token", server.Client())
+	if err != nil {
+		t.Fatalf("new client: %v", err)
+	}
+
+	user, err := client.GetCurrentUser(context.Background())
+	if err != nil {
+		t.Fatalf("GetCurrentUser error: %v", err)
+	}
+
+	if user.ID != "user-42" || user.Name != "Jane Doe" || user.Email != "jane@example.com" {
+		t.Fatalf("unexpected user: %+v", user)
+	}
+}
+
+func TestListErrorsSendsFilters(t *testing.T) {
+	fixture := mustReadFixture(t, "errors.json")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/projects/project-1/errors" {
+			t.Fatalf("unexpected path: %s", r.URL.Path)
+		}
+
+		query := r.URL.Query()
+		want := map[string]string{
+			"filters[error.status][][value]":      "open",
+			"filters[app.release_stage][][value]": "production",
+			"filters[error.first_seen][][type]":   "gt",
+			"filters[error.first_seen][][value]":  "2024-04-01T00:00:00Z",
+			"filters[search][][value]":            "nil name",
+			"sort":                                "last_seen",
+			"per_page":                            "25",
+		}
+		for key, value := range want {
+			if got := query.Get(key); got != value {
+				t.Errorf("%s = %q, want %q", key, got, value)
+			}
+		}
+		if query.Has("filters[event.severity][][value]") {
+			t.Errorf("unexpected severity filter in %s", r.URL.RawQuery)
+		}
+
+		_, _ = w.Write(fixture)
+	}))
+	t.Cleanup(server.Close)
+
+	client, err := NewClient(server.URL, "token-value", server.Client())
+	if err != nil {
+		t.Fatalf("new client: %v", err)
+	}
+
+	results, err := client.ListErrors(context.Background(), "project-1", ErrorFilter{
+		Status:         "open",
+		ReleaseStage:   "production",
+		FirstSeenAfter: time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC),
+		Query:          "nil name",
+		Limit:          100,
+	})
+	if err != nil {
+		t.Fatalf("ListErrors error: %v", err)
+	}
+	if len(results) != 1 || results[0].ErrorClass != "NoMethodError" || results[0].Events != 42 {
+		t.Fatalf("unexpected errors: %+v", results)
+	}
+}
+
+func TestIssueEndpoints(t *testing.T) {
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests = append(requests, r.Method+" "+r.URL.Path+" "+strings.TrimSpace(string(body)))
+		if r.Method == http.MethodGet {
+			_, _ = w.Write([]byte(`{"id":"event-1","exceptions":[{"errorClass":"NoMethodError","message":"undefined method","stacktrace":[{"file":"app.rb","method":"call","lineNumber":12,"inProject":true}]}]}`))
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	client, err := NewClient(server.URL, "token-value", server.Client())
+	if err != nil {
+		t.Fatalf("new client: %v", err)
+	}
+	ctx := context.Background()
+
+	event, err := client.GetLatestEvent(ctx, "project-1", "error-1")
+	if err != nil {
+		t.Fatalf("GetLatestEvent error: %v", err)
+	}
+	if len(event.Exceptions) != 1 || event.Exceptions[0].Stacktrace[0].LineNumber != float64(12) {
+		t.Fatalf("unexpected event: %+v", event)
+	}
+	if err := client.LinkIssue(ctx, "project-1", "error-1", "https://github.com/acme/app/issues/7"); err != nil {
+		t.Fatalf("LinkIssue error: %v", err)
+	}
+	if err := client.CreateComment(ctx, "project-1", "error-1", "Tracked in acme/app#7"); err != nil {
+		t.Fatalf("CreateComment error: %v", err)
+	}
+
+	want := []string{
+		"GET /projects/project-1/errors/error-1/latest_event ",
+		`PATCH /projects/project-1/errors/error-1 {"issue_url":"https://github.com/acme/app/issues/7","operation":"link_issue","verify_issue_url":false}`,
+		`POST /projects/project-1/errors/error-1/comments {"message":"Tracked in acme/app#7"}`,
+	}
+	if strings.Join(requests, "\n") != strings.Join(want, "\n") {
+		t.Errorf("requests =\n%s\nwant\n%s", strings.Join(requests, "\n"), strings.Join(want, "\n"))
+	}
+}
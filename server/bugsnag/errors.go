@@ -0,0 +1,114 @@
+package bugsnag
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// DefaultErrorLimit and MaxErrorLimit bound how many errors ListErrors returns.
+const (
+	DefaultErrorLimit = 10
+	MaxErrorLimit     = 25
+)
+
+// ErrorFilter narrows ListErrors. Empty fields do not filter.
+type ErrorFilter struct {
+	// Status is open, fixed, snoozed or ignored.
+	Status string
+	// Severity is error, warning or info.
+	Severity     string
+	ReleaseStage string
+	// AssigneeID is the collaborator ID errors are assigned to.
+	AssigneeID     string
+	FirstSeenAfter time.Time
+	LastSeenAfter  time.Time
+	// Query is free text matched against the error class, message and context.
+	Query string
+	// Sort is last_seen (the default), first_seen or events; results are
+	// always in descending order.
+	Sort string
+	// Limit caps the number of errors; zero selects DefaultErrorLimit.
+	Limit int
+}
+
+// Validate rejects statuses and severities Bugsnag does not know.
+func (f ErrorFilter) Validate() error {
+	switch f.Status {
+	case "", "open", "fixed", "snoozed", "ignored":
+	default:
+		return fmt.Errorf("unknown status %q: use open, fixed, snoozed or ignored", f.Status)
+	}
+
+	switch f.Severity {
+	case "", "error", "warning", "info":
+	default:
+		return fmt.Errorf("unknown severity %q: use error, warning or info", f.Severity)
+	}
+
+	switch f.Sort {
+	case "", "last_seen", "first_seen", "events":
+	default:
+		return fmt.Errorf("unknown sort %q: use last_seen, first_seen or events", f.Sort)
+	}
+
+	return nil
+}
+
+// ListErrors returns the project's errors matching filter, most recently seen
+// first unless the filter sorts otherwise. Results are never cached.
+func (c *Client) ListErrors(ctx context.Context, projectID string, filter ErrorFilter) ([]ErrorDetails, error) {
+	endpoint := fmt.Sprintf("/projects/%s/errors?%s", url.PathEscape(projectID), filter.values().Encode())
+
+	var results []ErrorDetails
+	if err := c.do(ctx, http.MethodGet, endpoint, nil, &results); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// values encodes the filter in the Data Access API's filters[field][] form.
+func (f ErrorFilter) values() url.Values {
+	values := url.Values{}
+	add := func(field, kind, value string) {
+		if value == "" {
+			return
+		}
+		values.Add("filters["+field+"][][type]", kind)
+		values.Add("filters["+field+"][][value]", value)
+	}
+
+	add("error.status", "eq", f.Status)
+	add("event.severity", "eq", f.Severity)
+	add("app.release_stage", "eq", f.ReleaseStage)
+	add("error.assigned_to", "eq", f.AssigneeID)
+	if !f.FirstSeenAfter.IsZero() {
+		add("error.first_seen", "gt", f.FirstSeenAfter.UTC().Format(time.RFC3339))
+	}
+	if !f.LastSeenAfter.IsZero() {
+		add("event.since", "eq", f.LastSeenAfter.UTC().Format(time.RFC3339))
+	}
+	add("search", "eq", f.Query)
+
+	limit := f.Limit
+	if limit <= 0 {
+		limit = DefaultErrorLimit
+	}
+	if limit > MaxErrorLimit {
+		limit = MaxErrorLimit
+	}
+
+	sort := f.Sort
+	if sort == "" {
+		sort = "last_seen"
+	}
+
+	values.Set("sort", sort)
+	values.Set("direction", "desc")
+	values.Set("per_page", strconv.Itoa(limit))
+	return values
+}
@@ -0,0 +1,166 @@
+package bugsnag
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultDashboardURL is the standard Bugsnag dashboard host that error links
+// in webhook payloads point to.
+const DefaultDashboardURL = "https://app.bugsnag.com"
+
+// Settings describe how to reach a Bugsnag instance. Zero values select the
+// hosted service at bugsnag.com.
+type Settings struct {
+	// APIURL is the Data Access API base URL, e.g. https://bugsnag.example.com:49080.
+	APIURL string
+	// DashboardURL is the web dashboard base URL error links are rewritten to.
+	DashboardURL string
+	// CABundle is a PEM bundle of extra root certificates to trust.
+	CABundle string
+	// ProxyURL sends API requests through an HTTP(S) proxy. When empty the
+	// standard HTTPS_PROXY environment variables apply.
+	ProxyURL string
+	Timeout  time.Duration
+	// CacheTTL is how long shared clients reuse organizations, projects and
+	// collaborators; zero selects DefaultCacheTTL.
+	CacheTTL time.Duration
+}
+
+// Factory builds Bugsnag clients that share one configured HTTP client, so
+// every caller reaches the same instance through the same transport. A new
+// factory is built on every configuration change, which also drops the shared
+// clients and their caches.
+type Factory struct {
+	apiURL       string
+	dashboardURL *url.URL
+	httpClient   *http.Client
+	cacheTTL     time.Duration
+
+	mu      sync.Mutex
+	clients map[string]*Client
+}
+
+// NewFactory validates the settings and prepares the shared HTTP client.
+func NewFactory(settings Settings) (*Factory, error) {
+	apiURL := strings.TrimRight(strings.TrimSpace(settings.APIURL), "/")
+	if apiURL == "" {
+		apiURL = DefaultBaseURL
+	}
+	if err := checkBaseURL(apiURL); err != nil {
+		return nil, fmt.Errorf("API URL: %w", err)
+	}
+
+	dashboard := strings.TrimRight(strings.TrimSpace(settings.DashboardURL), "/")
+	if dashboard == "" {
+		dashboard = DefaultDashboardURL
+	}
+	if err := checkBaseURL(dashboard); err != nil {
+		return nil, fmt.Errorf("dashboard URL: %w", err)
+	}
+	dashboardURL, _ := url.Parse(dashboard)
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if proxy := strings.TrimSpace(settings.ProxyURL); proxy != "" {
+		proxyURL, err := url.Parse(proxy)
+		if err != nil || proxyURL.Host == "" {
+			return nil, fmt.Errorf("proxy URL %q is not a valid URL", proxy)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	if bundle := strings.TrimSpace(settings.CABundle); bundle != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM([]byte(bundle)) {
+			return nil, fmt.Errorf("CA bundle contains no PEM certificates")
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	}
+
+	timeout := settings.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	cacheTTL := settings.CacheTTL
+	if cacheTTL <= 0 {
+		cacheTTL = DefaultCacheTTL
+	}
+
+	return &Factory{
+		apiURL:       apiURL,
+		dashboardURL: dashboardURL,
+		httpClient:   &http.Client{Timeout: timeout, Transport: transport},
+		cacheTTL:     cacheTTL,
+		clients:      map[string]*Client{},
+	}, nil
+}
+
+// DefaultFactory returns a factory for the hosted Bugsnag service.
+func DefaultFactory() *Factory {
+	f, _ := NewFactory(Settings{})
+	return f
+}
+
+// NewClient returns a client for the configured instance authenticated with
+// token. It does not cache responses; use Client for connection tokens.
+func (f *Factory) NewClient(token string) (*Client, error) {
+	return NewClient(f.apiURL, token, f.httpClient)
+}
+
+// Client returns the long-lived client for token, creating it on first use.
+// Shared clients cache organizations, projects and collaborators.
+func (f *Factory) Client(token string) (*Client, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if client, ok := f.clients[token]; ok {
+		return client, nil
+	}
+
+	client, err := f.NewClient(token)
+	if err != nil {
+		return nil, err
+	}
+	client.cache = newResponseCache(f.cacheTTL)
+	f.clients[token] = client
+	return client, nil
+}
+
+// DashboardURL rewrites a link to the hosted Bugsnag dashboard so it points
+// at the configured dashboard instead. Other links are returned unchanged.
+func (f *Factory) DashboardURL(raw string) string {
+	if raw == "" || f.dashboardURL.String() == DefaultDashboardURL {
+		return raw
+	}
+
+	link, err := url.Parse(raw)
+	if err != nil || link.Host != "app.bugsnag.com" {
+		return raw
+	}
+
+	link.Scheme = f.dashboardURL.Scheme
+	link.Host = f.dashboardURL.Host
+	link.Path = strings.TrimRight(f.dashboardURL.Path, "/") + link.Path
+	return link.String()
+}
+
+func checkBaseURL(raw string) error {
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" || parsed.Host == "" {
+		return fmt.Errorf("%q must be an absolute http(s) URL", raw)
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package bugsnag
+
+import (
+	"context"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNewFactoryValidatesSettings(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings Settings
+		wantErr  string
+	}{
+		{name: "defaults", settings: Settings{}},
+		{name: "relative API URL", settings: Settings{APIURL: "bugsnag.example.com"}, wantErr: "API URL"},
+		{name: "bad dashboard URL", settings: Settings{DashboardURL: "ftp://bugsnag.example.com"}, wantErr: "dashboard URL"},
+		{name: "bad proxy", settings: Settings{ProxyURL: "::"}, wantErr: "proxy URL"},
+		{name: "bad CA bundle", settings: Settings{CABundle: "not a certificate"}, wantErr: "CA bundle"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewFactory(tt.settings)
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestFactoryClientUsesBasePathAndCABundle(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/bugsnag-api/user" {
+			t.Errorf("expected the base path to be kept, got %s", r.URL.Path)
+		}
+		_, _ = w.Write([]byte(`{"id":"user-1"}`))
+	}))
+	t.Cleanup(server.Close)
+
+	caBundle := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	factory, err := NewFactory(Settings{APIURL: server.URL + "/bugsnag-api/", CABundle: string(caBundle)})
+	if err != nil {
+		t.Fatalf("NewFactory() error = %v", err)
+	}
+
+	client, err := factory.NewClient("token-value")
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	user, err := client.GetCurrentUser(context.Background())
+	if err != nil || user.ID != "user-1" {
+		t.Fatalf("GetCurrentUser() = %+v, %v", user, err)
+	}
+
+	// Without the bundle the self-signed certificate is rejected.
+	untrusted, _ := NewFactory(Settings{APIURL: server.URL})
+	client, _ = untrusted.NewClient("token-value")
+	if _, err := client.GetCurrentUser(context.Background()); err == nil {
+		t.Fatal("expected the untrusted certificate to be rejected")
+	}
+}
+
+func TestFactoryClientUsesProxy(t *testing.T) {
+	var proxied string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = r.URL.String()
+		_, _ = w.Write([]byte(`{"id":"user-1"}`))
+	}))
+	t.Cleanup(proxy.Close)
+
+	factory, err := NewFactory(Settings{APIURL: "http://bugsnag.internal:49080", ProxyURL: proxy.URL})
+	if err != nil {
+		t.Fatalf("NewFactory() error = %v", err)
+	}
+	client, _ := factory.NewClient("token-value")
+	if _, err := client.GetCurrentUser(context.Background()); err != nil {
+		t.Fatalf("GetCurrentUser() error = %v", err)
+	}
+	if proxied != "http://bugsnag.internal:49080/user" {
+		t.Errorf("expected the request to go through the proxy, got %q", proxied)
+	}
+}
+
+func TestFactoryDashboardURL(t *testing.T) {
+	link := "https://app.bugsnag.com/acme/api/errors/123?event_id=9"
+
+	if got := DefaultFactory().DashboardURL(link); got != link {
+		t.Errorf("expected the hosted dashboard link unchanged, got %s", got)
+	}
+
+	factory, _ := NewFactory(Settings{DashboardURL: "https://bugsnag.example.com:8443/dashboard/"})
+	if got := factory.DashboardURL(link); got != "https://bugsnag.example.com:8443/dashboard/acme/api/errors/123?event_id=9" {
+		t.Errorf("DashboardURL() = %s", got)
+	}
+	if got := factory.DashboardURL("https://github.com/acme/api"); got != "https://github.com/acme/api" {
+		t.Errorf("expected links to other hosts unchanged, got %s", got)
+	}
+}
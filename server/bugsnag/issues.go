@@ -0,0 +1,73 @@
+package bugsnag
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// Event is one occurrence of an error. Only the fields the plugin uses are
+// decoded.
+type Event struct {
+	ID         string           `json:"id"`
+	Exceptions []EventException `json:"exceptions"`
+}
+
+// EventException is one entry in an event's cause chain.
+type EventException struct {
+	ErrorClass string       `json:"errorClass"`
+	Message    string       `json:"message"`
+	Stacktrace []EventFrame `json:"stacktrace"`
+}
+
+// EventFrame is a stack frame of an event. Line and column numbers are kept
+// raw because Bugsnag sends them as numbers or strings depending on the
+// platform.
+type EventFrame struct {
+	File         string            `json:"file"`
+	Method       string            `json:"method"`
+	LineNumber   any               `json:"lineNumber"`
+	ColumnNumber any               `json:"columnNumber"`
+	InProject    bool              `json:"inProject"`
+	Code         map[string]string `json:"code"`
+}
+
+// GetLatestEvent returns the most recent event of an error.
+func (c *Client) GetLatestEvent(ctx context.Context, projectID, errorID string) (*Event, error) {
+	endpoint := fmt.Sprintf("/projects/%s/errors/%s/latest_event", url.PathEscape(projectID), url.PathEscape(errorID))
+
+	var event Event
+	if err := c.do(ctx, http.MethodGet, endpoint, nil, &event); err != nil {
+		return nil, err
+	}
+
+	return &event, nil
+}
+
+// LinkIssue links an issue-tracker issue to an error, replacing any issue
+// linked before. Bugsnag does not check that the URL exists.
+func (c *Client) LinkIssue(ctx context.Context, projectID, errorID, issueURL string) error {
+	if issueURL == "" {
+		return fmt.Errorf("issue URL is required")
+	}
+
+	payload := map[string]any{
+		"operation":        "link_issue",
+		"issue_url":        issueURL,
+		"verify_issue_url": false,
+	}
+
+	endpoint := fmt.Sprintf("/projects/%s/errors/%s", url.PathEscape(projectID), url.PathEscape(errorID))
+	return c.do(ctx, http.MethodPatch, endpoint, payload, nil)
+}
+
+// CreateComment adds a comment to an error.
+func (c *Client) CreateComment(ctx context.Context, projectID, errorID, message string) error {
+	payload := map[string]string{
+		"message": message,
+	}
+
+	endpoint := fmt.Sprintf("/projects/%s/errors/%s/comments", url.PathEscape(projectID), url.PathEscape(errorID))
+	return c.do(ctx, http.MethodPost, endpoint, payload, nil)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin"
+)
+
+const commandTrigger = "bugsnag"
+
+const commandHelp = "###### Bugsnag commands\n" +
+	"* `/bugsnag token set <token> [connection]` - link your Bugsnag personal auth token so card actions run as you\n" +
+	"* `/bugsnag token clear [connection]` - remove your linked token\n" +
+	"* `/bugsnag token status` - show the tokens you have linked\n" +
+	"* `/bugsnag errors search [filters] [text]` - find Bugsnag errors and post their cards here\n" +
+	"* `/bugsnag backfill [rule] [post|seed] [limit]` - import the open errors of this channel's project (system admins)\n" +
+	"* `/bugsnag oncall [rotation]` - show who is on call, or a rotation's upcoming shifts\n" +
+	"* `/bugsnag oncall override <rotation> @user [duration]` - put someone else on call (system admins and rotation members)\n" +
+	"* `/bugsnag oncall clear <rotation>` - remove a rotation's override\n" +
+	"* `/bugsnag help` - show this message"
+
+// commandHandler runs a /bugsnag subcommand with the arguments that follow it
+// and returns the ephemeral reply.
+type commandHandler func(p *Plugin, args *model.CommandArgs, params []string) *model.CommandResponse
+
+var commandHandlers = map[string]commandHandler{
+	"token":    textCommand((*Plugin).executeTokenCommand),
+	"errors":   (*Plugin).executeErrorsCommand,
+	"backfill": textCommand((*Plugin).executeBackfillCommand),
+	"oncall":   textCommand((*Plugin).executeOnCallCommand),
+}
+
+// textCommand adapts a subcommand whose reply is plain text.
+func textCommand(handler func(p *Plugin, args *model.CommandArgs, params []string) string) commandHandler {
+	return func(p *Plugin, args *model.CommandArgs, params []string) *model.CommandResponse {
+		return commandResponse(handler(p, args, params))
+	}
+}
+
+// newCommand describes the /bugsnag slash command and its autocomplete tree.
+func newCommand() *model.Command {
+	token := model.NewAutocompleteData("token", "[set|clear|status]", "Manage your personal Bugsnag token")
+
+	set := model.NewAutocompleteData("set", "<token> [connection]", "Link your Bugsnag personal auth token")
+	set.AddTextArgument("Bugsnag personal auth token", "<token>", "")
+	set.AddTextArgument("Connection ID, when several Bugsnag organizations are configured", "[connection]", "")
+	token.AddCommand(set)
+
+	clear := model.NewAutocompleteData("clear", "[connection]", "Remove your linked token")
+	clear.AddTextArgument("Connection ID", "[connection]", "")
+	token.AddCommand(clear)
+
+	token.AddCommand(model.NewAutocompleteData("status", "", "Show the tokens you have linked"))
+
+	errors := model.NewAutocompleteData("errors", "search", "Find Bugsnag errors")
+	search := model.NewAutocompleteData("search", "[filters] [text]", "Search a project's errors, e.g. project:api status:open last-seen:24h timeout")
+	search.AddTextArgument("project:, status:, severity:, stage:, assignee:, first-seen:, last-seen:, limit:, connection: and free text", "[filters] [text]", "")
+	errors.AddCommand(search)
+
+	backfill := model.NewAutocompleteData("backfill", "[rule] [post|seed] [limit]", "Import the open errors of this channel's project")
+	backfill.AddTextArgument("Channel rule ID, post or seed, and how many errors to import", "[rule] [post|seed] [limit]", "")
+	backfill.RoleID = model.SystemAdminRoleId
+
+	oncall := model.NewAutocompleteData("oncall", "[rotation|override|clear]", "Show and override on-call rotations")
+	override := model.NewAutocompleteData("override", "<rotation> @user [duration]", "Put someone else on call")
+	override.AddTextArgument("Rotation ID, user and an optional duration such as 8h or 2d", "<rotation> @user [duration]", "")
+	oncall.AddCommand(override)
+	clearOverride := model.NewAutocompleteData("clear", "<rotation>", "Remove a rotation's override")
+	clearOverride.AddTextArgument("Rotation ID", "<rotation>", "")
+	oncall.AddCommand(clearOverride)
+
+	root := model.NewAutocompleteData(commandTrigger, "[command]", "Available commands: token, errors, backfill, oncall, help")
+	root.AddCommand(token)
+	root.AddCommand(errors)
+	root.AddCommand(backfill)
+	root.AddCommand(oncall)
+	root.AddCommand(model.NewAutocompleteData("help", "", "Show help"))
+
+	return &model.Command{
+		Trigger:          commandTrigger,
+		DisplayName:      "Bugsnag",
+		Description:      "Interact with the Bugsnag integration.",
+		AutoComplete:     true,
+		AutoCompleteDesc: "Available commands: token, errors, backfill, oncall, help",
+		AutoCompleteHint: "[command]",
+		AutocompleteData: root,
+	}
+}
+
+// ExecuteCommand dispatches /bugsnag subcommands. Every reply is ephemeral.
+func (p *Plugin) ExecuteCommand(_ *plugin.Context, args *model.CommandArgs) (*model.CommandResponse, *model.AppError) {
+	fields := strings.Fields(args.Command)
+	if len(fields) < 2 || fields[1] == "help" {
+		return commandResponse(commandHelp), nil
+	}
+
+	handler, ok := commandHandlers[fields[1]]
+	if !ok {
+		return commandResponse(fmt.Sprintf("Unknown command `%s`.\n\n%s", fields[1], commandHelp)), nil
+	}
+
+	return handler(p, args, fields[2:]), nil
+}
+
+func commandResponse(text string) *model.CommandResponse {
+	return &model.CommandResponse{
+		ResponseType: model.CommandResponseTypeEphemeral,
+		Text:         text,
+	}
+}
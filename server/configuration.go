@@ -3,6 +3,12 @@ package main
 import (
 	"fmt"
 	"strings"
+
+	"github.com/a-voronkov/mattermost-bugsnag/server/bugsnag"
+	"github.com/a-voronkov/mattermost-bugsnag/server/connection"
+	"github.com/a-voronkov/mattermost-bugsnag/server/formatter"
+	"github.com/a-voronkov/mattermost-bugsnag/server/outbound"
+	"github.com/a-voronkov/mattermost-bugsnag/server/tracker"
 )
 
 // Configuration collects the server-side settings supplied via System Console.
@@ -14,6 +20,52 @@ type Configuration struct {
 	WebhookToken    string
 	EnableDebugLog  bool
 	SyncIntervalSec int
+
+	// BugsnagAPIURL and BugsnagDashboardURL point the plugin at an on-premise
+	// or regional Bugsnag instance; empty values select bugsnag.com.
+	BugsnagAPIURL       string
+	BugsnagDashboardURL string
+	// BugsnagCABundle is a PEM bundle of extra root certificates trusted for
+	// API requests, for instances behind a private CA.
+	BugsnagCABundle string
+	// BugsnagProxyURL routes API requests through an HTTP(S) proxy.
+	BugsnagProxyURL string
+
+	// Connections is a JSON list of additional named Bugsnag organizations,
+	// each with its own API token; see connection.Connection.
+	Connections string
+
+	// StacktraceMaxFrames limits the frames shown per exception in the
+	// stacktrace reply; longer traces are attached as a file.
+	StacktraceMaxFrames             int
+	StacktraceCollapseLibraryFrames bool
+
+	// DeliveryLogSize is how many recent webhook deliveries are kept for
+	// inspection and replay; 0 disables the log.
+	DeliveryLogSize int
+	// DeliveryLogRedact removes end-user data from logged payloads.
+	DeliveryLogRedact bool
+
+	// EncryptionKey wraps the keyring's master secrets in the KV store, so
+	// the KV data alone cannot decrypt stored secrets. The plugin generates
+	// it when empty. On installs that set it before the keyring existed it
+	// also seeded the keyring, so tokens encrypted with it stay readable.
+	EncryptionKey string
+	// RequireUserTokens stops card actions from falling back to the shared
+	// connection token for users who have not linked their own.
+	RequireUserTokens bool
+
+	// IssueTrackers is a JSON list of the Jira and GitHub trackers cards can
+	// create tickets in; see tracker.Tracker.
+	IssueTrackers string
+
+	// OutboundWebhooks is a JSON list of the endpoints plugin events are
+	// forwarded to; see outbound.Endpoint.
+	OutboundWebhooks string
+
+	// MetricsToken is the bearer token Prometheus must send to scrape
+	// /metrics; empty disables the endpoint.
+	MetricsToken string
 }
 
 // Clone returns a shallow copy. Useful when we start adding mutable slices/maps.
@@ -27,11 +79,39 @@ func (c *Configuration) Clone() Configuration {
 func (c *Configuration) Validate() error {
 	missing := []string{}
 
-	if strings.TrimSpace(c.BugsnagAPIToken) == "" {
+	connections, err := connection.Parse(c.Connections)
+	if err != nil {
+		return fmt.Errorf("invalid Bugsnag connections: %w", err)
+	}
+
+	if _, err := tracker.Parse(c.IssueTrackers); err != nil {
+		return fmt.Errorf("invalid issue trackers: %w", err)
+	}
+
+	if _, err := outbound.Parse(c.OutboundWebhooks); err != nil {
+		return fmt.Errorf("invalid outbound webhooks: %w", err)
+	}
+
+	if _, err := bugsnag.NewFactory(c.bugsnagSettings()); err != nil {
+		return fmt.Errorf("invalid Bugsnag instance settings: %w", err)
+	}
+
+	if strings.TrimSpace(c.BugsnagAPIToken) == "" && len(connections) == 0 {
 		missing = append(missing, "Bugsnag API Token")
 	}
 
-	if strings.TrimSpace(c.WebhookToken) == "" && strings.TrimSpace(c.WebhookSecret) == "" {
+	// Every connection needs a webhook token, its own or the global one; the
+	// default connection always uses the global one.
+	needsWebhookToken := false
+	if c.webhookToken() == "" {
+		needsWebhookToken = strings.TrimSpace(c.BugsnagAPIToken) != "" || len(connections) == 0
+		for _, conn := range connections {
+			if conn.WebhookToken == "" {
+				needsWebhookToken = true
+			}
+		}
+	}
+	if needsWebhookToken {
 		missing = append(missing, "Webhook Token/Secret")
 	}
 
@@ -39,9 +119,66 @@ func (c *Configuration) Validate() error {
 		c.SyncIntervalSec = 300
 	}
 
+	if c.StacktraceMaxFrames <= 0 {
+		c.StacktraceMaxFrames = formatter.DefaultStacktraceMaxFrames
+	}
+
 	if len(missing) > 0 {
 		return fmt.Errorf("missing required configuration: %s", strings.Join(missing, ", "))
 	}
 
 	return nil
 }
+
+// bugsnagSettings returns how clients reach the configured Bugsnag instance.
+func (c Configuration) bugsnagSettings() bugsnag.Settings {
+	return bugsnag.Settings{
+		APIURL:       c.BugsnagAPIURL,
+		DashboardURL: c.BugsnagDashboardURL,
+		CABundle:     c.BugsnagCABundle,
+		ProxyURL:     c.BugsnagProxyURL,
+	}
+}
+
+// webhookToken returns the global webhook token, accepting the older
+// WebhookSecret name.
+func (c Configuration) webhookToken() string {
+	if token := strings.TrimSpace(c.WebhookToken); token != "" {
+		return token
+	}
+	return strings.TrimSpace(c.WebhookSecret)
+}
+
+// AllConnections returns the default connection built from BugsnagAPIToken
+// and OrganizationID, when a token is set, followed by the connections in the
+// Connections setting. Invalid Connections JSON is reported by Validate and
+// ignored here.
+func (c Configuration) AllConnections() []connection.Connection {
+	var all []connection.Connection
+	if token := strings.TrimSpace(c.BugsnagAPIToken); token != "" {
+		all = append(all, connection.Connection{
+			ID:             connection.DefaultID,
+			Name:           "Default",
+			APIToken:       token,
+			OrganizationID: strings.TrimSpace(c.OrganizationID),
+			WebhookToken:   c.webhookToken(),
+		})
+	}
+
+	extra, _ := connection.Parse(c.Connections)
+	return append(all, extra...)
+}
+
+// issueTrackers returns the configured issue trackers. Invalid IssueTrackers
+// JSON is reported by Validate and ignored here.
+func (c Configuration) issueTrackers() []tracker.Tracker {
+	trackers, _ := tracker.Parse(c.IssueTrackers)
+	return trackers
+}
+
+// outboundEndpoints returns the configured outbound webhook endpoints. Invalid
+// OutboundWebhooks JSON is reported by Validate and ignored here.
+func (c Configuration) outboundEndpoints() []outbound.Endpoint {
+	endpoints, _ := outbound.Parse(c.OutboundWebhooks)
+	return endpoints
+}
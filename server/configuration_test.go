@@ -0,0 +1,90 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/a-voronkov/mattermost-bugsnag/server/connection"
+)
+
+func TestConfigurationAllConnections(t *testing.T) {
+	cfg := Configuration{
+		BugsnagAPIToken: "t-default",
+		OrganizationID:  "org-1",
+		WebhookSecret:   "secret",
+		Connections:     `[{"id":"mobile","api_token":"t-mobile"}]`,
+	}
+
+	connections := cfg.AllConnections()
+	if len(connections) != 2 {
+		t.Fatalf("expected the default and mobile connections, got %+v", connections)
+	}
+	if connections[0].ID != connection.DefaultID || connections[0].OrganizationID != "org-1" || connections[0].WebhookToken != "secret" {
+		t.Errorf("unexpected default connection %+v", connections[0])
+	}
+	if connections[1].ID != "mobile" || connections[1].APIToken != "t-mobile" {
+		t.Errorf("unexpected mobile connection %+v", connections[1])
+	}
+
+	if got := (Configuration{Connections: "not json"}).AllConnections(); len(got) != 0 {
+		t.Errorf("expected invalid connections to be ignored, got %+v", got)
+	}
+}
+
+func TestConfigurationValidateConnections(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     Configuration
+		wantErr string
+	}{
+		{
+			name: "single token",
+			cfg:  Configuration{BugsnagAPIToken: "t", WebhookToken: "w"},
+		},
+		{
+			name: "connections only, each with its own webhook token",
+			cfg:  Configuration{Connections: `[{"id":"mobile","api_token":"t","webhook_token":"w"}]`},
+		},
+		{
+			name:    "connection without any webhook token",
+			cfg:     Configuration{Connections: `[{"id":"mobile","api_token":"t"}]`},
+			wantErr: "Webhook Token/Secret",
+		},
+		{
+			name:    "default connection without the global webhook token",
+			cfg:     Configuration{BugsnagAPIToken: "t", Connections: `[{"id":"mobile","api_token":"t","webhook_token":"w"}]`},
+			wantErr: "Webhook Token/Secret",
+		},
+		{
+			name: "on-premise instance",
+			cfg:  Configuration{BugsnagAPIToken: "t", WebhookToken: "w", BugsnagAPIURL: "https://bugsnag.example.com:49080", BugsnagDashboardURL: "https://bugsnag.example.com"},
+		},
+		{
+			name:    "relative API URL",
+			cfg:     Configuration{BugsnagAPIToken: "t", WebhookToken: "w", BugsnagAPIURL: "bugsnag.example.com"},
+			wantErr: "invalid Bugsnag instance settings",
+		},
+		{
+			name:    "no API token at all",
+			cfg:     Configuration{WebhookToken: "w"},
+			wantErr: "Bugsnag API Token",
+		},
+		{
+			name:    "invalid connections",
+			cfg:     Configuration{BugsnagAPIToken: "t", WebhookToken: "w", Connections: `[{"id":"mobile"}]`},
+			wantErr: "api_token is required",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.cfg.Validate()
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("Validate() error = %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("Validate() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+// Package connection describes the Bugsnag accounts the plugin talks to. Each
+// connection pairs an API token with an organization and, optionally, its own
+// webhook token so deliveries can be told apart.
+package connection
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// DefaultID identifies the connection built from the single-token settings.
+const DefaultID = "default"
+
+// Connection is one named Bugsnag organization and the credentials to reach it.
+type Connection struct {
+	ID             string `json:"id"`
+	Name           string `json:"name,omitempty"`
+	APIToken       string `json:"api_token"`
+	OrganizationID string `json:"organization_id,omitempty"`
+	// WebhookToken authenticates deliveries for this connection. When empty,
+	// the global webhook token is used.
+	WebhookToken string `json:"webhook_token,omitempty"`
+}
+
+// DisplayName returns the name, falling back to the ID.
+func (c Connection) DisplayName() string {
+	if name := strings.TrimSpace(c.Name); name != "" {
+		return name
+	}
+	return c.ID
+}
+
+// Parse decodes and validates a JSON list of connections, as entered in the
+// plugin settings. An empty string yields no connections.
+func Parse(raw string) ([]Connection, error) {
+	if strings.TrimSpace(raw) == "" {
+		return nil, nil
+	}
+
+	var connections []Connection
+	if err := json.Unmarshal([]byte(raw), &connections); err != nil {
+		return nil, fmt.Errorf("decode connections: %w", err)
+	}
+
+	seen := map[string]bool{}
+	for i := range connections {
+		c := &connections[i]
+		c.ID = strings.TrimSpace(c.ID)
+		c.APIToken = strings.TrimSpace(c.APIToken)
+		c.OrganizationID = strings.TrimSpace(c.OrganizationID)
+		c.WebhookToken = strings.TrimSpace(c.WebhookToken)
+
+		switch {
+		case c.ID == "":
+			return nil, fmt.Errorf("connection %d: id is required", i+1)
+		case strings.ContainsAny(c.ID, "/?#: "):
+			return nil, fmt.Errorf("connection %q: id must not contain '/', '?', '#', ':' or spaces", c.ID)
+		case c.ID == DefaultID:
+			return nil, fmt.Errorf("connection id %q is reserved for the main API token setting", DefaultID)
+		case seen[c.ID]:
+			return nil, fmt.Errorf("connection %q is defined more than once", c.ID)
+		case c.APIToken == "":
+			return nil, fmt.Errorf("connection %q: api_token is required", c.ID)
+		}
+		seen[c.ID] = true
+	}
+
+	return connections, nil
+}
+
+// Find returns the connection with the given ID. An empty ID selects the
+// first connection, which is the default one when it is configured; records
+// saved before connections existed have no connection ID.
+func Find(connections []Connection, id string) (Connection, bool) {
+	id = strings.TrimSpace(id)
+	if id == "" {
+		if len(connections) == 0 {
+			return Connection{}, false
+		}
+		return connections[0], true
+	}
+
+	for _, c := range connections {
+		if c.ID == id {
+			return c, true
+		}
+	}
+	return Connection{}, false
+}
+
+// Same reports whether two connection IDs refer to the same connection, with
+// an empty ID standing for the first one.
+func Same(connections []Connection, a, b string) bool {
+	first, ok := Find(connections, a)
+	if !ok {
+		return strings.TrimSpace(a) == strings.TrimSpace(b)
+	}
+	second, ok := Find(connections, b)
+	return ok && first.ID == second.ID
+}
+
+// Public strips credentials so a connection can be shown to clients.
+func (c Connection) Public() Connection {
+	return Connection{ID: c.ID, Name: c.DisplayName(), OrganizationID: c.OrganizationID}
+}
@@ -0,0 +1,58 @@
+package connection
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	connections, err := Parse(`[{"id":"mobile","name":"Mobile","api_token":" t1 ","organization_id":"o1"},{"id":"web","api_token":"t2","webhook_token":"w2"}]`)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if len(connections) != 2 || connections[0].APIToken != "t1" || connections[1].WebhookToken != "w2" {
+		t.Fatalf("unexpected connections %+v", connections)
+	}
+	if connections[1].DisplayName() != "web" {
+		t.Errorf("expected the ID as display name, got %q", connections[1].DisplayName())
+	}
+
+	if connections, err := Parse("  "); err != nil || connections != nil {
+		t.Errorf("expected no connections for an empty setting, got %v, %v", connections, err)
+	}
+
+	tests := map[string]string{
+		`not json`:                           "decode",
+		`[{"api_token":"t"}]`:                "id is required",
+		`[{"id":"a b","api_token":"t"}]`:     "must not contain",
+		`[{"id":"default","api_token":"t"}]`: "reserved",
+		`[{"id":"a","api_token":"t"},{"id":"a","api_token":"u"}]`: "more than once",
+		`[{"id":"a"}]`: "api_token is required",
+	}
+	for raw, want := range tests {
+		if _, err := Parse(raw); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Parse(%s) error = %v, want %q", raw, err, want)
+		}
+	}
+}
+
+func TestFindAndSame(t *testing.T) {
+	connections := []Connection{{ID: DefaultID}, {ID: "mobile"}}
+
+	if c, ok := Find(connections, ""); !ok || c.ID != DefaultID {
+		t.Errorf("expected an empty ID to select the first connection, got %+v", c)
+	}
+	if c, ok := Find(connections, "mobile"); !ok || c.ID != "mobile" {
+		t.Errorf("Find(mobile) = %+v, %v", c, ok)
+	}
+	if _, ok := Find(connections, "web"); ok {
+		t.Error("expected an unknown connection not to be found")
+	}
+	if _, ok := Find(nil, ""); ok {
+		t.Error("expected no connection without configuration")
+	}
+
+	if !Same(connections, "", DefaultID) || Same(connections, "", "mobile") || !Same(connections, "mobile", "mobile") {
+		t.Error("unexpected Same results")
+	}
+}
@@ -14,4 +14,15 @@ const (
 	KVKeyUserMappings           = kvkeys.UserMappings
 	KVKeyActiveErrors           = kvkeys.ActiveErrors
 	KVKeyErrorPostPrefix        = kvkeys.ErrorPostPrefix
+	KVKeyReleasePostPrefix      = kvkeys.ReleasePostPrefix
+	KVKeyAuditLog               = kvkeys.AuditLog
+	KVKeyHealth                 = kvkeys.Health
+	KVKeyDeliveries             = kvkeys.Deliveries
+	KVKeyCardTemplates          = kvkeys.CardTemplates
+	KVKeyRepositories           = kvkeys.Repositories
+	KVKeyUserTokenPrefix        = kvkeys.UserTokenPrefix
+	KVKeyKeyring                = kvkeys.Keyring
+	KVKeyEscalations            = kvkeys.Escalations
+	KVKeyOnCallRotations        = kvkeys.OnCallRotations
+	KVKeyTicketClaimPrefix      = kvkeys.TicketClaimPrefix
 )
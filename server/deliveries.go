@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/a-voronkov/mattermost-bugsnag/server/connection"
+	"github.com/a-voronkov/mattermost-bugsnag/server/store"
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+const (
+	// maxWebhookBodyBytes bounds how much of a webhook request is read.
+	maxWebhookBodyBytes = 10 << 20
+	// maxDeliveryBodyBytes bounds the payload kept per logged delivery so the
+	// whole log fits in one KV value.
+	maxDeliveryBodyBytes = 64 << 10
+
+	deliveryValidationOK     = "ok"
+	deliveryValidationReplay = "replayed"
+)
+
+// deliverySecrets are headers and query parameters whose values are never logged.
+var deliverySecrets = []string{"token", "x-bugsnag-token", "authorization", "cookie"}
+
+// newDelivery builds the log entry for an incoming webhook request. Secrets are
+// always masked; with redact set, personal data is removed from the payload.
+func newDelivery(r *http.Request, body []byte, redact bool) store.Delivery {
+	delivery := store.Delivery{
+		ID:           model.NewId(),
+		ReceivedAt:   time.Now().UTC(),
+		Remote:       r.RemoteAddr,
+		Headers:      map[string]string{},
+		MatchedRules: []string{},
+	}
+
+	for name, values := range r.Header {
+		delivery.Headers[name] = maskSecret(name, strings.Join(values, ", "))
+	}
+	if query := r.URL.Query(); len(query) > 0 {
+		delivery.Query = map[string]string{}
+		for name, values := range query {
+			delivery.Query[name] = maskSecret(name, strings.Join(values, ", "))
+		}
+	}
+
+	if redact {
+		body = redactDeliveryBody(body)
+		delivery.Redacted = true
+	}
+	if len(body) > maxDeliveryBodyBytes {
+		body = body[:maxDeliveryBodyBytes]
+		delivery.Truncated = true
+	}
+	delivery.Body = string(body)
+
+	return delivery
+}
+
+func maskSecret(name, value string) string {
+	if value != "" && containsValue(deliverySecrets, name) {
+		return redactedValue
+	}
+	return value
+}
+
+// redactDeliveryBody removes the end user's identity, hostname and request
+// query string from a Bugsnag payload. Bodies that are not JSON objects are
+// dropped entirely since they cannot be redacted field by field.
+func redactDeliveryBody(body []byte) []byte {
+	var payload map[string]any
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil
+	}
+
+	if errorBlock, ok := payload["error"].(map[string]any); ok {
+		if user, ok := errorBlock["user"].(map[string]any); ok {
+			for field := range user {
+				user[field] = redactedValue
+			}
+		}
+		if device, ok := errorBlock["device"].(map[string]any); ok {
+			if _, ok := device["hostname"]; ok {
+				device["hostname"] = redactedValue
+			}
+		}
+		if requestURL, ok := errorBlock["requestUrl"].(string); ok && requestURL != "" {
+			errorBlock["requestUrl"] = stripURLQuery(requestURL)
+		}
+	}
+
+	redacted, err := json.Marshal(payload)
+	if err != nil {
+		return nil
+	}
+	return redacted
+}
+
+// apply copies the pipeline's result onto a delivery log entry.
+func (res webhookResult) apply(delivery *store.Delivery) {
+	delivery.Outcome = res.outcome
+	delivery.MatchedRules = res.matchedRules
+	delivery.Processed = res.processed
+	delivery.Errors = res.errors
+}
+
+// recordDelivery appends to the delivery log when it is enabled.
+func (p *Plugin) recordDelivery(cfg Configuration, delivery store.Delivery) {
+	if cfg.DeliveryLogSize <= 0 {
+		return
+	}
+
+	s := store.New(&pluginKVAdapter{api: p.API, namespace: p.kvNS()})
+	if err := s.AppendDelivery(delivery, cfg.DeliveryLogSize); err != nil {
+		p.API.LogWarn("failed to record webhook delivery", "err", err.Error())
+	}
+}
+
+// replayDelivery runs a logged delivery through the webhook pipeline again,
+// skipping token validation, and logs the replay as a new delivery.
+func (p *Plugin) replayDelivery(id string) (store.Delivery, error) {
+	cfg := p.getConfiguration()
+	s := store.New(&pluginKVAdapter{api: p.API, namespace: p.kvNS()})
+
+	original, err := s.GetDelivery(id)
+	if err != nil {
+		return store.Delivery{}, err
+	}
+	if original.Truncated {
+		return store.Delivery{}, store.ErrDeliveryTruncated
+	}
+
+	conn, ok := connection.Find(cfg.AllConnections(), original.Connection)
+	if !ok && original.Connection != "" {
+		return store.Delivery{}, fmt.Errorf("connection %q is no longer configured", original.Connection)
+	}
+
+	replay := store.Delivery{
+		ID:         model.NewId(),
+		ReceivedAt: time.Now().UTC(),
+		ReplayOf:   original.ID,
+		Connection: conn.ID,
+		Headers:    original.Headers,
+		Query:      original.Query,
+		Body:       original.Body,
+		Redacted:   original.Redacted,
+		Validation: deliveryValidationReplay,
+	}
+
+	p.API.LogInfo("replaying webhook delivery", "delivery_id", original.ID)
+
+	mm := newMMClient(p.API, cfg.EnableDebugLog, p.kvNS(), p.botUserID)
+	result := p.processWebhook(mm, cfg, conn, []byte(original.Body), original.Query["channel_id"])
+	result.apply(&replay)
+	p.recordDelivery(cfg, replay)
+
+	return replay, nil
+}
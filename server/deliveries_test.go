@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/a-voronkov/mattermost-bugsnag/server/metrics"
+	"github.com/a-voronkov/mattermost-bugsnag/server/store"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestNewDeliveryRedacts(t *testing.T) {
+	body := `{"trigger":{"type":"exception"},"error":{"errorId":"e1","requestUrl":"https://shop.example.com/cart?session=abc",` +
+		`"user":{"id":"u1","email":"jane@example.com"},"device":{"hostname":"web-1","osName":"Linux"}}}`
+
+	req := httptest.NewRequest(http.MethodPost, "/webhook?token=secret&channel_id=c1", strings.NewReader(body))
+	req.Header.Set("X-Bugsnag-Token", "secret")
+	req.Header.Set("Content-Type", "application/json")
+
+	delivery := newDelivery(req, []byte(body), true)
+
+	if delivery.Query["token"] != redactedValue || delivery.Headers["X-Bugsnag-Token"] != redactedValue {
+		t.Errorf("expected tokens to be masked, got query %v headers %v", delivery.Query, delivery.Headers)
+	}
+	if delivery.Query["channel_id"] != "c1" || delivery.Headers["Content-Type"] != "application/json" {
+		t.Errorf("expected other values to be kept, got query %v headers %v", delivery.Query, delivery.Headers)
+	}
+	if !delivery.Redacted {
+		t.Error("expected the delivery to be marked redacted")
+	}
+	for _, leaked := range []string{"jane@example.com", "u1", "web-1", "session=abc", "secret"} {
+		if strings.Contains(delivery.Body, leaked) {
+			t.Errorf("expected %q to be redacted from %s", leaked, delivery.Body)
+		}
+	}
+	if !strings.Contains(delivery.Body, `"osName":"Linux"`) {
+		t.Errorf("expected non-personal fields to be kept, got %s", delivery.Body)
+	}
+
+	if got := newDelivery(req, []byte("not json"), true); got.Body != "" {
+		t.Errorf("expected an unparseable body to be dropped when redacting, got %q", got.Body)
+	}
+	if got := newDelivery(req, []byte(body), false); got.Body != body || got.Redacted {
+		t.Errorf("expected the raw body without redaction, got %+v", got)
+	}
+}
+
+// captureDeliveries mocks the delivery log key, returning stored as its
+// current value and collecting what the plugin writes back.
+func captureDeliveries(api *plugintest.API, stored []store.Delivery) *[]store.Delivery {
+	data, _ := json.Marshal(stored)
+	written := &[]store.Delivery{}
+	api.On("KVGet", pluginID+":"+KVKeyDeliveries).Return(data, nil)
+	api.On("KVSet", pluginID+":"+KVKeyDeliveries, mock.Anything).Run(func(args mock.Arguments) {
+		_ = json.Unmarshal(args.Get(1).([]byte), written)
+	}).Return(nil)
+	return written
+}
+
+func TestHandleWebhookRecordsRejectedDelivery(t *testing.T) {
+	api := &plugintest.API{}
+	api.On("LogWarn", "webhook rejected", "err", "invalid webhook token", "remote", mock.Anything).Return()
+	written := captureDeliveries(api, nil)
+
+	p := &Plugin{}
+	p.SetAPI(api)
+	p.kvNamespace = pluginID
+	p.configuration.Store(&Configuration{WebhookToken: "secret-token", DeliveryLogSize: 5})
+
+	req := httptest.NewRequest(http.MethodPost, "/webhook?token=wrong-token", bytes.NewReader([]byte(`{}`)))
+	rr := httptest.NewRecorder()
+	p.handleWebhook(rr, req)
+
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected status %d, got %d", http.StatusUnauthorized, rr.Code)
+	}
+	if len(*written) != 1 {
+		t.Fatalf("expected one logged delivery, got %d", len(*written))
+	}
+	delivery := (*written)[0]
+	if delivery.Validation != "invalid webhook token" || delivery.Outcome != metrics.OutcomeRejected || delivery.Query["token"] != redactedValue {
+		t.Errorf("unexpected delivery %+v", delivery)
+	}
+}
+
+func TestReplayDelivery(t *testing.T) {
+	body, _ := json.Marshal(webhookPayload{
+		Trigger: triggerInfo{Type: "exception"},
+		Error:   &errorInfo{ErrorID: "e1"},
+		Project: &projectInfo{ID: "p1"},
+	})
+
+	api := &plugintest.API{}
+	api.On("LogInfo", "replaying webhook delivery", "delivery_id", "d1").Return()
+	api.On("KVGet", pluginID+":"+KVKeyProjectChannelMappings).Return(nil, nil)
+	written := captureDeliveries(api, []store.Delivery{
+		{ID: "d1", Validation: deliveryValidationOK, Outcome: metrics.OutcomeError, Body: string(body)},
+		{ID: "d2", Truncated: true},
+	})
+
+	p := &Plugin{}
+	p.SetAPI(api)
+	p.kvNamespace = pluginID
+	p.configuration.Store(&Configuration{DeliveryLogSize: 5})
+
+	replay, err := p.replayDelivery("d1")
+	if err != nil {
+		t.Fatalf("replayDelivery() error = %v", err)
+	}
+	if replay.ReplayOf != "d1" || replay.Validation != deliveryValidationReplay || replay.Outcome != metrics.OutcomeUnmatched {
+		t.Errorf("unexpected replay %+v", replay)
+	}
+	if len(*written) != 3 || (*written)[2].ReplayOf != "d1" {
+		t.Errorf("expected the replay to be appended to the log, got %+v", *written)
+	}
+
+	if _, err := p.replayDelivery("d2"); !errors.Is(err, store.ErrDeliveryTruncated) {
+		t.Errorf("expected ErrDeliveryTruncated, got %v", err)
+	}
+	if _, err := p.replayDelivery("missing"); !errors.Is(err, store.ErrDeliveryNotFound) {
+		t.Errorf("expected ErrDeliveryNotFound, got %v", err)
+	}
+}
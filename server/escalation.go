@@ -0,0 +1,59 @@
+package main
+
+import (
+	"time"
+
+	"github.com/a-voronkov/mattermost-bugsnag/server/store"
+)
+
+// startEscalation hands a new card whose rule escalates it to the escalator.
+// The mapping must already have Escalating set. assignee is the assignee shown
+// on the new card, such as the on-call user it was assigned to.
+func (p *Plugin) startEscalation(mm *MMClient, rule ChannelRule, mapping ErrorPostMapping, assignee string) {
+	if !mapping.Escalating {
+		return
+	}
+
+	if err := p.kvStore().UpsertEscalation(store.Escalation{
+		ProjectID: mapping.ProjectID,
+		ErrorID:   mapping.ErrorID,
+		ChannelID: mapping.ChannelID,
+		PostID:    mapping.PostID,
+		RuleID:    rule.ID,
+		CreatedAt: time.Now().UTC(),
+		Levels:    rule.Escalation.Levels,
+		Assignee:  assignee,
+	}); err != nil {
+		mm.LogDebug("failed to start escalation", "error_id", mapping.ErrorID, "err", err.Error())
+	}
+}
+
+// acknowledgeEscalation stops the escalation of the card of mapping, if it is
+// escalating, and returns the updated mapping. reason completes the thread
+// note, such as "acknowledged by @jane".
+func (p *Plugin) acknowledgeEscalation(mm *MMClient, mapping ErrorPostMapping, reason string) ErrorPostMapping {
+	if !mapping.Escalating {
+		return mapping
+	}
+
+	mapping.Escalating = false
+	if _, appErr := mm.UpdateJSON(errorPostKVKey(mapping.ProjectID, mapping.ErrorID), &mapping, func() {
+		mapping.Escalating = false
+	}); appErr != nil {
+		mm.LogDebug("failed to store error→post mapping", "err", appErr.Error())
+	}
+
+	removed, err := p.kvStore().RemoveEscalation(mapping.ProjectID, mapping.ErrorID)
+	if err != nil {
+		mm.LogDebug("failed to stop escalation", "error_id", mapping.ErrorID, "err", err.Error())
+		return mapping
+	}
+	// The escalator drops escalations that ran through every level on its
+	// own; those need no note.
+	if removed {
+		if _, appErr := mm.CreateReply(mapping.ChannelID, mapping.PostID, "✋ Escalation stopped: "+reason+"."); appErr != nil {
+			mm.LogDebug("failed to note stopped escalation", "err", appErr.Error())
+		}
+	}
+	return mapping
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/a-voronkov/mattermost-bugsnag/server/store"
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestEscalationPolicyEscalates(t *testing.T) {
+	levels := []store.EscalationLevel{{AfterMinutes: 15, Mentions: []string{"oncall"}}}
+
+	tests := []struct {
+		name     string
+		policy   *EscalationPolicy
+		severity string
+		want     bool
+	}{
+		{name: "no policy", severity: "error"},
+		{name: "no levels", policy: &EscalationPolicy{}, severity: "error"},
+		{name: "errors by default", policy: &EscalationPolicy{Levels: levels}, severity: "error", want: true},
+		{name: "warnings not by default", policy: &EscalationPolicy{Levels: levels}, severity: "warning"},
+		{name: "listed severity", policy: &EscalationPolicy{Severities: []string{"Warning"}, Levels: levels}, severity: "warning", want: true},
+		{name: "unlisted severity", policy: &EscalationPolicy{Severities: []string{"warning"}, Levels: levels}, severity: "error"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.escalates(tt.severity); got != tt.want {
+				t.Errorf("escalates(%q) = %v, want %v", tt.severity, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestErrorStatusChangedStopsEscalation(t *testing.T) {
+	escalations, _ := json.Marshal([]store.Escalation{{ProjectID: "proj-1", ErrorID: "err-1", PostID: "post-1"}, {ProjectID: "proj-1", ErrorID: "err-2", PostID: "post-2"}})
+
+	// A ticket was linked since the mapping was loaded; only Escalating may
+	// change.
+	stored, _ := json.Marshal(ErrorPostMapping{ProjectID: "proj-1", ErrorID: "err-1", ChannelID: "chan-1", PostID: "post-1", Escalating: true, TicketKey: "APP-1"})
+
+	api := &plugintest.API{}
+	api.On("KVGet", pluginID+":"+errorPostKVKey("proj-1", "err-1")).Return(stored, nil).Once()
+	api.On("KVSetWithOptions", pluginID+":"+errorPostKVKey("proj-1", "err-1"), mock.MatchedBy(func(data []byte) bool {
+		var mapping ErrorPostMapping
+		return json.Unmarshal(data, &mapping) == nil && !mapping.Escalating && mapping.PostID == "post-1" && mapping.TicketKey == "APP-1"
+	}), model.PluginKVSetOptions{Atomic: true, OldValue: stored}).Return(true, nil).Once()
+	api.On("KVGet", pluginID+":"+KVKeyEscalations).Return(escalations, nil).Once()
+	api.On("KVSetWithOptions", pluginID+":"+KVKeyEscalations, mock.MatchedBy(func(data []byte) bool {
+		var kept []store.Escalation
+		return json.Unmarshal(data, &kept) == nil && len(kept) == 1 && kept[0].ErrorID == "err-2"
+	}), model.PluginKVSetOptions{Atomic: true, OldValue: escalations}).Return(true, nil).Once()
+	api.On("CreatePost", mock.MatchedBy(func(post *model.Post) bool {
+		return post.RootId == "post-1" && strings.Contains(post.Message, "Escalation stopped: the error is now **ignored**.")
+	})).Return(&model.Post{Id: "reply-1"}, nil).Once()
+
+	p := &Plugin{}
+	p.SetAPI(api)
+	p.kvNamespace = pluginID
+
+	mm := newMMClient(api, false, pluginID, "")
+	mapping := ErrorPostMapping{ProjectID: "proj-1", ErrorID: "err-1", ChannelID: "chan-1", PostID: "post-1", Escalating: true}
+
+	mapping = p.errorStatusChanged(mm, mapping, "ignored")
+	if mapping.Escalating {
+		t.Fatal("expected the escalation to be acknowledged")
+	}
+	// A later change has nothing left to stop.
+	p.errorStatusChanged(mm, mapping, "open")
+
+	api.AssertExpectations(t)
+}
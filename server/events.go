@@ -0,0 +1,121 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/a-voronkov/mattermost-bugsnag/server/connection"
+	"github.com/a-voronkov/mattermost-bugsnag/server/formatter"
+	"github.com/a-voronkov/mattermost-bugsnag/server/outbound"
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// restartOutbound replaces the outbound webhook dispatcher with one for the
+// configured endpoints. Events still queued for the previous endpoints are
+// dropped.
+func (p *Plugin) restartOutbound(cfg Configuration) {
+	var next *outbound.Dispatcher
+	if endpoints := cfg.outboundEndpoints(); len(endpoints) > 0 {
+		next = outbound.NewDispatcher(endpoints, &http.Client{Timeout: outbound.DeliveryTimeout}, p.API)
+		next.Start()
+	}
+	if previous := p.dispatcher.Swap(next); previous != nil {
+		previous.Stop()
+	}
+}
+
+func (p *Plugin) stopOutbound() {
+	if previous := p.dispatcher.Swap(nil); previous != nil {
+		previous.Stop()
+	}
+}
+
+// emitEvent completes event with the error and card it is about and queues
+// it for the outbound webhooks that want it. data may be nil when the card
+// could not be loaded.
+func (p *Plugin) emitEvent(mm *MMClient, event outbound.Event, mapping ErrorPostMapping, data *formatter.ErrorData) {
+	dispatcher := p.dispatcher.Load()
+	if !dispatcher.Wants(event.Type) {
+		return
+	}
+
+	event.ID = model.NewId()
+	event.Time = time.Now().UTC()
+	event.ConnectionID = mapping.ConnectionID
+	event.ProjectID = mapping.ProjectID
+	event.ErrorID = mapping.ErrorID
+	event.ChannelID = mapping.ChannelID
+	event.PostID = mapping.PostID
+	if mapping.PostID != "" {
+		event.PostURL = mm.Permalink(mapping.PostID)
+	}
+
+	if data != nil {
+		event.Error = &outbound.Error{
+			Class:       data.ExceptionClass,
+			Message:     data.Message,
+			Severity:    data.Severity,
+			Environment: data.Environment,
+			URL:         data.ErrorURL,
+		}
+		if event.Status == "" {
+			event.Status = data.Status
+		}
+		if event.Assignee == "" {
+			event.Assignee = strings.TrimPrefix(data.Assignee(), "@")
+		}
+	}
+
+	dispatcher.Emit(event)
+}
+
+// emitCardEvent emits event for the card of mapping, loading the card for the
+// error details.
+func (p *Plugin) emitCardEvent(mm *MMClient, event outbound.Event, mapping ErrorPostMapping) {
+	if !p.dispatcher.Load().Wants(event.Type) {
+		return
+	}
+
+	var data *formatter.ErrorData
+	if post, appErr := mm.GetPost(mapping.PostID); appErr == nil {
+		if card, ok := formatter.CardData(post); ok {
+			data = &card
+		}
+	}
+	p.emitEvent(mm, event, mapping, data)
+}
+
+// emitWebhookChanges emits the status change and assignment a webhook
+// delivery made to a card that was already posted.
+func (p *Plugin) emitWebhookChanges(mm *MMClient, payload webhookPayload, previous *formatter.ErrorData, data formatter.ErrorData, mapping ErrorPostMapping) {
+	if previous == nil {
+		return
+	}
+	if previous.Status != data.Status {
+		p.emitEvent(mm, outbound.Event{Type: outbound.EventStatusChanged, Source: outbound.SourceWebhook, Status: data.Status, PreviousStatus: previous.Status}, mapping, &data)
+	}
+	if payload.Trigger.triggerType() == TriggerCollaboratorAssigned && data.Assignee() != "" && previous.Assignee() != data.Assignee() {
+		p.emitEvent(mm, outbound.Event{Type: outbound.EventAssigned, Source: outbound.SourceWebhook}, mapping, &data)
+	}
+}
+
+// emitSpike emits error.spike for a spike delivery that was posted to at least
+// one channel. Project spikes are not about a single error and have no card.
+func (p *Plugin) emitSpike(mm *MMClient, conn connection.Connection, payload webhookPayload) {
+	trigger := payload.Trigger.triggerType()
+	if trigger != TriggerProjectSpiking && trigger != TriggerErrorEventFrequency || !p.dispatcher.Load().Wants(outbound.EventSpike) {
+		return
+	}
+
+	event := outbound.Event{Type: outbound.EventSpike, Source: outbound.SourceWebhook, Detail: payload.Trigger.Message}
+	mapping := ErrorPostMapping{ConnectionID: conn.ID, ProjectID: payload.getProjectID(), ErrorID: payload.getErrorID()}
+	if mapping.ErrorID != "" {
+		var stored ErrorPostMapping
+		if found, appErr := mm.LoadJSON(errorPostKVKey(mapping.ProjectID, mapping.ErrorID), &stored); appErr == nil && found {
+			p.emitCardEvent(mm, event, stored)
+			return
+		}
+	}
+	p.emitEvent(mm, event, mapping, nil)
+}
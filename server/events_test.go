@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/a-voronkov/mattermost-bugsnag/server/outbound"
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/stretchr/testify/mock"
+)
+
+// newOutboundReceiver starts an endpoint that checks signatures and hands
+// every event to the returned channel, and a dispatcher delivering to it.
+func newOutboundReceiver(t *testing.T, api outbound.Logger, events ...string) (*outbound.Dispatcher, <-chan outbound.Event) {
+	t.Helper()
+
+	received := make(chan outbound.Event, 4)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		timestamp, _ := strconv.ParseInt(r.Header.Get(outbound.HeaderTimestamp), 10, 64)
+		if r.Header.Get(outbound.HeaderSignature) != outbound.Sign("hook-secret", timestamp, body) {
+			http.Error(w, "bad signature", http.StatusUnauthorized)
+			return
+		}
+		var event outbound.Event
+		_ = json.Unmarshal(body, &event)
+		received <- event
+	}))
+	t.Cleanup(server.Close)
+
+	dispatcher := outbound.NewDispatcher([]outbound.Endpoint{{ID: "ops", URL: server.URL, Secret: "hook-secret", Events: events}}, server.Client(), api)
+	dispatcher.Start()
+	t.Cleanup(dispatcher.Stop)
+	return dispatcher, received
+}
+
+func receiveEvent(t *testing.T, received <-chan outbound.Event) outbound.Event {
+	t.Helper()
+	select {
+	case event := <-received:
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("no outbound event delivered")
+		return outbound.Event{}
+	}
+}
+
+func TestHandleActionsEmitsStatusChange(t *testing.T) {
+	clients := newBugsnagServer(t, map[string]string{"/projects/proj-1/errors/err-1": `{}`})
+
+	api := cardAPI(t, ErrorPostMapping{ProjectID: "proj-1", ErrorID: "err-1", ChannelID: "chan-1", PostID: "post-1"}, ticketRule)
+	api.On("GetUser", "user-1").Return(&model.User{Id: "user-1", Username: "jane"}, nil)
+	api.On("KVGet", pluginID+":"+KVKeyUserMappings).Return(nil, nil)
+	api.On("KVGet", pluginID+":"+KVKeyAuditLog).Return(nil, nil)
+	api.On("KVSet", pluginID+":"+KVKeyAuditLog, mock.Anything).Return(nil)
+	api.On("UpdatePost", mock.Anything).Return(&model.Post{Id: "post-1"}, nil).Once()
+	api.On("CreatePost", mock.Anything).Return(&model.Post{Id: "reply-1"}, nil).Once()
+	api.On("LogInfo", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return().Maybe()
+	api.On("LogInfo", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return().Maybe()
+
+	dispatcher, received := newOutboundReceiver(t, api, outbound.EventStatusChanged)
+	p := &Plugin{}
+	p.SetAPI(api)
+	p.kvNamespace = pluginID
+	p.configuration.Store(&Configuration{BugsnagAPIToken: "shared-token"})
+	p.clients.Store(clients)
+	p.dispatcher.Store(dispatcher)
+
+	body, _ := json.Marshal(model.PostActionIntegrationRequest{
+		UserId:  "user-1",
+		Context: map[string]any{"action": "ignore", "error_id": "err-1", "project_id": "proj-1"},
+	})
+	rr := httptest.NewRecorder()
+	p.handleActions(rr, actionRequest(body, "user-1"))
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("unexpected status %d: %s", rr.Code, rr.Body.String())
+	}
+
+	event := receiveEvent(t, received)
+	if event.Type != outbound.EventStatusChanged || event.Source != outbound.SourceCard || event.Status != "ignored" || event.PreviousStatus != "open" {
+		t.Errorf("unexpected event %+v", event)
+	}
+	if event.ID == "" || event.PostURL != "https://mm.example.com/_redirect/pl/post-1" || event.Actor == nil || event.Actor.Username != "jane" ||
+		event.Error == nil || event.Error.Class != "NoMethodError" {
+		t.Errorf("incomplete event %+v", event)
+	}
+}
+
+func TestSyncedStatusChangeEmitsEvent(t *testing.T) {
+	api := cardAPI(t, ErrorPostMapping{ConnectionID: "mobile", ProjectID: "proj-1", ErrorID: "err-1", ChannelID: "chan-1", PostID: "post-1"}, ticketRule)
+
+	dispatcher, received := newOutboundReceiver(t, api)
+	p := &Plugin{}
+	p.SetAPI(api)
+	p.kvNamespace = pluginID
+	p.configuration.Store(&Configuration{})
+	p.dispatcher.Store(dispatcher)
+
+	p.syncedStatusChange("proj-1", "err-1", "open", "fixed")
+
+	event := receiveEvent(t, received)
+	if event.Type != outbound.EventStatusChanged || event.Source != outbound.SourceSync || event.ConnectionID != "mobile" ||
+		event.Status != "fixed" || event.PreviousStatus != "open" || event.Actor != nil {
+		t.Errorf("unexpected event %+v", event)
+	}
+}
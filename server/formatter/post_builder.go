@@ -1,6 +1,7 @@
 package formatter
 
 import (
+	"encoding/json"
 	"fmt"
 	"strings"
 
@@ -8,25 +9,116 @@ import (
 	"github.com/mattermost/mattermost/server/public/model"
 )
 
+// CardPropKey is the post prop that holds the ErrorData a card was rendered
+// from, so later updates can re-render the card instead of patching it.
+const CardPropKey = "bugsnag_card"
+
 // Counts mirrors the aggregate counts provided by Bugsnag for an error.
 type Counts struct {
-	Users     int
-	Events1h  int
-	Events24h int
+	Users     int `json:"users,omitempty"`
+	Events1h  int `json:"events_1h,omitempty"`
+	Events24h int `json:"events_24h,omitempty"`
 }
 
-// ErrorData captures the essential details needed to render a Bugsnag error card.
+// ErrorData is the canonical model of a Bugsnag error card. Every code path
+// that creates or updates a card (webhook, actions, scheduler) goes through it.
 type ErrorData struct {
-	ID          string
-	ProjectID   string
-	ProjectName string
-	Summary     string
-	Status      string
-	Environment string
-	Severity    string
-	Counts      Counts
-	LastSeen    string
-	ErrorURL    string
+	ID             string `json:"id"`
+	ProjectID      string `json:"project_id"`
+	ProjectName    string `json:"project_name,omitempty"`
+	ExceptionClass string `json:"exception_class,omitempty"`
+	Message        string `json:"message,omitempty"`
+	Summary        string `json:"summary,omitempty"` // fallback title when class and message are empty
+	Context        string `json:"context,omitempty"`
+	Status         string `json:"status,omitempty"`
+	Environment    string `json:"environment,omitempty"`
+	Severity       string `json:"severity,omitempty"`
+	AppVersion     string `json:"app_version,omitempty"`
+	// AssigneeUsername is the mapped Mattermost username (without @). When it
+	// is empty, AssigneeEmail is shown instead.
+	AssigneeUsername string `json:"assignee_username,omitempty"`
+	AssigneeEmail    string `json:"assignee_email,omitempty"`
+	Counts           Counts `json:"counts"`
+	LastSeen         string `json:"last_seen,omitempty"`
+	ErrorURL         string `json:"error_url,omitempty"`
+	// Device and OS describe where the error happened, such as "Google Pixel
+	// 8" and "Android 14". Unlike Request they are filled in whenever the
+	// payload has them, so templates can show them; the built-in layout
+	// leaves them out.
+	Device string `json:"device,omitempty"`
+	OS     string `json:"os,omitempty"`
+	// Request is the optional context section. It is only filled in when the
+	// channel rule asks for context on the card.
+	Request RequestContext `json:"request"`
+	// Playbook is the Playbooks run started for the error, if any.
+	Playbook *PlaybookRun `json:"playbook,omitempty"`
+	// PlaybookAvailable shows the Start playbook button on cards without a
+	// run. It is set when the channel rule has a playbook for the error.
+	PlaybookAvailable bool `json:"playbook_available,omitempty"`
+	// Ticket is the issue-tracker issue created for the error, if any.
+	Ticket *Ticket `json:"ticket,omitempty"`
+	// TicketAvailable shows the Create ticket button on cards without a
+	// ticket. It is set when an issue tracker is configured.
+	TicketAvailable bool `json:"ticket_available,omitempty"`
+}
+
+// Ticket links a card to an issue in an issue tracker.
+type Ticket struct {
+	Key string `json:"key,omitempty"`
+	URL string `json:"url,omitempty"`
+}
+
+// Summary renders the ticket as a card field value.
+func (t *Ticket) Summary() string {
+	if t == nil || t.URL == "" {
+		return ""
+	}
+	key := t.Key
+	if key == "" {
+		key = "Issue"
+	}
+	return fmt.Sprintf("[%s](%s)", key, t.URL)
+}
+
+// PlaybookRun links a card to a Mattermost Playbooks run.
+type PlaybookRun struct {
+	ID     string `json:"id,omitempty"`
+	Name   string `json:"name,omitempty"`
+	Status string `json:"status,omitempty"`
+	URL    string `json:"url,omitempty"`
+}
+
+// Summary renders the run as a card field value.
+func (r *PlaybookRun) Summary() string {
+	if r == nil || r.ID == "" {
+		return ""
+	}
+	name := r.Name
+	if name == "" {
+		name = "Playbook run"
+	}
+	if r.URL != "" {
+		name = fmt.Sprintf("[%s](%s)", name, r.URL)
+	}
+	if r.Status == "" {
+		return name
+	}
+	return name + " · " + r.Status
+}
+
+// RequestContext describes where an error happened: the affected request,
+// the device and the end user. Values are already redacted.
+type RequestContext struct {
+	URL      string `json:"url,omitempty"`
+	Browser  string `json:"browser,omitempty"`
+	OS       string `json:"os,omitempty"`
+	Hostname string `json:"hostname,omitempty"`
+	User     string `json:"user,omitempty"`
+}
+
+// IsEmpty reports whether there is no context to show.
+func (c RequestContext) IsEmpty() bool {
+	return c == RequestContext{}
 }
 
 // ErrorPostMapping identifies where the card belongs in Mattermost.
@@ -36,23 +128,176 @@ type ErrorPostMapping struct {
 	ErrorID   string
 }
 
-// MMUserMapping links a Bugsnag error to a Mattermost user mention for display.
-type MMUserMapping struct {
-	MMUserID string
-}
-
 // BuildErrorPost creates a Mattermost post representing a Bugsnag error with
 // an attachment that includes summary details and action buttons.
-func BuildErrorPost(errorData ErrorData, mapping ErrorPostMapping, mmUserMapping MMUserMapping) model.Post {
-	message := buildMessage(errorData)
-	attachment := buildAttachment(errorData, mapping, mmUserMapping)
+func BuildErrorPost(errorData ErrorData, mapping ErrorPostMapping) *model.Post {
+	post := &model.Post{ChannelId: mapping.ChannelID}
+	ApplyCard(post, errorData, mapping)
+	return post
+}
+
+// ApplyCard renders errorData onto post with the built-in layout, replacing its
+// message, attachment and the stored card model.
+func ApplyCard(post *model.Post, errorData ErrorData, mapping ErrorPostMapping) {
+	_ = ApplyTemplatedCard(post, errorData, mapping, nil)
+}
 
-	return model.Post{
-		ChannelId: mapping.ChannelID,
-		Message:   message,
-		Props: map[string]any{
-			"attachments": []*model.SlackAttachment{attachment},
-		},
+// BuildTitle returns the post message shown above the card attachment.
+func BuildTitle(errorData ErrorData) string {
+	exceptionClass := strings.TrimSpace(errorData.ExceptionClass)
+	message := strings.TrimSpace(errorData.Message)
+	summary := strings.TrimSpace(errorData.Summary)
+
+	switch {
+	case exceptionClass != "" && message != "":
+		return fmt.Sprintf(":rotating_light: **%s**: %s", exceptionClass, message)
+	case exceptionClass != "":
+		return ":rotating_light: **" + exceptionClass + "**"
+	case message != "":
+		return ":rotating_light: " + message
+	case summary != "":
+		return ":rotating_light: " + summary
+	default:
+		return ":rotating_light: Bugsnag error"
+	}
+}
+
+// cardStyle supplies the severity markers used while rendering a card.
+type cardStyle struct {
+	emoji func(severity string) string
+	color func(severity string) string
+}
+
+var defaultStyle = cardStyle{emoji: SeverityEmoji, color: SeverityColor}
+
+// BuildAttachment renders the card attachment. Fields are emitted in a fixed
+// order and empty values are skipped, so the output is deterministic.
+func BuildAttachment(errorData ErrorData, mapping ErrorPostMapping) *model.SlackAttachment {
+	return buildAttachment(errorData, mapping, defaultStyle)
+}
+
+func buildAttachment(errorData ErrorData, mapping ErrorPostMapping, style cardStyle) *model.SlackAttachment {
+	var fields []*model.SlackAttachmentField
+	addField := func(title, value string) {
+		if strings.TrimSpace(value) == "" {
+			return
+		}
+		fields = append(fields, &model.SlackAttachmentField{Title: title, Value: value, Short: true})
+	}
+
+	if severity := strings.TrimSpace(errorData.Severity); severity != "" {
+		addField("Severity", style.emoji(severity)+" "+severity)
+	}
+	addField("Environment", strings.TrimSpace(errorData.Environment))
+	addField("Status", strings.TrimSpace(errorData.Status))
+	addField("Assigned", errorData.Assignee())
+	addField("Context", strings.TrimSpace(errorData.Context))
+	addField("App Version", strings.TrimSpace(errorData.AppVersion))
+	if errorData.Counts.Users > 0 {
+		addField("Users", fmt.Sprintf("%d", errorData.Counts.Users))
+	}
+	switch {
+	case errorData.Counts.Events1h > 0:
+		addField("Events (1h/24h)", fmt.Sprintf("%d / %d", errorData.Counts.Events1h, errorData.Counts.Events24h))
+	case errorData.Counts.Events24h > 0:
+		addField("Events (24h)", fmt.Sprintf("%d", errorData.Counts.Events24h))
+	}
+	addField("Last seen", strings.TrimSpace(errorData.LastSeen))
+	addField("URL", errorData.Request.URL)
+	addField("Browser", errorData.Request.Browser)
+	addField("OS", errorData.Request.OS)
+	addField("Hostname", errorData.Request.Hostname)
+	addField("User", errorData.Request.User)
+	addField("Playbook", errorData.Playbook.Summary())
+	addField("Ticket", errorData.Ticket.Summary())
+
+	footer := "Bugsnag"
+	if projectName := strings.TrimSpace(errorData.ProjectName); projectName != "" {
+		footer = fmt.Sprintf("Bugsnag • %s", projectName)
+	}
+
+	title := strings.TrimSpace(errorData.ExceptionClass)
+	if title == "" {
+		title = strings.TrimSpace(errorData.Summary)
+	}
+
+	return &model.SlackAttachment{
+		Fallback:  strings.ReplaceAll(strings.TrimPrefix(BuildTitle(errorData), ":rotating_light: "), "**", ""),
+		Color:     style.color(errorData.Severity),
+		Title:     title,
+		TitleLink: errorData.ErrorURL,
+		Text:      strings.TrimSpace(errorData.Message),
+		Fields:    fields,
+		Footer:    footer,
+		// Note: "Open in Bugsnag" link is available via TitleLink on the attachment title
+		Actions: BuildActions(BuildActionsParams{
+			Mapping:        mapping,
+			ErrorURL:       errorData.ErrorURL,
+			CurrentStatus:  errorData.Status,
+			AssignedUserID: errorData.AssigneeUsername,
+			StartPlaybook:  errorData.PlaybookAvailable && errorData.Playbook == nil,
+			CreateTicket:   errorData.TicketAvailable && errorData.Ticket == nil,
+		}),
+	}
+}
+
+// RenderContext renders the context section as a thread reply. It returns an
+// empty string when there is nothing to show.
+func RenderContext(c RequestContext) string {
+	if c.IsEmpty() {
+		return ""
+	}
+
+	var sb strings.Builder
+	sb.WriteString("**Context:**")
+	for _, line := range [][2]string{
+		{"URL", c.URL},
+		{"Browser", c.Browser},
+		{"OS", c.OS},
+		{"Hostname", c.Hostname},
+		{"User", c.User},
+	} {
+		if strings.TrimSpace(line[1]) != "" {
+			sb.WriteString(fmt.Sprintf("\n- %s: %s", line[0], line[1]))
+		}
+	}
+	return sb.String()
+}
+
+// Assignee returns the assignee as shown on the card: an @-mention when the
+// collaborator is mapped to a Mattermost user, otherwise their email.
+func (d ErrorData) Assignee() string {
+	if username := strings.TrimSpace(d.AssigneeUsername); username != "" {
+		return "@" + username
+	}
+	return strings.TrimSpace(d.AssigneeEmail)
+}
+
+// SeverityEmoji returns the marker shown next to a severity value.
+func SeverityEmoji(severity string) string {
+	switch severity {
+	case "error":
+		return "🔴"
+	case "warning":
+		return "🟡"
+	case "info":
+		return "🔵"
+	default:
+		return "⚪"
+	}
+}
+
+// SeverityColor returns the attachment color for a severity value.
+func SeverityColor(severity string) string {
+	switch severity {
+	case "error":
+		return "#D9534F" // red
+	case "warning":
+		return "#F0AD4E" // yellow/orange
+	case "info":
+		return "#5BC0DE" // blue
+	default:
+		return "#4949E4" // Bugsnag purple
 	}
 }
 
@@ -63,47 +308,111 @@ type UpdatePostParams struct {
 	Mapping          ErrorPostMapping
 	ErrorURL         string
 	AssignedUsername string // Mattermost username (without @)
+	// Counts replaces the event counts when set.
+	Counts *Counts
+	// Templates are the stored card templates; the card is re-rendered with
+	// the one it was created with.
+	Templates []CardTemplate
 }
 
-// UpdatePost updates the status and/or assignment in an existing post's attachment.
+// UpdatePost applies a status and/or assignment change to an existing card.
+// Cards created with ApplyCard are re-rendered from their stored ErrorData;
+// older cards without it only get their Status field and buttons patched.
 // Returns the updated post ready to be saved.
 func UpdatePost(params UpdatePostParams) *model.Post {
 	post := params.Post
 
-	// Update attachment if present
+	if errorData, ok := CardData(post); ok {
+		if params.NewStatus != "" {
+			errorData.Status = params.NewStatus
+		}
+		if params.AssignedUsername != "" {
+			errorData.AssigneeUsername = params.AssignedUsername
+		}
+		if params.Counts != nil {
+			errorData.Counts = *params.Counts
+		}
+		if errorData.ErrorURL == "" {
+			errorData.ErrorURL = params.ErrorURL
+		}
+		_ = ApplyTemplatedCard(post, errorData, params.Mapping, CardTemplateFor(post, params.Templates))
+		return post
+	}
+
 	att := extractFirstAttachment(post)
-	if att != nil {
-		// Update status in text field
-		lines := strings.Split(att.Text, "\n")
-		for i, line := range lines {
-			if strings.HasPrefix(line, "Status:") {
-				parts := strings.Split(line, " | ")
-				parts[0] = fmt.Sprintf("Status: %s", params.NewStatus)
-				lines[i] = strings.Join(parts, " | ")
-				break
-			}
+	if att == nil {
+		return post
+	}
+
+	status := params.NewStatus
+	for i, field := range att.Fields {
+		if field.Title != "Status" {
+			continue
 		}
-		att.Text = strings.Join(lines, "\n")
+		if status == "" {
+			status, _ = field.Value.(string)
+		} else {
+			att.Fields[i].Value = status
+		}
+		break
+	}
 
-		// Update status in Fields
-		for i, field := range att.Fields {
-			if field.Title == "Status" {
-				att.Fields[i].Value = params.NewStatus
-				break
-			}
+	att.Actions = BuildActions(BuildActionsParams{
+		Mapping:        params.Mapping,
+		ErrorURL:       params.ErrorURL,
+		CurrentStatus:  status,
+		AssignedUserID: params.AssignedUsername,
+	})
+	post.Props["attachments"] = []*model.SlackAttachment{att}
+
+	return post
+}
+
+// CardData returns the ErrorData stored on a card by ApplyCard. It handles both
+// in-memory posts and posts loaded from the database, where props are decoded
+// as generic maps.
+func CardData(post *model.Post) (ErrorData, bool) {
+	if post == nil || post.Props == nil {
+		return ErrorData{}, false
+	}
+
+	switch v := post.Props[CardPropKey].(type) {
+	case nil:
+		return ErrorData{}, false
+	case ErrorData:
+		return v, true
+	case *ErrorData:
+		return *v, v != nil
+	default:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return ErrorData{}, false
 		}
+		var errorData ErrorData
+		if err := json.Unmarshal(data, &errorData); err != nil {
+			return ErrorData{}, false
+		}
+		return errorData, true
+	}
+}
 
-		// Rebuild actions with current status for proper button states
-		att.Actions = BuildActions(BuildActionsParams{
-			Mapping:        params.Mapping,
-			ErrorURL:       params.ErrorURL,
-			CurrentStatus:  params.NewStatus,
-			AssignedUserID: params.AssignedUsername,
-		})
-		post.Props["attachments"] = []*model.SlackAttachment{att}
+// CardStatus returns the status currently shown on a card.
+func CardStatus(post *model.Post) string {
+	if errorData, ok := CardData(post); ok {
+		return errorData.Status
 	}
 
-	return post
+	att := extractFirstAttachment(post)
+	if att == nil {
+		return ""
+	}
+	for _, field := range att.Fields {
+		if field.Title == "Status" {
+			status, _ := field.Value.(string)
+			return status
+		}
+	}
+	return ""
 }
 
 // extractFirstAttachment extracts the first SlackAttachment from post Props.
@@ -142,6 +451,9 @@ func extractFirstAttachment(post *model.Post) *model.SlackAttachment {
 func mapToSlackAttachment(m map[string]interface{}) *model.SlackAttachment {
 	att := &model.SlackAttachment{}
 
+	if v, ok := m["fallback"].(string); ok {
+		att.Fallback = v
+	}
 	if v, ok := m["title"].(string); ok {
 		att.Title = v
 	}
@@ -192,73 +504,16 @@ func UpdatePostStatus(post *model.Post, newStatus string, mapping ErrorPostMappi
 	})
 }
 
-func buildMessage(errorData ErrorData) string {
-	base := fmt.Sprintf(":rotating_light: **[BUG]** %s", strings.TrimSpace(errorData.Summary))
-	status := strings.TrimSpace(errorData.Status)
-	if status == "" {
-		return base
-	}
-
-	return fmt.Sprintf("%s · Status: %s", base, status)
-}
-
-func buildAttachment(errorData ErrorData, mapping ErrorPostMapping, mmUserMapping MMUserMapping) *model.SlackAttachment {
-	fields := []string{}
-
-	if strings.TrimSpace(errorData.Status) != "" {
-		fields = append(fields, fmt.Sprintf("Status: %s", strings.TrimSpace(errorData.Status)))
-	}
-	if strings.TrimSpace(errorData.Environment) != "" {
-		fields = append(fields, fmt.Sprintf("Env: %s", strings.TrimSpace(errorData.Environment)))
-	}
-	if strings.TrimSpace(errorData.Severity) != "" {
-		fields = append(fields, fmt.Sprintf("Severity: %s", strings.TrimSpace(errorData.Severity)))
-	}
-	if errorData.Counts.Users > 0 {
-		fields = append(fields, fmt.Sprintf("Users: %d", errorData.Counts.Users))
-	}
-	if errorData.Counts.Events1h > 0 || errorData.Counts.Events24h > 0 {
-		fields = append(fields, fmt.Sprintf("Events (1h/24h): %d / %d", errorData.Counts.Events1h, errorData.Counts.Events24h))
-	}
-	if strings.TrimSpace(mmUserMapping.MMUserID) != "" {
-		fields = append(fields, fmt.Sprintf("Assigned to <@%s>", strings.TrimSpace(mmUserMapping.MMUserID)))
-	}
-
-	text := strings.Join(fields, " | ")
-	if strings.TrimSpace(errorData.LastSeen) != "" {
-		if text != "" {
-			text += "\n"
-		}
-		text += fmt.Sprintf("Last seen: %s", strings.TrimSpace(errorData.LastSeen))
-	}
-
-	footer := "Bugsnag"
-	if strings.TrimSpace(errorData.ProjectName) != "" {
-		footer = fmt.Sprintf("Bugsnag • %s", strings.TrimSpace(errorData.ProjectName))
-	}
-
-	return &model.SlackAttachment{
-		Title:     errorData.Summary,
-		TitleLink: errorData.ErrorURL,
-		Text:      text,
-		Footer:    footer,
-		Actions:   buildActions(mapping, errorData.ErrorURL),
-	}
-}
-
 // BuildActionsParams contains the parameters for building action buttons.
 type BuildActionsParams struct {
 	Mapping        ErrorPostMapping
 	ErrorURL       string
 	CurrentStatus  string
 	AssignedUserID string
-}
-
-func buildActions(mapping ErrorPostMapping, errorURL string) []*model.PostAction {
-	return BuildActions(BuildActionsParams{
-		Mapping:  mapping,
-		ErrorURL: errorURL,
-	})
+	// StartPlaybook adds a button that starts a Playbooks run.
+	StartPlaybook bool
+	// CreateTicket adds a button that creates an issue-tracker ticket.
+	CreateTicket bool
 }
 
 // BuildActions creates action buttons for a Bugsnag error post with optional
@@ -365,6 +620,42 @@ func BuildActions(params BuildActionsParams) []*model.PostAction {
 		})
 	}
 
+	if params.StartPlaybook {
+		actions = append(actions, &model.PostAction{
+			Id:    "startplaybook",
+			Name:  "▶ Start playbook",
+			Style: "default",
+			Type:  model.PostActionTypeButton,
+			Integration: &model.PostActionIntegration{
+				URL: actionURL,
+				Context: map[string]any{
+					"action":     "start_playbook",
+					"error_id":   params.Mapping.ErrorID,
+					"project_id": params.Mapping.ProjectID,
+					"error_url":  params.ErrorURL,
+				},
+			},
+		})
+	}
+
+	if params.CreateTicket {
+		actions = append(actions, &model.PostAction{
+			Id:    "createticket",
+			Name:  "🎫 Create ticket",
+			Style: "default",
+			Type:  model.PostActionTypeButton,
+			Integration: &model.PostActionIntegration{
+				URL: actionURL,
+				Context: map[string]any{
+					"action":     "create_ticket",
+					"error_id":   params.Mapping.ErrorID,
+					"project_id": params.Mapping.ProjectID,
+					"error_url":  params.ErrorURL,
+				},
+			},
+		})
+	}
+
 	// Note: "Open in Bugsnag" link is available via TitleLink on the attachment title
 
 	return actions
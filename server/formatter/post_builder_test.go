@@ -1,20 +1,66 @@
 package formatter
 
 import (
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/mattermost/mattermost/server/public/model"
 )
 
-func TestBuildErrorPost(t *testing.T) {
-	errorData := ErrorData{
-		ID:          "abcd1234efgh5678",
-		ProjectID:   "proj-1",
-		ProjectName: "backend-api",
-		Summary:     "NullReferenceException in CheckoutController",
-		Status:      "open",
-		Environment: "production",
-		Severity:    "error",
+var updateGolden = flag.Bool("update", false, "rewrite golden files in testdata")
+
+// assertGolden compares the JSON rendering of a card (message and props) with
+// testdata/<name>.golden.json. Run `go test ./formatter -update` to regenerate
+// the files after an intended change.
+func assertGolden(t *testing.T, name string, post *model.Post) {
+	t.Helper()
+
+	card := struct {
+		ChannelID string         `json:"channel_id"`
+		Message   string         `json:"message"`
+		Props     map[string]any `json:"props"`
+	}{post.ChannelId, post.Message, post.Props}
+
+	got, err := json.MarshalIndent(card, "", "  ")
+	if err != nil {
+		t.Fatalf("marshal post: %v", err)
+	}
+	got = append(got, '\n')
+
+	path := filepath.Join("testdata", name+".golden.json")
+	if *updateGolden {
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatalf("write golden %s: %v", path, err)
+		}
+	}
+
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read golden %s: %v", path, err)
+	}
+
+	if string(got) != string(want) {
+		t.Errorf("%s does not match golden file %s\ngot:\n%s\nwant:\n%s", name, path, got, want)
+	}
+}
+
+func fullErrorData() ErrorData {
+	return ErrorData{
+		ID:               "abcd1234efgh5678",
+		ProjectID:        "proj-1",
+		ProjectName:      "backend-api",
+		ExceptionClass:   "NullReferenceException",
+		Message:          "Object reference not set to an instance of an object",
+		Context:          "CheckoutController#submit",
+		Status:           "open",
+		Environment:      "production",
+		Severity:         "error",
+		AppVersion:       "2.4.1",
+		AssigneeUsername: "alice",
 		Counts: Counts{
 			Users:     12,
 			Events1h:  3,
@@ -23,68 +69,218 @@ func TestBuildErrorPost(t *testing.T) {
 		LastSeen: "2025-11-28T10:23:00Z",
 		ErrorURL: "https://app.bugsnag.com/org/project/errors/abcd1234efgh5678",
 	}
+}
+
+var testMapping = ErrorPostMapping{
+	ChannelID: "channel-123",
+	ProjectID: "proj-1",
+	ErrorID:   "abcd1234efgh5678",
+}
 
-	mapping := ErrorPostMapping{
-		ChannelID: "channel-123",
-		ProjectID: "proj-1",
-		ErrorID:   "abcd1234efgh5678",
+func TestBuildErrorPostGolden(t *testing.T) {
+	tests := []struct {
+		name string
+		data ErrorData
+	}{
+		{name: "full", data: fullErrorData()},
+		{
+			name: "minimal",
+			data: ErrorData{ID: "err-1", ProjectID: "proj-1", Summary: "New error in production"},
+		},
+		{
+			name: "fixed_warning_email_assignee",
+			data: ErrorData{
+				ID:             "err-2",
+				ProjectID:      "proj-1",
+				ExceptionClass: "TimeoutError",
+				Status:         "fixed",
+				Severity:       "warning",
+				AssigneeEmail:  "bob@example.com",
+				ErrorURL:       "https://app.bugsnag.com/org/project/errors/err-2",
+			},
+		},
 	}
 
-	mmMapping := MMUserMapping{MMUserID: "mm-user-42"}
+	withContext := fullErrorData()
+	withContext.Request = RequestContext{URL: "https://shop.example.com/checkout", OS: "iOS 17.1", User: "[redacted]"}
+	tests = append(tests, struct {
+		name string
+		data ErrorData
+	}{name: "with_context", data: withContext})
 
-	post := BuildErrorPost(errorData, mapping, mmMapping)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assertGolden(t, "card_"+tt.name, BuildErrorPost(tt.data, testMapping))
+		})
+	}
+}
 
-	if post.ChannelId != mapping.ChannelID {
-		t.Fatalf("unexpected channel id: %s", post.ChannelId)
+func TestBuildErrorPostIsDeterministic(t *testing.T) {
+	first, _ := json.Marshal(BuildErrorPost(fullErrorData(), testMapping))
+	second, _ := json.Marshal(BuildErrorPost(fullErrorData(), testMapping))
+	if string(first) != string(second) {
+		t.Fatalf("rendering is not deterministic:\n%s\n%s", first, second)
 	}
+}
 
-	expectedMessage := ":rotating_light: **[BUG]** NullReferenceException in CheckoutController · Status: open"
-	if post.Message != expectedMessage {
-		t.Fatalf("unexpected message: %s", post.Message)
+func TestBuildTitle(t *testing.T) {
+	tests := []struct {
+		name string
+		data ErrorData
+		want string
+	}{
+		{
+			name: "with exception class only",
+			data: ErrorData{ExceptionClass: "NullReferenceException"},
+			want: ":rotating_light: **NullReferenceException**",
+		},
+		{
+			name: "with exception class and message",
+			data: ErrorData{ExceptionClass: "NullReferenceException", Message: "Object reference not set"},
+			want: ":rotating_light: **NullReferenceException**: Object reference not set",
+		},
+		{
+			name: "message only",
+			data: ErrorData{Message: "Something went wrong"},
+			want: ":rotating_light: Something went wrong",
+		},
+		{
+			name: "summary fallback",
+			data: ErrorData{Summary: "New error"},
+			want: ":rotating_light: New error",
+		},
+		{
+			name: "empty",
+			data: ErrorData{},
+			want: ":rotating_light: Bugsnag error",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := BuildTitle(tt.data); got != tt.want {
+				t.Errorf("BuildTitle() = %q, want %q", got, tt.want)
+			}
+		})
 	}
+}
+
+func TestUpdatePostRerendersStoredCard(t *testing.T) {
+	data := fullErrorData()
+	data.AssigneeUsername = ""
+	post := BuildErrorPost(data, testMapping)
+
+	// Simulate a post loaded from the database, where props are generic maps.
+	raw, err := json.Marshal(post)
+	if err != nil {
+		t.Fatalf("marshal post: %v", err)
+	}
+	loaded := &model.Post{}
+	if err := json.Unmarshal(raw, loaded); err != nil {
+		t.Fatalf("unmarshal post: %v", err)
+	}
+
+	updated := UpdatePost(UpdatePostParams{Post: loaded, NewStatus: "fixed", Mapping: testMapping})
+	updated = UpdatePost(UpdatePostParams{Post: updated, AssignedUsername: "alice", Mapping: testMapping})
+
+	data.Status = "fixed"
+	data.AssigneeUsername = "alice"
+	want := BuildErrorPost(data, testMapping)
 
-	attachments, ok := post.Props["attachments"].([]*model.SlackAttachment)
-	if !ok {
-		t.Fatalf("expected attachments slice, got %T", post.Props["attachments"])
+	gotJSON, _ := json.Marshal(updated.Props)
+	wantJSON, _ := json.Marshal(want.Props)
+	if string(gotJSON) != string(wantJSON) {
+		t.Fatalf("updated card differs from fresh render:\ngot:  %s\nwant: %s", gotJSON, wantJSON)
 	}
+	if updated.Message != want.Message {
+		t.Fatalf("unexpected message: %s", updated.Message)
+	}
+	if CardStatus(updated) != "fixed" {
+		t.Fatalf("unexpected status: %s", CardStatus(updated))
+	}
+}
 
-	if len(attachments) != 1 {
-		t.Fatalf("expected one attachment, got %d", len(attachments))
+func TestUpdatePostLegacyCard(t *testing.T) {
+	post := &model.Post{Props: map[string]any{
+		"attachments": []interface{}{
+			map[string]interface{}{
+				"title": "LegacyError",
+				"fields": []interface{}{
+					map[string]interface{}{"title": "Status", "value": "open", "short": true},
+				},
+			},
+		},
+	}}
+
+	if got := CardStatus(post); got != "open" {
+		t.Fatalf("expected legacy status open, got %q", got)
 	}
 
-	attachment := attachments[0]
+	// An assignment without a status change must keep the current status.
+	updated := UpdatePost(UpdatePostParams{Post: post, AssignedUsername: "alice", Mapping: testMapping})
+	if got := CardStatus(updated); got != "open" {
+		t.Fatalf("expected status to stay open, got %q", got)
+	}
 
-	if attachment.Title != errorData.Summary {
-		t.Errorf("unexpected title: %s", attachment.Title)
+	updated = UpdatePost(UpdatePostParams{Post: updated, NewStatus: "ignored", Mapping: testMapping})
+	if got := CardStatus(updated); got != "ignored" {
+		t.Fatalf("expected status ignored, got %q", got)
 	}
 
-	expectedTitleLink := errorData.ErrorURL
-	if attachment.TitleLink != expectedTitleLink {
-		t.Errorf("unexpected title link: %s", attachment.TitleLink)
+	att := extractFirstAttachment(updated)
+	if att.Actions[2].Id != "unignore" {
+		t.Fatalf("expected unignore action, got %s", att.Actions[2].Id)
 	}
+}
 
-	expectedText := "Status: open | Env: production | Severity: error | Users: 12 | Events (1h/24h): 3 / 42 | Assigned to <@mm-user-42>\nLast seen: 2025-11-28T10:23:00Z"
-	if attachment.Text != expectedText {
-		t.Fatalf("unexpected text: %s", attachment.Text)
+func TestRenderContext(t *testing.T) {
+	if got := RenderContext(RequestContext{}); got != "" {
+		t.Fatalf("expected empty context, got %q", got)
 	}
 
-	expectedFooter := "Bugsnag • backend-api"
-	if attachment.Footer != expectedFooter {
-		t.Errorf("unexpected footer: %s", attachment.Footer)
+	got := RenderContext(RequestContext{URL: "https://example.com/a", OS: "Android 14", User: "Jane"})
+	want := "**Context:**\n- URL: https://example.com/a\n- OS: Android 14\n- User: Jane"
+	if got != want {
+		t.Fatalf("RenderContext() = %q, want %q", got, want)
 	}
+}
 
-	// 3 actions: Assign to me, Resolve, Ignore (no "Open in Bugsnag" button - it's a TitleLink)
-	if len(attachment.Actions) != 3 {
-		t.Fatalf("expected 3 actions, got %d", len(attachment.Actions))
+func TestBuildAttachmentTicketAndPlaybook(t *testing.T) {
+	actionIDs := func(att *model.SlackAttachment) string {
+		var ids []string
+		for _, action := range att.Actions {
+			ids = append(ids, action.Id)
+		}
+		return strings.Join(ids, ",")
+	}
+	fieldValue := func(att *model.SlackAttachment, title string) string {
+		for _, field := range att.Fields {
+			if field.Title == title {
+				value, _ := field.Value.(string)
+				return value
+			}
+		}
+		return ""
 	}
 
-	firstAction := attachment.Actions[0]
-	if firstAction.Id != "assign_me" || firstAction.Name != "Assign to me" {
-		t.Errorf("unexpected first action: %+v", firstAction)
+	data := fullErrorData()
+	data.PlaybookAvailable = true
+	data.TicketAvailable = true
+	att := BuildAttachment(data, testMapping)
+	if got := actionIDs(att); !strings.HasSuffix(got, ",startplaybook,createticket") {
+		t.Fatalf("expected playbook and ticket buttons, got %s", got)
 	}
 
-	context := firstAction.Integration.Context
-	if context["error_id"] != mapping.ErrorID || context["project_id"] != mapping.ProjectID {
-		t.Errorf("unexpected context: %+v", context)
+	data.Playbook = &PlaybookRun{ID: "run-1", Name: "Bugsnag: NoMethodError", Status: "in progress", URL: "https://mm.example.com/playbooks/runs/run-1"}
+	data.Ticket = &Ticket{Key: "OPS-12", URL: "https://acme.atlassian.net/browse/OPS-12"}
+	att = BuildAttachment(data, testMapping)
+	if got := actionIDs(att); strings.Contains(got, "startplaybook") || strings.Contains(got, "createticket") {
+		t.Fatalf("buttons must be hidden once linked, got %s", got)
+	}
+	if got := fieldValue(att, "Playbook"); got != "[Bugsnag: NoMethodError](https://mm.example.com/playbooks/runs/run-1) · in progress" {
+		t.Errorf("Playbook field = %q", got)
+	}
+	if got := fieldValue(att, "Ticket"); got != "[OPS-12](https://acme.atlassian.net/browse/OPS-12)" {
+		t.Errorf("Ticket field = %q", got)
 	}
 }
@@ -0,0 +1,78 @@
+package formatter
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// ReleaseData captures the details needed to render a Bugsnag release card.
+type ReleaseData struct {
+	ProjectID    string
+	ProjectName  string
+	Version      string
+	ReleaseStage string
+	Revision     string
+	ReleaseURL   string
+}
+
+// BuildReleasePost creates a Mattermost post announcing a new release.
+func BuildReleasePost(release ReleaseData, channelID string) *model.Post {
+	version := strings.TrimSpace(release.Version)
+	if version == "" {
+		version = "unknown version"
+	}
+
+	message := fmt.Sprintf(":rocket: Released **%s**", version)
+	if stage := strings.TrimSpace(release.ReleaseStage); stage != "" {
+		message += fmt.Sprintf(" to **%s**", stage)
+	}
+
+	var fields []*model.SlackAttachmentField
+	addField := func(title, value string) {
+		if strings.TrimSpace(value) == "" {
+			return
+		}
+		fields = append(fields, &model.SlackAttachmentField{Title: title, Value: value, Short: true})
+	}
+	addField("Version", strings.TrimSpace(release.Version))
+	addField("Release Stage", strings.TrimSpace(release.ReleaseStage))
+	addField("Revision", strings.TrimSpace(release.Revision))
+	addField("Project", strings.TrimSpace(release.ProjectName))
+
+	footer := "Bugsnag"
+	if projectName := strings.TrimSpace(release.ProjectName); projectName != "" {
+		footer = fmt.Sprintf("Bugsnag • %s", projectName)
+	}
+
+	title := ""
+	if release.ReleaseURL != "" {
+		title = "View release in Bugsnag"
+	}
+
+	return &model.Post{
+		ChannelId: channelID,
+		Message:   message,
+		Props: map[string]any{
+			"attachments": []*model.SlackAttachment{{
+				Fallback:  strings.ReplaceAll(strings.TrimPrefix(message, ":rocket: "), "**", ""),
+				Color:     "#4949E4", // Bugsnag purple
+				Title:     title,
+				TitleLink: release.ReleaseURL,
+				Fields:    fields,
+				Footer:    footer,
+			}},
+		},
+	}
+}
+
+// BuildReleaseErrorNote is the reply added to a release thread when an error is
+// first seen in that release.
+func BuildReleaseErrorNote(errorData ErrorData, permalink string) string {
+	title := strings.TrimPrefix(BuildTitle(errorData), ":rotating_light: ")
+	if permalink == "" {
+		return fmt.Sprintf(":rotating_light: New error first seen in this release: %s", title)
+	}
+	return fmt.Sprintf(":rotating_light: New error first seen in this release: %s ([card](%s))", title, permalink)
+}
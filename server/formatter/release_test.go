@@ -0,0 +1,33 @@
+package formatter
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBuildReleasePostGolden(t *testing.T) {
+	post := BuildReleasePost(ReleaseData{
+		ProjectID:    "proj-1",
+		ProjectName:  "backend-api",
+		Version:      "2.4.1",
+		ReleaseStage: "production",
+		Revision:     "9f2c1e7",
+		ReleaseURL:   "https://app.bugsnag.com/acme/backend-api/releases/2.4.1",
+	}, "channel-1")
+
+	assertGolden(t, "release_full", post)
+}
+
+func TestBuildReleaseErrorNote(t *testing.T) {
+	data := ErrorData{ExceptionClass: "TypeError", Message: "x is undefined", Severity: "error"}
+
+	got := BuildReleaseErrorNote(data, "https://mm.example.com/_redirect/pl/post-1")
+	want := ":rotating_light: New error first seen in this release: " + strings.TrimPrefix(BuildTitle(data), ":rotating_light: ") + " ([card](https://mm.example.com/_redirect/pl/post-1))"
+	if got != want {
+		t.Errorf("BuildReleaseErrorNote() = %q, want %q", got, want)
+	}
+
+	if got := BuildReleaseErrorNote(data, ""); strings.Contains(got, "[card]") {
+		t.Errorf("expected no card link without a permalink, got %q", got)
+	}
+}
@@ -0,0 +1,315 @@
+package formatter
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultStacktraceMaxFrames is the number of frames shown per exception when
+// no limit is configured.
+const DefaultStacktraceMaxFrames = 15
+
+// DefaultStacktraceMaxLength keeps the thread reply well below the Mattermost
+// post size limit so it stays readable.
+const DefaultStacktraceMaxLength = 4000
+
+// StacktraceFilename is the name of the file attached when a trace overflows
+// the thread reply.
+const StacktraceFilename = "stacktrace.txt"
+
+// StackFrame is a single frame of a Bugsnag stacktrace.
+type StackFrame struct {
+	File         string
+	Method       string
+	LineNumber   string
+	ColumnNumber string
+	InProject    bool
+	// Code maps line numbers to source lines around the frame, as sent by Bugsnag.
+	Code map[string]string
+	// URL links the frame to its source, when a repository is configured.
+	URL string
+}
+
+// Exception is one entry in an error's cause chain.
+type Exception struct {
+	ErrorClass string
+	Message    string
+	Stacktrace []StackFrame
+}
+
+// StacktraceOptions controls how much of a trace is rendered in the thread.
+type StacktraceOptions struct {
+	// MaxFrames limits the frames shown per exception; 0 means no limit.
+	MaxFrames int
+	// MaxLength is the rune budget for the reply; 0 uses DefaultStacktraceMaxLength.
+	MaxLength int
+	// CollapseLibraryFrames folds runs of library frames into a single line.
+	CollapseLibraryFrames bool
+}
+
+// Stacktrace is the rendered form of an error's exceptions.
+type Stacktrace struct {
+	// Reply is the markdown posted in the card thread.
+	Reply string
+	// Full is the complete plain-text trace. It is only set when Reply had to
+	// leave something out, and should be attached as StacktraceFilename.
+	Full string
+}
+
+// RenderStacktrace renders every exception in the cause chain. In-project frames
+// show their code context with the offending line marked.
+func RenderStacktrace(exceptions []Exception, opts StacktraceOptions) Stacktrace {
+	if !hasFrames(exceptions) {
+		return Stacktrace{}
+	}
+
+	maxLength := opts.MaxLength
+	if maxLength <= 0 {
+		maxLength = DefaultStacktraceMaxLength
+	}
+
+	reply, omitted := renderStacktraceReply(exceptions, opts)
+	if !omitted && len([]rune(reply)) <= maxLength {
+		return Stacktrace{Reply: reply}
+	}
+
+	const note = "\n_Full stacktrace attached as " + StacktraceFilename + "._"
+	reply = truncateStacktraceReply(reply, maxLength-len([]rune(note)))
+
+	return Stacktrace{
+		Reply: reply + note,
+		Full:  renderStacktraceFull(exceptions),
+	}
+}
+
+// StacktraceText renders the exceptions as plain text, showing at most
+// maxFrames frames per exception; 0 means no limit.
+func StacktraceText(exceptions []Exception, maxFrames int) string {
+	if !hasFrames(exceptions) {
+		return ""
+	}
+
+	limited := make([]Exception, len(exceptions))
+	for i, exception := range exceptions {
+		limited[i] = exception
+		if maxFrames > 0 && len(exception.Stacktrace) > maxFrames {
+			limited[i].Stacktrace = exception.Stacktrace[:maxFrames]
+		}
+	}
+	return strings.TrimRight(renderStacktraceFull(limited), "\n")
+}
+
+func hasFrames(exceptions []Exception) bool {
+	for _, exception := range exceptions {
+		if len(exception.Stacktrace) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// renderStacktraceReply renders the markdown reply and reports whether frames
+// were left out because of the frame limit.
+func renderStacktraceReply(exceptions []Exception, opts StacktraceOptions) (string, bool) {
+	var sb strings.Builder
+	omitted := false
+
+	sb.WriteString("**Stacktrace:**\n")
+	for i, exception := range exceptions {
+		if i > 0 {
+			sb.WriteString("\n**Caused by:** ")
+		}
+		sb.WriteString(exceptionHeading(exception, true))
+		sb.WriteString("\n")
+
+		if len(exception.Stacktrace) == 0 {
+			continue
+		}
+
+		frames := exception.Stacktrace
+		if opts.MaxFrames > 0 && len(frames) > opts.MaxFrames {
+			frames = frames[:opts.MaxFrames]
+			omitted = true
+		}
+
+		collapse := opts.CollapseLibraryFrames && hasInProjectFrame(exception.Stacktrace)
+
+		sb.WriteString("```\n")
+		for j := 0; j < len(frames); j++ {
+			if collapse && !frames[j].InProject {
+				end := j
+				for end < len(frames) && !frames[end].InProject {
+					end++
+				}
+				if end-j > 1 {
+					sb.WriteString(fmt.Sprintf("  … %d library frames\n", end-j))
+					j = end - 1
+					continue
+				}
+			}
+			writeFrame(&sb, frames[j])
+		}
+		if remaining := len(exception.Stacktrace) - len(frames); remaining > 0 {
+			sb.WriteString(fmt.Sprintf("  ... and %d more frames\n", remaining))
+		}
+		sb.WriteString("```\n")
+		sb.WriteString(frameLinks(frames))
+	}
+
+	return strings.TrimRight(sb.String(), "\n"), omitted
+}
+
+// renderStacktraceFull renders every frame of every exception as plain text.
+func renderStacktraceFull(exceptions []Exception) string {
+	var sb strings.Builder
+	for i, exception := range exceptions {
+		if i > 0 {
+			sb.WriteString("\nCaused by: ")
+		}
+		sb.WriteString(exceptionHeading(exception, false))
+		sb.WriteString("\n")
+		for _, frame := range exception.Stacktrace {
+			writeFrame(&sb, frame)
+		}
+	}
+	return sb.String()
+}
+
+func exceptionHeading(exception Exception, markdown bool) string {
+	errorClass := strings.TrimSpace(exception.ErrorClass)
+	message := strings.TrimSpace(exception.Message)
+	if errorClass == "" {
+		errorClass = "Error"
+	}
+	if markdown {
+		errorClass = "**" + errorClass + "**"
+	}
+	if message == "" {
+		return errorClass
+	}
+	return errorClass + ": " + message
+}
+
+func hasInProjectFrame(frames []StackFrame) bool {
+	for _, frame := range frames {
+		if frame.InProject {
+			return true
+		}
+	}
+	return false
+}
+
+func writeFrame(sb *strings.Builder, frame StackFrame) {
+	prefix := "  "
+	if frame.InProject {
+		prefix = "→ " // highlight in-project frames
+	}
+
+	sb.WriteString(prefix + FrameLocation(frame) + " in " + frameMethod(frame) + "\n")
+
+	if frame.InProject {
+		writeCodeContext(sb, frame)
+	}
+}
+
+// FrameLocation returns "file:line:column", omitting parts that are unknown.
+func FrameLocation(frame StackFrame) string {
+	location := frame.File
+	if location == "" {
+		location = "<unknown>"
+	}
+	if frame.LineNumber != "" {
+		location += ":" + frame.LineNumber
+		if frame.ColumnNumber != "" {
+			location += ":" + frame.ColumnNumber
+		}
+	}
+	return location
+}
+
+func frameMethod(frame StackFrame) string {
+	if frame.Method == "" {
+		return "<anonymous>"
+	}
+	return frame.Method
+}
+
+func writeCodeContext(sb *strings.Builder, frame StackFrame) {
+	if len(frame.Code) == 0 {
+		return
+	}
+
+	code := make(map[int]string, len(frame.Code))
+	lines := make([]int, 0, len(frame.Code))
+	width := 0
+	for key, line := range frame.Code {
+		n, err := strconv.Atoi(strings.TrimSpace(key))
+		if err != nil {
+			continue
+		}
+		code[n] = strings.TrimRight(line, " \t\r\n")
+		lines = append(lines, n)
+		if w := len(strconv.Itoa(n)); w > width {
+			width = w
+		}
+	}
+	sort.Ints(lines)
+
+	current, _ := strconv.Atoi(frame.LineNumber)
+	for _, n := range lines {
+		marker := "    "
+		if n == current {
+			marker = "  > "
+		}
+		sb.WriteString(fmt.Sprintf("%s%*d | %s\n", marker, width, n, code[n]))
+	}
+}
+
+// frameLinks lists source links for linked frames below the code block, since
+// links do not render inside code blocks.
+func frameLinks(frames []StackFrame) string {
+	var sb strings.Builder
+	seen := map[string]bool{}
+	for _, frame := range frames {
+		if frame.URL == "" || seen[frame.URL] {
+			continue
+		}
+		seen[frame.URL] = true
+		sb.WriteString(fmt.Sprintf("- [%s](%s)\n", FrameLocation(frame), frame.URL))
+	}
+	return sb.String()
+}
+
+// truncateStacktraceReply cuts the reply at a line boundary so it fits in
+// maxLength runes, closing an open code block if needed.
+func truncateStacktraceReply(reply string, maxLength int) string {
+	if len([]rune(reply)) <= maxLength {
+		return reply
+	}
+
+	const closing = "\n…\n```"
+	budget := maxLength - len([]rune(closing))
+
+	var sb strings.Builder
+	inCode := false
+	used := 0
+	for _, line := range strings.Split(reply, "\n") {
+		size := len([]rune(line)) + 1
+		if used+size > budget {
+			break
+		}
+		sb.WriteString(line + "\n")
+		used += size
+		if strings.HasPrefix(line, "```") {
+			inCode = !inCode
+		}
+	}
+
+	out := strings.TrimRight(sb.String(), "\n")
+	if inCode {
+		return out + closing
+	}
+	return out + "\n…"
+}
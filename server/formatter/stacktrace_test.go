@@ -0,0 +1,160 @@
+package formatter
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func assertGoldenText(t *testing.T, name, got string) {
+	t.Helper()
+
+	path := filepath.Join("testdata", name+".golden.md")
+	if *updateGolden {
+		if err := os.WriteFile(path, []byte(got), 0o644); err != nil {
+			t.Fatalf("write golden %s: %v", path, err)
+		}
+	}
+
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read golden %s: %v", path, err)
+	}
+
+	if got != string(want) {
+		t.Errorf("%s does not match golden file %s\ngot:\n%s\nwant:\n%s", name, path, got, want)
+	}
+}
+
+func chainedExceptions() []Exception {
+	return []Exception{
+		{
+			ErrorClass: "OrderError",
+			Message:    "could not submit order",
+			Stacktrace: []StackFrame{
+				{
+					File: "app/controllers/checkout_controller.rb", Method: "submit", LineNumber: "42", ColumnNumber: "7", InProject: true,
+					Code: map[string]string{"40": "  def submit", "41": "    order = load_order", "42": "    order.total!", "43": "  end"},
+				},
+				{File: "gems/actionpack/metal.rb", Method: "dispatch", LineNumber: "190"},
+				{File: "gems/actionpack/router.rb", Method: "serve", LineNumber: "45"},
+				{File: "gems/rack/builder.rb", Method: "call", LineNumber: "12"},
+				{File: "app/middleware/auth.rb", Method: "call", LineNumber: "9", InProject: true},
+			},
+		},
+		{
+			ErrorClass: "NoMethodError",
+			Message:    "undefined method `total!' for nil",
+			Stacktrace: []StackFrame{
+				{File: "app/models/order.rb", Method: "total!", LineNumber: "88", InProject: true},
+			},
+		},
+	}
+}
+
+func TestRenderStacktraceGolden(t *testing.T) {
+	trace := RenderStacktrace(chainedExceptions(), StacktraceOptions{
+		MaxFrames:             DefaultStacktraceMaxFrames,
+		CollapseLibraryFrames: true,
+	})
+
+	if trace.Full != "" {
+		t.Fatalf("expected no attachment, got %q", trace.Full)
+	}
+	assertGoldenText(t, "stacktrace_chained", trace.Reply)
+}
+
+func TestRenderStacktraceWithoutCollapsing(t *testing.T) {
+	trace := RenderStacktrace(chainedExceptions(), StacktraceOptions{})
+
+	if !strings.Contains(trace.Reply, "gems/rack/builder.rb:12 in call") {
+		t.Fatalf("expected library frames to be listed:\n%s", trace.Reply)
+	}
+	if strings.Contains(trace.Reply, "library frames") {
+		t.Fatalf("did not expect collapsed frames:\n%s", trace.Reply)
+	}
+}
+
+func TestRenderStacktraceKeepsLibraryOnlyTraces(t *testing.T) {
+	trace := RenderStacktrace([]Exception{{
+		ErrorClass: "Error",
+		Stacktrace: []StackFrame{{File: "lib/a.js", LineNumber: "1"}, {File: "lib/b.js", LineNumber: "2"}},
+	}}, StacktraceOptions{CollapseLibraryFrames: true})
+
+	if !strings.Contains(trace.Reply, "lib/a.js:1") || !strings.Contains(trace.Reply, "lib/b.js:2") {
+		t.Fatalf("library-only traces must not be collapsed away:\n%s", trace.Reply)
+	}
+}
+
+func TestRenderStacktraceFrameLimitAttachesFullTrace(t *testing.T) {
+	var frames []StackFrame
+	for i := 1; i <= 20; i++ {
+		frames = append(frames, StackFrame{File: fmt.Sprintf("app/file%d.go", i), LineNumber: fmt.Sprint(i), InProject: true})
+	}
+
+	trace := RenderStacktrace([]Exception{{ErrorClass: "panic", Stacktrace: frames}}, StacktraceOptions{MaxFrames: 5})
+
+	if !strings.Contains(trace.Reply, "... and 15 more frames") {
+		t.Fatalf("expected remaining frame count:\n%s", trace.Reply)
+	}
+	if !strings.Contains(trace.Reply, StacktraceFilename) {
+		t.Fatalf("expected attachment note:\n%s", trace.Reply)
+	}
+	if !strings.Contains(trace.Full, "app/file20.go:20") {
+		t.Fatalf("full trace must contain every frame:\n%s", trace.Full)
+	}
+}
+
+func TestRenderStacktraceLengthLimit(t *testing.T) {
+	var frames []StackFrame
+	for i := 1; i <= 200; i++ {
+		frames = append(frames, StackFrame{File: strings.Repeat("x", 80) + ".go", LineNumber: fmt.Sprint(i), InProject: true})
+	}
+
+	trace := RenderStacktrace([]Exception{{ErrorClass: "panic", Stacktrace: frames}}, StacktraceOptions{MaxLength: 1000})
+
+	if n := len([]rune(trace.Reply)); n > 1000 {
+		t.Fatalf("reply exceeds length limit: %d runes", n)
+	}
+	if strings.Count(trace.Reply, "```")%2 != 0 {
+		t.Fatalf("code block left open:\n%s", trace.Reply)
+	}
+	if trace.Full == "" {
+		t.Fatal("expected full trace attachment")
+	}
+}
+
+func TestRenderStacktraceEmpty(t *testing.T) {
+	if trace := RenderStacktrace(nil, StacktraceOptions{}); trace.Reply != "" || trace.Full != "" {
+		t.Fatalf("expected empty trace, got %+v", trace)
+	}
+}
+
+func TestRenderStacktraceFrameLinks(t *testing.T) {
+	exceptions := chainedExceptions()
+	exceptions[0].Stacktrace[0].URL = "https://github.com/acme/shop/blob/v1.2.0/app/controllers/checkout_controller.rb#L42"
+	exceptions[1].Stacktrace[0].URL = "https://github.com/acme/shop/blob/v1.2.0/app/models/order.rb#L88"
+
+	trace := RenderStacktrace(exceptions, StacktraceOptions{CollapseLibraryFrames: true})
+
+	assertGoldenText(t, "stacktrace_linked", trace.Reply)
+	if strings.Contains(trace.Full, "](") {
+		t.Fatal("links must only be rendered in the reply")
+	}
+}
+
+func TestStacktraceText(t *testing.T) {
+	text := StacktraceText(chainedExceptions(), 2)
+
+	if !strings.HasPrefix(text, "OrderError: could not submit order\n→ app/controllers/checkout_controller.rb:42:7 in submit") {
+		t.Fatalf("unexpected trace:\n%s", text)
+	}
+	if strings.Contains(text, "gems/actionpack/router.rb") || !strings.Contains(text, "Caused by: NoMethodError") {
+		t.Fatalf("expected two frames per exception and the cause:\n%s", text)
+	}
+	if StacktraceText(nil, 0) != "" {
+		t.Fatal("expected an empty trace")
+	}
+}
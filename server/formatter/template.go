@@ -0,0 +1,288 @@
+package formatter
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// CardTemplateIDPropKey is the post prop that holds the ID of the CardTemplate
+// a card was rendered with, so updates re-render it with the template as it is
+// currently stored.
+const CardTemplateIDPropKey = "bugsnag_card_template_id"
+
+// legacyCardTemplatePropKey held a full copy of the template on cards rendered
+// by earlier versions. Only its ID is still read.
+const legacyCardTemplatePropKey = "bugsnag_card_template"
+
+// DefaultTemplateID is the ID of the stored template applied to every channel
+// rule that does not reference a template of its own.
+const DefaultTemplateID = "default"
+
+// CardTemplate lets admins customize how cards are rendered. Title, Text and
+// field values are Go text/template strings executed against ErrorData. Any
+// part left empty falls back to the built-in layout.
+type CardTemplate struct {
+	ID     string          `json:"id"`
+	Name   string          `json:"name,omitempty"`
+	Title  string          `json:"title,omitempty"`
+	Text   string          `json:"text,omitempty"`
+	Fields []FieldTemplate `json:"fields,omitempty"`
+	// Emoji and Colors override the severity markers, keyed by severity.
+	Emoji  map[string]string `json:"emoji,omitempty"`
+	Colors map[string]string `json:"colors,omitempty"`
+}
+
+// FieldTemplate describes one attachment field. Fields render in the order
+// they are listed and are skipped when their value renders empty.
+type FieldTemplate struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+	Short bool   `json:"short,omitempty"`
+}
+
+var hexColorPattern = regexp.MustCompile(`^#[0-9A-Fa-f]{6}$`)
+
+// SampleErrorData returns representative data used to validate and preview
+// templates.
+func SampleErrorData() ErrorData {
+	return ErrorData{
+		ID:               "5f8e1c2a9b3d4e0012345678",
+		ProjectID:        "5f8e1c2a9b3d4e0087654321",
+		ProjectName:      "checkout-api",
+		ExceptionClass:   "NullReferenceException",
+		Message:          "Object reference not set to an instance of an object",
+		Summary:          "New error in production",
+		Context:          "CheckoutController#submit",
+		Status:           "open",
+		Environment:      "production",
+		Severity:         "error",
+		AppVersion:       "2.4.1",
+		AssigneeUsername: "alice",
+		Counts:           Counts{Users: 12, Events1h: 3, Events24h: 42},
+		LastSeen:         "2025-11-28T10:23:00Z",
+		ErrorURL:         "https://app.bugsnag.com/acme/checkout-api/errors/5f8e1c2a9b3d4e0012345678",
+		Device:           "Apple MacBookPro18,3",
+		OS:               "macOS 14.2",
+		Request: RequestContext{
+			URL:      "https://shop.example.com/checkout",
+			Browser:  "Chrome 120.0",
+			OS:       "macOS 14.2",
+			Hostname: "web-3",
+			User:     "Jane Doe (user-42)",
+		},
+	}
+}
+
+// Validate checks that every template parses and executes against sample
+// data, and that colors are hex values.
+func (t CardTemplate) Validate() error {
+	if strings.TrimSpace(t.ID) == "" {
+		return fmt.Errorf("template id is required")
+	}
+
+	for severity, color := range t.Colors {
+		if !hexColorPattern.MatchString(color) {
+			return fmt.Errorf("color for %q must be a hex value like #D9534F, got %q", severity, color)
+		}
+	}
+
+	for i, field := range t.Fields {
+		if strings.TrimSpace(field.Title) == "" {
+			return fmt.Errorf("field %d: title is required", i+1)
+		}
+	}
+
+	if _, _, err := t.render(SampleErrorData(), ErrorPostMapping{}); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ApplyTemplatedCard renders errorData onto post using tmpl, or the built-in
+// layout when tmpl is nil. If the template fails to execute, the built-in
+// layout is used and the error is returned so the caller can log it.
+func ApplyTemplatedCard(post *model.Post, errorData ErrorData, mapping ErrorPostMapping, tmpl *CardTemplate) error {
+	if post.Props == nil {
+		post.Props = map[string]any{}
+	}
+
+	post.Props[CardPropKey] = errorData
+
+	var renderErr error
+	if tmpl != nil {
+		message, attachment, err := tmpl.render(errorData, mapping)
+		if err == nil {
+			post.Message = message
+			post.Props["attachments"] = []*model.SlackAttachment{attachment}
+			post.Props[CardTemplateIDPropKey] = tmpl.ID
+			delete(post.Props, legacyCardTemplatePropKey)
+			return nil
+		}
+		renderErr = fmt.Errorf("render card template %q: %w", tmpl.ID, err)
+	}
+
+	delete(post.Props, CardTemplateIDPropKey)
+	delete(post.Props, legacyCardTemplatePropKey)
+	post.Message = BuildTitle(errorData)
+	post.Props["attachments"] = []*model.SlackAttachment{BuildAttachment(errorData, mapping)}
+	return renderErr
+}
+
+// CardTemplateID returns the ID of the template a card was rendered with, or
+// an empty string when it uses the built-in layout.
+func CardTemplateID(post *model.Post) string {
+	if post == nil || post.Props == nil {
+		return ""
+	}
+	if id, ok := post.Props[CardTemplateIDPropKey].(string); ok {
+		return id
+	}
+
+	switch v := post.Props[legacyCardTemplatePropKey].(type) {
+	case nil:
+		return ""
+	case CardTemplate:
+		return v.ID
+	case *CardTemplate:
+		return v.ID
+	default:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return ""
+		}
+		var tmpl CardTemplate
+		if err := json.Unmarshal(data, &tmpl); err != nil {
+			return ""
+		}
+		return tmpl.ID
+	}
+}
+
+// CardTemplateFor returns the stored template a card was rendered with. It
+// returns nil, which selects the built-in layout, when the card has no
+// template or its template has since been deleted.
+func CardTemplateFor(post *model.Post, templates []CardTemplate) *CardTemplate {
+	id := CardTemplateID(post)
+	if id == "" {
+		return nil
+	}
+	for i := range templates {
+		if templates[i].ID == id {
+			return &templates[i]
+		}
+	}
+	return nil
+}
+
+// FindTemplate returns the template with the given ID, falling back to the
+// stored default template. It returns nil when neither exists, which selects
+// the built-in layout.
+func FindTemplate(templates []CardTemplate, id string) *CardTemplate {
+	id = strings.TrimSpace(id)
+	var fallback *CardTemplate
+	for i := range templates {
+		if id != "" && templates[i].ID == id {
+			return &templates[i]
+		}
+		if templates[i].ID == DefaultTemplateID {
+			fallback = &templates[i]
+		}
+	}
+	return fallback
+}
+
+func (t CardTemplate) render(errorData ErrorData, mapping ErrorPostMapping) (string, *model.SlackAttachment, error) {
+	style := t.style()
+	attachment := buildAttachment(errorData, mapping, style)
+
+	message := BuildTitle(errorData)
+	if t.Title != "" {
+		rendered, err := t.execute("title", t.Title, errorData)
+		if err != nil {
+			return "", nil, err
+		}
+		message = rendered
+	}
+
+	if t.Text != "" {
+		rendered, err := t.execute("text", t.Text, errorData)
+		if err != nil {
+			return "", nil, err
+		}
+		attachment.Text = rendered
+	}
+
+	if len(t.Fields) > 0 {
+		attachment.Fields = nil
+		for i, field := range t.Fields {
+			value, err := t.execute(fmt.Sprintf("field %d (%s)", i+1, field.Title), field.Value, errorData)
+			if err != nil {
+				return "", nil, err
+			}
+			if value == "" {
+				continue
+			}
+			attachment.Fields = append(attachment.Fields, &model.SlackAttachmentField{
+				Title: field.Title,
+				Value: value,
+				Short: model.SlackCompatibleBool(field.Short),
+			})
+		}
+	}
+
+	return message, attachment, nil
+}
+
+func (t CardTemplate) execute(name, text string, errorData ErrorData) (string, error) {
+	parsed, err := template.New(name).Funcs(t.funcs()).Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("parse %s: %w", name, err)
+	}
+
+	var buf bytes.Buffer
+	if err := parsed.Execute(&buf, errorData); err != nil {
+		return "", fmt.Errorf("execute %s: %w", name, err)
+	}
+
+	return strings.TrimSpace(buf.String()), nil
+}
+
+func (t CardTemplate) funcs() template.FuncMap {
+	style := t.style()
+	return template.FuncMap{
+		"severityEmoji": style.emoji,
+		"upper":         strings.ToUpper,
+		"lower":         strings.ToLower,
+		"truncate": func(n int, s string) string {
+			runes := []rune(s)
+			if n <= 0 || len(runes) <= n {
+				return s
+			}
+			return string(runes[:n]) + "…"
+		},
+	}
+}
+
+func (t CardTemplate) style() cardStyle {
+	return cardStyle{
+		emoji: func(severity string) string {
+			if v, ok := t.Emoji[severity]; ok {
+				return v
+			}
+			return SeverityEmoji(severity)
+		},
+		color: func(severity string) string {
+			if v, ok := t.Colors[severity]; ok {
+				return v
+			}
+			return SeverityColor(severity)
+		},
+	}
+}
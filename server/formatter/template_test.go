@@ -0,0 +1,135 @@
+package formatter
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+func mobileTemplate() CardTemplate {
+	return CardTemplate{
+		ID:    "mobile",
+		Title: "{{severityEmoji .Severity}} {{.ExceptionClass}} in {{.ProjectName}}",
+		Fields: []FieldTemplate{
+			{Title: "App Version", Value: "{{.AppVersion}}", Short: true},
+			{Title: "Env", Value: "{{upper .Environment}}", Short: true},
+			{Title: "Assigned", Value: "{{.Assignee}}"},
+			{Title: "Empty", Value: "{{.Context | printf \"%.0s\"}}"},
+		},
+		Emoji:  map[string]string{"error": "💥"},
+		Colors: map[string]string{"error": "#000000"},
+	}
+}
+
+func TestCardTemplateGolden(t *testing.T) {
+	tmpl := mobileTemplate()
+	post := &model.Post{ChannelId: testMapping.ChannelID}
+
+	if err := ApplyTemplatedCard(post, fullErrorData(), testMapping, &tmpl); err != nil {
+		t.Fatalf("apply template: %v", err)
+	}
+
+	assertGolden(t, "card_template_mobile", post)
+}
+
+func TestCardTemplateValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		tmpl    CardTemplate
+		wantErr string
+	}{
+		{name: "valid", tmpl: mobileTemplate()},
+		{name: "missing id", tmpl: CardTemplate{Title: "x"}, wantErr: "id is required"},
+		{name: "parse error", tmpl: CardTemplate{ID: "t", Title: "{{.ExceptionClass"}, wantErr: "parse title"},
+		{name: "unknown field", tmpl: CardTemplate{ID: "t", Text: "{{.Nope}}"}, wantErr: "execute text"},
+		{name: "bad color", tmpl: CardTemplate{ID: "t", Colors: map[string]string{"error": "red"}}, wantErr: "hex value"},
+		{name: "field without title", tmpl: CardTemplate{ID: "t", Fields: []FieldTemplate{{Value: "x"}}}, wantErr: "title is required"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.tmpl.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestApplyTemplatedCardFallsBackOnError(t *testing.T) {
+	tmpl := CardTemplate{ID: "broken", Text: "{{.Nope}}"}
+	post := &model.Post{}
+
+	if err := ApplyTemplatedCard(post, fullErrorData(), testMapping, &tmpl); err == nil {
+		t.Fatal("expected render error")
+	}
+
+	if post.Message != BuildTitle(fullErrorData()) {
+		t.Fatalf("expected built-in title, got %q", post.Message)
+	}
+	if CardTemplateID(post) != "" {
+		t.Fatal("broken template must not be stored on the card")
+	}
+}
+
+func TestUpdatePostKeepsTemplate(t *testing.T) {
+	tmpl := mobileTemplate()
+	post := &model.Post{}
+	if err := ApplyTemplatedCard(post, fullErrorData(), testMapping, &tmpl); err != nil {
+		t.Fatalf("apply template: %v", err)
+	}
+
+	if _, ok := post.Props["bugsnag_card_template"]; ok {
+		t.Fatal("the template source must not be copied onto the card")
+	}
+
+	updated := UpdatePost(UpdatePostParams{Post: post, NewStatus: "fixed", Mapping: testMapping, Templates: []CardTemplate{tmpl}})
+
+	if !strings.HasPrefix(updated.Message, "💥 NullReferenceException") {
+		t.Fatalf("template title lost after update: %q", updated.Message)
+	}
+	if CardStatus(updated) != "fixed" {
+		t.Fatalf("unexpected status: %s", CardStatus(updated))
+	}
+}
+
+func TestUpdatePostUsesStoredTemplate(t *testing.T) {
+	tmpl := mobileTemplate()
+	post := &model.Post{}
+	if err := ApplyTemplatedCard(post, fullErrorData(), testMapping, &tmpl); err != nil {
+		t.Fatalf("apply template: %v", err)
+	}
+
+	edited := tmpl
+	edited.Title = "edited {{.ExceptionClass}}"
+	updated := UpdatePost(UpdatePostParams{Post: post, NewStatus: "fixed", Mapping: testMapping, Templates: []CardTemplate{edited}})
+	if updated.Message != "edited NullReferenceException" {
+		t.Fatalf("expected the edited template, got %q", updated.Message)
+	}
+
+	updated = UpdatePost(UpdatePostParams{Post: updated, NewStatus: "fixed", Mapping: testMapping})
+	if updated.Message != BuildTitle(func() ErrorData { d := fullErrorData(); d.Status = "fixed"; return d }()) {
+		t.Fatalf("expected the built-in layout once the template is gone, got %q", updated.Message)
+	}
+}
+
+func TestFindTemplate(t *testing.T) {
+	templates := []CardTemplate{{ID: "mobile"}, {ID: DefaultTemplateID}}
+
+	if got := FindTemplate(templates, "mobile"); got == nil || got.ID != "mobile" {
+		t.Fatalf("expected mobile template, got %+v", got)
+	}
+	if got := FindTemplate(templates, "missing"); got == nil || got.ID != DefaultTemplateID {
+		t.Fatalf("expected default template, got %+v", got)
+	}
+	if got := FindTemplate(templates[:1], ""); got != nil {
+		t.Fatalf("expected built-in layout, got %+v", got)
+	}
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/a-voronkov/mattermost-bugsnag/server/connection"
+	"github.com/a-voronkov/mattermost-bugsnag/server/formatter"
+	"github.com/a-voronkov/mattermost-bugsnag/server/outbound"
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// incidentChannelPrefix starts the name of every incident channel.
+const incidentChannelPrefix = "bugsnag-"
+
+// incidentCountTTL is how long an error's event count fetched for an incident
+// policy is reused, so a burst of webhooks for one error asks Bugsnag once.
+const incidentCountTTL = time.Minute
+
+var nonSlugChars = regexp.MustCompile(`[^a-z0-9]+`)
+
+// eventCounts remembers the event counts recently fetched per error. Failed
+// lookups are remembered as well, so an unreachable Bugsnag is not asked on
+// every webhook.
+type eventCounts struct {
+	mu      sync.Mutex
+	entries map[string]eventCount
+}
+
+type eventCount struct {
+	events  int
+	err     error
+	fetched time.Time
+}
+
+// get returns the count stored under key, calling fetch when it is older than
+// incidentCountTTL.
+func (c *eventCounts) get(key string, now time.Time, fetch func() (int, error)) (int, error) {
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	c.mu.Unlock()
+	if ok && now.Sub(entry.fetched) < incidentCountTTL {
+		return entry.events, entry.err
+	}
+
+	events, err := fetch()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = map[string]eventCount{}
+	}
+	for k, e := range c.entries {
+		if now.Sub(e.fetched) >= incidentCountTTL {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = eventCount{events: events, err: err, fetched: now}
+	return events, err
+}
+
+// qualifiesForIncident reports whether an error meets the rule's incident
+// policy. events returns the error's total event count; it is only called for
+// a MinEvents condition that no cheaper condition already satisfied.
+func qualifiesForIncident(policy *IncidentPolicy, payload webhookPayload, events func() (int, error)) bool {
+	if policy == nil {
+		return false
+	}
+	if len(policy.Environments) > 0 && !containsValue(policy.Environments, payload.getEnvironment()) {
+		return false
+	}
+
+	if !policy.Unhandled && !policy.Spiking && policy.MinEvents <= 0 {
+		return true
+	}
+	if policy.Unhandled && payload.isUnhandled() {
+		return true
+	}
+	if policy.Spiking {
+		switch payload.Trigger.triggerType() {
+		case TriggerProjectSpiking, TriggerErrorEventFrequency:
+			return true
+		}
+	}
+	if policy.MinEvents > 0 {
+		count, err := events()
+		return err == nil && count >= policy.MinEvents
+	}
+
+	return false
+}
+
+// openIncidentIfNeeded creates an incident channel for the error when the rule
+// asks for one and none is open yet. It stores the channel on the mapping and
+// returns the mapping. resolvedBy is the user who last resolved the error, if
+// any.
+func (p *Plugin) openIncidentIfNeeded(mm *MMClient, rule ChannelRule, payload webhookPayload, data formatter.ErrorData, mapping ErrorPostMapping, resolvedBy string, tmpl *formatter.CardTemplate, cfg Configuration) ErrorPostMapping {
+	if rule.Incident == nil || mapping.IncidentChannelID != "" {
+		return mapping
+	}
+	if data.Status != "" && data.Status != "open" {
+		return mapping
+	}
+
+	events := func() (int, error) {
+		return p.eventCounts.get(mapping.ProjectID+":"+mapping.ErrorID, time.Now(), func() (int, error) {
+			conn, found := connection.Find(cfg.AllConnections(), rule.ConnectionID)
+			if !found {
+				return 0, fmt.Errorf("connection %q is not configured", rule.ConnectionID)
+			}
+			client, err := p.bugsnagClients().Client(conn.APIToken)
+			if err != nil {
+				return 0, err
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			details, err := client.GetError(ctx, mapping.ProjectID, mapping.ErrorID)
+			if err != nil {
+				mm.LogDebug("failed to fetch event count for incident policy", "error_id", mapping.ErrorID, "err", err.Error())
+				return 0, err
+			}
+			return details.Events, nil
+		})
+	}
+	if !qualifiesForIncident(rule.Incident, payload, events) {
+		return mapping
+	}
+
+	channel, err := p.createIncidentChannel(mm, rule, data, mapping)
+	if err != nil {
+		p.API.LogWarn("failed to open incident channel", "rule_id", rule.ID, "error_id", mapping.ErrorID, "err", err.Error())
+		return mapping
+	}
+
+	userMappings, _ := loadUserMappings(mm)
+	p.inviteIncidentMembers(channel, incidentMembers(rule, payload, userMappingsFor(userMappings, rule.ConnectionID), resolvedBy))
+
+	// Seed the channel with a copy of the card and its stacktrace. The card
+	// in the rule's channel stays the one kept up to date.
+	card := &model.Post{ChannelId: channel.Id}
+	if err := formatter.ApplyTemplatedCard(card, data, formatter.ErrorPostMapping{
+		ChannelID: channel.Id,
+		ProjectID: mapping.ProjectID,
+		ErrorID:   mapping.ErrorID,
+	}, tmpl); err != nil {
+		p.API.LogWarn("card template failed, using built-in layout", "err", err.Error())
+	}
+	if post, appErr := mm.CreateCardPost(card); appErr != nil {
+		mm.LogDebug("failed to seed incident channel with the card", "channel_id", channel.Id, "err", appErr.Error())
+	} else {
+		p.postStacktraceReply(mm, channel.Id, post.Id, payload, cfg)
+	}
+
+	if _, appErr := mm.CreateReply(mapping.ChannelID, mapping.PostID, fmt.Sprintf("🚨 **Incident**: opened ~%s for this error.", channel.Name)); appErr != nil {
+		mm.LogDebug("failed to link incident channel from the card", "err", appErr.Error())
+	}
+
+	// The mapping may have changed while the channel was being set up, so
+	// only the channel is written back.
+	mapping.IncidentChannelID = channel.Id
+	if _, appErr := mm.UpdateJSON(errorPostKVKey(mapping.ProjectID, mapping.ErrorID), &mapping, func() {
+		mapping.IncidentChannelID = channel.Id
+	}); appErr != nil {
+		mm.LogDebug("failed to store incident channel", "err", appErr.Error())
+	}
+
+	p.API.LogInfo("incident channel opened", "channel_id", channel.Id, "rule_id", rule.ID, "error_id", mapping.ErrorID)
+	return mapping
+}
+
+// createIncidentChannel creates the incident channel in the team of the rule's
+// channel. Names of archived incident channels are not reused, so an error
+// that regresses gets a fresh channel.
+func (p *Plugin) createIncidentChannel(mm *MMClient, rule ChannelRule, data formatter.ErrorData, mapping ErrorPostMapping) (*model.Channel, error) {
+	ruleChannel, appErr := mm.GetChannel(rule.ChannelID)
+	if appErr != nil {
+		return nil, fmt.Errorf("load rule channel: %w", appErr)
+	}
+
+	channelType := model.ChannelTypeOpen
+	if rule.Incident.Private {
+		channelType = model.ChannelTypePrivate
+	}
+
+	base := incidentChannelName(data.ExceptionClass, mapping.ErrorID)
+	name := base
+	for i := 2; ; i++ {
+		_, appErr := p.API.GetChannelByName(ruleChannel.TeamId, name, true)
+		if appErr != nil && appErr.StatusCode == http.StatusNotFound {
+			break
+		}
+		if appErr != nil {
+			return nil, fmt.Errorf("look up channel %q: %w", name, appErr)
+		}
+		if i > 20 {
+			return nil, fmt.Errorf("no free channel name for %q", base)
+		}
+		name = fmt.Sprintf("%s-%d", base, i)
+	}
+
+	title := data.ExceptionClass
+	if title == "" {
+		title = data.Message
+	}
+	purpose := fmt.Sprintf("Incident for Bugsnag error %s", mapping.ErrorID)
+	if data.ProjectName != "" {
+		purpose = fmt.Sprintf("Incident for a %s error in Bugsnag", data.ProjectName)
+	}
+	var links []string
+	if link := mm.Permalink(mapping.PostID); link != "" {
+		links = append(links, fmt.Sprintf("[Error card](%s)", link))
+	}
+	if data.ErrorURL != "" {
+		links = append(links, fmt.Sprintf("[Open in Bugsnag](%s)", data.ErrorURL))
+	}
+	header := strings.Join(links, " · ")
+
+	channel, appErr := p.API.CreateChannel(&model.Channel{
+		TeamId:      ruleChannel.TeamId,
+		Type:        channelType,
+		Name:        name,
+		DisplayName: truncateText("🔥 "+title, model.ChannelDisplayNameMaxRunes),
+		Purpose:     truncateText(purpose, model.ChannelPurposeMaxRunes),
+		Header:      truncateText(header, model.ChannelHeaderMaxRunes),
+		CreatorId:   p.botUserID,
+	})
+	if appErr != nil {
+		return nil, fmt.Errorf("create channel: %w", appErr)
+	}
+
+	if p.botUserID != "" {
+		if _, appErr := p.API.AddChannelMember(channel.Id, p.botUserID); appErr != nil {
+			mm.LogDebug("failed to add the bot to the incident channel", "channel_id", channel.Id, "err", appErr.Error())
+		}
+	}
+
+	return channel, nil
+}
+
+// incidentChannelName names an incident channel after the error's class and
+// ID, within Mattermost's channel name rules.
+func incidentChannelName(exceptionClass, errorID string) string {
+	id := strings.Trim(nonSlugChars.ReplaceAllString(strings.ToLower(errorID), "-"), "-")
+	if len(id) > 8 {
+		id = id[len(id)-8:]
+	}
+
+	class := strings.Trim(nonSlugChars.ReplaceAllString(strings.ToLower(exceptionClass), "-"), "-")
+	if class == "" {
+		class = "error"
+	}
+	// Leave room for the prefix, the ID and a "-N" suffix.
+	if limit := model.ChannelNameMaxLength - len(incidentChannelPrefix) - len(id) - 4; len(class) > limit {
+		class = strings.TrimRight(class[:limit], "-")
+	}
+
+	return strings.TrimRight(incidentChannelPrefix+class+"-"+id, "-")
+}
+
+// incidentMembers returns the users to invite: the mapped assignee, the rule's
+// on-call group and whoever last resolved the error.
+func incidentMembers(rule ChannelRule, payload webhookPayload, userMappings []UserMapping, resolvedBy string) []string {
+	var members []string
+	add := func(userID string) {
+		userID = strings.TrimSpace(userID)
+		if userID == "" {
+			return
+		}
+		for _, existing := range members {
+			if existing == userID {
+				return
+			}
+		}
+		members = append(members, userID)
+	}
+
+	if assignee := payload.getAssignedCollaborator(); assignee != nil {
+		add(mapBugsnagToMattermost(userMappings, assignee.ID, assignee.Email))
+	}
+	for _, userID := range rule.Incident.OnCallUserIDs {
+		add(userID)
+	}
+	add(resolvedBy)
+
+	return members
+}
+
+// inviteIncidentMembers adds the users to the channel, skipping those who
+// cannot join it.
+func (p *Plugin) inviteIncidentMembers(channel *model.Channel, userIDs []string) {
+	for _, userID := range userIDs {
+		if _, appErr := p.API.AddChannelMember(channel.Id, userID); appErr != nil {
+			p.API.LogWarn("failed to invite user to incident channel", "channel_id", channel.Id, "user_id", userID, "err", appErr.Error())
+		}
+	}
+}
+
+// closeIncident archives the error's incident channel once the error is fixed
+// and forgets it, so a regression opens a new one.
+func (p *Plugin) closeIncident(mm *MMClient, mapping ErrorPostMapping, status string) ErrorPostMapping {
+	if mapping.IncidentChannelID == "" || status != "fixed" {
+		return mapping
+	}
+
+	if _, appErr := mm.CreatePost(mapping.IncidentChannelID, "✅ The error was fixed in Bugsnag. Archiving this channel.", nil); appErr != nil {
+		mm.LogDebug("failed to announce incident close", "channel_id", mapping.IncidentChannelID, "err", appErr.Error())
+	}
+	if appErr := p.API.DeleteChannel(mapping.IncidentChannelID); appErr != nil {
+		p.API.LogWarn("failed to archive incident channel", "channel_id", mapping.IncidentChannelID, "err", appErr.Error())
+		return mapping
+	}
+	p.API.LogInfo("incident channel archived", "channel_id", mapping.IncidentChannelID, "error_id", mapping.ErrorID)
+
+	mapping.IncidentChannelID = ""
+	if _, appErr := mm.UpdateJSON(errorPostKVKey(mapping.ProjectID, mapping.ErrorID), &mapping, func() {
+		mapping.IncidentChannelID = ""
+	}); appErr != nil {
+		mm.LogDebug("failed to clear incident channel", "err", appErr.Error())
+	}
+	return mapping
+}
+
+// errorStatusChanged applies a new Bugsnag status to the error's incident
+// channel and playbook run, and returns the updated mapping.
+func (p *Plugin) errorStatusChanged(mm *MMClient, mapping ErrorPostMapping, status string) ErrorPostMapping {
+	mapping = p.acknowledgeEscalation(mm, mapping, fmt.Sprintf("the error is now **%s**", status))
+	mapping = p.closeIncident(mm, mapping, status)
+	p.resolvePlaybookRun(mm, mapping, status)
+	return mapping
+}
+
+// syncedStatusChange handles a status change the status sync picked up from
+// Bugsnag.
+func (p *Plugin) syncedStatusChange(projectID, errorID, from, to string) {
+	mm := newMMClient(p.API, p.getConfiguration().EnableDebugLog, p.kvNS(), p.botUserID)
+
+	var mapping ErrorPostMapping
+	if found, appErr := mm.LoadJSON(errorPostKVKey(projectID, errorID), &mapping); appErr != nil || !found {
+		return
+	}
+	mapping = p.errorStatusChanged(mm, mapping, to)
+	p.emitCardEvent(mm, outbound.Event{Type: outbound.EventStatusChanged, Source: outbound.SourceSync, Status: to, PreviousStatus: from}, mapping)
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/a-voronkov/mattermost-bugsnag/server/formatter"
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestQualifiesForIncident(t *testing.T) {
+	production := &appInfo{ReleaseStage: "production"}
+	events := func(count int) func() (int, error) {
+		return func() (int, error) { return count, nil }
+	}
+
+	tests := []struct {
+		name    string
+		policy  *IncidentPolicy
+		payload webhookPayload
+		events  func() (int, error)
+		want    bool
+	}{
+		{name: "no policy", payload: webhookPayload{Error: &errorInfo{Unhandled: true}}},
+		{
+			name:    "environment only",
+			policy:  &IncidentPolicy{Environments: []string{"production"}},
+			payload: webhookPayload{Error: &errorInfo{App: production}},
+			want:    true,
+		},
+		{
+			name:    "other environment",
+			policy:  &IncidentPolicy{Environments: []string{"production"}, Unhandled: true},
+			payload: webhookPayload{Error: &errorInfo{Unhandled: true, App: &appInfo{ReleaseStage: "staging"}}},
+		},
+		{
+			name:    "unhandled",
+			policy:  &IncidentPolicy{Unhandled: true, MinEvents: 100},
+			payload: webhookPayload{Error: &errorInfo{Unhandled: true}},
+			events:  func() (int, error) { t.Fatal("events fetched although unhandled matched"); return 0, nil },
+			want:    true,
+		},
+		{
+			name:    "spiking",
+			policy:  &IncidentPolicy{Spiking: true},
+			payload: webhookPayload{Trigger: triggerInfo{Type: string(TriggerErrorEventFrequency)}, Error: &errorInfo{}},
+			want:    true,
+		},
+		{
+			name:    "below event threshold",
+			policy:  &IncidentPolicy{MinEvents: 100},
+			payload: webhookPayload{Error: &errorInfo{}},
+			events:  events(99),
+		},
+		{
+			name:    "above event threshold",
+			policy:  &IncidentPolicy{MinEvents: 100},
+			payload: webhookPayload{Error: &errorInfo{}},
+			events:  events(250),
+			want:    true,
+		},
+		{
+			name:    "event count unavailable",
+			policy:  &IncidentPolicy{MinEvents: 100},
+			payload: webhookPayload{Error: &errorInfo{}},
+			events:  func() (int, error) { return 0, errors.New("timeout") },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := qualifiesForIncident(tt.policy, tt.payload, tt.events); got != tt.want {
+				t.Errorf("qualifiesForIncident() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIncidentChannelName(t *testing.T) {
+	tests := []struct {
+		class, errorID, want string
+	}{
+		{"NoMethodError", "5f1a2b3c4d5e6f7a8b9c0d1e", "bugsnag-nomethoderror-8b9c0d1e"},
+		{"ActiveRecord::RecordNotFound", "err-1", "bugsnag-activerecord-recordnotfound-err-1"},
+		{"", "err-1", "bugsnag-error-err-1"},
+	}
+
+	for _, tt := range tests {
+		if got := incidentChannelName(tt.class, tt.errorID); got != tt.want {
+			t.Errorf("incidentChannelName(%q, %q) = %q, want %q", tt.class, tt.errorID, got, tt.want)
+		}
+	}
+
+	long := incidentChannelName(strings.Repeat("VeryLongExceptionName", 10), "5f1a2b3c4d5e6f7a8b9c0d1e")
+	if len(long)+3 > model.ChannelNameMaxLength || !strings.HasSuffix(long, "-8b9c0d1e") {
+		t.Errorf("incidentChannelName() = %q does not leave room for a suffix", long)
+	}
+}
+
+func TestUpsertErrorCardOpensIncident(t *testing.T) {
+	api := regressionAPI(t)
+	api.On("CreatePost", mock.MatchedBy(func(post *model.Post) bool {
+		return post.RootId == "old-post" && strings.Contains(post.Message, "Reopened")
+	})).Return(&model.Post{Id: "reply-1"}, nil).Once()
+	api.On("GetChannel", "channel-1").Return(&model.Channel{Id: "channel-1", TeamId: "team-1"}, nil)
+	api.On("GetChannelByName", "team-1", "bugsnag-crash-err-1", true).Return(&model.Channel{Id: "archived"}, nil)
+	api.On("GetChannelByName", "team-1", "bugsnag-crash-err-1-2", true).Return(nil, model.NewAppError("GetChannelByName", "not_found", nil, "", http.StatusNotFound))
+	api.On("CreateChannel", mock.MatchedBy(func(channel *model.Channel) bool {
+		return channel.TeamId == "team-1" && channel.Name == "bugsnag-crash-err-1-2" && channel.Type == model.ChannelTypePrivate &&
+			channel.DisplayName == "🔥 Crash" && strings.Contains(channel.Header, "(https://mm.example.com/_redirect/pl/old-post)")
+	})).Return(&model.Channel{Id: "incident-1", Name: "bugsnag-crash-err-1-2"}, nil).Once()
+	api.On("AddChannelMember", "incident-1", "oncall-1").Return(&model.ChannelMember{}, nil).Once()
+	api.On("AddChannelMember", "incident-1", "user-1").Return(&model.ChannelMember{}, nil).Once()
+	api.On("CreatePost", mock.MatchedBy(func(post *model.Post) bool {
+		return post.ChannelId == "incident-1" && post.RootId == ""
+	})).Return(&model.Post{Id: "incident-card", ChannelId: "incident-1"}, nil).Once()
+	api.On("CreatePost", mock.MatchedBy(func(post *model.Post) bool {
+		return post.RootId == "old-post" && strings.Contains(post.Message, "~bugsnag-crash-err-1-2")
+	})).Return(&model.Post{Id: "reply-2"}, nil).Once()
+	api.On("KVSetWithOptions", pluginID+":"+KVKeyErrorPostPrefix+"proj-1:err-1", mock.MatchedBy(func(data []byte) bool {
+		var stored ErrorPostMapping
+		_ = json.Unmarshal(data, &stored)
+		return stored.IncidentChannelID == "incident-1" && stored.PostID == "old-post" && stored.ResolvedBy == "user-1"
+	}), mock.Anything).Return(true, nil).Once()
+	api.On("LogInfo", "incident channel opened", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return()
+
+	p := &Plugin{}
+	p.SetAPI(api)
+	p.kvNamespace = pluginID
+
+	payload := regressionPayload()
+	payload.Error.Unhandled = true
+	rule := ChannelRule{ID: "r1", ChannelID: "channel-1", Incident: &IncidentPolicy{Unhandled: true, Private: true, OnCallUserIDs: []string{"oncall-1", "user-1"}}}
+	if err := p.upsertErrorCard(newMMClient(api, false, pluginID, ""), rule, payload, Configuration{}); err != nil {
+		t.Fatalf("upsertErrorCard() error = %v", err)
+	}
+
+	// The resolver is invited once, although also on call.
+	api.AssertNumberOfCalls(t, "CreateChannel", 1)
+	api.AssertNumberOfCalls(t, "AddChannelMember", 2)
+	api.AssertNumberOfCalls(t, "CreatePost", 3)
+	api.AssertNumberOfCalls(t, "KVSetWithOptions", 1)
+}
+
+func TestCreateIncidentChannelStopsOnLookupFailure(t *testing.T) {
+	api := &plugintest.API{}
+	api.On("GetChannel", "channel-1").Return(&model.Channel{Id: "channel-1", TeamId: "team-1"}, nil)
+	api.On("GetChannelByName", "team-1", "bugsnag-crash-err-1", true).Return(nil, model.NewAppError("GetChannelByName", "store_error", nil, "", http.StatusInternalServerError))
+
+	p := &Plugin{}
+	p.SetAPI(api)
+
+	rule := ChannelRule{ID: "r1", ChannelID: "channel-1", Incident: &IncidentPolicy{}}
+	if _, err := p.createIncidentChannel(newMMClient(api, false, pluginID, ""), rule, formatter.ErrorData{ExceptionClass: "Crash"}, ErrorPostMapping{ErrorID: "err-1"}); err == nil {
+		t.Fatal("expected the lookup failure to be returned")
+	}
+	api.AssertNotCalled(t, "CreateChannel", mock.Anything)
+}
+
+func TestEventCountsReusesRecentCounts(t *testing.T) {
+	var counts eventCounts
+	calls := 0
+	fetch := func() (int, error) {
+		calls++
+		return 0, errors.New("unreachable")
+	}
+
+	now := time.Now()
+	_, _ = counts.get("proj-1:err-1", now, fetch)
+	if _, err := counts.get("proj-1:err-1", now.Add(30*time.Second), fetch); err == nil || calls != 1 {
+		t.Fatalf("expected the failed lookup to be reused, got %d calls and err %v", calls, err)
+	}
+	_, _ = counts.get("proj-1:err-2", now, fetch)
+	_, _ = counts.get("proj-1:err-1", now.Add(incidentCountTTL), fetch)
+	if calls != 3 {
+		t.Errorf("expected other errors and expired counts to be fetched, got %d calls", calls)
+	}
+}
+
+func TestCloseIncident(t *testing.T) {
+	api := &plugintest.API{}
+	api.On("CreatePost", mock.MatchedBy(func(post *model.Post) bool {
+		return post.ChannelId == "incident-1" && strings.Contains(post.Message, "Archiving this channel")
+	})).Return(&model.Post{Id: "note"}, nil).Once()
+	api.On("DeleteChannel", "incident-1").Return(nil).Once()
+	// A ticket was linked while the channel was archived; only the incident
+	// channel may change.
+	stored, _ := json.Marshal(ErrorPostMapping{ProjectID: "proj-1", ErrorID: "err-1", ChannelID: "channel-1", PostID: "old-post", IncidentChannelID: "incident-1", TicketKey: "APP-1"})
+	api.On("KVGet", pluginID+":"+KVKeyErrorPostPrefix+"proj-1:err-1").Return(stored, nil).Once()
+	api.On("KVSetWithOptions", pluginID+":"+KVKeyErrorPostPrefix+"proj-1:err-1", mock.MatchedBy(func(data []byte) bool {
+		var mapping ErrorPostMapping
+		_ = json.Unmarshal(data, &mapping)
+		return mapping.IncidentChannelID == "" && mapping.PostID == "old-post" && mapping.TicketKey == "APP-1"
+	}), model.PluginKVSetOptions{Atomic: true, OldValue: stored}).Return(true, nil).Once()
+	api.On("LogInfo", "incident channel archived", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return()
+
+	p := &Plugin{}
+	p.SetAPI(api)
+	p.kvNamespace = pluginID
+
+	mm := newMMClient(api, false, pluginID, "")
+	mapping := ErrorPostMapping{ProjectID: "proj-1", ErrorID: "err-1", ChannelID: "channel-1", PostID: "old-post", IncidentChannelID: "incident-1"}
+
+	if got := p.closeIncident(mm, mapping, "ignored"); got.IncidentChannelID != "incident-1" {
+		t.Fatalf("closeIncident() closed the incident of an ignored error")
+	}
+	if got := p.closeIncident(mm, mapping, "fixed"); got.IncidentChannelID != "" {
+		t.Fatalf("closeIncident() kept the incident channel %q", got.IncidentChannelID)
+	}
+
+	api.AssertExpectations(t)
+}
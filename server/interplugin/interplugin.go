@@ -0,0 +1,46 @@
+// Package interplugin reaches other Mattermost plugins, such as Playbooks or
+// Jira, through the server's inter-plugin HTTP API.
+package interplugin
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// HTTPer sends inter-plugin requests; plugin.API implements it.
+type HTTPer interface {
+	PluginHTTP(request *http.Request) *http.Response
+}
+
+// HTTPFunc adapts a function to HTTPer.
+type HTTPFunc func(request *http.Request) *http.Response
+
+// PluginHTTP calls f(request).
+func (f HTTPFunc) PluginHTTP(request *http.Request) *http.Response {
+	return f(request)
+}
+
+// Transport routes requests through the inter-plugin HTTP API. The first
+// element of a request's path is the ID of the plugin it goes to.
+type Transport struct {
+	API HTTPer
+}
+
+// NewClient returns an HTTP client that sends its requests through api.
+func NewClient(api HTTPer) *http.Client {
+	return &http.Client{Transport: Transport{API: api}}
+}
+
+// RoundTrip sends req to the plugin its path names.
+func (t Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.API == nil {
+		return nil, fmt.Errorf("inter-plugin requests are not available")
+	}
+	resp := t.API.PluginHTTP(req)
+	if resp == nil {
+		pluginID, _, _ := strings.Cut(strings.TrimPrefix(req.URL.Path, "/"), "/")
+		return nil, fmt.Errorf("%s plugin did not respond; is it installed and enabled?", pluginID)
+	}
+	return resp, nil
+}
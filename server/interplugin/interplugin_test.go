@@ -0,0 +1,33 @@
+package interplugin
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestTransport(t *testing.T) {
+	var path string
+	client := NewClient(HTTPFunc(func(req *http.Request) *http.Response {
+		path = req.URL.Path
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewBufferString("ok"))}
+	}))
+	resp, err := client.Get("/playbooks/api/v0/runs")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	_ = resp.Body.Close()
+	if path != "/playbooks/api/v0/runs" {
+		t.Errorf("request went to %q", path)
+	}
+
+	missing := NewClient(HTTPFunc(func(*http.Request) *http.Response { return nil }))
+	if _, err := missing.Get("/jira/api/v2/create-issue"); err == nil || !strings.Contains(err.Error(), "jira plugin did not respond") {
+		t.Errorf("expected a missing-plugin error, got %v", err)
+	}
+	if _, err := NewClient(nil).Get("/jira/api/v2/create-issue"); err == nil || !strings.Contains(err.Error(), "not available") {
+		t.Errorf("expected an unavailable error, got %v", err)
+	}
+}
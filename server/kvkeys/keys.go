@@ -18,4 +18,40 @@ const (
 
 	// ErrorPostPrefix is the prefix for error-to-post mapping keys.
 	ErrorPostPrefix = "bugsnag:error-post:"
+
+	// ReleasePostPrefix is the prefix for release-to-post mapping keys.
+	ReleasePostPrefix = "bugsnag:release-post:"
+
+	// CardTemplates stores the admin-defined card templates.
+	CardTemplates = "bugsnag:card-templates"
+
+	// AuditLog stores the audit trail of card actions and status changes.
+	AuditLog = "bugsnag:audit-log"
+
+	// Health stores the last webhook and sync times reported by /api/v1/health.
+	Health = "bugsnag:health"
+
+	// Deliveries stores the ring buffer of recent raw webhook deliveries.
+	Deliveries = "bugsnag:deliveries"
+
+	// Repositories stores the per-project source repository configuration.
+	Repositories = "bugsnag:repositories"
+
+	// UserTokenPrefix is the prefix for the per-user encrypted Bugsnag tokens.
+	UserTokenPrefix = "bugsnag:user-token:"
+
+	// Keyring stores the generated master secrets that encrypt stored secrets.
+	Keyring = "bugsnag:keyring"
+
+	// Escalations stores the cards waiting to be acknowledged and the
+	// escalation levels already notified for them.
+	Escalations = "bugsnag:escalations"
+
+	// OnCallRotations stores the on-call rotations channel rules assign new
+	// errors to.
+	OnCallRotations = "bugsnag:oncall-rotations"
+
+	// TicketClaimPrefix is the prefix for the short-lived keys that stop two
+	// submissions from creating a ticket for the same error.
+	TicketClaimPrefix = "bugsnag:ticket-claim:"
 )
@@ -4,13 +4,20 @@ import (
 	"fmt"
 	"strings"
 
+	"github.com/a-voronkov/mattermost-bugsnag/server/formatter"
+	"github.com/a-voronkov/mattermost-bugsnag/server/oncall"
+	"github.com/a-voronkov/mattermost-bugsnag/server/sourcelink"
+	"github.com/a-voronkov/mattermost-bugsnag/server/store"
 	"github.com/mattermost/mattermost/server/public/model"
 )
 
 // ChannelRule describes where to send a Bugsnag event for a given project, and
 // what filters must match before posting.
 type ChannelRule struct {
-	ID           string   `json:"id"`
+	ID string `json:"id"`
+	// ConnectionID selects the Bugsnag connection the project belongs to;
+	// empty means the default connection.
+	ConnectionID string   `json:"connection_id,omitempty"`
 	ProjectID    string   `json:"project_id"`
 	ProjectName  string   `json:"project_name,omitempty"`
 	ChannelID    string   `json:"channel_id"`
@@ -18,21 +25,152 @@ type ChannelRule struct {
 	Environments []string `json:"environments,omitempty"`
 	Severities   []string `json:"severities,omitempty"`
 	Events       []string `json:"events,omitempty"`
+	TemplateID   string   `json:"template_id,omitempty"`
+	ShowContext  string   `json:"show_context,omitempty"`
+	RedactFields []string `json:"redact_fields,omitempty"`
+	Resurface    string   `json:"resurface,omitempty"`
+	// Incident opens a dedicated channel for errors that meet its conditions.
+	Incident *IncidentPolicy `json:"incident,omitempty"`
+	// Playbook starts Mattermost Playbooks runs for the rule's errors.
+	Playbook *PlaybookPolicy `json:"playbook,omitempty"`
+	// IssueTracker is the tracker preselected when creating a ticket from
+	// one of the rule's cards; empty selects the first one.
+	IssueTracker string `json:"issue_tracker,omitempty"`
+	// Escalation notifies people when the rule's new cards are not
+	// acknowledged in time.
+	Escalation *EscalationPolicy `json:"escalation,omitempty"`
+	// OnCallRotation is the ID of the rotation whose current on-call user
+	// new errors are assigned to.
+	OnCallRotation string `json:"oncall_rotation,omitempty"`
 }
 
+// EscalationPolicy escalates the rule's new cards level by level until
+// someone acts on the card or the error changes state in Bugsnag. Severities
+// limits it to errors of those severities; empty escalates errors of severity
+// "error".
+type EscalationPolicy struct {
+	Severities []string                `json:"severities,omitempty"`
+	Levels     []store.EscalationLevel `json:"levels"`
+}
+
+// escalates reports whether an error of the given severity is escalated.
+func (e *EscalationPolicy) escalates(severity string) bool {
+	if e == nil || len(e.Levels) == 0 {
+		return false
+	}
+	if len(e.Severities) == 0 {
+		return strings.EqualFold(strings.TrimSpace(severity), "error")
+	}
+	return containsValue(e.Severities, severity)
+}
+
+// PlaybookPolicy selects the Playbooks playbook for a rule's errors.
+type PlaybookPolicy struct {
+	// PlaybookID is used for errors whose severity has no entry in
+	// BySeverity.
+	PlaybookID string `json:"playbook_id,omitempty"`
+	// BySeverity is keyed by lower-case severity; the API normalizes keys
+	// when rules are saved.
+	BySeverity map[string]string `json:"by_severity,omitempty"`
+	// AutoStart starts a run when the rule posts a card for a new error.
+	AutoStart bool `json:"auto_start,omitempty"`
+	// FinishOnResolve finishes the run when the error is fixed. Otherwise a
+	// status update is posted to the run.
+	FinishOnResolve bool `json:"finish_on_resolve,omitempty"`
+}
+
+// playbookFor returns the playbook for an error of the given severity, or an
+// empty string when there is none.
+func (p *PlaybookPolicy) playbookFor(severity string) string {
+	if p == nil {
+		return ""
+	}
+	if playbookID := p.BySeverity[strings.ToLower(strings.TrimSpace(severity))]; playbookID != "" {
+		return playbookID
+	}
+	return p.PlaybookID
+}
+
+// IncidentPolicy decides which errors of a channel rule get their own incident
+// channel. Environments limits the policy to errors from those release stages;
+// an error then opens an incident when it meets any of the other conditions,
+// or always when none is set.
+type IncidentPolicy struct {
+	Environments []string `json:"environments,omitempty"`
+	Unhandled    bool     `json:"unhandled,omitempty"`
+	// Spiking matches project spikes and frequent-error alerts.
+	Spiking bool `json:"spiking,omitempty"`
+	// MinEvents matches errors with at least this many events in total.
+	MinEvents int `json:"min_events,omitempty"`
+	// OnCallUserIDs are the Mattermost users invited to every incident
+	// channel of the rule.
+	OnCallUserIDs []string `json:"on_call_user_ids,omitempty"`
+	// Private creates private channels instead of public ones.
+	Private bool `json:"private,omitempty"`
+}
+
+// Values for ChannelRule.ShowContext. Leaving it empty hides the context.
+const (
+	contextModeCard   = "card"
+	contextModeThread = "thread"
+)
+
+// Values for ChannelRule.Resurface. Leaving it empty updates the existing card
+// in place when an error regresses.
+const (
+	resurfaceNewCard   = "new_card"
+	resurfaceBroadcast = "broadcast"
+)
+
+// Values for ChannelRule.RedactFields.
+const (
+	redactURL       = "url"
+	redactURLQuery  = "url_query"
+	redactBrowser   = "browser"
+	redactOS        = "os"
+	redactHostname  = "hostname"
+	redactUserID    = "user_id"
+	redactUserName  = "user_name"
+	redactUserEmail = "user_email"
+)
+
+// redactedValue replaces redacted context values.
+const redactedValue = "[redacted]"
+
 // ErrorPostMapping stores where a specific Bugsnag error was posted in
 // Mattermost so subsequent webhook deliveries can update the same card.
 type ErrorPostMapping struct {
-	ProjectID string `json:"project_id"`
-	ErrorID   string `json:"error_id"`
-	ChannelID string `json:"channel_id"`
-	PostID    string `json:"post_id"`
+	ConnectionID string `json:"connection_id,omitempty"`
+	ProjectID    string `json:"project_id"`
+	ErrorID      string `json:"error_id"`
+	ChannelID    string `json:"channel_id"`
+	PostID       string `json:"post_id"`
+	// ResolvedBy is the Mattermost user who last resolved the error from the
+	// card, mentioned when the error regresses.
+	ResolvedBy string `json:"resolved_by,omitempty"`
+	// IncidentChannelID is the open incident channel of the error, archived
+	// when the error is fixed.
+	IncidentChannelID string `json:"incident_channel_id,omitempty"`
+	// PlaybookRunID is the Playbooks run started for the error, and
+	// PlaybookOwnerID the user the plugin acts as when updating it.
+	PlaybookRunID   string `json:"playbook_run_id,omitempty"`
+	PlaybookOwnerID string `json:"playbook_owner_id,omitempty"`
+	// TicketKey and TicketURL identify the issue-tracker ticket created for
+	// the error.
+	TicketKey string `json:"ticket_key,omitempty"`
+	TicketURL string `json:"ticket_url,omitempty"`
+	// Escalating is set while the card escalates and cleared once it is
+	// acknowledged.
+	Escalating bool `json:"escalating,omitempty"`
 }
 
 // UserMapping connects a Mattermost user to a Bugsnag user record (by explicit
 // ID or by email). Either BugsnagUserID or BugsnagEmail can be set; Mattermost
 // lookups first match MMUserID, then fallback to email matching.
 type UserMapping struct {
+	// ConnectionID limits the mapping to one Bugsnag connection; empty
+	// mappings apply to all of them.
+	ConnectionID  string `json:"connection_id,omitempty"`
 	BugsnagUserID string `json:"bugsnag_user_id,omitempty"`
 	BugsnagEmail  string `json:"bugsnag_email,omitempty"`
 	MMUserID      string `json:"mm_user_id,omitempty"`
@@ -65,6 +203,67 @@ func loadChannelRules(mm *MMClient) ([]ChannelRule, error) {
 	return rules, nil
 }
 
+// loadCardTemplates reads the admin-defined card templates from KV.
+func loadCardTemplates(mm *MMClient) ([]formatter.CardTemplate, error) {
+	var templates []formatter.CardTemplate
+	found, appErr := mm.LoadJSON(KVKeyCardTemplates, &templates)
+	if appErr != nil {
+		return nil, fmt.Errorf("load card templates: %w", appErr)
+	}
+	if !found {
+		return []formatter.CardTemplate{}, nil
+	}
+	return templates, nil
+}
+
+// loadRepositories reads the per-project source repository configuration from KV.
+func loadRepositories(mm *MMClient) ([]sourcelink.Repository, error) {
+	var repositories []sourcelink.Repository
+	found, appErr := mm.LoadJSON(KVKeyRepositories, &repositories)
+	if appErr != nil {
+		return nil, fmt.Errorf("load repositories: %w", appErr)
+	}
+	if !found {
+		return []sourcelink.Repository{}, nil
+	}
+	return repositories, nil
+}
+
+func loadRotations(mm *MMClient) ([]oncall.Rotation, error) {
+	var rotations []oncall.Rotation
+	found, appErr := mm.LoadJSON(KVKeyOnCallRotations, &rotations)
+	if appErr != nil {
+		return nil, fmt.Errorf("load on-call rotations: %w", appErr)
+	}
+	if !found {
+		return []oncall.Rotation{}, nil
+	}
+	return rotations, nil
+}
+
+// userMappingsFor returns the user mappings that apply to a connection: those
+// made for it and those without a connection.
+func userMappingsFor(mappings []UserMapping, connectionID string) []UserMapping {
+	var matching []UserMapping
+	for _, m := range mappings {
+		if m.ConnectionID == "" || m.ConnectionID == connectionID {
+			matching = append(matching, m)
+		}
+	}
+	return matching
+}
+
+// ruleForCard returns the rule that posts the project's errors to the card's
+// channel.
+func ruleForCard(rules []ChannelRule, mapping ErrorPostMapping) (ChannelRule, bool) {
+	for _, rule := range rules {
+		if rule.ProjectID == mapping.ProjectID && rule.ChannelID == mapping.ChannelID {
+			return rule, true
+		}
+	}
+	return ChannelRule{}, false
+}
+
 // getRulesForProject returns all channel rules that match the given project ID.
 func getRulesForProject(rules []ChannelRule, projectID string) []ChannelRule {
 	var matching []ChannelRule
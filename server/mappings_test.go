@@ -188,3 +188,16 @@ func TestContainsValue(t *testing.T) {
 		}
 	}
 }
+
+func TestUserMappingsFor(t *testing.T) {
+	mappings := []UserMapping{
+		{MMUserID: "u1", BugsnagUserID: "shared"},
+		{MMUserID: "u2", BugsnagUserID: "mobile-only", ConnectionID: "mobile"},
+		{MMUserID: "u3", BugsnagUserID: "web-only", ConnectionID: "web"},
+	}
+
+	got := userMappingsFor(mappings, "mobile")
+	if len(got) != 2 || got[0].MMUserID != "u1" || got[1].MMUserID != "u2" {
+		t.Errorf("expected shared and mobile mappings, got %+v", got)
+	}
+}
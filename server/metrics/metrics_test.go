@@ -0,0 +1,90 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWriteText(t *testing.T) {
+	r := NewRegistry()
+	deliveries := r.NewCounter("test_deliveries_total", "Deliveries by outcome.", "outcome")
+	active := r.NewGauge("test_active", "Active things.")
+	latency := r.NewHistogram("test_latency_seconds", "Latency.", []float64{0.1, 1}, "code")
+
+	deliveries.Inc("processed")
+	deliveries.Inc("processed")
+	deliveries.Inc(`bad"value`)
+	active.Set(3)
+	latency.Observe(0.05, "200")
+	latency.Observe(0.5, "200")
+	latency.Observe(2, "200")
+
+	var sb strings.Builder
+	if err := r.WriteText(&sb); err != nil {
+		t.Fatalf("WriteText() error = %v", err)
+	}
+
+	want := `# HELP test_deliveries_total Deliveries by outcome.
+# TYPE test_deliveries_total counter
+test_deliveries_total{outcome="bad\"value"} 1
+test_deliveries_total{outcome="processed"} 2
+# HELP test_active Active things.
+# TYPE test_active gauge
+test_active 3
+# HELP test_latency_seconds Latency.
+# TYPE test_latency_seconds histogram
+test_latency_seconds_bucket{code="200",le="0.1"} 1
+test_latency_seconds_bucket{code="200",le="1"} 2
+test_latency_seconds_bucket{code="200",le="+Inf"} 3
+test_latency_seconds_sum{code="200"} 2.55
+test_latency_seconds_count{code="200"} 3
+`
+	if sb.String() != want {
+		t.Errorf("WriteText() got:\n%s\nwant:\n%s", sb.String(), want)
+	}
+}
+
+func TestCounterIgnoresNegativeAdds(t *testing.T) {
+	c := NewRegistry().NewCounter("test_total", "Test.")
+	c.Add(2)
+	c.Add(-1)
+	if got := c.Value(); got != 2 {
+		t.Errorf("Value() = %v, want 2", got)
+	}
+}
+
+func TestLabelCountMismatchPanics(t *testing.T) {
+	c := NewRegistry().NewCounter("test_total", "Test.", "outcome")
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a panic for missing label values")
+		}
+	}()
+	c.Inc()
+}
+
+func TestServeHTTP(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounter("test_total", "Test.").Inc()
+
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rr.Code)
+	}
+	if !strings.HasPrefix(rr.Header().Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Errorf("unexpected Content-Type %q", rr.Header().Get("Content-Type"))
+	}
+	if !strings.Contains(rr.Body.String(), "test_total 1\n") {
+		t.Errorf("expected counter in body, got:\n%s", rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/metrics", nil))
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected status %d for POST, got %d", http.StatusMethodNotAllowed, rr.Code)
+	}
+}
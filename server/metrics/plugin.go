@@ -0,0 +1,49 @@
+package metrics
+
+// Default is the registry served at /metrics. The plugin's metrics are
+// registered on it so any package can record without threading a registry
+// through constructors.
+var Default = NewRegistry()
+
+// Values for the WebhookDeliveries outcome label.
+const (
+	OutcomeProcessed = "processed"
+	OutcomeUnmatched = "unmatched"
+	OutcomeRejected  = "rejected"
+	OutcomeDuplicate = "duplicate"
+	OutcomeError     = "error"
+)
+
+// Values for the Actions outcome label.
+const (
+	ActionSuccess = "success"
+	ActionFailure = "failure"
+)
+
+// Values for the OutboundDeliveries outcome label.
+const (
+	OutboundDelivered = "delivered"
+	OutboundFailed    = "failed"
+	OutboundDropped   = "dropped"
+)
+
+var (
+	WebhookDeliveries = Default.NewCounter("bugsnag_webhook_deliveries_total",
+		"Webhook deliveries by outcome.", "outcome")
+	RuleMatches = Default.NewCounter("bugsnag_rule_matches_total",
+		"Webhook deliveries matched by each channel rule.", "rule_id")
+	PostsCreated = Default.NewCounter("bugsnag_posts_created_total",
+		"Posts created by the plugin, by kind (card or reply).", "kind")
+	PostsUpdated = Default.NewCounter("bugsnag_posts_updated_total",
+		"Posts updated by the plugin.")
+	BugsnagRequests = Default.NewHistogram("bugsnag_api_request_duration_seconds",
+		"Bugsnag API request latency by HTTP method and status code.", nil, "method", "code")
+	SyncTickDuration = Default.NewHistogram("bugsnag_sync_tick_duration_seconds",
+		"Duration of each status sync tick.", []float64{0.5, 1, 5, 10, 30, 60, 120, 300})
+	ActiveErrors = Default.NewGauge("bugsnag_active_errors",
+		"Errors tracked by the status sync.")
+	Actions = Default.NewCounter("bugsnag_actions_total",
+		"Card actions by action and outcome.", "action", "outcome")
+	OutboundDeliveries = Default.NewCounter("bugsnag_outbound_deliveries_total",
+		"Outbound event deliveries by endpoint and outcome.", "endpoint", "outcome")
+)
@@ -2,8 +2,11 @@ package main
 
 import (
 	"encoding/json"
+	"reflect"
 	"strings"
+	"time"
 
+	"github.com/a-voronkov/mattermost-bugsnag/server/metrics"
 	"github.com/mattermost/mattermost/server/public/model"
 	"github.com/mattermost/mattermost/server/public/plugin"
 )
@@ -32,16 +35,53 @@ func (c *MMClient) CreatePost(channelID, message string, attachments []*model.Sl
 		post.Props = map[string]interface{}{"attachments": attachments}
 	}
 
-	return c.api.CreatePost(post)
+	return c.createPost(post, "post")
+}
+
+// CreateCardPost creates a post prepared by the formatter package as the bot user.
+func (c *MMClient) CreateCardPost(post *model.Post) (*model.Post, *model.AppError) {
+	post.UserId = c.botUserID
+	return c.createPost(post, "card")
 }
 
 func (c *MMClient) CreateReply(channelID, rootPostID, message string) (*model.Post, *model.AppError) {
 	post := &model.Post{ChannelId: channelID, Message: message, RootId: rootPostID, UserId: c.botUserID}
-	return c.api.CreatePost(post)
+	return c.createPost(post, "reply")
+}
+
+// CreateReplyWithFile uploads data as a file and posts it in the thread along
+// with message.
+func (c *MMClient) CreateReplyWithFile(channelID, rootPostID, message, filename string, data []byte) (*model.Post, *model.AppError) {
+	info, appErr := c.api.UploadFile(data, channelID, filename)
+	if appErr != nil {
+		return nil, appErr
+	}
+
+	post := &model.Post{
+		ChannelId: channelID,
+		Message:   message,
+		RootId:    rootPostID,
+		UserId:    c.botUserID,
+		FileIds:   model.StringArray{info.Id},
+	}
+	return c.createPost(post, "reply")
+}
+
+// createPost creates the post and counts it by kind in the plugin metrics.
+func (c *MMClient) createPost(post *model.Post, kind string) (*model.Post, *model.AppError) {
+	created, appErr := c.api.CreatePost(post)
+	if appErr == nil {
+		metrics.PostsCreated.Inc(kind)
+	}
+	return created, appErr
 }
 
 func (c *MMClient) UpdatePost(post *model.Post) (*model.Post, *model.AppError) {
-	return c.api.UpdatePost(post)
+	updated, appErr := c.api.UpdatePost(post)
+	if appErr == nil {
+		metrics.PostsUpdated.Inc()
+	}
+	return updated, appErr
 }
 
 func (c *MMClient) GetPost(postID string) (*model.Post, *model.AppError) {
@@ -56,6 +96,26 @@ func (c *MMClient) GetUser(userID string) (*model.User, *model.AppError) {
 	return c.api.GetUser(userID)
 }
 
+// Permalink returns a link to the post, or an empty string when the site URL
+// is not configured.
+func (c *MMClient) Permalink(postID string) string {
+	siteURL := c.SiteURL()
+	if siteURL == "" {
+		return ""
+	}
+	return siteURL + "/_redirect/pl/" + postID
+}
+
+// SiteURL returns the configured site URL without a trailing slash, or an
+// empty string when it is not configured.
+func (c *MMClient) SiteURL() string {
+	cfg := c.api.GetConfig()
+	if cfg == nil || cfg.ServiceSettings.SiteURL == nil {
+		return ""
+	}
+	return strings.TrimRight(*cfg.ServiceSettings.SiteURL, "/")
+}
+
 func (c *MMClient) StoreJSON(key string, value any) *model.AppError {
 	data, err := json.Marshal(value)
 	if err != nil {
@@ -82,6 +142,57 @@ func (c *MMClient) LoadJSON(key string, dest any) (bool, *model.AppError) {
 	return true, nil
 }
 
+// UpdateJSON loads the JSON value at key into dest, applies change and
+// writes it back only if nobody else wrote the key in between, retrying a few
+// times. It reports false when the key does not exist.
+func (c *MMClient) UpdateJSON(key string, dest any, change func()) (bool, *model.AppError) {
+	for attempt := 0; attempt < 3; attempt++ {
+		old, appErr := c.api.KVGet(c.namespaced(key))
+		if appErr != nil {
+			return false, appErr
+		}
+		if old == nil {
+			return false, nil
+		}
+		// Start from scratch on every attempt, so fields another writer
+		// cleared do not survive from the previous one.
+		reflect.ValueOf(dest).Elem().SetZero()
+		if err := json.Unmarshal(old, dest); err != nil {
+			return false, model.NewAppError("KVGet", "app.plugin.json_unmarshal.app_error", nil, err.Error(), 0)
+		}
+
+		change()
+		data, err := json.Marshal(dest)
+		if err != nil {
+			return false, model.NewAppError("KVSet", "app.plugin.json_marshal.app_error", nil, err.Error(), 0)
+		}
+		ok, appErr := c.api.KVSetWithOptions(c.namespaced(key), data, model.PluginKVSetOptions{Atomic: true, OldValue: old})
+		if appErr != nil {
+			return false, appErr
+		}
+		if ok {
+			return true, nil
+		}
+	}
+	return false, model.NewAppError("KVSet", "app.plugin.kv_conflict.app_error", nil, "key "+key+" kept changing", 0)
+}
+
+// Claim sets key only if it is unset, so a single caller wins. The claim
+// expires after ttl in case its holder never releases it.
+func (c *MMClient) Claim(key string, ttl time.Duration) (bool, *model.AppError) {
+	return c.api.KVSetWithOptions(c.namespaced(key), []byte("1"), model.PluginKVSetOptions{
+		Atomic:          true,
+		ExpireInSeconds: int64(ttl / time.Second),
+	})
+}
+
+// Release deletes a key taken with Claim.
+func (c *MMClient) Release(key string) {
+	if appErr := c.api.KVDelete(c.namespaced(key)); appErr != nil {
+		c.LogDebug("failed to release claim", "key", key, "err", appErr.Error())
+	}
+}
+
 func (c *MMClient) LogDebug(msg string, keyValuePairs ...interface{}) {
 	if c.debug {
 		c.api.LogDebug(msg, keyValuePairs...)
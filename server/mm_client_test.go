@@ -0,0 +1,38 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestUpdateJSONRetriesOnConflict(t *testing.T) {
+	key := pluginID + ":" + errorPostKVKey("proj-1", "err-1")
+	first, _ := json.Marshal(ErrorPostMapping{ErrorID: "err-1", PostID: "post-1", IncidentChannelID: "incident-1"})
+	second, _ := json.Marshal(ErrorPostMapping{ErrorID: "err-1", PostID: "post-1"})
+
+	api := &plugintest.API{}
+	api.On("KVGet", key).Return(first, nil).Once()
+	api.On("KVGet", key).Return(second, nil).Once()
+	api.On("KVSetWithOptions", key, mock.Anything, model.PluginKVSetOptions{Atomic: true, OldValue: first}).Return(false, nil).Once()
+	api.On("KVSetWithOptions", key, mock.MatchedBy(func(data []byte) bool {
+		var mapping ErrorPostMapping
+		return json.Unmarshal(data, &mapping) == nil && mapping.TicketKey == "APP-1" && mapping.IncidentChannelID == ""
+	}), model.PluginKVSetOptions{Atomic: true, OldValue: second}).Return(true, nil).Once()
+
+	var mapping ErrorPostMapping
+	updated, appErr := newMMClient(api, false, pluginID, "").UpdateJSON(errorPostKVKey("proj-1", "err-1"), &mapping, func() {
+		mapping.TicketKey = "APP-1"
+	})
+	if appErr != nil || !updated {
+		t.Fatalf("UpdateJSON() = %v, %v", updated, appErr)
+	}
+	if mapping.IncidentChannelID != "" {
+		t.Errorf("expected the cleared incident channel to stay cleared, got %q", mapping.IncidentChannelID)
+	}
+
+	api.AssertExpectations(t)
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/a-voronkov/mattermost-bugsnag/server/bugsnag"
+	"github.com/a-voronkov/mattermost-bugsnag/server/connection"
+	"github.com/a-voronkov/mattermost-bugsnag/server/oncall"
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+const oncallCommandUsage = "Usage:\n" +
+	"* `/bugsnag oncall` - show who is on call in every rotation\n" +
+	"* `/bugsnag oncall <rotation>` - show the rotation's upcoming shifts\n" +
+	"* `/bugsnag oncall override <rotation> @user [duration]` - put someone else on call, until the current shift ends or for a duration such as 8h or 2d\n" +
+	"* `/bugsnag oncall clear <rotation>` - remove the rotation's override"
+
+// upcomingShifts is how many shifts /bugsnag oncall <rotation> lists.
+const upcomingShifts = 5
+
+// assignOnCall assigns a new error in Bugsnag to whoever is on call in the
+// rule's rotation. It returns their Mattermost username and the rotation, or
+// an empty username when the rule has no rotation, nobody is on call or the
+// assignment failed.
+func (p *Plugin) assignOnCall(mm *MMClient, rule ChannelRule, projectID, errorID string, userMappings []UserMapping, cfg Configuration) (string, oncall.Rotation) {
+	if rule.OnCallRotation == "" {
+		return "", oncall.Rotation{}
+	}
+
+	rotations, err := loadRotations(mm)
+	if err != nil {
+		mm.LogDebug("failed to load on-call rotations", "err", err.Error())
+		return "", oncall.Rotation{}
+	}
+	rotation, ok := oncall.Find(rotations, rule.OnCallRotation)
+	if !ok {
+		p.API.LogWarn("channel rule refers to an unknown on-call rotation", "rule_id", rule.ID, "rotation", rule.OnCallRotation)
+		return "", oncall.Rotation{}
+	}
+	shift, ok := rotation.Current(time.Now())
+	if !ok {
+		mm.LogDebug("nobody is on call", "rotation", rotation.ID)
+		return "", rotation
+	}
+
+	user, appErr := p.API.GetUserByUsername(shift.User)
+	if appErr != nil {
+		p.API.LogWarn("failed to find on-call user", "rotation", rotation.ID, "username", shift.User, "err", appErr.Error())
+		return "", rotation
+	}
+	bugsnagUser, _ := mapUserToBugsnag(userMappings, user)
+	assignee := bugsnag.BestAssignee(bugsnag.UserMapping{
+		BugsnagUserID: bugsnagUser.BugsnagUserID,
+		BugsnagEmail:  bugsnagUser.BugsnagEmail,
+	})
+	if assignee == "" {
+		p.API.LogWarn("on-call user has no Bugsnag user mapping", "rotation", rotation.ID, "username", user.Username)
+		return "", rotation
+	}
+
+	conn, _ := connection.Find(cfg.AllConnections(), rule.ConnectionID)
+	client, err := p.bugsnagClients().Client(conn.APIToken)
+	if err != nil {
+		mm.LogDebug("Bugsnag client unavailable, on-call assignment skipped", "err", err.Error())
+		return "", rotation
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := client.AssignError(ctx, projectID, errorID, assignee); err != nil {
+		p.API.LogWarn("failed to assign error to on-call user", "error_id", errorID, "username", user.Username, "err", err.Error())
+		return "", rotation
+	}
+
+	return user.Username, rotation
+}
+
+// executeOnCallCommand handles /bugsnag oncall.
+func (p *Plugin) executeOnCallCommand(args *model.CommandArgs, params []string) string {
+	mm := newMMClient(p.API, p.getConfiguration().EnableDebugLog, p.kvNS(), p.botUserID)
+	rotations, err := loadRotations(mm)
+	if err != nil {
+		p.API.LogWarn("failed to load on-call rotations", "err", err.Error())
+		return "Could not load the on-call rotations."
+	}
+
+	now := time.Now()
+	switch {
+	case len(params) == 0:
+		return onCallSummary(rotations, now)
+	case params[0] == "override" && (len(params) == 3 || len(params) == 4):
+		return p.overrideOnCall(args, rotations, params[1], params[2], params[3:], now)
+	case params[0] == "clear" && len(params) == 2:
+		return p.clearOnCallOverride(args, rotations, params[1])
+	case len(params) == 1 && params[0] != "override" && params[0] != "clear":
+		rotation, ok := oncall.Find(rotations, params[0])
+		if !ok {
+			return fmt.Sprintf("No on-call rotation `%s`.", params[0])
+		}
+		return onCallSchedule(rotation, now)
+	default:
+		return oncallCommandUsage
+	}
+}
+
+func onCallSummary(rotations []oncall.Rotation, now time.Time) string {
+	if len(rotations) == 0 {
+		return "No on-call rotations are configured."
+	}
+
+	lines := []string{"###### On call"}
+	for _, rotation := range rotations {
+		shift, ok := rotation.Current(now)
+		if !ok {
+			lines = append(lines, fmt.Sprintf("* **%s** (`%s`): nobody yet, the rotation starts on %s", rotation.DisplayName(), rotation.ID, rotation.Start))
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("* **%s** (`%s`): %s", rotation.DisplayName(), rotation.ID, describeShift(rotation, shift)))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func onCallSchedule(rotation oncall.Rotation, now time.Time) string {
+	lines := []string{fmt.Sprintf("###### %s", rotation.DisplayName())}
+	if shift, ok := rotation.Current(now); ok && shift.Override {
+		lines = append(lines, fmt.Sprintf("Override: %s", describeShift(rotation, shift)))
+	}
+
+	shifts := rotation.Upcoming(now, upcomingShifts)
+	if len(shifts) == 0 {
+		lines = append(lines, fmt.Sprintf("The rotation starts on %s.", rotation.Start))
+		return strings.Join(lines, "\n")
+	}
+	for _, shift := range shifts {
+		lines = append(lines, fmt.Sprintf("* @%s: %s to %s", shift.User, formatShiftTime(rotation, shift.Start), formatShiftTime(rotation, shift.End)))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func describeShift(rotation oncall.Rotation, shift oncall.Shift) string {
+	text := fmt.Sprintf("@%s until %s", shift.User, formatShiftTime(rotation, shift.End))
+	if shift.Override {
+		text += " (override)"
+	}
+	return text
+}
+
+func formatShiftTime(rotation oncall.Rotation, t time.Time) string {
+	return t.In(rotation.Location()).Format("Mon Jan 2 15:04 MST")
+}
+
+// overrideOnCall puts username on call in the rotation, until the current
+// shift ends or for the given duration.
+func (p *Plugin) overrideOnCall(args *model.CommandArgs, rotations []oncall.Rotation, rotationID, username string, duration []string, now time.Time) string {
+	index, msg := p.editableRotation(args, rotations, rotationID)
+	if index < 0 {
+		return msg
+	}
+	rotation := rotations[index]
+
+	username = strings.TrimPrefix(strings.TrimSpace(username), "@")
+	user, appErr := p.API.GetUserByUsername(username)
+	if appErr != nil {
+		return fmt.Sprintf("No user @%s.", username)
+	}
+
+	var until time.Time
+	if len(duration) == 1 {
+		d, err := parseOverrideDuration(duration[0])
+		if err != nil {
+			return fmt.Sprintf("%s.\n\n%s", err.Error(), oncallCommandUsage)
+		}
+		until = now.Add(d)
+	} else {
+		shift, ok := rotation.Scheduled(now)
+		if !ok {
+			return fmt.Sprintf("The rotation starts on %s. Give the override a duration such as 8h.", rotation.Start)
+		}
+		until = shift.End
+	}
+
+	setBy := ""
+	if actor, appErr := p.API.GetUser(args.UserId); appErr == nil {
+		setBy = actor.Username
+	}
+	rotation.Override = &oncall.Override{User: user.Username, Until: until.UTC(), SetBy: setBy}
+	rotations[index] = rotation
+	if msg := p.saveRotations(rotations); msg != "" {
+		return msg
+	}
+	return fmt.Sprintf("@%s is on call for **%s** until %s.", user.Username, rotation.DisplayName(), formatShiftTime(rotation, until))
+}
+
+func (p *Plugin) clearOnCallOverride(args *model.CommandArgs, rotations []oncall.Rotation, rotationID string) string {
+	index, msg := p.editableRotation(args, rotations, rotationID)
+	if index < 0 {
+		return msg
+	}
+	rotation := rotations[index]
+	if rotation.Override == nil {
+		return fmt.Sprintf("**%s** has no override.", rotation.DisplayName())
+	}
+
+	rotation.Override = nil
+	rotations[index] = rotation
+	if msg := p.saveRotations(rotations); msg != "" {
+		return msg
+	}
+	if shift, ok := rotation.Current(time.Now()); ok {
+		return fmt.Sprintf("Override removed: @%s is on call for **%s** again.", shift.User, rotation.DisplayName())
+	}
+	return fmt.Sprintf("Override removed from **%s**.", rotation.DisplayName())
+}
+
+// editableRotation finds the rotation the user may override: system admins
+// can override any rotation and users the rotations they are part of. It
+// returns -1 and the reply when the rotation cannot be changed.
+func (p *Plugin) editableRotation(args *model.CommandArgs, rotations []oncall.Rotation, rotationID string) (int, string) {
+	index := -1
+	for i, rotation := range rotations {
+		if rotation.ID == rotationID {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		return -1, fmt.Sprintf("No on-call rotation `%s`.", rotationID)
+	}
+
+	if !p.API.HasPermissionTo(args.UserId, model.PermissionManageSystem) {
+		user, appErr := p.API.GetUser(args.UserId)
+		if appErr != nil || !rotations[index].Includes(user.Username) {
+			return -1, "Only system admins and members of the rotation can override it."
+		}
+	}
+	return index, ""
+}
+
+// saveRotations stores the rotations and returns the reply when that fails.
+func (p *Plugin) saveRotations(rotations []oncall.Rotation) string {
+	mm := newMMClient(p.API, p.getConfiguration().EnableDebugLog, p.kvNS(), p.botUserID)
+	if appErr := mm.StoreJSON(KVKeyOnCallRotations, rotations); appErr != nil {
+		p.API.LogWarn("failed to save on-call rotations", "err", appErr.Error())
+		return "Could not save the on-call rotation."
+	}
+	return ""
+}
+
+// parseOverrideDuration parses a duration such as "30m", "8h" or "2d".
+func parseOverrideDuration(value string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil && n > 0 {
+			return time.Duration(n) * 24 * time.Hour, nil
+		}
+	}
+	if d, err := time.ParseDuration(value); err == nil && d > 0 {
+		return d, nil
+	}
+	return 0, fmt.Errorf("%q is not a duration like 8h or 2d", value)
+}
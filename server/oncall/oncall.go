@@ -0,0 +1,256 @@
+// Package oncall computes who is on call in a rotation: an ordered list of
+// users handing off to each other on a daily or weekly schedule.
+package oncall
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Supported handoff schedules.
+const (
+	HandoffDaily  = "daily"
+	HandoffWeekly = "weekly"
+)
+
+// DefaultHandoffTime is the local time of day shifts change hands when a
+// rotation does not set one.
+const DefaultHandoffTime = "09:00"
+
+// Rotation is an on-call rotation. Each user is on call for one shift, in
+// order, and the first user's first shift starts at the first handoff on or
+// after Start.
+type Rotation struct {
+	ID   string `json:"id"`
+	Name string `json:"name,omitempty"`
+	// Users are Mattermost usernames, in rotation order.
+	Users []string `json:"users"`
+	// Handoff is daily or weekly.
+	Handoff string `json:"handoff"`
+	// HandoffDay is the weekday weekly shifts start on. Defaults to Monday.
+	HandoffDay string `json:"handoff_day,omitempty"`
+	// HandoffTime is the HH:MM time shifts start at. Defaults to 09:00.
+	HandoffTime string `json:"handoff_time,omitempty"`
+	// Timezone is the IANA zone of the handoff time. Defaults to UTC.
+	Timezone string `json:"timezone,omitempty"`
+	// Start is the YYYY-MM-DD date the rotation starts on.
+	Start string `json:"start"`
+	// Override puts another user on call until it expires.
+	Override *Override `json:"override,omitempty"`
+}
+
+// Override replaces the scheduled on-call user until Until.
+type Override struct {
+	User  string    `json:"user"`
+	Until time.Time `json:"until"`
+	// SetBy is the username of whoever set the override.
+	SetBy string `json:"set_by,omitempty"`
+}
+
+// Shift is a period one user is on call.
+type Shift struct {
+	User  string    `json:"user"`
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	// Override is set when the shift comes from an override rather than the
+	// schedule.
+	Override bool `json:"override,omitempty"`
+}
+
+// Validate checks that the rotation has users and a schedule that can be
+// computed.
+func (r Rotation) Validate() error {
+	if strings.TrimSpace(r.ID) == "" {
+		return fmt.Errorf("id is required")
+	}
+	if strings.ContainsAny(r.ID, " \t") {
+		return fmt.Errorf("id must not contain spaces")
+	}
+
+	if len(r.Users) == 0 {
+		return fmt.Errorf("at least one user is required")
+	}
+	for _, user := range r.Users {
+		if normalizeUser(user) == "" {
+			return fmt.Errorf("users must not be empty")
+		}
+	}
+
+	if r.Handoff != HandoffDaily && r.Handoff != HandoffWeekly {
+		return fmt.Errorf("handoff must be %q or %q", HandoffDaily, HandoffWeekly)
+	}
+	if _, ok := parseWeekday(r.HandoffDay); !ok {
+		return fmt.Errorf("handoff_day %q is not a weekday", r.HandoffDay)
+	}
+	if _, _, ok := parseClock(r.HandoffTime); !ok {
+		return fmt.Errorf("handoff_time %q is not an HH:MM time", r.HandoffTime)
+	}
+	if _, err := r.location(); err != nil {
+		return fmt.Errorf("unknown timezone %q", r.Timezone)
+	}
+	if _, err := time.Parse("2006-01-02", r.Start); err != nil {
+		return fmt.Errorf("start must be a YYYY-MM-DD date")
+	}
+
+	if r.Override != nil && normalizeUser(r.Override.User) == "" {
+		return fmt.Errorf("override user is required")
+	}
+	return nil
+}
+
+// Current returns who is on call at now: the override user while the
+// override lasts, the scheduled user otherwise. It returns false when the
+// rotation cannot be computed or has not started yet.
+func (r Rotation) Current(now time.Time) (Shift, bool) {
+	scheduled, ok := r.Scheduled(now)
+	if r.Override != nil && now.Before(r.Override.Until) {
+		return Shift{User: normalizeUser(r.Override.User), Start: now, End: r.Override.Until, Override: true}, true
+	}
+	return scheduled, ok
+}
+
+// Scheduled returns the scheduled shift at now, ignoring any override.
+func (r Rotation) Scheduled(now time.Time) (Shift, bool) {
+	shifts := r.Upcoming(now, 1)
+	if len(shifts) == 0 {
+		return Shift{}, false
+	}
+	return shifts[0], true
+}
+
+// Upcoming returns the scheduled shift at now followed by the next ones, n
+// shifts in all. It ignores any override.
+func (r Rotation) Upcoming(now time.Time, n int) []Shift {
+	first, days, ok := r.schedule()
+	if !ok || len(r.Users) == 0 || now.Before(first) {
+		return nil
+	}
+	start := func(k int) time.Time {
+		return time.Date(first.Year(), first.Month(), first.Day()+k*days, first.Hour(), first.Minute(), 0, 0, first.Location())
+	}
+
+	// Estimate the shift from elapsed time, then correct for DST changes.
+	k := int(now.Sub(first) / (time.Duration(days) * 24 * time.Hour))
+	for k > 0 && start(k).After(now) {
+		k--
+	}
+	for !start(k + 1).After(now) {
+		k++
+	}
+
+	shifts := make([]Shift, 0, n)
+	for i := 0; i < n; i++ {
+		shifts = append(shifts, Shift{
+			User:  normalizeUser(r.Users[(k+i)%len(r.Users)]),
+			Start: start(k + i),
+			End:   start(k + i + 1),
+		})
+	}
+	return shifts
+}
+
+// schedule returns the start of the first shift and the shift length in days.
+func (r Rotation) schedule() (time.Time, int, bool) {
+	loc, err := r.location()
+	if err != nil {
+		return time.Time{}, 0, false
+	}
+	hour, minute, ok := parseClock(r.HandoffTime)
+	if !ok {
+		return time.Time{}, 0, false
+	}
+	date, err := time.Parse("2006-01-02", r.Start)
+	if err != nil {
+		return time.Time{}, 0, false
+	}
+	first := time.Date(date.Year(), date.Month(), date.Day(), hour, minute, 0, 0, loc)
+
+	switch r.Handoff {
+	case HandoffDaily:
+		return first, 1, true
+	case HandoffWeekly:
+		weekday, ok := parseWeekday(r.HandoffDay)
+		if !ok {
+			return time.Time{}, 0, false
+		}
+		offset := (int(weekday) - int(first.Weekday()) + 7) % 7
+		return first.AddDate(0, 0, offset), 7, true
+	default:
+		return time.Time{}, 0, false
+	}
+}
+
+func (r Rotation) location() (*time.Location, error) {
+	if strings.TrimSpace(r.Timezone) == "" {
+		return time.UTC, nil
+	}
+	return time.LoadLocation(r.Timezone)
+}
+
+// Location returns the rotation's timezone, falling back to UTC.
+func (r Rotation) Location() *time.Location {
+	loc, err := r.location()
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// DisplayName returns the rotation name, or its ID when it has none.
+func (r Rotation) DisplayName() string {
+	if name := strings.TrimSpace(r.Name); name != "" {
+		return name
+	}
+	return r.ID
+}
+
+// Includes reports whether username is one of the rotation's users.
+func (r Rotation) Includes(username string) bool {
+	username = normalizeUser(username)
+	for _, user := range r.Users {
+		if strings.EqualFold(normalizeUser(user), username) {
+			return true
+		}
+	}
+	return false
+}
+
+// Find returns the rotation with the given ID.
+func Find(rotations []Rotation, id string) (Rotation, bool) {
+	for _, rotation := range rotations {
+		if rotation.ID == id {
+			return rotation, true
+		}
+	}
+	return Rotation{}, false
+}
+
+func normalizeUser(user string) string {
+	return strings.TrimPrefix(strings.TrimSpace(user), "@")
+}
+
+func parseWeekday(value string) (time.Weekday, bool) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if value == "" {
+		return time.Monday, true
+	}
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		name := strings.ToLower(day.String())
+		if value == name || value == name[:3] {
+			return day, true
+		}
+	}
+	return 0, false
+}
+
+func parseClock(value string) (hour, minute int, ok bool) {
+	if strings.TrimSpace(value) == "" {
+		value = DefaultHandoffTime
+	}
+	clock, err := time.Parse("15:04", strings.TrimSpace(value))
+	if err != nil {
+		return 0, 0, false
+	}
+	return clock.Hour(), clock.Minute(), true
+}
@@ -0,0 +1,114 @@
+package oncall
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRotationValidate(t *testing.T) {
+	valid := Rotation{ID: "backend", Users: []string{"@jane", "bob"}, Handoff: HandoffWeekly, Timezone: "Europe/Berlin", Start: "2024-05-06"}
+	if err := valid.Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(r *Rotation)
+	}{
+		{name: "missing id", modify: func(r *Rotation) { r.ID = "" }},
+		{name: "no users", modify: func(r *Rotation) { r.Users = nil }},
+		{name: "empty user", modify: func(r *Rotation) { r.Users = []string{"jane", "@"} }},
+		{name: "unknown handoff", modify: func(r *Rotation) { r.Handoff = "monthly" }},
+		{name: "bad weekday", modify: func(r *Rotation) { r.HandoffDay = "someday" }},
+		{name: "bad time", modify: func(r *Rotation) { r.HandoffTime = "9am" }},
+		{name: "bad timezone", modify: func(r *Rotation) { r.Timezone = "Mars/Olympus" }},
+		{name: "bad start", modify: func(r *Rotation) { r.Start = "May 6" }},
+		{name: "override without user", modify: func(r *Rotation) { r.Override = &Override{} }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rotation := valid
+			tt.modify(&rotation)
+			if err := rotation.Validate(); err == nil {
+				t.Error("expected a validation error")
+			}
+		})
+	}
+}
+
+func TestRotationCurrent(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip("timezone data unavailable")
+	}
+
+	// 2024-05-01 is a Wednesday, so the first weekly shift starts on Monday
+	// 2024-05-06 at 09:00 in Berlin.
+	weekly := Rotation{ID: "backend", Users: []string{"@jane", "bob", "carol"}, Handoff: HandoffWeekly, Timezone: "Europe/Berlin", Start: "2024-05-01"}
+	daily := Rotation{ID: "web", Users: []string{"jane", "bob"}, Handoff: HandoffDaily, HandoffTime: "18:30", Start: "2024-03-30", Timezone: "Europe/Berlin"}
+
+	tests := []struct {
+		name     string
+		rotation Rotation
+		now      time.Time
+		want     string
+		start    time.Time
+	}{
+		{name: "first shift", rotation: weekly, now: time.Date(2024, 5, 6, 9, 0, 0, 0, berlin), want: "jane", start: time.Date(2024, 5, 6, 9, 0, 0, 0, berlin)},
+		{name: "just before handoff", rotation: weekly, now: time.Date(2024, 5, 13, 8, 59, 0, 0, berlin), want: "jane", start: time.Date(2024, 5, 6, 9, 0, 0, 0, berlin)},
+		{name: "second shift", rotation: weekly, now: time.Date(2024, 5, 13, 9, 0, 0, 0, berlin), want: "bob", start: time.Date(2024, 5, 13, 9, 0, 0, 0, berlin)},
+		{name: "wraps around", rotation: weekly, now: time.Date(2024, 5, 28, 12, 0, 0, 0, berlin), want: "jane", start: time.Date(2024, 5, 27, 9, 0, 0, 0, berlin)},
+		// Clocks go forward on 2024-03-31, so the day is 23 hours long.
+		{name: "across DST change", rotation: daily, now: time.Date(2024, 3, 31, 18, 30, 0, 0, berlin), want: "bob", start: time.Date(2024, 3, 31, 18, 30, 0, 0, berlin)},
+		{name: "before DST handoff", rotation: daily, now: time.Date(2024, 3, 31, 18, 29, 0, 0, berlin), want: "jane", start: time.Date(2024, 3, 30, 18, 30, 0, 0, berlin)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			shift, ok := tt.rotation.Current(tt.now)
+			if !ok {
+				t.Fatal("expected someone on call")
+			}
+			if shift.User != tt.want || !shift.Start.Equal(tt.start) || shift.Override {
+				t.Errorf("Current() = %+v, want %s from %s", shift, tt.want, tt.start)
+			}
+		})
+	}
+
+	if _, ok := weekly.Current(time.Date(2024, 5, 6, 8, 0, 0, 0, berlin)); ok {
+		t.Error("expected nobody on call before the rotation starts")
+	}
+}
+
+func TestRotationOverride(t *testing.T) {
+	now := time.Date(2024, 5, 8, 12, 0, 0, 0, time.UTC)
+	rotation := Rotation{ID: "backend", Users: []string{"jane", "bob"}, Handoff: HandoffDaily, Start: "2024-05-01",
+		Override: &Override{User: "@carol", Until: now.Add(time.Hour)}}
+
+	shift, ok := rotation.Current(now)
+	if !ok || shift.User != "carol" || !shift.Override || !shift.End.Equal(now.Add(time.Hour)) {
+		t.Errorf("unexpected override shift %+v", shift)
+	}
+
+	shift, ok = rotation.Current(now.Add(2 * time.Hour))
+	if !ok || shift.User != "bob" || shift.Override {
+		t.Errorf("expected the schedule after the override expired, got %+v", shift)
+	}
+}
+
+func TestRotationUpcoming(t *testing.T) {
+	rotation := Rotation{ID: "backend", Users: []string{"jane", "bob"}, Handoff: HandoffWeekly, HandoffDay: "wed", HandoffTime: "10:00", Start: "2024-05-01"}
+
+	shifts := rotation.Upcoming(time.Date(2024, 5, 9, 0, 0, 0, 0, time.UTC), 3)
+	if len(shifts) != 3 {
+		t.Fatalf("expected 3 shifts, got %d", len(shifts))
+	}
+	want := []string{"bob", "jane", "bob"}
+	for i, shift := range shifts {
+		if shift.User != want[i] {
+			t.Errorf("shift %d: got %s, want %s", i, shift.User, want[i])
+		}
+	}
+	if !shifts[0].Start.Equal(time.Date(2024, 5, 8, 10, 0, 0, 0, time.UTC)) || !shifts[0].End.Equal(shifts[1].Start) {
+		t.Errorf("unexpected shift times %+v", shifts)
+	}
+}
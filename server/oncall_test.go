@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/a-voronkov/mattermost-bugsnag/server/bugsnag"
+	"github.com/a-voronkov/mattermost-bugsnag/server/formatter"
+	"github.com/a-voronkov/mattermost-bugsnag/server/oncall"
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestUpsertErrorCardAssignsOnCall(t *testing.T) {
+	var assigned string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Method == http.MethodPatch && r.URL.Path == "/projects/proj-1/errors/err-1" {
+			assigned = strings.TrimSpace(string(body))
+			_, _ = w.Write([]byte(`{}`))
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	t.Cleanup(server.Close)
+	clients, err := bugsnag.NewFactory(bugsnag.Settings{APIURL: server.URL})
+	if err != nil {
+		t.Fatalf("NewFactory() error = %v", err)
+	}
+
+	rotations, _ := json.Marshal([]oncall.Rotation{{ID: "backend", Name: "Backend", Users: []string{"jane"}, Handoff: oncall.HandoffDaily, Start: "2024-05-01"}})
+	userMappings, _ := json.Marshal([]UserMapping{{MMUserID: "user-jane", BugsnagUserID: "collab-jane"}})
+
+	api := &plugintest.API{}
+	api.On("KVGet", pluginID+":"+KVKeyErrorPostPrefix+"proj-1:err-1").Return(nil, nil)
+	api.On("KVGet", pluginID+":"+KVKeyUserMappings).Return(userMappings, nil)
+	api.On("KVGet", pluginID+":"+KVKeyCardTemplates).Return(nil, nil)
+	api.On("KVGet", pluginID+":"+KVKeyOnCallRotations).Return(rotations, nil)
+	api.On("KVGet", pluginID+":"+KVKeyActiveErrors).Return(nil, nil)
+	api.On("KVSet", mock.Anything, mock.Anything).Return(nil)
+	api.On("GetUserByUsername", "jane").Return(&model.User{Id: "user-jane", Username: "jane"}, nil).Once()
+	api.On("CreatePost", mock.MatchedBy(func(post *model.Post) bool {
+		data, ok := formatter.CardData(post)
+		return post.RootId == "" && ok && data.AssigneeUsername == "jane"
+	})).Return(&model.Post{Id: "post-1", ChannelId: "channel-1"}, nil).Once()
+	api.On("CreatePost", mock.MatchedBy(func(post *model.Post) bool {
+		return post.RootId == "post-1" && post.Message == "📟 @jane is on call for **Backend** and has been assigned this error."
+	})).Return(&model.Post{Id: "reply-1"}, nil).Once()
+
+	p := &Plugin{}
+	p.SetAPI(api)
+	p.kvNamespace = pluginID
+	p.clients.Store(clients)
+
+	payload := webhookPayload{
+		Trigger: triggerInfo{Type: "firstException"},
+		Error:   &errorInfo{ErrorID: "err-1", ExceptionClass: "Crash", Status: "open"},
+		Project: &projectInfo{ID: "proj-1"},
+	}
+	rule := ChannelRule{ID: "r1", ProjectID: "proj-1", ChannelID: "channel-1", OnCallRotation: "backend"}
+	if err := p.upsertErrorCard(newMMClient(api, false, pluginID, ""), rule, payload, Configuration{BugsnagAPIToken: "shared-token"}); err != nil {
+		t.Fatalf("upsertErrorCard() error = %v", err)
+	}
+
+	if assigned != `{"assigned_collaborator_id":"collab-jane"}` {
+		t.Errorf("unexpected assignment %q", assigned)
+	}
+	api.AssertExpectations(t)
+}
+
+func TestExecuteOnCallCommand(t *testing.T) {
+	stored, _ := json.Marshal([]oncall.Rotation{{ID: "backend", Name: "Backend", Users: []string{"jane", "bob"}, Handoff: oncall.HandoffWeekly, Start: "2024-05-06"}})
+
+	api := &plugintest.API{}
+	api.On("KVGet", pluginID+":"+KVKeyOnCallRotations).Return(func(string) []byte { return stored }, nil)
+	api.On("KVSet", pluginID+":"+KVKeyOnCallRotations, mock.Anything).Run(func(args mock.Arguments) {
+		stored = args.Get(1).([]byte)
+	}).Return(nil)
+	api.On("HasPermissionTo", mock.Anything, model.PermissionManageSystem).Return(false)
+	api.On("GetUser", "user-jane").Return(&model.User{Id: "user-jane", Username: "jane"}, nil)
+	api.On("GetUser", "user-eve").Return(&model.User{Id: "user-eve", Username: "eve"}, nil)
+	api.On("GetUserByUsername", "carol").Return(&model.User{Id: "user-carol", Username: "carol"}, nil)
+
+	p := &Plugin{}
+	p.SetAPI(api)
+	p.kvNamespace = pluginID
+	p.configuration.Store(&Configuration{})
+
+	run := func(userID string, params ...string) string {
+		return p.executeOnCallCommand(&model.CommandArgs{UserId: userID}, params)
+	}
+
+	if reply := run("user-jane"); !strings.Contains(reply, "**Backend** (`backend`): @") {
+		t.Errorf("unexpected summary %q", reply)
+	}
+	if reply := run("user-jane", "backend"); strings.Count(reply, "\n* @") != upcomingShifts {
+		t.Errorf("unexpected schedule %q", reply)
+	}
+	if reply := run("user-eve", "override", "backend", "@carol"); !strings.Contains(reply, "Only system admins and members") {
+		t.Errorf("expected non-members to be refused, got %q", reply)
+	}
+
+	if reply := run("user-jane", "override", "backend", "@carol", "8h"); !strings.HasPrefix(reply, "@carol is on call for **Backend** until") {
+		t.Errorf("unexpected override reply %q", reply)
+	}
+	var rotations []oncall.Rotation
+	_ = json.Unmarshal(stored, &rotations)
+	if len(rotations) != 1 || rotations[0].Override == nil || rotations[0].Override.User != "carol" || rotations[0].Override.SetBy != "jane" ||
+		time.Until(rotations[0].Override.Until) < 7*time.Hour {
+		t.Fatalf("unexpected stored rotations %+v", rotations)
+	}
+	if reply := run("user-jane"); !strings.Contains(reply, "@carol until") || !strings.Contains(reply, "(override)") {
+		t.Errorf("expected the override in the summary, got %q", reply)
+	}
+
+	if reply := run("user-jane", "clear", "backend"); !strings.HasPrefix(reply, "Override removed") {
+		t.Errorf("unexpected clear reply %q", reply)
+	}
+	rotations = nil
+	_ = json.Unmarshal(stored, &rotations)
+	if rotations[0].Override != nil {
+		t.Errorf("expected the override to be removed, got %+v", rotations[0].Override)
+	}
+
+	if reply := run("user-jane", "override", "backend"); reply != oncallCommandUsage {
+		t.Errorf("expected usage, got %q", reply)
+	}
+}
@@ -0,0 +1,215 @@
+package outbound
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/a-voronkov/mattermost-bugsnag/server/metrics"
+)
+
+// Request headers set on every delivery.
+const (
+	HeaderEvent     = "X-Bugsnag-Plugin-Event"
+	HeaderDelivery  = "X-Bugsnag-Plugin-Delivery"
+	HeaderTimestamp = "X-Bugsnag-Plugin-Timestamp"
+	HeaderSignature = "X-Bugsnag-Plugin-Signature"
+)
+
+// DefaultRetryDelays are the waits before each retry of a failed delivery.
+var DefaultRetryDelays = []time.Duration{5 * time.Second, 30 * time.Second, 2 * time.Minute, 10 * time.Minute}
+
+// DeliveryTimeout bounds each delivery attempt, including reading the
+// response.
+const DeliveryTimeout = 10 * time.Second
+
+// queueSize bounds the events waiting for each endpoint. Events beyond it are
+// dropped rather than blocking the plugin.
+const queueSize = 256
+
+// Logger is the subset of the plugin API the dispatcher logs with.
+type Logger interface {
+	LogWarn(msg string, keyValuePairs ...any)
+}
+
+// Sign returns the signature of a payload sent at timestamp: the hex
+// HMAC-SHA256 of "<timestamp>.<body>" keyed with the endpoint secret, prefixed
+// with "sha256=".
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Dispatcher delivers events to endpoints in the background. Each endpoint has
+// its own queue and worker, so a slow receiver only delays its own events and
+// events reach each receiver in order.
+type Dispatcher struct {
+	client *http.Client
+	logger Logger
+	// RetryDelays overrides DefaultRetryDelays, for tests. Set it before Start.
+	RetryDelays []time.Duration
+
+	workers []*worker
+	// ctx is cancelled by Stop, which interrupts requests in flight.
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+type worker struct {
+	endpoint Endpoint
+	queue    chan Event
+}
+
+// NewDispatcher builds a dispatcher for endpoints. A nil client selects one
+// with DeliveryTimeout.
+func NewDispatcher(endpoints []Endpoint, client *http.Client, logger Logger) *Dispatcher {
+	if client == nil {
+		client = &http.Client{Timeout: DeliveryTimeout}
+	}
+	d := &Dispatcher{client: client, logger: logger, RetryDelays: DefaultRetryDelays}
+	for _, endpoint := range endpoints {
+		d.workers = append(d.workers, &worker{endpoint: endpoint, queue: make(chan Event, queueSize)})
+	}
+	return d
+}
+
+// Start launches a worker per endpoint.
+func (d *Dispatcher) Start() {
+	d.ctx, d.cancel = context.WithCancel(context.Background())
+	for _, w := range d.workers {
+		d.wg.Add(1)
+		go d.run(w)
+	}
+}
+
+// Stop halts the workers and aborts deliveries in flight. Queued events and
+// pending retries are dropped.
+func (d *Dispatcher) Stop() {
+	if d.cancel == nil {
+		return
+	}
+	d.cancel()
+	d.wg.Wait()
+	d.cancel = nil
+}
+
+// Wants reports whether any endpoint receives events of the given type, so
+// callers can skip building events nobody listens to.
+func (d *Dispatcher) Wants(eventType string) bool {
+	if d == nil {
+		return false
+	}
+	for _, w := range d.workers {
+		if w.endpoint.Wants(eventType) {
+			return true
+		}
+	}
+	return false
+}
+
+// Emit queues event for every endpoint that wants it. It never blocks: when an
+// endpoint's queue is full the event is dropped for that endpoint.
+func (d *Dispatcher) Emit(event Event) {
+	if d == nil {
+		return
+	}
+	for _, w := range d.workers {
+		if !w.endpoint.Wants(event.Type) {
+			continue
+		}
+		select {
+		case w.queue <- event:
+		default:
+			metrics.OutboundDeliveries.Inc(w.endpoint.ID, metrics.OutboundDropped)
+			d.logger.LogWarn("outbound event dropped, queue full", "endpoint", w.endpoint.ID, "event", event.Type, "event_id", event.ID)
+		}
+	}
+}
+
+func (d *Dispatcher) run(w *worker) {
+	defer d.wg.Done()
+	for {
+		select {
+		case <-d.ctx.Done():
+			return
+		case event := <-w.queue:
+			d.deliver(w.endpoint, event)
+		}
+	}
+}
+
+// deliver posts event to endpoint, retrying network errors, 429 and 5xx
+// responses. Other 4xx responses are not retried: the receiver rejected the
+// payload and sending it again will not help.
+func (d *Dispatcher) deliver(endpoint Endpoint, event Event) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		d.logger.LogWarn("failed to encode outbound event", "endpoint", endpoint.ID, "event", event.Type, "err", err.Error())
+		return
+	}
+
+	for attempt := 0; ; attempt++ {
+		retry, err := d.send(d.ctx, endpoint, event, body)
+		if err == nil {
+			metrics.OutboundDeliveries.Inc(endpoint.ID, metrics.OutboundDelivered)
+			return
+		}
+		if d.ctx.Err() != nil {
+			return
+		}
+		if !retry || attempt >= len(d.RetryDelays) {
+			metrics.OutboundDeliveries.Inc(endpoint.ID, metrics.OutboundFailed)
+			d.logger.LogWarn("outbound event delivery failed", "endpoint", endpoint.ID, "event", event.Type, "event_id", event.ID, "attempts", attempt+1, "err", err.Error())
+			return
+		}
+
+		timer := time.NewTimer(d.RetryDelays[attempt])
+		select {
+		case <-d.ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// send makes one delivery attempt and reports whether a failure is worth
+// retrying. Each attempt is signed with a fresh timestamp.
+func (d *Dispatcher) send(ctx context.Context, endpoint Endpoint, event Event, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, event.Type)
+	req.Header.Set(HeaderDelivery, event.ID)
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(HeaderSignature, Sign(endpoint.Secret, timestamp, body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return retry, fmt.Errorf("endpoint responded %s", resp.Status)
+}
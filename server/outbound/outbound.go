@@ -0,0 +1,150 @@
+// Package outbound forwards the plugin's own events, such as cards created or
+// errors resolved from Mattermost, to configured HTTP endpoints. Payloads are
+// signed with HMAC-SHA256 and failed deliveries are retried.
+package outbound
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Event types.
+const (
+	EventCardCreated   = "card.created"
+	EventStatusChanged = "error.status_changed"
+	EventAssigned      = "error.assigned"
+	EventSpike         = "error.spike"
+)
+
+// EventTypes lists every event type, in documentation order.
+var EventTypes = []string{EventCardCreated, EventStatusChanged, EventAssigned, EventSpike}
+
+// Event sources: where the change that caused an event was made.
+const (
+	SourceCard     = "card"
+	SourceWebhook  = "webhook"
+	SourceSync     = "sync"
+	SourceBackfill = "backfill"
+	SourceSearch   = "search"
+)
+
+// Event is the normalized payload sent to endpoints.
+type Event struct {
+	// ID is unique per event and stays the same across retries, so receivers
+	// can drop duplicates.
+	ID           string    `json:"id"`
+	Type         string    `json:"type"`
+	Time         time.Time `json:"time"`
+	Source       string    `json:"source"`
+	ConnectionID string    `json:"connection_id,omitempty"`
+	ProjectID    string    `json:"project_id"`
+	ErrorID      string    `json:"error_id"`
+	Error        *Error    `json:"error,omitempty"`
+	// ChannelID, PostID and PostURL locate the error's card.
+	ChannelID string `json:"channel_id,omitempty"`
+	PostID    string `json:"post_id,omitempty"`
+	PostURL   string `json:"post_url,omitempty"`
+	// Status is the error's status after the event and PreviousStatus its
+	// status before a status change.
+	Status         string `json:"status,omitempty"`
+	PreviousStatus string `json:"previous_status,omitempty"`
+	// Assignee is the Mattermost username, or the Bugsnag email of an
+	// unmapped collaborator.
+	Assignee string `json:"assignee,omitempty"`
+	// Detail is Bugsnag's description of a spike, such as the event rate.
+	Detail string `json:"detail,omitempty"`
+	// Actor is the Mattermost user who made the change, when it was made in
+	// Mattermost.
+	Actor *Actor `json:"actor,omitempty"`
+}
+
+// Error summarizes the Bugsnag error an event is about.
+type Error struct {
+	Class       string `json:"class,omitempty"`
+	Message     string `json:"message,omitempty"`
+	Severity    string `json:"severity,omitempty"`
+	Environment string `json:"environment,omitempty"`
+	URL         string `json:"url,omitempty"`
+}
+
+// Actor is a Mattermost user.
+type Actor struct {
+	UserID   string `json:"user_id"`
+	Username string `json:"username,omitempty"`
+}
+
+// Endpoint is one configured receiver, as entered in the OutboundWebhooks
+// plugin setting.
+type Endpoint struct {
+	ID  string `json:"id"`
+	URL string `json:"url"`
+	// Secret signs the payloads sent to the endpoint.
+	Secret string `json:"secret"`
+	// Events limits the event types sent; empty sends all of them.
+	Events []string `json:"events,omitempty"`
+}
+
+// Wants reports whether the endpoint receives events of the given type.
+func (e Endpoint) Wants(eventType string) bool {
+	if len(e.Events) == 0 {
+		return true
+	}
+	for _, t := range e.Events {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// Parse decodes and validates a JSON list of endpoints. An empty string yields
+// no endpoints.
+func Parse(raw string) ([]Endpoint, error) {
+	if strings.TrimSpace(raw) == "" {
+		return nil, nil
+	}
+
+	var endpoints []Endpoint
+	if err := json.Unmarshal([]byte(raw), &endpoints); err != nil {
+		return nil, fmt.Errorf("decode outbound webhooks: %w", err)
+	}
+
+	known := map[string]bool{}
+	for _, t := range EventTypes {
+		known[t] = true
+	}
+
+	seen := map[string]bool{}
+	for i := range endpoints {
+		e := &endpoints[i]
+		e.ID = strings.TrimSpace(e.ID)
+		e.URL = strings.TrimSpace(e.URL)
+		e.Secret = strings.TrimSpace(e.Secret)
+
+		switch {
+		case e.ID == "":
+			return nil, fmt.Errorf("outbound webhook %d: id is required", i+1)
+		case seen[e.ID]:
+			return nil, fmt.Errorf("outbound webhook %q is defined more than once", e.ID)
+		case e.Secret == "":
+			return nil, fmt.Errorf("outbound webhook %q: secret is required", e.ID)
+		}
+		seen[e.ID] = true
+
+		parsed, err := url.Parse(e.URL)
+		if err != nil || (parsed.Scheme != "https" && parsed.Scheme != "http") || parsed.Host == "" {
+			return nil, fmt.Errorf("outbound webhook %q: url must be an http(s) URL", e.ID)
+		}
+
+		for _, t := range e.Events {
+			if !known[t] {
+				return nil, fmt.Errorf("outbound webhook %q: unknown event %q, use %s", e.ID, t, strings.Join(EventTypes, ", "))
+			}
+		}
+	}
+
+	return endpoints, nil
+}
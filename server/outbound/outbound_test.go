@@ -0,0 +1,192 @@
+package outbound
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/a-voronkov/mattermost-bugsnag/server/metrics"
+)
+
+type testLogger struct {
+	mu       sync.Mutex
+	messages []string
+}
+
+func (l *testLogger) LogWarn(msg string, _ ...any) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.messages = append(l.messages, msg)
+}
+
+func TestParse(t *testing.T) {
+	endpoints, err := Parse(`[{"id":" ops ","url":"https://hooks.example.com/bugsnag","secret":"s3cret","events":["error.status_changed"]},{"id":"all","url":"http://10.0.0.1:8080/in","secret":"x"}]`)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if len(endpoints) != 2 || endpoints[0].ID != "ops" {
+		t.Fatalf("unexpected endpoints %+v", endpoints)
+	}
+	if endpoints[0].Wants(EventCardCreated) || !endpoints[0].Wants(EventStatusChanged) || !endpoints[1].Wants(EventSpike) {
+		t.Errorf("unexpected event filters %+v", endpoints)
+	}
+
+	invalid := map[string]string{
+		`[{"url":"https://x","secret":"s"}]`:                                                    "id is required",
+		`[{"id":"a","url":"https://x","secret":"s"},{"id":"a","url":"https://y","secret":"s"}]`: "more than once",
+		`[{"id":"a","url":"https://x"}]`:                                                        "secret is required",
+		`[{"id":"a","url":"ftp://x","secret":"s"}]`:                                             "http(s) URL",
+		`[{"id":"a","url":"https://x","secret":"s","events":["error.deleted"]}]`:                "unknown event",
+	}
+	for raw, want := range invalid {
+		if _, err := Parse(raw); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Parse(%s) error = %v, want %q", raw, err, want)
+		}
+	}
+}
+
+func TestDispatcherSignsAndRetries(t *testing.T) {
+	var mu sync.Mutex
+	var attempts int
+	received := make(chan Event, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		timestamp, _ := strconv.ParseInt(r.Header.Get(HeaderTimestamp), 10, 64)
+		if r.Header.Get(HeaderSignature) != Sign("s3cret", timestamp, body) {
+			http.Error(w, "bad signature", http.StatusUnauthorized)
+			return
+		}
+
+		mu.Lock()
+		attempts++
+		first := attempts == 1
+		mu.Unlock()
+		if first {
+			http.Error(w, "try later", http.StatusServiceUnavailable)
+			return
+		}
+
+		var event Event
+		_ = json.Unmarshal(body, &event)
+		if r.Header.Get(HeaderEvent) != event.Type || r.Header.Get(HeaderDelivery) != event.ID {
+			http.Error(w, "bad headers", http.StatusBadRequest)
+			return
+		}
+		received <- event
+	}))
+	t.Cleanup(server.Close)
+
+	before := metrics.OutboundDeliveries.Value("hooks", metrics.OutboundDelivered)
+	d := NewDispatcher([]Endpoint{{ID: "hooks", URL: server.URL, Secret: "s3cret", Events: []string{EventStatusChanged}}}, server.Client(), &testLogger{})
+	d.RetryDelays = []time.Duration{time.Millisecond}
+	d.Start()
+	t.Cleanup(d.Stop)
+
+	if d.Wants(EventCardCreated) || !d.Wants(EventStatusChanged) {
+		t.Fatalf("unexpected Wants results")
+	}
+	d.Emit(Event{ID: "evt-0", Type: EventCardCreated, ProjectID: "proj-1", ErrorID: "err-1"})
+	d.Emit(Event{ID: "evt-1", Type: EventStatusChanged, ProjectID: "proj-1", ErrorID: "err-1", Status: "fixed", PreviousStatus: "open"})
+
+	select {
+	case event := <-received:
+		if event.ID != "evt-1" || event.Status != "fixed" || event.PreviousStatus != "open" {
+			t.Errorf("unexpected event %+v", event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("event was not delivered")
+	}
+	// The worker counts the delivery once the response is read.
+	deadline := time.Now().Add(5 * time.Second)
+	for metrics.OutboundDeliveries.Value("hooks", metrics.OutboundDelivered) == before && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := metrics.OutboundDeliveries.Value("hooks", metrics.OutboundDelivered) - before; got != 1 {
+		t.Errorf("delivered count = %v, want 1", got)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if attempts != 2 {
+		t.Errorf("attempts = %d, want 2", attempts)
+	}
+}
+
+func TestDispatcherDoesNotRetryRejections(t *testing.T) {
+	var mu sync.Mutex
+	var attempts int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		mu.Lock()
+		attempts++
+		mu.Unlock()
+		http.Error(w, "no", http.StatusBadRequest)
+	}))
+	t.Cleanup(server.Close)
+
+	before := metrics.OutboundDeliveries.Value("rejects", metrics.OutboundFailed)
+	logger := &testLogger{}
+	d := NewDispatcher([]Endpoint{{ID: "rejects", URL: server.URL, Secret: "s"}}, server.Client(), logger)
+	d.RetryDelays = []time.Duration{time.Millisecond, time.Millisecond}
+	d.Start()
+	d.Emit(Event{ID: "evt-1", Type: EventSpike})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for metrics.OutboundDeliveries.Value("rejects", metrics.OutboundFailed) == before && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	d.Stop()
+
+	mu.Lock()
+	defer mu.Unlock()
+	logger.mu.Lock()
+	defer logger.mu.Unlock()
+	if attempts != 1 || fmt.Sprint(logger.messages) != "[outbound event delivery failed]" {
+		t.Errorf("attempts = %d, logs = %v", attempts, logger.messages)
+	}
+}
+
+func TestDispatcherStopAbortsDelivery(t *testing.T) {
+	received := make(chan struct{})
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		close(received)
+		// A receiver that never answers on its own.
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	t.Cleanup(func() {
+		close(release)
+		server.Close()
+	})
+
+	logger := &testLogger{}
+	d := NewDispatcher([]Endpoint{{ID: "stuck", URL: server.URL, Secret: "s"}}, server.Client(), logger)
+	d.Start()
+	d.Emit(Event{ID: "evt-1", Type: EventSpike})
+	<-received
+
+	stopped := make(chan struct{})
+	go func() {
+		d.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stop() waited for the stuck delivery")
+	}
+
+	logger.mu.Lock()
+	defer logger.mu.Unlock()
+	if len(logger.messages) != 0 {
+		t.Errorf("an aborted delivery should not be reported as failed, got %v", logger.messages)
+	}
+}
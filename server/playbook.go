@@ -0,0 +1,279 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/a-voronkov/mattermost-bugsnag/server/formatter"
+	"github.com/a-voronkov/mattermost-bugsnag/server/metrics"
+	"github.com/a-voronkov/mattermost-bugsnag/server/playbooks"
+	"github.com/a-voronkov/mattermost-bugsnag/server/store"
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// actionStartPlaybook is the card action that starts a Playbooks run.
+const actionStartPlaybook = "start_playbook"
+
+// playbookRunNameMaxRunes keeps run names readable in the Playbooks sidebar.
+const playbookRunNameMaxRunes = 64
+
+// startPlaybookRun starts the rule's playbook for an error, acting as ownerID,
+// links the run to the card and returns the updated mapping.
+func (p *Plugin) startPlaybookRun(mm *MMClient, rule ChannelRule, mapping ErrorPostMapping, data formatter.ErrorData, ownerID string) (ErrorPostMapping, *playbooks.Run, error) {
+	playbookID := rule.Playbook.playbookFor(data.Severity)
+	if playbookID == "" {
+		return mapping, nil, fmt.Errorf("no playbook is configured for %q errors", data.Severity)
+	}
+
+	ruleChannel, appErr := mm.GetChannel(rule.ChannelID)
+	if appErr != nil {
+		return mapping, nil, fmt.Errorf("load rule channel: %w", appErr)
+	}
+
+	title := data.ExceptionClass
+	if title == "" {
+		title = data.Message
+	}
+	var links []string
+	if data.ErrorURL != "" {
+		links = append(links, fmt.Sprintf("[Open in Bugsnag](%s)", data.ErrorURL))
+	}
+	if link := mm.Permalink(mapping.PostID); link != "" {
+		links = append(links, fmt.Sprintf("[Error card](%s)", link))
+	}
+	description := strings.TrimSpace(data.Message)
+	if len(links) > 0 {
+		description = strings.TrimSpace(description + "\n\n" + strings.Join(links, " · "))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	run, err := p.playbooksClient().CreateRun(ctx, ownerID, playbooks.CreateRunOptions{
+		Name:        truncateText("Bugsnag: "+title, playbookRunNameMaxRunes),
+		OwnerUserID: ownerID,
+		TeamID:      ruleChannel.TeamId,
+		PlaybookID:  playbookID,
+		Description: description,
+		ChannelID:   mapping.IncidentChannelID,
+		PostID:      mapping.PostID,
+	})
+	if err != nil {
+		return mapping, nil, fmt.Errorf("start playbook run: %w", err)
+	}
+
+	// The mapping may have changed while the run was being created, so only
+	// the run is written back.
+	mapping.PlaybookRunID = run.ID
+	mapping.PlaybookOwnerID = ownerID
+	if _, appErr := mm.UpdateJSON(errorPostKVKey(mapping.ProjectID, mapping.ErrorID), &mapping, func() {
+		mapping.PlaybookRunID = run.ID
+		mapping.PlaybookOwnerID = ownerID
+	}); appErr != nil {
+		mm.LogDebug("failed to store playbook run", "err", appErr.Error())
+	}
+
+	p.setCardPlaybook(mm, mapping, run)
+
+	reply := fmt.Sprintf("▶ **Playbook**: started run [%s](%s).", run.Name, playbookRunURL(mm, run.ID))
+	if _, appErr := mm.CreateReply(mapping.ChannelID, mapping.PostID, reply); appErr != nil {
+		mm.LogDebug("failed to link playbook run from the card", "err", appErr.Error())
+	}
+
+	p.API.LogInfo("playbook run started", "run_id", run.ID, "playbook_id", playbookID, "error_id", mapping.ErrorID)
+	return mapping, run, nil
+}
+
+// autoStartPlaybook starts the rule's playbook for a new card when the rule
+// asks for it. The run is owned by the error's mapped assignee, or else by the
+// bot.
+func (p *Plugin) autoStartPlaybook(mm *MMClient, rule ChannelRule, payload webhookPayload, data formatter.ErrorData, mapping ErrorPostMapping) {
+	if rule.Playbook == nil || !rule.Playbook.AutoStart || !data.PlaybookAvailable {
+		return
+	}
+
+	ownerID := p.botUserID
+	if assignee := payload.getAssignedCollaborator(); assignee != nil {
+		userMappings, _ := loadUserMappings(mm)
+		if userID := mapBugsnagToMattermost(userMappingsFor(userMappings, rule.ConnectionID), assignee.ID, assignee.Email); userID != "" {
+			ownerID = userID
+		}
+	}
+
+	if _, _, err := p.startPlaybookRun(mm, rule, mapping, data, ownerID); err != nil {
+		p.API.LogWarn("failed to start playbook run", "rule_id", rule.ID, "error_id", mapping.ErrorID, "err", err.Error())
+	}
+}
+
+// setCardPlaybook shows the run and its status on the card.
+func (p *Plugin) setCardPlaybook(mm *MMClient, mapping ErrorPostMapping, run *playbooks.Run) {
+	p.updateCardData(mm, mapping, func(data *formatter.ErrorData) {
+		data.Playbook = &formatter.PlaybookRun{
+			ID:     run.ID,
+			Name:   run.Name,
+			Status: playbookStatusLabel(run.CurrentStatus),
+			URL:    playbookRunURL(mm, run.ID),
+		}
+	})
+}
+
+// updateCardData applies change to the data stored on a card and re-renders
+// it with the card's template. Cards without stored data are left alone.
+func (p *Plugin) updateCardData(mm *MMClient, mapping ErrorPostMapping, change func(*formatter.ErrorData)) {
+	post, appErr := mm.GetPost(mapping.PostID)
+	if appErr != nil {
+		mm.LogDebug("failed to load card", "post_id", mapping.PostID, "err", appErr.Error())
+		return
+	}
+	data, ok := formatter.CardData(post)
+	if !ok {
+		return
+	}
+
+	templates, err := loadCardTemplates(mm)
+	if err != nil {
+		mm.LogDebug("failed to load card templates", "err", err.Error())
+		return
+	}
+
+	change(&data)
+	if err := formatter.ApplyTemplatedCard(post, data, formatter.ErrorPostMapping{
+		ChannelID: mapping.ChannelID,
+		ProjectID: mapping.ProjectID,
+		ErrorID:   mapping.ErrorID,
+	}, formatter.CardTemplateFor(post, templates)); err != nil {
+		p.API.LogWarn("card template failed, using built-in layout", "err", err.Error())
+	}
+	if _, appErr := mm.UpdatePost(post); appErr != nil {
+		mm.LogDebug("failed to update card", "post_id", mapping.PostID, "err", appErr.Error())
+	}
+}
+
+// resolvePlaybookRun reports a fixed error to its run, finishing the run when
+// the rule asks for it, and refreshes the run status on the card.
+func (p *Plugin) resolvePlaybookRun(mm *MMClient, mapping ErrorPostMapping, status string) {
+	if mapping.PlaybookRunID == "" || status != "fixed" {
+		return
+	}
+
+	var policy *PlaybookPolicy
+	if rules, err := loadChannelRules(mm); err == nil {
+		if rule, ok := ruleForCard(rules, mapping); ok {
+			policy = rule.Playbook
+		}
+	}
+
+	client := p.playbooksClient()
+	userID := mapping.PlaybookOwnerID
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := client.UpdateStatus(ctx, userID, mapping.PlaybookRunID, "✅ The Bugsnag error was marked as fixed."); err != nil {
+		p.API.LogWarn("failed to post playbook status update", "run_id", mapping.PlaybookRunID, "err", err.Error())
+	}
+	if policy != nil && policy.FinishOnResolve {
+		if err := client.FinishRun(ctx, userID, mapping.PlaybookRunID); err != nil {
+			p.API.LogWarn("failed to finish playbook run", "run_id", mapping.PlaybookRunID, "err", err.Error())
+		}
+	}
+
+	run, err := client.GetRun(ctx, userID, mapping.PlaybookRunID)
+	if err != nil {
+		mm.LogDebug("failed to refresh playbook run", "run_id", mapping.PlaybookRunID, "err", err.Error())
+		return
+	}
+	p.setCardPlaybook(mm, mapping, run)
+}
+
+// handleStartPlaybookAction starts the playbook of the card's channel rule.
+func (p *Plugin) handleStartPlaybookAction(w http.ResponseWriter, payload model.PostActionIntegrationRequest) {
+	errorID, _ := payload.Context["error_id"].(string)
+	projectID, _ := payload.Context["project_id"].(string)
+
+	respond := func(text string) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(model.PostActionIntegrationResponse{EphemeralText: text})
+	}
+
+	mm := newMMClient(p.API, p.getConfiguration().EnableDebugLog, p.kvNS(), p.botUserID)
+
+	user, appErr := mm.GetUser(payload.UserId)
+	if appErr != nil {
+		http.Error(w, "invalid user", http.StatusBadRequest)
+		return
+	}
+
+	var mapping ErrorPostMapping
+	if found, _ := mm.LoadJSON(errorPostKVKey(projectID, errorID), &mapping); !found {
+		respond("This card is no longer tracked, so a playbook cannot be started from it.")
+		return
+	}
+	if mapping.PlaybookRunID != "" {
+		respond(fmt.Sprintf("A playbook run is already linked to this error: %s", playbookRunURL(mm, mapping.PlaybookRunID)))
+		return
+	}
+
+	rules, err := loadChannelRules(mm)
+	if err != nil {
+		p.API.LogWarn("failed to load channel rules", "err", err.Error())
+	}
+	rule, ok := ruleForCard(rules, mapping)
+	if !ok || rule.Playbook == nil {
+		respond("No playbook is configured for this channel.")
+		return
+	}
+
+	post, appErr := mm.GetPost(mapping.PostID)
+	if appErr != nil {
+		http.Error(w, "card not found", http.StatusNotFound)
+		return
+	}
+	data, _ := formatter.CardData(post)
+
+	audit := store.AuditRecord{
+		Source:    store.AuditSourceCard,
+		Action:    actionStartPlaybook,
+		UserID:    user.Id,
+		Username:  user.Username,
+		ProjectID: projectID,
+		ErrorID:   errorID,
+	}
+
+	mapping, run, err := p.startPlaybookRun(mm, rule, mapping, data, user.Id)
+	if err != nil {
+		p.API.LogWarn("failed to start playbook run", "rule_id", rule.ID, "error_id", errorID, "err", err.Error())
+		metrics.Actions.Inc(actionStartPlaybook, metrics.ActionFailure)
+		audit.Response = err.Error()
+		p.recordAudit(audit)
+		respond(fmt.Sprintf("Could not start the playbook: %s", err.Error()))
+		return
+	}
+
+	p.acknowledgeEscalation(mm, mapping, "acknowledged by @"+user.Username)
+
+	metrics.Actions.Inc(actionStartPlaybook, metrics.ActionSuccess)
+	audit.Success = true
+	audit.Response = "started playbook run " + run.ID
+	p.recordAudit(audit)
+	respond(fmt.Sprintf("Started playbook run [%s](%s).", run.Name, playbookRunURL(mm, run.ID)))
+}
+
+// playbookRunURL links to a run in the Playbooks UI.
+func playbookRunURL(mm *MMClient, runID string) string {
+	return mm.SiteURL() + "/playbooks/runs/" + runID
+}
+
+// playbookStatusLabel turns a Playbooks run status into the label on the card.
+func playbookStatusLabel(status string) string {
+	switch status {
+	case playbooks.StatusInProgress:
+		return "in progress"
+	case playbooks.StatusFinished:
+		return "finished"
+	default:
+		return strings.ToLower(status)
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/a-voronkov/mattermost-bugsnag/server/formatter"
+	"github.com/a-voronkov/mattermost-bugsnag/server/playbooks"
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestPlaybookFor(t *testing.T) {
+	policy := &PlaybookPolicy{PlaybookID: "pb-default", BySeverity: map[string]string{"error": "pb-critical"}}
+
+	if got := policy.playbookFor("Error"); got != "pb-critical" {
+		t.Errorf("playbookFor(Error) = %q, want pb-critical", got)
+	}
+	if got := policy.playbookFor("warning"); got != "pb-default" {
+		t.Errorf("playbookFor(warning) = %q, want pb-default", got)
+	}
+	if got := (*PlaybookPolicy)(nil).playbookFor("error"); got != "" {
+		t.Errorf("nil policy returned %q", got)
+	}
+}
+
+// newFakePlaybooks starts a local Playbooks server and returns a client for
+// it along with the requests it received.
+func newFakePlaybooks(t *testing.T) (*playbooks.Client, *[]string) {
+	t.Helper()
+
+	var requests []string
+	status := playbooks.StatusInProgress
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path+" as "+r.Header.Get("Mattermost-User-ID"))
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/api/v0/runs":
+			var options playbooks.CreateRunOptions
+			_ = json.NewDecoder(r.Body).Decode(&options)
+			_ = json.NewEncoder(w).Encode(playbooks.Run{ID: "run-1", Name: options.Name, TeamID: options.TeamID, PlaybookID: options.PlaybookID, CurrentStatus: status})
+		case r.Method == http.MethodPut && r.URL.Path == "/api/v0/runs/run-1/finish":
+			status = playbooks.StatusFinished
+		case r.Method == http.MethodGet && r.URL.Path == "/api/v0/runs/run-1":
+			_ = json.NewEncoder(w).Encode(playbooks.Run{ID: "run-1", Name: "Bugsnag: NoMethodError", CurrentStatus: status})
+		case r.Method == http.MethodPost && r.URL.Path == "/api/v0/runs/run-1/status":
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return playbooks.NewClient(server.URL, server.Client()), &requests
+}
+
+// playbookRule returns chan-1's rule with policy.
+func playbookRule(policy *PlaybookPolicy) ChannelRule {
+	return ChannelRule{ID: "r1", ProjectID: "proj-1", ChannelID: "chan-1", Playbook: policy}
+}
+
+func TestHandleStartPlaybookAction(t *testing.T) {
+	client, requests := newFakePlaybooks(t)
+
+	api := cardAPI(t, ErrorPostMapping{ProjectID: "proj-1", ErrorID: "err-1", ChannelID: "chan-1", PostID: "post-1", IncidentChannelID: "incident-1"},
+		playbookRule(&PlaybookPolicy{PlaybookID: "pb-default", BySeverity: map[string]string{"error": "pb-critical"}}))
+	api.On("GetUser", "user-1").Return(&model.User{Id: "user-1", Username: "jane"}, nil)
+	api.On("GetChannel", "chan-1").Return(&model.Channel{Id: "chan-1", TeamId: "team-1"}, nil)
+	api.On("KVSetWithOptions", pluginID+":"+errorPostKVKey("proj-1", "err-1"), mock.MatchedBy(func(data []byte) bool {
+		var mapping ErrorPostMapping
+		return json.Unmarshal(data, &mapping) == nil && mapping.PlaybookRunID == "run-1" && mapping.PlaybookOwnerID == "user-1" &&
+			mapping.IncidentChannelID == "incident-1"
+	}), mock.Anything).Return(true, nil).Once()
+	api.On("UpdatePost", mock.MatchedBy(func(post *model.Post) bool {
+		data, _ := formatter.CardData(post)
+		for _, action := range post.Attachments()[0].Actions {
+			if action.Id == "startplaybook" {
+				return false
+			}
+		}
+		return data.Playbook != nil && data.Playbook.Status == "in progress" && data.Playbook.URL == "https://mm.example.com/playbooks/runs/run-1"
+	})).Return(&model.Post{Id: "post-1"}, nil).Once()
+	api.On("CreatePost", mock.MatchedBy(func(post *model.Post) bool {
+		return post.RootId == "post-1" && strings.Contains(post.Message, "started run [Bugsnag: NoMethodError]")
+	})).Return(&model.Post{Id: "reply-1"}, nil).Once()
+	api.On("KVGet", pluginID+":"+KVKeyAuditLog).Return(nil, nil)
+	api.On("KVSet", pluginID+":"+KVKeyAuditLog, mock.Anything).Return(nil).Once()
+
+	p := &Plugin{playbooks: client}
+	p.SetAPI(api)
+	p.kvNamespace = pluginID
+
+	body, _ := json.Marshal(model.PostActionIntegrationRequest{
+		UserId:    "user-1",
+		ChannelId: "chan-1",
+		Context:   map[string]any{"action": actionStartPlaybook, "error_id": "err-1", "project_id": "proj-1"},
+	})
+	rr := httptest.NewRecorder()
+	p.handleActions(rr, actionRequest(body, "user-1"))
+
+	var resp model.PostActionIntegrationResponse
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if !strings.Contains(resp.EphemeralText, "Started playbook run") {
+		t.Fatalf("unexpected response %q", resp.EphemeralText)
+	}
+	if len(*requests) != 1 || (*requests)[0] != "POST /api/v0/runs as user-1" {
+		t.Errorf("unexpected Playbooks requests %v", *requests)
+	}
+
+	api.AssertExpectations(t)
+}
+
+func TestHandleStartPlaybookActionRejectsForgedUser(t *testing.T) {
+	client, requests := newFakePlaybooks(t)
+
+	api := &plugintest.API{}
+	p := &Plugin{playbooks: client}
+	p.SetAPI(api)
+	p.kvNamespace = pluginID
+
+	body, _ := json.Marshal(model.PostActionIntegrationRequest{
+		UserId:    "user-1",
+		ChannelId: "chan-1",
+		Context:   map[string]any{"action": actionStartPlaybook, "error_id": "err-1", "project_id": "proj-1"},
+	})
+	rr := httptest.NewRecorder()
+	p.handleActions(rr, actionRequest(body, "user-2"))
+
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected status %d, got %d", http.StatusUnauthorized, rr.Code)
+	}
+	if len(*requests) != 0 {
+		t.Errorf("expected no Playbooks requests, got %v", *requests)
+	}
+}
+
+func TestResolvePlaybookRunFinishesRun(t *testing.T) {
+	client, requests := newFakePlaybooks(t)
+
+	mapping := ErrorPostMapping{ProjectID: "proj-1", ErrorID: "err-1", ChannelID: "chan-1", PostID: "post-1", PlaybookRunID: "run-1", PlaybookOwnerID: "user-1"}
+	api := cardAPI(t, mapping, playbookRule(&PlaybookPolicy{PlaybookID: "pb-default", FinishOnResolve: true}))
+	api.On("UpdatePost", mock.MatchedBy(func(post *model.Post) bool {
+		data, _ := formatter.CardData(post)
+		return data.Playbook != nil && data.Playbook.Status == "finished"
+	})).Return(&model.Post{Id: "post-1"}, nil).Once()
+
+	p := &Plugin{playbooks: client}
+	p.SetAPI(api)
+	p.kvNamespace = pluginID
+
+	p.resolvePlaybookRun(newMMClient(api, false, pluginID, ""), mapping, "fixed")
+
+	want := "POST /api/v0/runs/run-1/status as user-1,PUT /api/v0/runs/run-1/finish as user-1,GET /api/v0/runs/run-1 as user-1"
+	if got := strings.Join(*requests, ","); got != want {
+		t.Errorf("Playbooks requests = %s, want %s", got, want)
+	}
+
+	api.AssertExpectations(t)
+}
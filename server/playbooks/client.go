@@ -0,0 +1,139 @@
+// Package playbooks talks to the Mattermost Playbooks plugin over the
+// inter-plugin HTTP API.
+package playbooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/a-voronkov/mattermost-bugsnag/server/interplugin"
+)
+
+// PluginID is the ID of the Mattermost Playbooks plugin.
+const PluginID = "playbooks"
+
+// Run statuses reported by Playbooks.
+const (
+	StatusInProgress = "InProgress"
+	StatusFinished   = "Finished"
+)
+
+// Run is a Playbooks run.
+type Run struct {
+	ID            string `json:"id"`
+	Name          string `json:"name"`
+	TeamID        string `json:"team_id"`
+	ChannelID     string `json:"channel_id"`
+	PlaybookID    string `json:"playbook_id"`
+	OwnerUserID   string `json:"owner_user_id"`
+	CurrentStatus string `json:"current_status"`
+}
+
+// CreateRunOptions describes a run to start.
+type CreateRunOptions struct {
+	Name        string `json:"name"`
+	OwnerUserID string `json:"owner_user_id"`
+	TeamID      string `json:"team_id"`
+	PlaybookID  string `json:"playbook_id"`
+	Description string `json:"description,omitempty"`
+	// ChannelID runs the playbook in an existing channel instead of the one
+	// the playbook would create.
+	ChannelID string `json:"channel_id,omitempty"`
+	// PostID is the post the run was started from.
+	PostID string `json:"post_id,omitempty"`
+}
+
+// Client calls the Playbooks REST API. Every request acts as a Mattermost
+// user, passed in the Mattermost-User-ID header.
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+}
+
+// NewClient returns a client for the Playbooks API at baseURL, such as a test
+// server.
+func NewClient(baseURL string, httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &Client{baseURL: strings.TrimRight(baseURL, "/"), httpClient: httpClient}
+}
+
+// NewPluginClient returns a client that reaches the Playbooks plugin through
+// the server's inter-plugin HTTP API.
+func NewPluginClient(api interplugin.HTTPer) *Client {
+	return NewClient("/"+PluginID, interplugin.NewClient(api))
+}
+
+// CreateRun starts a playbook run.
+func (c *Client) CreateRun(ctx context.Context, userID string, options CreateRunOptions) (*Run, error) {
+	var run Run
+	if err := c.do(ctx, userID, http.MethodPost, "/api/v0/runs", options, &run); err != nil {
+		return nil, err
+	}
+	return &run, nil
+}
+
+// GetRun returns a run.
+func (c *Client) GetRun(ctx context.Context, userID, runID string) (*Run, error) {
+	var run Run
+	if err := c.do(ctx, userID, http.MethodGet, "/api/v0/runs/"+url.PathEscape(runID), nil, &run); err != nil {
+		return nil, err
+	}
+	return &run, nil
+}
+
+// UpdateStatus posts a status update to a run.
+func (c *Client) UpdateStatus(ctx context.Context, userID, runID, message string) error {
+	body := map[string]any{"message": message, "reminder": 0}
+	return c.do(ctx, userID, http.MethodPost, "/api/v0/runs/"+url.PathEscape(runID)+"/status", body, nil)
+}
+
+// FinishRun finishes a run.
+func (c *Client) FinishRun(ctx context.Context, userID, runID string) error {
+	return c.do(ctx, userID, http.MethodPut, "/api/v0/runs/"+url.PathEscape(runID)+"/finish", nil, nil)
+}
+
+func (c *Client) do(ctx context.Context, userID, method, endpoint string, body any, out any) error {
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			return fmt.Errorf("encode request: %w", err)
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+endpoint, &buf)
+	if err != nil {
+		return fmt.Errorf("build request: %w", err)
+	}
+	req.Header.Set("Mattermost-User-ID", userID)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("execute request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("playbooks API returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return fmt.Errorf("decode response: %w", err)
+		}
+	}
+
+	return nil
+}
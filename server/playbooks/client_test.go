@@ -0,0 +1,113 @@
+package playbooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/a-voronkov/mattermost-bugsnag/server/interplugin"
+)
+
+// fakePlaybooks serves the subset of the Playbooks API the client uses and
+// records the requests.
+type fakePlaybooks struct {
+	runs     map[string]*Run
+	requests []string
+}
+
+func (f *fakePlaybooks) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Mattermost-User-ID") == "" {
+		http.Error(w, "not authorized", http.StatusUnauthorized)
+		return
+	}
+	f.requests = append(f.requests, r.Method+" "+r.URL.Path)
+
+	id, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/v0/runs/"), "/")
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/api/v0/runs":
+		var options CreateRunOptions
+		_ = json.NewDecoder(r.Body).Decode(&options)
+		run := &Run{ID: "run-1", Name: options.Name, TeamID: options.TeamID, PlaybookID: options.PlaybookID, ChannelID: options.ChannelID, OwnerUserID: options.OwnerUserID, CurrentStatus: StatusInProgress}
+		f.runs[run.ID] = run
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(run)
+	case f.runs[id] == nil:
+		http.NotFound(w, r)
+	case r.Method == http.MethodGet && action == "":
+		_ = json.NewEncoder(w).Encode(f.runs[id])
+	case r.Method == http.MethodPost && action == "status":
+		w.WriteHeader(http.StatusOK)
+	case r.Method == http.MethodPut && action == "finish":
+		f.runs[id].CurrentStatus = StatusFinished
+		w.WriteHeader(http.StatusOK)
+	default:
+		http.Error(w, "unexpected request", http.StatusBadRequest)
+	}
+}
+
+func TestClientRunLifecycle(t *testing.T) {
+	fake := &fakePlaybooks{runs: map[string]*Run{}}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	client := NewClient(server.URL, server.Client())
+	ctx := context.Background()
+
+	run, err := client.CreateRun(ctx, "user-1", CreateRunOptions{Name: "NoMethodError", OwnerUserID: "user-1", TeamID: "team-1", PlaybookID: "pb-1"})
+	if err != nil {
+		t.Fatalf("CreateRun() error = %v", err)
+	}
+	if run.ID != "run-1" || run.CurrentStatus != StatusInProgress || run.PlaybookID != "pb-1" {
+		t.Fatalf("unexpected run %+v", run)
+	}
+
+	if err := client.UpdateStatus(ctx, "user-1", run.ID, "Fixed in Bugsnag"); err != nil {
+		t.Fatalf("UpdateStatus() error = %v", err)
+	}
+	if err := client.FinishRun(ctx, "user-1", run.ID); err != nil {
+		t.Fatalf("FinishRun() error = %v", err)
+	}
+	run, err = client.GetRun(ctx, "user-1", run.ID)
+	if err != nil || run.CurrentStatus != StatusFinished {
+		t.Fatalf("GetRun() = %+v, %v", run, err)
+	}
+
+	if _, err := client.GetRun(ctx, "user-1", "missing"); err == nil || !strings.Contains(err.Error(), "status 404") {
+		t.Errorf("expected a 404 error, got %v", err)
+	}
+	if _, err := client.GetRun(ctx, "", run.ID); err == nil {
+		t.Errorf("expected requests without a user to be rejected")
+	}
+
+	want := []string{"POST /api/v0/runs", "POST /api/v0/runs/run-1/status", "PUT /api/v0/runs/run-1/finish", "GET /api/v0/runs/run-1", "GET /api/v0/runs/missing"}
+	if strings.Join(fake.requests, ",") != strings.Join(want, ",") {
+		t.Errorf("requests = %v, want %v", fake.requests, want)
+	}
+}
+
+func TestPluginClientRoutesToPlaybooks(t *testing.T) {
+	var path, userID string
+	client := NewPluginClient(interplugin.HTTPFunc(func(req *http.Request) *http.Response {
+		path = req.URL.Path
+		userID = req.Header.Get("Mattermost-User-ID")
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewBufferString(`{"id":"run-1","current_status":"InProgress"}`))}
+	}))
+
+	run, err := client.GetRun(context.Background(), "bot-1", "run-1")
+	if err != nil {
+		t.Fatalf("GetRun() error = %v", err)
+	}
+	if path != "/playbooks/api/v0/runs/run-1" || userID != "bot-1" || run.CurrentStatus != StatusInProgress {
+		t.Errorf("unexpected request to %q as %q: %+v", path, userID, run)
+	}
+
+	missing := NewPluginClient(interplugin.HTTPFunc(func(*http.Request) *http.Response { return nil }))
+	if _, err := missing.GetRun(context.Background(), "bot-1", "run-1"); err == nil || !strings.Contains(err.Error(), "installed") {
+		t.Errorf("expected a missing-plugin error, got %v", err)
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"crypto/subtle"
 	_ "embed"
 	"image"
 	"image/color"
@@ -13,7 +14,14 @@ import (
 	"time"
 
 	"github.com/a-voronkov/mattermost-bugsnag/server/api"
+	"github.com/a-voronkov/mattermost-bugsnag/server/bugsnag"
+	"github.com/a-voronkov/mattermost-bugsnag/server/connection"
+	"github.com/a-voronkov/mattermost-bugsnag/server/metrics"
+	"github.com/a-voronkov/mattermost-bugsnag/server/outbound"
+	"github.com/a-voronkov/mattermost-bugsnag/server/playbooks"
 	"github.com/a-voronkov/mattermost-bugsnag/server/scheduler"
+	"github.com/a-voronkov/mattermost-bugsnag/server/store"
+	"github.com/a-voronkov/mattermost-bugsnag/server/tracker"
 	"github.com/mattermost/mattermost/server/public/model"
 	"github.com/mattermost/mattermost/server/public/plugin"
 )
@@ -28,11 +36,19 @@ type Plugin struct {
 	plugin.MattermostPlugin
 
 	configuration atomic.Pointer[Configuration]
+	sealer        atomic.Pointer[store.Sealer]
+	clients       atomic.Pointer[bugsnag.Factory]
 	kvNamespace   string
 	syncMu        sync.Mutex
 	syncRunner    *scheduler.Runner
+	escalator     *scheduler.Escalator
+	dispatcher    atomic.Pointer[outbound.Dispatcher]
 	apiHandler    http.Handler
 	botUserID     string
+	// eventCounts caches the event counts incident policies look up.
+	eventCounts eventCounts
+	// playbooks overrides the Playbooks client, for tests.
+	playbooks *playbooks.Client
 }
 
 func main() {
@@ -41,6 +57,11 @@ func main() {
 
 // OnActivate initializes the plugin and starts background routines.
 func (p *Plugin) OnActivate() error {
+	// Scrub configured secrets from everything the plugin logs.
+	if _, ok := p.API.(*redactingAPI); !ok {
+		p.API = &redactingAPI{API: p.API, secrets: p.knownSecrets}
+	}
+
 	botUserID, err := p.ensureBot()
 	if err != nil {
 		p.API.LogError("failed to ensure bot", "err", err.Error())
@@ -49,6 +70,11 @@ func (p *Plugin) OnActivate() error {
 	p.botUserID = botUserID
 	p.API.LogInfo("Bugsnag bot ready", "bot_user_id", botUserID)
 
+	if err := p.API.RegisterCommand(newCommand()); err != nil {
+		p.API.LogError("failed to register command", "err", err.Error())
+		return err
+	}
+
 	return p.OnConfigurationChange()
 }
 
@@ -190,31 +216,72 @@ func (p *Plugin) OnConfigurationChange() error {
 		return err
 	}
 
-	if err := configuration.Validate(); err != nil {
+	if p.kvNamespace == "" {
+		p.kvNamespace = pluginID
+		p.API.LogDebug("kv namespace initialized", "namespace", p.kvNamespace)
+	}
+
+	// EncryptionKey wraps the keyring. A keyring still wrapped with the
+	// previous value is re-wrapped, so the setting can be regenerated while the
+	// plugin runs. The key also seeds a new keyring, which keeps personal
+	// tokens sealed with it before the keyring existed readable.
+	sealer, err := p.kvStore().LoadKeyring(configuration.EncryptionKey, p.keyWrap(configuration))
+	if err != nil {
+		p.API.LogError("failed to load encryption keyring", "err", err.Error())
+		return err
+	}
+	p.sealer.Store(sealer)
+
+	sealed := configuration
+	if configuration, err = sealed.openSecrets(sealer); err != nil {
 		p.API.LogWarn("invalid configuration", "err", err.Error())
 		return err
 	}
 
-	if p.kvNamespace == "" {
-		p.kvNamespace = pluginID
-		p.API.LogDebug("kv namespace initialized", "namespace", p.kvNamespace)
+	if err := configuration.Validate(); err != nil {
+		p.API.LogWarn("invalid configuration", "err", err.Error())
+		return err
 	}
 
+	// Validate has already checked the instance settings.
+	clients, _ := bugsnag.NewFactory(configuration.bugsnagSettings())
+	p.clients.Store(clients)
 	p.configuration.Store(&configuration)
-	p.API.LogInfo("configuration loaded", "org_id", configuration.OrganizationID, "sync_interval_sec", configuration.SyncIntervalSec)
+
+	// Seal plaintext secrets in the saved settings. Saving triggers this hook
+	// again, so it runs outside of it. A generated EncryptionKey is saved
+	// first; the settings are sealed when the hook runs for it.
+	if configuration.EncryptionKey == "" {
+		go func() {
+			if err := p.generateEncryptionKey(); err != nil {
+				p.API.LogWarn("failed to generate the encryption key, the master secrets stay unwrapped in the KV store", "err", err.Error())
+			}
+		}()
+	} else if sealed.needsSealing(sealer) {
+		go func() {
+			if _, err := p.sealStoredSettings(sealer); err != nil {
+				p.API.LogWarn("failed to encrypt plugin settings", "err", err.Error())
+			}
+		}()
+	}
+
+	p.API.LogInfo("configuration loaded", "org_id", configuration.OrganizationID, "connections", len(configuration.AllConnections()), "sync_interval_sec", configuration.SyncIntervalSec)
 	p.restartSyncRoutine(configuration)
+	p.restartOutbound(configuration)
 	return nil
 }
 
 // OnDeactivate stops background work when the plugin is disabled.
 func (p *Plugin) OnDeactivate() error {
 	p.stopSyncRoutine()
+	p.stopOutbound()
 	return nil
 }
 
 // Close stops background work when the server is shutting down.
 func (p *Plugin) Close() {
 	p.stopSyncRoutine()
+	p.stopOutbound()
 }
 
 func (p *Plugin) restartSyncRoutine(cfg Configuration) {
@@ -223,14 +290,20 @@ func (p *Plugin) restartSyncRoutine(cfg Configuration) {
 
 	p.stopSyncRoutineLocked()
 
+	// Escalations run on their own schedule, even with the status sync off.
+	p.escalator = scheduler.NewEscalator(p.API, cfg.EnableDebugLog, p.kvNS(), p.botUserID)
+	p.escalator.Start(scheduler.EscalationInterval)
+
 	interval := time.Duration(cfg.SyncIntervalSec) * time.Second
 	if interval <= 0 {
 		return
 	}
 
-	p.syncRunner = scheduler.NewRunner(p.API, cfg.EnableDebugLog, func() string {
-		return p.getConfiguration().BugsnagAPIToken
+	p.syncRunner = scheduler.NewRunner(p.API, cfg.EnableDebugLog, p.bugsnagClients(), func(connectionID string) string {
+		conn, _ := connection.Find(p.getConfiguration().AllConnections(), connectionID)
+		return conn.APIToken
 	}, p.kvNS())
+	p.syncRunner.SetStatusChangeHook(p.syncedStatusChange)
 	p.syncRunner.Start(interval)
 }
 
@@ -245,6 +318,10 @@ func (p *Plugin) stopSyncRoutineLocked() {
 		p.syncRunner.Stop()
 		p.syncRunner = nil
 	}
+	if p.escalator != nil {
+		p.escalator.Stop()
+		p.escalator = nil
+	}
 }
 
 // ServeHTTP routes external HTTP requests to the appropriate handler.
@@ -256,7 +333,17 @@ func (p *Plugin) ServeHTTP(_ *plugin.Context, w http.ResponseWriter, r *http.Req
 	case "/actions":
 		p.handleActions(w, r)
 		return
+	case ticketDialogPath:
+		p.handleTicketDialog(w, r)
+		return
+	case "/metrics":
+		p.handleMetrics(w, r)
+		return
 	default:
+		if strings.HasPrefix(r.URL.Path, "/webhook/") {
+			p.handleWebhook(w, r)
+			return
+		}
 		if strings.HasPrefix(r.URL.Path, "/api/") {
 			p.getAPIHandler().ServeHTTP(w, r)
 			return
@@ -265,6 +352,25 @@ func (p *Plugin) ServeHTTP(_ *plugin.Context, w http.ResponseWriter, r *http.Req
 	}
 }
 
+// handleMetrics serves the Prometheus metrics to scrapers that send the
+// configured metrics token. The endpoint is off until a token is set.
+func (p *Plugin) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	token := p.getConfiguration().MetricsToken
+	if token == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	provided, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(strings.TrimSpace(provided)), []byte(token)) != 1 {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="bugsnag-metrics"`)
+		http.Error(w, "invalid metrics token", http.StatusUnauthorized)
+		return
+	}
+
+	metrics.Default.ServeHTTP(w, r)
+}
+
 // getConfiguration returns the active configuration or a zero-value configuration
 // when nothing has been loaded yet.
 func (p *Plugin) getConfiguration() Configuration {
@@ -274,6 +380,32 @@ func (p *Plugin) getConfiguration() Configuration {
 	return Configuration{}
 }
 
+// playbooksClient returns the client for the Playbooks plugin.
+func (p *Plugin) playbooksClient() *playbooks.Client {
+	if p.playbooks != nil {
+		return p.playbooks
+	}
+	return playbooks.NewPluginClient(p.API)
+}
+
+// bugsnagClients returns the factory for Bugsnag API clients, falling back to
+// bugsnag.com before the configuration has been loaded.
+func (p *Plugin) bugsnagClients() *bugsnag.Factory {
+	if clients := p.clients.Load(); clients != nil {
+		return clients
+	}
+	return bugsnag.DefaultFactory()
+}
+
+// bugsnagClient returns the shared client for a connection token, or a fresh
+// one for a personal token so users' tokens are not kept after they unlink.
+func (p *Plugin) bugsnagClient(token string, personal bool) (*bugsnag.Client, error) {
+	if personal {
+		return p.bugsnagClients().NewClient(token)
+	}
+	return p.bugsnagClients().Client(token)
+}
+
 func (p *Plugin) kvNS() string {
 	if p.kvNamespace == "" {
 		return pluginID
@@ -296,15 +428,29 @@ func (p *Plugin) logError(msg string, keyValuePairs ...any) {
 func (p *Plugin) getAPIHandler() http.Handler {
 	if p.apiHandler == nil {
 		p.apiHandler = api.NewRouter(api.Config{
-			TokenProvider: func() string {
-				cfg := p.getConfiguration()
-				return cfg.BugsnagAPIToken
-			},
-			OrgIDProvider: func() string {
-				cfg := p.getConfiguration()
-				return cfg.OrganizationID
+			Connections: func() []connection.Connection {
+				return p.getConfiguration().AllConnections()
 			},
 			KVStore: &pluginKVAdapter{api: p.API, namespace: p.kvNS()},
+			Clients: p.bugsnagClients,
+			ChannelExists: func(channelID string) bool {
+				channel, appErr := p.API.GetChannel(channelID)
+				return appErr == nil && channel.DeleteAt == 0
+			},
+			UserActive: func(userID string) bool {
+				user, appErr := p.API.GetUser(userID)
+				return appErr == nil && user.DeleteAt == 0
+			},
+			ReplayDelivery:      p.replayDelivery,
+			Backfill:            p.backfillRule,
+			RotateEncryptionKey: p.rotateEncryptionKey,
+			Secrets:             p.knownSecrets,
+			IssueTrackers: func() []tracker.Tracker {
+				return p.getConfiguration().issueTrackers()
+			},
+			IsSystemAdmin: func(userID string) bool {
+				return p.API.HasPermissionTo(userID, model.PermissionManageSystem)
+			},
 		})
 	}
 
@@ -332,3 +478,40 @@ func (a *pluginKVAdapter) Set(key string, value []byte) error {
 	}
 	return nil
 }
+
+// kvListPageSize is the page size used when enumerating plugin KV keys.
+const kvListPageSize = 200
+
+// ListKeys returns the keys starting with prefix, without the namespace.
+func (a *pluginKVAdapter) ListKeys(prefix string) ([]string, error) {
+	namespaced := a.namespace + ":" + prefix
+
+	var keys []string
+	for page := 0; ; page++ {
+		batch, appErr := a.api.KVList(page, kvListPageSize)
+		if appErr != nil {
+			return nil, appErr
+		}
+		for _, key := range batch {
+			if strings.HasPrefix(key, namespaced) {
+				keys = append(keys, strings.TrimPrefix(key, a.namespace+":"))
+			}
+		}
+		if len(batch) < kvListPageSize {
+			return keys, nil
+		}
+	}
+}
+
+// CompareAndSet writes value only if key still holds old; a nil old value
+// means the key must be unset.
+func (a *pluginKVAdapter) CompareAndSet(key string, old, value []byte) (bool, error) {
+	ok, appErr := a.api.KVSetWithOptions(a.namespace+":"+key, value, model.PluginKVSetOptions{
+		Atomic:   true,
+		OldValue: old,
+	})
+	if appErr != nil {
+		return false, appErr
+	}
+	return ok, nil
+}
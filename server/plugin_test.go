@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandleMetricsRequiresToken(t *testing.T) {
+	p := &Plugin{}
+
+	tests := []struct {
+		name   string
+		token  string
+		header string
+		want   int
+	}{
+		{name: "disabled", header: "Bearer anything", want: http.StatusNotFound},
+		{name: "missing token", token: "scrape-token", want: http.StatusUnauthorized},
+		{name: "wrong token", token: "scrape-token", header: "Bearer other", want: http.StatusUnauthorized},
+		{name: "valid token", token: "scrape-token", header: "Bearer scrape-token", want: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p.configuration.Store(&Configuration{MetricsToken: tt.token})
+			req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			rr := httptest.NewRecorder()
+			p.handleMetrics(rr, req)
+			if rr.Code != tt.want {
+				t.Fatalf("expected status %d, got %d", tt.want, rr.Code)
+			}
+			if tt.want == http.StatusOK && !strings.Contains(rr.Body.String(), "bugsnag_") {
+				t.Error("expected the plugin metrics")
+			}
+		})
+	}
+}
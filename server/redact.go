@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/a-voronkov/mattermost-bugsnag/server/store"
+	"github.com/mattermost/mattermost/server/public/plugin"
+)
+
+// redactingAPI wraps plugin.API so configured secrets never reach the server
+// log, whichever package does the logging.
+type redactingAPI struct {
+	plugin.API
+	secrets func() []string
+}
+
+func (a *redactingAPI) LogDebug(msg string, keyValuePairs ...any) {
+	msg, keyValuePairs = a.redact(msg, keyValuePairs)
+	a.API.LogDebug(msg, keyValuePairs...)
+}
+
+func (a *redactingAPI) LogInfo(msg string, keyValuePairs ...any) {
+	msg, keyValuePairs = a.redact(msg, keyValuePairs)
+	a.API.LogInfo(msg, keyValuePairs...)
+}
+
+func (a *redactingAPI) LogWarn(msg string, keyValuePairs ...any) {
+	msg, keyValuePairs = a.redact(msg, keyValuePairs)
+	a.API.LogWarn(msg, keyValuePairs...)
+}
+
+func (a *redactingAPI) LogError(msg string, keyValuePairs ...any) {
+	msg, keyValuePairs = a.redact(msg, keyValuePairs)
+	a.API.LogError(msg, keyValuePairs...)
+}
+
+// redact scrubs secrets from the message and from string, error and Stringer
+// values. Other values cannot carry a token and are passed through.
+func (a *redactingAPI) redact(msg string, keyValuePairs []any) (string, []any) {
+	secrets := a.secrets()
+
+	redacted := make([]any, len(keyValuePairs))
+	for i, value := range keyValuePairs {
+		switch v := value.(type) {
+		case string:
+			redacted[i] = store.RedactSecrets(v, secrets)
+		case error:
+			redacted[i] = store.RedactSecrets(v.Error(), secrets)
+		case fmt.Stringer:
+			redacted[i] = store.RedactSecrets(v.String(), secrets)
+		default:
+			redacted[i] = value
+		}
+	}
+
+	return store.RedactSecrets(msg, secrets), redacted
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/a-voronkov/mattermost-bugsnag/server/formatter"
+	"github.com/a-voronkov/mattermost-bugsnag/server/store"
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// isRegression reports whether an update brings back an error that had been
+// resolved: Bugsnag reopened it, or the card showed it as fixed and the
+// payload reports it open again.
+func isRegression(trigger triggerInfo, previous *formatter.ErrorData, data formatter.ErrorData) bool {
+	if trigger.triggerType() == TriggerReopened {
+		return true
+	}
+	return previous != nil && previous.Status == "fixed" && data.Status == "open"
+}
+
+// regressionMessage describes the regression, flagging the app version it came
+// back in and mentioning whoever resolved it last.
+func regressionMessage(data formatter.ErrorData, resolverUsername string) string {
+	msg := "🔁 **Regression**: this error came back"
+	if version := strings.TrimSpace(data.AppVersion); version != "" {
+		msg += fmt.Sprintf(" in version `%s`", version)
+	}
+	if resolverUsername != "" {
+		msg += fmt.Sprintf(" after being resolved by @%s", resolverUsername)
+	}
+	return msg + "."
+}
+
+// resurfaces reports whether the rule makes regressions visible again. An
+// unknown mode, saved before modes were validated, is logged and treated as
+// empty, so the card is updated in place instead of failing the webhook.
+func (p *Plugin) resurfaces(rule ChannelRule) bool {
+	switch rule.Resurface {
+	case "":
+		return false
+	case resurfaceNewCard, resurfaceBroadcast:
+		return true
+	default:
+		p.API.LogWarn("channel rule has an unknown resurface mode, updating the card in place", "rule_id", rule.ID, "resurface", rule.Resurface)
+		return false
+	}
+}
+
+// resurfaceError makes a regressed error visible again according to the rule's
+// Resurface mode and returns the mapping to store for future updates.
+func (p *Plugin) resurfaceError(mm *MMClient, rule ChannelRule, mapping ErrorPostMapping, payload webhookPayload, data formatter.ErrorData, tmpl *formatter.CardTemplate, cfg Configuration) (ErrorPostMapping, error) {
+	resolver := ""
+	if mapping.ResolvedBy != "" {
+		if user, appErr := mm.GetUser(mapping.ResolvedBy); appErr == nil {
+			resolver = user.Username
+		}
+	}
+	message := regressionMessage(data, resolver)
+	originalLink := mm.Permalink(mapping.PostID)
+
+	switch rule.Resurface {
+	case resurfaceNewCard:
+		card := &model.Post{ChannelId: mapping.ChannelID}
+		if err := formatter.ApplyTemplatedCard(card, data, formatter.ErrorPostMapping{
+			ChannelID: mapping.ChannelID,
+			ProjectID: mapping.ProjectID,
+			ErrorID:   mapping.ErrorID,
+		}, tmpl); err != nil {
+			p.API.LogWarn("card template failed, using built-in layout", "err", err.Error())
+		}
+		post, appErr := mm.CreateCardPost(card)
+		if appErr != nil {
+			return mapping, fmt.Errorf("create regression card: %w", appErr)
+		}
+
+		reply := message
+		if originalLink != "" {
+			reply += fmt.Sprintf(" [Original thread](%s)", originalLink)
+		}
+		if _, appErr := mm.CreateReply(mapping.ChannelID, post.Id, reply); appErr != nil {
+			mm.LogDebug("failed to add regression reply", "err", appErr.Error())
+		}
+
+		note := "🔁 This error regressed and was posted as a new card."
+		if newLink := mm.Permalink(post.Id); newLink != "" {
+			note = fmt.Sprintf("🔁 This error regressed and was posted as a [new card](%s).", newLink)
+		}
+		if _, appErr := mm.CreateReply(mapping.ChannelID, mapping.PostID, note); appErr != nil {
+			mm.LogDebug("failed to link regression card from original thread", "err", appErr.Error())
+		}
+
+		p.postStacktraceReply(mm, mapping.ChannelID, post.Id, payload, cfg)
+
+		mapping.PostID = post.Id
+		p.registerActiveError(mm, mapping)
+	case resurfaceBroadcast:
+		if _, appErr := mm.CreateReply(mapping.ChannelID, mapping.PostID, message); appErr != nil {
+			return mapping, fmt.Errorf("reply with regression: %w", appErr)
+		}
+		broadcast := message
+		if originalLink != "" {
+			broadcast += fmt.Sprintf(" [View thread](%s)", originalLink)
+		}
+		if _, appErr := mm.CreatePost(mapping.ChannelID, broadcast, nil); appErr != nil {
+			return mapping, fmt.Errorf("broadcast regression: %w", appErr)
+		}
+	default:
+		return mapping, fmt.Errorf("unknown resurface mode %q", rule.Resurface)
+	}
+
+	mapping.ResolvedBy = ""
+	return mapping, nil
+}
+
+// registerActiveError tracks the card for the periodic status sync.
+func (p *Plugin) registerActiveError(mm *MMClient, mapping ErrorPostMapping) {
+	kvStore := &pluginKVAdapter{api: p.API, namespace: p.kvNS()}
+	s := store.New(kvStore)
+	activeErr := store.ActiveError{
+		ConnectionID: mapping.ConnectionID,
+		ErrorID:      mapping.ErrorID,
+		ProjectID:    mapping.ProjectID,
+		PostID:       mapping.PostID,
+		ChannelID:    mapping.ChannelID,
+		LastSyncedAt: time.Now().UTC(),
+	}
+	if err := s.UpsertActiveError(activeErr); err != nil {
+		mm.LogDebug("failed to register active error for sync", "err", err.Error())
+	}
+}
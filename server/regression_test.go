@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/a-voronkov/mattermost-bugsnag/server/formatter"
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestIsRegression(t *testing.T) {
+	fixed := &formatter.ErrorData{Status: "fixed"}
+
+	tests := []struct {
+		name     string
+		trigger  triggerInfo
+		previous *formatter.ErrorData
+		status   string
+		want     bool
+	}{
+		{"reopened trigger", triggerInfo{Type: string(TriggerReopened)}, nil, "open", true},
+		{"fixed card reported open", triggerInfo{Type: string(TriggerException)}, fixed, "open", true},
+		{"open card stays open", triggerInfo{Type: string(TriggerException)}, &formatter.ErrorData{Status: "open"}, "open", false},
+		{"fixed card stays fixed", triggerInfo{Type: string(TriggerComment)}, fixed, "fixed", false},
+		{"no previous card", triggerInfo{Type: string(TriggerException)}, nil, "open", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isRegression(tt.trigger, tt.previous, formatter.ErrorData{Status: tt.status}); got != tt.want {
+				t.Errorf("isRegression() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRegressionMessage(t *testing.T) {
+	tests := []struct {
+		version  string
+		resolver string
+		want     string
+	}{
+		{"2.4.2", "alice", "🔁 **Regression**: this error came back in version `2.4.2` after being resolved by @alice."},
+		{"", "alice", "🔁 **Regression**: this error came back after being resolved by @alice."},
+		{"2.4.2", "", "🔁 **Regression**: this error came back in version `2.4.2`."},
+	}
+
+	for _, tt := range tests {
+		if got := regressionMessage(formatter.ErrorData{AppVersion: tt.version}, tt.resolver); got != tt.want {
+			t.Errorf("regressionMessage(%q, %q) = %q, want %q", tt.version, tt.resolver, got, tt.want)
+		}
+	}
+}
+
+// regressionAPI mocks an existing, resolved card for err-1 that regresses.
+func regressionAPI(t *testing.T) *plugintest.API {
+	t.Helper()
+
+	siteURL := "https://mm.example.com"
+	oldCard := formatter.BuildErrorPost(formatter.ErrorData{ID: "err-1", ProjectID: "proj-1", ExceptionClass: "Crash", Status: "fixed"},
+		formatter.ErrorPostMapping{ChannelID: "channel-1", ProjectID: "proj-1", ErrorID: "err-1"})
+	oldCard.Id = "old-post"
+	oldCard.ChannelId = "channel-1"
+	mapping, _ := json.Marshal(ErrorPostMapping{ProjectID: "proj-1", ErrorID: "err-1", ChannelID: "channel-1", PostID: "old-post", ResolvedBy: "user-1"})
+
+	api := &plugintest.API{}
+	api.On("KVGet", pluginID+":"+KVKeyErrorPostPrefix+"proj-1:err-1").Return(mapping, nil)
+	api.On("KVGet", pluginID+":"+KVKeyUserMappings).Return(nil, nil)
+	api.On("KVGet", pluginID+":"+KVKeyCardTemplates).Return(nil, nil)
+	api.On("GetPost", "old-post").Return(oldCard, nil)
+	api.On("UpdatePost", mock.AnythingOfType("*model.Post")).Return(&model.Post{Id: "old-post"}, nil)
+	api.On("GetUser", "user-1").Return(&model.User{Id: "user-1", Username: "alice"}, nil)
+	api.On("GetConfig").Return(&model.Config{ServiceSettings: model.ServiceSettings{SiteURL: &siteURL}})
+	return api
+}
+
+func regressionPayload() webhookPayload {
+	return webhookPayload{
+		Trigger: triggerInfo{Type: string(TriggerReopened), Message: "Error reopened"},
+		Error:   &errorInfo{ErrorID: "err-1", ExceptionClass: "Crash", Status: "open", App: &appInfo{Version: "2.4.2"}},
+		Project: &projectInfo{ID: "proj-1"},
+	}
+}
+
+func TestUpsertErrorCardResurfacesAsNewCard(t *testing.T) {
+	api := regressionAPI(t)
+	api.On("CreatePost", mock.MatchedBy(func(post *model.Post) bool {
+		return post.RootId == "" && post.ChannelId == "channel-1"
+	})).Return(&model.Post{Id: "new-post", ChannelId: "channel-1"}, nil).Once()
+	api.On("CreatePost", mock.MatchedBy(func(post *model.Post) bool {
+		return post.RootId == "new-post" &&
+			strings.Contains(post.Message, "version `2.4.2` after being resolved by @alice") &&
+			strings.Contains(post.Message, "(https://mm.example.com/_redirect/pl/old-post)")
+	})).Return(&model.Post{Id: "reply-1"}, nil).Once()
+	api.On("CreatePost", mock.MatchedBy(func(post *model.Post) bool {
+		return post.RootId == "old-post" && strings.Contains(post.Message, "(https://mm.example.com/_redirect/pl/new-post)")
+	})).Return(&model.Post{Id: "reply-2"}, nil).Once()
+	api.On("KVGet", pluginID+":"+KVKeyActiveErrors).Return(nil, nil)
+	api.On("KVSet", pluginID+":"+KVKeyActiveErrors, mock.Anything).Return(nil)
+	api.On("KVSet", pluginID+":"+KVKeyErrorPostPrefix+"proj-1:err-1", mock.MatchedBy(func(data []byte) bool {
+		var stored ErrorPostMapping
+		_ = json.Unmarshal(data, &stored)
+		return stored.PostID == "new-post" && stored.ResolvedBy == ""
+	})).Return(nil).Once()
+
+	p := &Plugin{}
+	p.SetAPI(api)
+	p.kvNamespace = pluginID
+
+	rule := ChannelRule{ChannelID: "channel-1", Resurface: resurfaceNewCard}
+	if err := p.upsertErrorCard(newMMClient(api, false, pluginID, ""), rule, regressionPayload(), Configuration{}); err != nil {
+		t.Fatalf("upsertErrorCard() error = %v", err)
+	}
+
+	api.AssertExpectations(t)
+}
+
+func TestUpsertErrorCardResurfacesWithBroadcast(t *testing.T) {
+	api := regressionAPI(t)
+	api.On("CreatePost", mock.MatchedBy(func(post *model.Post) bool {
+		return post.RootId == "old-post" && strings.Contains(post.Message, "@alice")
+	})).Return(&model.Post{Id: "reply-1"}, nil).Once()
+	api.On("CreatePost", mock.MatchedBy(func(post *model.Post) bool {
+		return post.RootId == "" && strings.Contains(post.Message, "@alice") &&
+			strings.Contains(post.Message, "[View thread](https://mm.example.com/_redirect/pl/old-post)")
+	})).Return(&model.Post{Id: "broadcast"}, nil).Once()
+	api.On("KVSet", pluginID+":"+KVKeyErrorPostPrefix+"proj-1:err-1", mock.MatchedBy(func(data []byte) bool {
+		var stored ErrorPostMapping
+		_ = json.Unmarshal(data, &stored)
+		return stored.PostID == "old-post" && stored.ResolvedBy == ""
+	})).Return(nil).Once()
+
+	p := &Plugin{}
+	p.SetAPI(api)
+	p.kvNamespace = pluginID
+
+	rule := ChannelRule{ChannelID: "channel-1", Resurface: resurfaceBroadcast}
+	if err := p.upsertErrorCard(newMMClient(api, false, pluginID, ""), rule, regressionPayload(), Configuration{}); err != nil {
+		t.Fatalf("upsertErrorCard() error = %v", err)
+	}
+
+	api.AssertExpectations(t)
+}
+
+func TestUpsertErrorCardIgnoresUnknownResurfaceMode(t *testing.T) {
+	api := regressionAPI(t)
+	api.On("LogWarn", "channel rule has an unknown resurface mode, updating the card in place", "rule_id", "r1", "resurface", "newcard").Return().Once()
+	api.On("CreatePost", mock.MatchedBy(func(post *model.Post) bool {
+		return post.RootId == "old-post" && strings.Contains(post.Message, "Reopened")
+	})).Return(&model.Post{Id: "reply-1"}, nil).Once()
+
+	p := &Plugin{}
+	p.SetAPI(api)
+	p.kvNamespace = pluginID
+
+	rule := ChannelRule{ID: "r1", ChannelID: "channel-1", Resurface: "newcard"}
+	if err := p.upsertErrorCard(newMMClient(api, false, pluginID, ""), rule, regressionPayload(), Configuration{}); err != nil {
+		t.Fatalf("upsertErrorCard() error = %v", err)
+	}
+
+	api.AssertNumberOfCalls(t, "LogWarn", 1)
+	api.AssertNumberOfCalls(t, "CreatePost", 1)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/a-voronkov/mattermost-bugsnag/server/formatter"
+)
+
+// ReleasePostMapping stores where a release was announced in a channel so that
+// errors first seen in that release can be threaded under it.
+type ReleasePostMapping struct {
+	ProjectID    string `json:"project_id"`
+	Version      string `json:"version"`
+	ReleaseStage string `json:"release_stage,omitempty"`
+	ChannelID    string `json:"channel_id"`
+	PostID       string `json:"post_id"`
+}
+
+func releasePostKVKey(projectID, channelID, version string) string {
+	return fmt.Sprintf("%s%s:%s:%s", KVKeyReleasePostPrefix, projectID, channelID, version)
+}
+
+// isReleaseOnly reports whether the delivery announces a release rather than
+// an error.
+func (p webhookPayload) isReleaseOnly() bool {
+	return p.Error == nil && p.Release != nil
+}
+
+// matchesReleaseRule reports whether a rule subscribed to release notifications
+// for the release's stage. Rules without an events filter only receive error
+// cards.
+func matchesReleaseRule(rule ChannelRule, payload webhookPayload) bool {
+	if payload.Release == nil || !containsValue(rule.Events, string(TriggerRelease)) {
+		return false
+	}
+	if len(rule.Environments) > 0 && !containsValue(rule.Environments, payload.Release.ReleaseStage) {
+		return false
+	}
+	return true
+}
+
+// errReleaseAlreadyPosted is returned when the release was already announced in
+// the channel, so the delivery is a duplicate.
+var errReleaseAlreadyPosted = errors.New("release already announced")
+
+// upsertReleaseCard posts the release card once per channel and version.
+func (p *Plugin) upsertReleaseCard(mm *MMClient, channelID string, payload webhookPayload) error {
+	if strings.TrimSpace(channelID) == "" {
+		return fmt.Errorf("channelID is required")
+	}
+
+	release := payload.Release
+	if strings.TrimSpace(release.Version) == "" {
+		return fmt.Errorf("release version is required")
+	}
+	projectID := payload.getProjectID()
+	key := releasePostKVKey(projectID, channelID, release.Version)
+
+	var mapping ReleasePostMapping
+	found, appErr := mm.LoadJSON(key, &mapping)
+	if appErr != nil {
+		return fmt.Errorf("load release mapping: %w", appErr)
+	}
+	if found {
+		mm.LogDebug("release already announced", "project_id", projectID, "version", release.Version, "post_id", mapping.PostID)
+		return errReleaseAlreadyPosted
+	}
+
+	revision := ""
+	if release.SourceControl != nil {
+		revision = release.SourceControl.Revision
+	}
+
+	post, appErr := mm.CreateCardPost(formatter.BuildReleasePost(formatter.ReleaseData{
+		ProjectID:    projectID,
+		ProjectName:  payload.getProjectName(),
+		Version:      release.Version,
+		ReleaseStage: release.ReleaseStage,
+		Revision:     revision,
+		ReleaseURL:   release.URL,
+	}, channelID))
+	if appErr != nil {
+		return fmt.Errorf("create release post: %w", appErr)
+	}
+
+	mapping = ReleasePostMapping{
+		ProjectID:    projectID,
+		Version:      release.Version,
+		ReleaseStage: release.ReleaseStage,
+		ChannelID:    channelID,
+		PostID:       post.Id,
+	}
+	if appErr := mm.StoreJSON(key, mapping); appErr != nil {
+		mm.LogDebug("failed to store release→post mapping", "err", appErr.Error())
+	}
+
+	return nil
+}
+
+// threadUnderRelease links a newly created error card from the thread of the
+// release it was seen in, when that release was announced in the channel.
+// Only errors first seen in this event are new in the release; an older error
+// that gets a new card was not introduced by it.
+func (p *Plugin) threadUnderRelease(mm *MMClient, channelID, cardPostID string, payload webhookPayload, data formatter.ErrorData) {
+	if payload.Trigger.triggerType() != TriggerFirstException {
+		return
+	}
+	version := payload.getAppVersion()
+	if version == "" {
+		return
+	}
+
+	var mapping ReleasePostMapping
+	found, appErr := mm.LoadJSON(releasePostKVKey(payload.getProjectID(), channelID, version), &mapping)
+	if appErr != nil {
+		mm.LogDebug("failed to load release mapping", "err", appErr.Error())
+		return
+	}
+	if !found {
+		return
+	}
+
+	note := formatter.BuildReleaseErrorNote(data, mm.Permalink(cardPostID))
+	if _, appErr := mm.CreateReply(mapping.ChannelID, mapping.PostID, note); appErr != nil {
+		mm.LogDebug("failed to thread error under release", "err", appErr.Error())
+	}
+}
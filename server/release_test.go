@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/a-voronkov/mattermost-bugsnag/server/formatter"
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestHandleWebhookPostsReleaseCard(t *testing.T) {
+	rules, _ := json.Marshal([]ChannelRule{
+		{ID: "r1", ProjectID: "proj-1", ChannelID: "releases", Events: []string{string(TriggerRelease)}, Environments: []string{"production"}},
+		{ID: "r2", ProjectID: "proj-1", ChannelID: "errors-only"},
+		{ID: "r3", ProjectID: "proj-1", ChannelID: "staging", Events: []string{string(TriggerRelease)}, Environments: []string{"staging"}},
+	})
+	releaseKey := pluginID + ":" + releasePostKVKey("proj-1", "releases", "2.4.1")
+
+	api := &plugintest.API{}
+	api.On("LogInfo", "received webhook", "remote", mock.Anything).Return()
+	api.On("KVGet", pluginID+":"+KVKeyProjectChannelMappings).Return(rules, nil)
+	api.On("KVGet", releaseKey).Return(nil, nil)
+	api.On("CreatePost", mock.MatchedBy(func(post *model.Post) bool {
+		return post.ChannelId == "releases" && post.Message == ":rocket: Released **2.4.1** to **production**"
+	})).Return(&model.Post{Id: "release-post", ChannelId: "releases"}, nil).Once()
+	api.On("KVSet", releaseKey, mock.Anything).Return(nil).Once()
+	api.On("KVGet", pluginID+":"+KVKeyHealth).Return(nil, nil)
+	api.On("KVSet", pluginID+":"+KVKeyHealth, mock.Anything).Return(nil)
+	api.On("LogDebug", mock.Anything, mock.Anything, mock.Anything).Return().Maybe()
+
+	p := &Plugin{}
+	p.SetAPI(api)
+	p.kvNamespace = pluginID
+	p.configuration.Store(&Configuration{})
+
+	payload := webhookPayload{
+		Trigger: triggerInfo{Type: "release"},
+		Project: &projectInfo{ID: "proj-1", Name: "backend-api"},
+		Release: &releaseInfo{Version: "2.4.1", ReleaseStage: "production"},
+	}
+	body, _ := json.Marshal(payload)
+
+	req := httptest.NewRequest(http.MethodPost, "/webhook", bytes.NewReader(body))
+	rr := httptest.NewRecorder()
+
+	p.handleWebhook(rr, req)
+
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusAccepted, rr.Code, rr.Body.String())
+	}
+	if !strings.Contains(rr.Body.String(), `"processed":1`) {
+		t.Errorf("expected one processed rule, got %s", rr.Body.String())
+	}
+	api.AssertExpectations(t)
+}
+
+func TestUpsertReleaseCardSkipsAnnouncedRelease(t *testing.T) {
+	existing, _ := json.Marshal(ReleasePostMapping{ProjectID: "proj-1", Version: "2.4.1", ChannelID: "releases", PostID: "release-post"})
+
+	api := &plugintest.API{}
+	api.On("KVGet", pluginID+":"+releasePostKVKey("proj-1", "releases", "2.4.1")).Return(existing, nil)
+	api.On("LogDebug", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return().Maybe()
+
+	p := &Plugin{}
+	p.SetAPI(api)
+
+	payload := webhookPayload{
+		Project: &projectInfo{ID: "proj-1"},
+		Release: &releaseInfo{Version: "2.4.1", ReleaseStage: "production"},
+	}
+	if err := p.upsertReleaseCard(newMMClient(api, false, pluginID, ""), "releases", payload); !errors.Is(err, errReleaseAlreadyPosted) {
+		t.Fatalf("upsertReleaseCard() error = %v, want errReleaseAlreadyPosted", err)
+	}
+
+	api.AssertNotCalled(t, "CreatePost", mock.Anything)
+}
+
+func TestThreadUnderRelease(t *testing.T) {
+	existing, _ := json.Marshal(ReleasePostMapping{ProjectID: "proj-1", Version: "2.4.1", ChannelID: "releases", PostID: "release-post"})
+	siteURL := "https://mm.example.com/"
+
+	api := &plugintest.API{}
+	api.On("KVGet", pluginID+":"+releasePostKVKey("proj-1", "releases", "2.4.1")).Return(existing, nil)
+	api.On("GetConfig").Return(&model.Config{ServiceSettings: model.ServiceSettings{SiteURL: &siteURL}})
+	api.On("CreatePost", mock.MatchedBy(func(post *model.Post) bool {
+		return post.RootId == "release-post" && post.ChannelId == "releases" &&
+			strings.Contains(post.Message, "(https://mm.example.com/_redirect/pl/card-post)")
+	})).Return(&model.Post{Id: "reply"}, nil).Once()
+
+	p := &Plugin{}
+	p.SetAPI(api)
+
+	payload := webhookPayload{
+		Trigger: triggerInfo{Type: "firstException"},
+		Error:   &errorInfo{ErrorID: "err-1", ExceptionClass: "Crash", App: &appInfo{Version: "2.4.1"}},
+		Project: &projectInfo{ID: "proj-1"},
+	}
+	p.threadUnderRelease(newMMClient(api, false, pluginID, ""), "releases", "card-post", payload, formatter.ErrorData{ExceptionClass: "Crash"})
+
+	api.AssertExpectations(t)
+}
+
+func TestThreadUnderReleaseSkipsOlderErrors(t *testing.T) {
+	api := &plugintest.API{}
+	p := &Plugin{}
+	p.SetAPI(api)
+
+	// The error was first seen before 2.4.1; it only gets a new card.
+	payload := webhookPayload{
+		Trigger: triggerInfo{Type: "exception"},
+		Error:   &errorInfo{ErrorID: "err-1", ExceptionClass: "Crash", App: &appInfo{Version: "2.4.1"}},
+		Project: &projectInfo{ID: "proj-1"},
+	}
+	p.threadUnderRelease(newMMClient(api, false, pluginID, ""), "releases", "card-post", payload, formatter.ErrorData{ExceptionClass: "Crash"})
+
+	api.AssertNotCalled(t, "KVGet", mock.Anything)
+	api.AssertNotCalled(t, "CreatePost", mock.Anything)
+}
@@ -0,0 +1,245 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/a-voronkov/mattermost-bugsnag/server/formatter"
+	"github.com/a-voronkov/mattermost-bugsnag/server/store"
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin"
+	"github.com/mattermost/mattermost/server/public/pluginapi/cluster"
+)
+
+// AuditActionEscalate is the audit action recorded when an escalation level
+// is notified.
+const AuditActionEscalate = "escalate"
+
+// EscalationInterval is how often the escalator looks for due levels, and so
+// how late a level can be notified.
+const EscalationInterval = time.Minute
+
+// escalationMutexKey names the cluster mutex that lets a single server of a
+// cluster escalate at a time, so every level is notified once.
+const escalationMutexKey = "bugsnag-escalator"
+
+// Escalator notifies the contacts of escalation levels about cards nobody
+// acknowledged in time. A card stops escalating when its escalation is removed
+// from the store, or once its card is no longer open or has a new assignee.
+type Escalator struct {
+	api       plugin.API
+	debug     bool
+	namespace string
+	botUserID string
+	now       func() time.Time
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+// NewEscalator builds an escalator that posts as the bot user.
+func NewEscalator(api plugin.API, debug bool, namespace, botUserID string) *Escalator {
+	return &Escalator{
+		api:       api,
+		debug:     debug,
+		namespace: namespace,
+		botUserID: botUserID,
+		now:       time.Now,
+	}
+}
+
+// Start launches the ticker loop.
+func (e *Escalator) Start(interval time.Duration) {
+	e.stop = make(chan struct{})
+	e.done = make(chan struct{})
+
+	go e.run(interval)
+}
+
+// Stop halts the ticker loop.
+func (e *Escalator) Stop() {
+	if e.stop == nil {
+		return
+	}
+
+	close(e.stop)
+	<-e.done
+	e.stop = nil
+	e.done = nil
+}
+
+func (e *Escalator) run(interval time.Duration) {
+	defer close(e.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-e.stop:
+			return
+		case <-ticker.C:
+			e.tick()
+		}
+	}
+}
+
+func (e *Escalator) tick() {
+	mutex, err := cluster.NewMutex(e.api, escalationMutexKey)
+	if err != nil {
+		e.logDebug("escalation: failed to create mutex", "err", err.Error())
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), EscalationInterval)
+	defer cancel()
+	if err := mutex.LockWithContext(ctx); err != nil {
+		e.logDebug("escalation: another server is still escalating", "err", err.Error())
+		return
+	}
+	defer mutex.Unlock()
+
+	s := store.New(apiKV{e.api, e.namespace})
+	escalations, err := s.ListEscalations()
+	if err != nil {
+		e.logDebug("escalation: failed to load escalations", "err", err.Error())
+		return
+	}
+
+	now := e.now()
+	for _, escalation := range escalations {
+		post, appErr := e.api.GetPost(escalation.PostID)
+		if appErr != nil {
+			e.logDebug("escalation: failed to load card", "post_id", escalation.PostID, "err", appErr.Error())
+			if appErr.StatusCode == http.StatusNotFound {
+				e.remove(s, escalation)
+			}
+			continue
+		}
+
+		// Changes the plugin did not see, such as an assignment made in
+		// Bugsnag, still show on the card. An on-call user assigned with the
+		// card has not acknowledged it yet. Cards without a status are open.
+		data, _ := formatter.CardData(post)
+		status := formatter.CardStatus(post)
+		if post.DeleteAt != 0 || (status != "" && status != "open") || data.Assignee() != escalation.Assignee {
+			e.remove(s, escalation)
+			continue
+		}
+
+		notified := escalation.Notified
+		for {
+			level, due := escalation.Due(now)
+			if !due {
+				break
+			}
+			e.notify(escalation, level, data)
+			escalation.Notified++
+		}
+
+		switch {
+		case escalation.Notified == notified:
+		case escalation.Notified >= len(escalation.Levels):
+			e.remove(s, escalation)
+		default:
+			if err := s.SetEscalationNotified(escalation.ProjectID, escalation.ErrorID, escalation.Notified); err != nil {
+				e.logDebug("escalation: failed to save progress", "error_id", escalation.ErrorID, "err", err.Error())
+			}
+		}
+	}
+}
+
+// notify mentions the level's contacts in the card thread and sends them
+// direct messages, then records the level in the audit log.
+func (e *Escalator) notify(escalation store.Escalation, level store.EscalationLevel, data formatter.ErrorData) {
+	after := fmt.Sprintf("%d minutes", level.AfterMinutes)
+	if level.AfterMinutes == 1 {
+		after = "1 minute"
+	}
+
+	var notified []string
+	if mentions := mentionList(level.Mentions); mentions != "" {
+		message := fmt.Sprintf("🚨 **Escalation**: not acknowledged after %s. %s, please assign or resolve this error.", after, mentions)
+		if _, appErr := e.api.CreatePost(&model.Post{ChannelId: escalation.ChannelID, RootId: escalation.PostID, UserId: e.botUserID, Message: message}); appErr != nil {
+			e.logDebug("escalation: failed to post mention", "post_id", escalation.PostID, "err", appErr.Error())
+		} else {
+			notified = append(notified, mentions)
+		}
+	}
+
+	title := strings.TrimSpace(data.ExceptionClass)
+	if title == "" {
+		title = "Bugsnag error"
+	}
+	if link := e.permalink(escalation.PostID); link != "" {
+		title = fmt.Sprintf("[%s](%s)", title, link)
+	}
+	for _, username := range level.DirectMessages {
+		username = strings.TrimPrefix(strings.TrimSpace(username), "@")
+		if err := e.directMessage(username, fmt.Sprintf("🚨 **Escalation**: %s has not been acknowledged after %s. Please assign or resolve it.", title, after)); err != nil {
+			e.logDebug("escalation: failed to send direct message", "username", username, "err", err.Error())
+			continue
+		}
+		notified = append(notified, "DM @"+username)
+	}
+
+	if err := store.New(apiKV{e.api, e.namespace}).AppendAudit(store.AuditRecord{
+		Source:    store.AuditSourceSync,
+		Action:    AuditActionEscalate,
+		ProjectID: escalation.ProjectID,
+		ErrorID:   escalation.ErrorID,
+		Success:   len(notified) > 0,
+		Response:  fmt.Sprintf("level %d: %s", escalation.Notified+1, strings.Join(notified, ", ")),
+	}); err != nil {
+		e.logDebug("escalation: failed to record audit entry", "error_id", escalation.ErrorID, "err", err.Error())
+	}
+}
+
+func (e *Escalator) directMessage(username, message string) error {
+	user, appErr := e.api.GetUserByUsername(username)
+	if appErr != nil {
+		return appErr
+	}
+	channel, appErr := e.api.GetDirectChannel(e.botUserID, user.Id)
+	if appErr != nil {
+		return appErr
+	}
+	if _, appErr := e.api.CreatePost(&model.Post{ChannelId: channel.Id, UserId: e.botUserID, Message: message}); appErr != nil {
+		return appErr
+	}
+	return nil
+}
+
+func (e *Escalator) remove(s *store.Store, escalation store.Escalation) {
+	if _, err := s.RemoveEscalation(escalation.ProjectID, escalation.ErrorID); err != nil {
+		e.logDebug("escalation: failed to stop escalation", "error_id", escalation.ErrorID, "err", err.Error())
+	}
+}
+
+// permalink links to a post, or returns an empty string when the site URL is
+// not configured.
+func (e *Escalator) permalink(postID string) string {
+	cfg := e.api.GetConfig()
+	if cfg == nil || cfg.ServiceSettings.SiteURL == nil || *cfg.ServiceSettings.SiteURL == "" {
+		return ""
+	}
+	return strings.TrimRight(*cfg.ServiceSettings.SiteURL, "/") + "/_redirect/pl/" + postID
+}
+
+func (e *Escalator) logDebug(msg string, keyValuePairs ...interface{}) {
+	if e.debug {
+		e.api.LogDebug(msg, keyValuePairs...)
+	}
+}
+
+// mentionList renders usernames and group names as @-mentions.
+func mentionList(names []string) string {
+	var mentions []string
+	for _, name := range names {
+		if name = strings.TrimPrefix(strings.TrimSpace(name), "@"); name != "" {
+			mentions = append(mentions, "@"+name)
+		}
+	}
+	return strings.Join(mentions, " ")
+}
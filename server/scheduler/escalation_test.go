@@ -0,0 +1,79 @@
+package scheduler
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/a-voronkov/mattermost-bugsnag/server/formatter"
+	"github.com/a-voronkov/mattermost-bugsnag/server/kvkeys"
+	"github.com/a-voronkov/mattermost-bugsnag/server/store"
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/mock"
+)
+
+func escalationCard(id string, data formatter.ErrorData) *model.Post {
+	post := formatter.BuildErrorPost(data, formatter.ErrorPostMapping{ChannelID: "chan-1", ProjectID: "proj-1", ErrorID: data.ID})
+	post.Id = id
+	return post
+}
+
+func TestEscalatorTick(t *testing.T) {
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	levels := []store.EscalationLevel{
+		{AfterMinutes: 15, Mentions: []string{"oncall-backend"}},
+		{AfterMinutes: 45, DirectMessages: []string{"@jane"}},
+		{AfterMinutes: 90, Mentions: []string{"cto"}},
+	}
+	stored, _ := json.Marshal([]store.Escalation{
+		{ProjectID: "proj-1", ErrorID: "err-1", ChannelID: "chan-1", PostID: "post-1", CreatedAt: created, Levels: levels},
+		{ProjectID: "proj-1", ErrorID: "err-2", ChannelID: "chan-1", PostID: "post-2", CreatedAt: created, Levels: levels},
+		{ProjectID: "proj-1", ErrorID: "err-3", ChannelID: "chan-1", PostID: "post-3", CreatedAt: created.Add(40 * time.Minute), Levels: levels, Assignee: "@jane"},
+	})
+
+	siteURL := "https://mm.example.com"
+	api := &plugintest.API{}
+	// Each tick holds the cluster mutex, so one server escalates at a time.
+	api.On("KVSetWithOptions", "mutex_"+escalationMutexKey, []byte{1}, mock.MatchedBy(func(options model.PluginKVSetOptions) bool {
+		return options.Atomic && options.OldValue == nil
+	})).Return(true, nil).Once()
+	api.On("KVSetWithOptions", "mutex_"+escalationMutexKey, []byte(nil), model.PluginKVSetOptions{}).Return(true, nil).Once()
+	api.On("KVGet", "ns:"+kvkeys.Escalations).Return(func(string) []byte { return stored }, nil)
+	api.On("KVGet", "ns:"+kvkeys.AuditLog).Return(nil, nil)
+	api.On("KVSet", "ns:"+kvkeys.AuditLog, mock.Anything).Return(nil)
+	api.On("GetConfig").Return(&model.Config{ServiceSettings: model.ServiceSettings{SiteURL: &siteURL}})
+	// A card without a status is open.
+	api.On("GetPost", "post-1").Return(escalationCard("post-1", formatter.ErrorData{ID: "err-1", ProjectID: "proj-1", ExceptionClass: "NoMethodError"}), nil)
+	// Assigned in Bugsnag: the card shows an assignee, so it stops escalating.
+	api.On("GetPost", "post-2").Return(escalationCard("post-2", formatter.ErrorData{ID: "err-2", ProjectID: "proj-1", Status: "open", AssigneeEmail: "bob@acme.com"}), nil)
+	// Posted already assigned to the on-call user, which is no acknowledgement.
+	api.On("GetPost", "post-3").Return(escalationCard("post-3", formatter.ErrorData{ID: "err-3", ProjectID: "proj-1", Status: "open", AssigneeUsername: "jane"}), nil)
+
+	api.On("CreatePost", mock.MatchedBy(func(post *model.Post) bool {
+		return post.RootId == "post-1" && post.UserId == "bot-1" && strings.Contains(post.Message, "not acknowledged after 15 minutes. @oncall-backend")
+	})).Return(&model.Post{Id: "reply-1"}, nil).Once()
+	api.On("GetUserByUsername", "jane").Return(&model.User{Id: "user-jane"}, nil).Once()
+	api.On("GetDirectChannel", "bot-1", "user-jane").Return(&model.Channel{Id: "dm-1"}, nil).Once()
+	api.On("CreatePost", mock.MatchedBy(func(post *model.Post) bool {
+		return post.ChannelId == "dm-1" && strings.Contains(post.Message, "[NoMethodError](https://mm.example.com/_redirect/pl/post-1)")
+	})).Return(&model.Post{Id: "dm-post-1"}, nil).Once()
+
+	api.On("KVSetWithOptions", "ns:"+kvkeys.Escalations, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		stored = args.Get(1).([]byte)
+	}).Return(true, nil)
+
+	e := NewEscalator(api, false, "ns", "bot-1")
+	e.now = func() time.Time { return created.Add(50 * time.Minute) }
+	e.tick()
+
+	// The assigned error stops escalating, err-1 has notified two levels and
+	// err-3 has none due yet.
+	var escalations []store.Escalation
+	_ = json.Unmarshal(stored, &escalations)
+	if len(escalations) != 2 || escalations[0].ErrorID != "err-1" || escalations[0].Notified != 2 || escalations[1].ErrorID != "err-3" {
+		t.Errorf("unexpected escalations %+v", escalations)
+	}
+	api.AssertExpectations(t)
+}
@@ -8,17 +8,25 @@ import (
 	"time"
 
 	"github.com/a-voronkov/mattermost-bugsnag/server/bugsnag"
+	"github.com/a-voronkov/mattermost-bugsnag/server/formatter"
 	"github.com/a-voronkov/mattermost-bugsnag/server/kvkeys"
+	"github.com/a-voronkov/mattermost-bugsnag/server/metrics"
+	"github.com/a-voronkov/mattermost-bugsnag/server/store"
 	"github.com/mattermost/mattermost/server/public/model"
 	"github.com/mattermost/mattermost/server/public/plugin"
 )
 
+// AuditActionStatusChange is the audit action recorded when a sync picks up a
+// status change made in Bugsnag.
+const AuditActionStatusChange = "status_change"
+
 // ActiveError tracks a Bugsnag error that should be refreshed periodically.
 type ActiveError struct {
-	ProjectID string `json:"project_id"`
-	ErrorID   string `json:"error_id"`
-	ChannelID string `json:"channel_id"`
-	PostID    string `json:"post_id"`
+	ConnectionID string `json:"connection_id,omitempty"`
+	ProjectID    string `json:"project_id"`
+	ErrorID      string `json:"error_id"`
+	ChannelID    string `json:"channel_id"`
+	PostID       string `json:"post_id"`
 }
 
 type errorSnapshot struct {
@@ -34,33 +42,56 @@ type BugsnagClient interface {
 	GetError(ctx context.Context, projectID, errorID string) (*bugsnag.ErrorDetails, error)
 }
 
+// TokenProvider returns the Bugsnag API token of a connection, or an empty
+// string when the connection is not configured. An empty connection ID selects
+// the default connection.
+type TokenProvider func(connectionID string) string
+
+// StatusChangeHook is called after the sync applies a status change made in
+// Bugsnag to a card, with the card's previous status and the new one.
+type StatusChangeHook func(projectID, errorID, from, to string)
+
 // Runner periodically refreshes active errors and updates their posts/threads.
 type Runner struct {
 	api           plugin.API
 	debug         bool
 	client        BugsnagClient
-	tokenProvider func() string
+	clients       map[string]BugsnagClient
+	factory       *bugsnag.Factory
+	tokenProvider TokenProvider
 	namespace     string
+	onStatus      StatusChangeHook
 	stop          chan struct{}
 	done          chan struct{}
 	interval      time.Duration
 }
 
-// NewRunner builds a scheduler runner backed by the plugin API.
-func NewRunner(api plugin.API, debug bool, tokenProvider func() string, namespace string) *Runner {
+// NewRunner builds a scheduler runner backed by the plugin API. Clients are
+// built by factory; a nil factory selects the hosted bugsnag.com service.
+func NewRunner(api plugin.API, debug bool, factory *bugsnag.Factory, tokenProvider TokenProvider, namespace string) *Runner {
+	if factory == nil {
+		factory = bugsnag.DefaultFactory()
+	}
 	return &Runner{
 		api:           api,
 		debug:         debug,
+		factory:       factory,
 		tokenProvider: tokenProvider,
 		namespace:     namespace,
 	}
 }
 
-// SetClient allows injection of a custom Bugsnag client (useful for testing).
+// SetClient allows injection of a custom Bugsnag client used for every
+// connection (useful for testing).
 func (r *Runner) SetClient(client BugsnagClient) {
 	r.client = client
 }
 
+// SetStatusChangeHook registers a hook called for every synced status change.
+func (r *Runner) SetStatusChangeHook(hook StatusChangeHook) {
+	r.onStatus = hook
+}
+
 // Start launches the ticker loop.
 func (r *Runner) Start(interval time.Duration) {
 	r.interval = interval
@@ -99,80 +130,167 @@ func (r *Runner) run() {
 }
 
 func (r *Runner) tick() {
+	start := time.Now()
+	status := store.SyncStatus{At: start}
+	defer func() {
+		metrics.SyncTickDuration.ObserveSince(start)
+		status.Duration = time.Since(start).Round(time.Millisecond).String()
+		if err := store.New(apiKV{r.api, r.namespace}).RecordSync(status); err != nil {
+			r.logDebug("sync: failed to record health", "err", err.Error())
+		}
+	}()
+
 	ctx, cancel := context.WithTimeout(context.Background(), r.interval)
 	defer cancel()
 
-	// Ensure we have a Bugsnag client
-	if err := r.ensureClient(); err != nil {
-		r.logDebug("failed to create Bugsnag client", "err", err.Error())
+	activeErrors, err := r.loadActiveErrors()
+	if err != nil {
+		r.logDebug("failed to load active errors", "err", err.Error())
+		status.Error = err.Error()
 		return
 	}
+	metrics.ActiveErrors.Set(float64(len(activeErrors)))
+	status.ActiveErrors = len(activeErrors)
 
-	activeErrors, err := r.loadActiveErrors()
+	templates, err := r.loadCardTemplates()
 	if err != nil {
-		r.logDebug("failed to load active errors", "err", err.Error())
+		r.logDebug("failed to load card templates", "err", err.Error())
+		status.Error = err.Error()
 		return
 	}
 
 	for _, active := range activeErrors {
-		snapshot, fetchErr := r.fetchErrorSnapshot(ctx, active.ProjectID, active.ErrorID)
+		client, err := r.clientFor(active.ConnectionID)
+		if err != nil {
+			r.logDebug("failed to create Bugsnag client", "connection_id", active.ConnectionID, "err", err.Error())
+			status.Failures++
+			status.Error = err.Error()
+			continue
+		}
+
+		snapshot, fetchErr := r.fetchErrorSnapshot(ctx, client, active.ProjectID, active.ErrorID)
 		if fetchErr != nil {
 			r.logDebug("bugsnag sync fetch failed", "project_id", active.ProjectID, "error_id", active.ErrorID, "err", fetchErr.Error())
+			status.Failures++
+			status.Error = fmt.Sprintf("fetch %s: %s", active.ErrorID, fetchErr.Error())
+			continue
+		}
+
+		// Errors seeded by a backfill have no card to update. Stop tracking
+		// them once they are no longer open; if one reopens, the webhook
+		// posts its card.
+		if active.PostID == "" {
+			if snapshot.Status != "open" {
+				if err := store.New(apiKV{r.api, r.namespace}).RemoveActiveError(active.ProjectID, active.ErrorID); err != nil {
+					r.logDebug("sync: failed to drop seeded error", "error_id", active.ErrorID, "err", err.Error())
+				}
+			}
 			continue
 		}
 
 		post, appErr := r.api.GetPost(active.PostID)
 		if appErr != nil {
 			r.logDebug("sync: failed to load post", "post_id", active.PostID, "err", appErr.Error())
+			status.Failures++
+			status.Error = fmt.Sprintf("load post %s: %s", active.PostID, appErr.Error())
 			continue
 		}
 
-		// Extract current status from attachment
-		oldStatus := r.extractStatusFromPost(post)
+		oldStatus := formatter.CardStatus(post)
 
-		// Only update if status changed
-		if oldStatus == snapshot.Status {
+		// Bugsnag reports events of the last 24 hours but not of the last
+		// hour, so the card shows only what it knows.
+		counts := formatter.Counts{Events24h: snapshot.Events24h}
+		data, _ := formatter.CardData(post)
+		counts.Users = data.Counts.Users
+		countsChanged := data.Counts != counts
+
+		if oldStatus == snapshot.Status && !countsChanged {
 			r.logDebug("sync: status unchanged", "error_id", active.ErrorID, "status", oldStatus)
 			continue
 		}
 
-		// Update Status field in attachment
-		r.updateAttachmentStatus(post, snapshot.Status)
+		post = formatter.UpdatePost(formatter.UpdatePostParams{
+			Post:      post,
+			NewStatus: snapshot.Status,
+			Mapping: formatter.ErrorPostMapping{
+				ChannelID: active.ChannelID,
+				ProjectID: active.ProjectID,
+				ErrorID:   active.ErrorID,
+			},
+			Counts:    &counts,
+			Templates: templates,
+		})
 
 		if _, appErr = r.api.UpdatePost(post); appErr != nil {
 			r.logDebug("sync: failed to update post", "post_id", post.Id, "err", appErr.Error())
 			continue
 		}
+		metrics.PostsUpdated.Inc()
+
+		// Only status changes are noted in the thread.
+		if oldStatus == snapshot.Status {
+			continue
+		}
 
 		// Write thread message about status change
 		threadMessage := fmt.Sprintf("🔄 Status changed: **%s** → **%s** (synced from Bugsnag)", oldStatus, snapshot.Status)
 		if _, appErr = r.api.CreatePost(&model.Post{ChannelId: active.ChannelID, RootId: active.PostID, Message: threadMessage}); appErr != nil {
 			r.logDebug("sync: failed to create thread note", "post_id", active.PostID, "err", appErr.Error())
+		} else {
+			metrics.PostsCreated.Inc("reply")
+		}
+
+		if err := store.New(apiKV{r.api, r.namespace}).AppendAudit(store.AuditRecord{
+			Source:     store.AuditSourceSync,
+			Action:     AuditActionStatusChange,
+			ProjectID:  active.ProjectID,
+			ErrorID:    active.ErrorID,
+			FromStatus: oldStatus,
+			ToStatus:   snapshot.Status,
+			Success:    true,
+			Response:   "status synced from Bugsnag",
+		}); err != nil {
+			r.logDebug("sync: failed to record audit entry", "error_id", active.ErrorID, "err", err.Error())
+		}
+
+		if r.onStatus != nil {
+			r.onStatus(active.ProjectID, active.ErrorID, oldStatus, snapshot.Status)
 		}
 	}
 }
 
-func (r *Runner) ensureClient() error {
+// clientFor returns the Bugsnag client for a connection, creating it on first use.
+func (r *Runner) clientFor(connectionID string) (BugsnagClient, error) {
 	if r.client != nil {
-		return nil
+		return r.client, nil
+	}
+	if client, ok := r.clients[connectionID]; ok {
+		return client, nil
 	}
 
-	token := r.tokenProvider()
+	token := r.tokenProvider(connectionID)
 	if token == "" {
-		return fmt.Errorf("no Bugsnag API token configured")
+		if connectionID == "" {
+			return nil, fmt.Errorf("no Bugsnag API token configured")
+		}
+		return nil, fmt.Errorf("no Bugsnag API token configured for connection %q", connectionID)
 	}
 
-	client, err := bugsnag.NewDefaultClient(token)
+	client, err := r.factory.Client(token)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	r.client = client
-	return nil
+	if r.clients == nil {
+		r.clients = map[string]BugsnagClient{}
+	}
+	r.clients[connectionID] = client
+	return client, nil
 }
 
-func (r *Runner) fetchErrorSnapshot(ctx context.Context, projectID, errorID string) (errorSnapshot, error) {
-	details, err := r.client.GetError(ctx, projectID, errorID)
+func (r *Runner) fetchErrorSnapshot(ctx context.Context, client BugsnagClient, projectID, errorID string) (errorSnapshot, error) {
+	details, err := client.GetError(ctx, projectID, errorID)
 	if err != nil {
 		return errorSnapshot{}, err
 	}
@@ -206,95 +324,67 @@ func (r *Runner) loadActiveErrors() ([]ActiveError, error) {
 	return active, nil
 }
 
-func (r *Runner) namespaced(key string) string {
-	if strings.TrimSpace(r.namespace) == "" {
-		return key
-	}
-	return r.namespace + ":" + key
-}
-
-func (r *Runner) logDebug(msg string, keyValuePairs ...interface{}) {
-	if r.debug {
-		r.api.LogDebug(msg, keyValuePairs...)
-	}
-}
-
-// extractStatusFromPost reads the Status field from the post's attachment.
-func (r *Runner) extractStatusFromPost(post *model.Post) string {
-	if post.Props == nil {
-		return ""
-	}
-
-	attachments, ok := post.Props["attachments"]
-	if !ok {
-		return ""
-	}
-
-	// Handle []interface{} from DB
-	attList, ok := attachments.([]interface{})
-	if !ok || len(attList) == 0 {
-		return ""
+func (r *Runner) loadCardTemplates() ([]formatter.CardTemplate, error) {
+	data, appErr := r.api.KVGet(r.namespaced(kvkeys.CardTemplates))
+	if appErr != nil {
+		return nil, fmt.Errorf("load card templates: %w", appErr)
 	}
-
-	attMap, ok := attList[0].(map[string]interface{})
-	if !ok {
-		return ""
+	if data == nil {
+		return nil, nil
 	}
 
-	fields, ok := attMap["fields"].([]interface{})
-	if !ok {
-		return ""
+	var templates []formatter.CardTemplate
+	if err := json.Unmarshal(data, &templates); err != nil {
+		return nil, fmt.Errorf("parse card templates: %w", err)
 	}
 
-	for _, f := range fields {
-		fieldMap, ok := f.(map[string]interface{})
-		if !ok {
-			continue
-		}
-		if fieldMap["title"] == "Status" {
-			if val, ok := fieldMap["value"].(string); ok {
-				return val
-			}
-		}
-	}
+	return templates, nil
+}
 
-	return ""
+// apiKV adapts a plugin API and KV namespace to store.KVStore.
+type apiKV struct {
+	api       plugin.API
+	namespace string
 }
 
-// updateAttachmentStatus updates the Status field in the post's attachment.
-func (r *Runner) updateAttachmentStatus(post *model.Post, newStatus string) {
-	if post.Props == nil {
-		return
+func (kv apiKV) Get(key string) ([]byte, error) {
+	data, appErr := kv.api.KVGet(namespaced(kv.namespace, key))
+	if appErr != nil {
+		return nil, appErr
 	}
+	return data, nil
+}
 
-	attachments, ok := post.Props["attachments"]
-	if !ok {
-		return
+func (kv apiKV) Set(key string, value []byte) error {
+	if appErr := kv.api.KVSet(namespaced(kv.namespace, key), value); appErr != nil {
+		return appErr
 	}
+	return nil
+}
 
-	attList, ok := attachments.([]interface{})
-	if !ok || len(attList) == 0 {
-		return
+// CompareAndSet writes value only if key still holds old; a nil old value
+// means the key must be unset.
+func (kv apiKV) CompareAndSet(key string, old, value []byte) (bool, error) {
+	ok, appErr := kv.api.KVSetWithOptions(namespaced(kv.namespace, key), value, model.PluginKVSetOptions{Atomic: true, OldValue: old})
+	if appErr != nil {
+		return false, appErr
 	}
+	return ok, nil
+}
 
-	attMap, ok := attList[0].(map[string]interface{})
-	if !ok {
-		return
-	}
+func (r *Runner) namespaced(key string) string {
+	return namespaced(r.namespace, key)
+}
 
-	fields, ok := attMap["fields"].([]interface{})
-	if !ok {
-		return
+func namespaced(namespace, key string) string {
+	if strings.TrimSpace(namespace) == "" {
+		return key
 	}
+	return namespace + ":" + key
+}
 
-	for _, f := range fields {
-		fieldMap, ok := f.(map[string]interface{})
-		if !ok {
-			continue
-		}
-		if fieldMap["title"] == "Status" {
-			fieldMap["value"] = newStatus
-			break
-		}
+func (r *Runner) logDebug(msg string, keyValuePairs ...interface{}) {
+	if r.debug {
+		r.api.LogDebug(msg, keyValuePairs...)
 	}
 }
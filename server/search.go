@@ -0,0 +1,505 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/a-voronkov/mattermost-bugsnag/server/bugsnag"
+	"github.com/a-voronkov/mattermost-bugsnag/server/connection"
+	"github.com/a-voronkov/mattermost-bugsnag/server/formatter"
+	"github.com/a-voronkov/mattermost-bugsnag/server/kvkeys"
+	"github.com/a-voronkov/mattermost-bugsnag/server/metrics"
+	"github.com/a-voronkov/mattermost-bugsnag/server/outbound"
+	"github.com/a-voronkov/mattermost-bugsnag/server/store"
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+const errorsCommandUsage = "Usage: `/bugsnag errors search [project:<id|name>] [status:open] [severity:error] [stage:production] [assignee:me|<email>] [first-seen:7d] [last-seen:24h] [limit:10] [connection:<id>] [text]`.\n\n" +
+	"Without `project:` the project routed to this channel is searched. Ages accept `m`, `h` and `d` suffixes or a date such as `2024-05-01`."
+
+// actionPostCard is the interactive action behind the "Post here" buttons of
+// search results.
+const actionPostCard = "post_card"
+
+// errorSearch is a parsed /bugsnag errors search command.
+type errorSearch struct {
+	ConnectionID string
+	// Project is a project ID or name; empty selects the channel's project.
+	Project string
+	// Assignee is "me", an email address or a collaborator ID.
+	Assignee string
+	Filter   bugsnag.ErrorFilter
+}
+
+// parseErrorSearch reads key:value filters from params. Words that are not a
+// known filter, such as "Foo::Bar", become the free-text query.
+func parseErrorSearch(params []string, now time.Time) (errorSearch, error) {
+	var search errorSearch
+	var text []string
+
+	for _, param := range params {
+		key, value, ok := strings.Cut(param, ":")
+		if !ok || value == "" {
+			text = append(text, param)
+			continue
+		}
+
+		switch strings.ToLower(key) {
+		case "project":
+			search.Project = value
+		case "connection":
+			search.ConnectionID = value
+		case "status":
+			search.Filter.Status = strings.ToLower(value)
+		case "severity":
+			search.Filter.Severity = strings.ToLower(value)
+		case "stage":
+			search.Filter.ReleaseStage = value
+		case "assignee":
+			search.Assignee = value
+		case "first-seen":
+			after, err := parseSeenAfter(value, now)
+			if err != nil {
+				return search, fmt.Errorf("first-seen: %w", err)
+			}
+			search.Filter.FirstSeenAfter = after
+		case "last-seen":
+			after, err := parseSeenAfter(value, now)
+			if err != nil {
+				return search, fmt.Errorf("last-seen: %w", err)
+			}
+			search.Filter.LastSeenAfter = after
+		case "limit":
+			limit, err := strconv.Atoi(value)
+			if err != nil || limit < 1 || limit > bugsnag.MaxErrorLimit {
+				return search, fmt.Errorf("limit must be between 1 and %d", bugsnag.MaxErrorLimit)
+			}
+			search.Filter.Limit = limit
+		default:
+			text = append(text, param)
+		}
+	}
+
+	search.Filter.Query = strings.Join(text, " ")
+	return search, search.Filter.Validate()
+}
+
+// parseSeenAfter turns an age such as "30m", "24h" or "7d", or a date, into
+// the time errors must have been seen after.
+func parseSeenAfter(value string, now time.Time) (time.Time, error) {
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil && n > 0 {
+			return now.AddDate(0, 0, -n), nil
+		}
+	}
+	if age, err := time.ParseDuration(value); err == nil && age > 0 {
+		return now.Add(-age), nil
+	}
+	if date, err := time.Parse("2006-01-02", value); err == nil {
+		return date, nil
+	}
+	if ts, err := time.Parse(time.RFC3339, value); err == nil {
+		return ts, nil
+	}
+	return time.Time{}, fmt.Errorf("%q is not an age like 24h or 7d, or a date like 2024-05-01", value)
+}
+
+// executeErrorsCommand handles /bugsnag errors.
+func (p *Plugin) executeErrorsCommand(args *model.CommandArgs, params []string) *model.CommandResponse {
+	if len(params) == 0 || params[0] != "search" {
+		return commandResponse(errorsCommandUsage)
+	}
+
+	search, err := parseErrorSearch(params[1:], time.Now().UTC())
+	if err != nil {
+		return commandResponse(fmt.Sprintf("Invalid search: %s.\n\n%s", err.Error(), errorsCommandUsage))
+	}
+
+	cfg := p.getConfiguration()
+	conn, msg := findCommandConnection(cfg, search.ConnectionID)
+	if msg != "" {
+		return commandResponse(msg)
+	}
+
+	token, personal := p.actionToken(cfg, args.UserId, conn)
+	if token == "" {
+		if cfg.RequireUserTokens {
+			return commandResponse("Link your Bugsnag token with `/bugsnag token set <token>` to search Bugsnag errors.")
+		}
+		return commandResponse(fmt.Sprintf("No Bugsnag API token is configured for **%s**.", conn.DisplayName()))
+	}
+	client, err := p.bugsnagClient(token, personal)
+	if err != nil {
+		return commandResponse("Could not create a Bugsnag client: " + err.Error())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	mm := newMMClient(p.API, cfg.EnableDebugLog, p.kvNS(), p.botUserID)
+
+	projectID, projectName, msg := p.searchProject(ctx, mm, client, cfg.AllConnections(), conn, search.Project, args.ChannelId)
+	if msg != "" {
+		return commandResponse(msg)
+	}
+
+	if search.Assignee != "" {
+		assigneeID, msg := p.searchAssignee(ctx, mm, client, conn, search.Assignee, args.UserId)
+		if msg != "" {
+			return commandResponse(msg)
+		}
+		search.Filter.AssigneeID = assigneeID
+	}
+
+	results, err := client.ListErrors(ctx, projectID, search.Filter)
+	if err != nil {
+		p.API.LogWarn("Bugsnag error search failed", "project_id", projectID, "err", err.Error())
+		return commandResponse("Searching Bugsnag failed: " + err.Error())
+	}
+
+	return errorSearchResponse(results, conn, projectID, projectName, strings.Join(params[1:], " "), p.bugsnagClients())
+}
+
+// searchProject resolves the project to search: the one given by ID or name,
+// or the only project this channel receives cards for.
+func (p *Plugin) searchProject(ctx context.Context, mm *MMClient, client *bugsnag.Client, connections []connection.Connection, conn connection.Connection, project, channelID string) (id, name, msg string) {
+	if project == "" {
+		rules, err := loadChannelRules(mm)
+		if err != nil {
+			p.API.LogWarn("failed to load channel rules", "err", err.Error())
+		}
+
+		projects := map[string]string{}
+		for _, rule := range rules {
+			if rule.ChannelID == channelID && connection.Same(connections, rule.ConnectionID, conn.ID) {
+				projects[rule.ProjectID] = rule.ProjectName
+			}
+		}
+		switch len(projects) {
+		case 0:
+			return "", "", "No Bugsnag project is routed to this channel. Pick one with `project:<id|name>`."
+		case 1:
+		default:
+			return "", "", fmt.Sprintf("This channel receives cards for %d Bugsnag projects. Pick one with `project:<id|name>`.", len(projects))
+		}
+		for id, name := range projects {
+			return id, name, ""
+		}
+	}
+
+	orgID := conn.OrganizationID
+	if orgID == "" {
+		if orgs, err := client.GetOrganizations(ctx); err == nil && len(orgs) > 0 {
+			orgID = orgs[0].ID
+		}
+	}
+	if orgID != "" {
+		if projects, err := client.GetProjects(ctx, orgID); err == nil {
+			for _, candidate := range projects {
+				if candidate.ID == project || strings.EqualFold(candidate.Name, project) {
+					return candidate.ID, candidate.Name, ""
+				}
+			}
+		}
+	}
+
+	// The project may be outside the first organization; let Bugsnag decide.
+	return project, "", ""
+}
+
+// searchAssignee resolves assignee:me, an email address or a collaborator ID
+// to the collaborator ID Bugsnag filters on.
+func (p *Plugin) searchAssignee(ctx context.Context, mm *MMClient, client *bugsnag.Client, conn connection.Connection, assignee, userID string) (string, string) {
+	if strings.EqualFold(assignee, "me") {
+		user, appErr := mm.GetUser(userID)
+		if appErr != nil {
+			return "", "Could not load your Mattermost user."
+		}
+		mappings, err := loadUserMappings(mm)
+		if err != nil {
+			p.API.LogWarn("failed to load user mappings", "err", err.Error())
+		}
+		mapping, ok := mapUserToBugsnag(userMappingsFor(mappings, conn.ID), user)
+		if id := bugsnag.BestAssignee(bugsnag.UserMapping{BugsnagUserID: mapping.BugsnagUserID}); ok && id != "" {
+			return id, ""
+		}
+		return "", "You are not mapped to a Bugsnag collaborator, so `assignee:me` cannot be resolved. Ask a system admin to add a user mapping."
+	}
+
+	if !strings.Contains(assignee, "@") {
+		return assignee, ""
+	}
+
+	orgID := conn.OrganizationID
+	if orgID == "" {
+		orgs, err := client.GetOrganizations(ctx)
+		if err != nil || len(orgs) == 0 {
+			return "", "Could not load Bugsnag organizations to resolve the assignee."
+		}
+		orgID = orgs[0].ID
+	}
+	collaborators, err := client.GetCollaborators(ctx, orgID)
+	if err != nil {
+		return "", "Could not load Bugsnag collaborators to resolve the assignee."
+	}
+	for _, collaborator := range collaborators {
+		if strings.EqualFold(collaborator.Email, assignee) {
+			return collaborator.ID, ""
+		}
+	}
+	return "", fmt.Sprintf("No Bugsnag collaborator has the email `%s`.", assignee)
+}
+
+// errorSearchResponse renders results as a table with one "Post here" button
+// per row.
+func errorSearchResponse(results []bugsnag.ErrorDetails, conn connection.Connection, projectID, projectName, query string, clients *bugsnag.Factory) *model.CommandResponse {
+	project := projectName
+	if project == "" {
+		project = projectID
+	}
+	if len(results) == 0 {
+		return commandResponse(fmt.Sprintf("No Bugsnag errors in **%s** match `%s`.", project, query))
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "#### Bugsnag errors in %s", project)
+	if query != "" {
+		fmt.Fprintf(&b, " matching `%s`", query)
+	}
+	b.WriteString("\n\n| # | Error | Status | Severity | Events | Last seen |\n|---|---|---|---|---:|---|\n")
+
+	actions := make([]*model.PostAction, 0, len(results))
+	for i, result := range results {
+		title := tableCell(truncateText(joinNonEmpty(": ", result.ErrorClass, result.Message), 80))
+		if link := clients.DashboardURL(result.URL); link != "" {
+			title = fmt.Sprintf("[%s](%s)", title, link)
+		}
+		fmt.Fprintf(&b, "| %d | %s | %s | %s | %d | %s |\n", i+1, title, result.Status, result.Severity, result.Events, formatSeen(result.LastSeen))
+
+		actions = append(actions, &model.PostAction{
+			Id:   fmt.Sprintf("postcard%d", i+1),
+			Name: fmt.Sprintf("Post #%d here", i+1),
+			Type: model.PostActionTypeButton,
+			Integration: &model.PostActionIntegration{
+				URL: fmt.Sprintf("/plugins/%s/actions", kvkeys.PluginID),
+				Context: map[string]any{
+					"action":        actionPostCard,
+					"connection_id": conn.ID,
+					"project_id":    projectID,
+					"project_name":  projectName,
+					"error_id":      result.ID,
+				},
+			},
+		})
+	}
+
+	resp := commandResponse(b.String())
+	resp.Attachments = []*model.SlackAttachment{{
+		Text:    "Post an error's card to this channel:",
+		Actions: actions,
+	}}
+	return resp
+}
+
+// handlePostCardAction posts a card for an error picked from search results
+// into the channel the search ran in.
+func (p *Plugin) handlePostCardAction(w http.ResponseWriter, r *http.Request, payload model.PostActionIntegrationRequest) {
+	errorID, _ := payload.Context["error_id"].(string)
+	projectID, _ := payload.Context["project_id"].(string)
+	projectName, _ := payload.Context["project_name"].(string)
+	connectionID, _ := payload.Context["connection_id"].(string)
+
+	respond := func(text string) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(model.PostActionIntegrationResponse{EphemeralText: text})
+	}
+
+	if errorID == "" || projectID == "" || payload.ChannelId == "" {
+		http.Error(w, "missing error, project or channel", http.StatusBadRequest)
+		return
+	}
+	// The bot posts the card, so the user must be allowed to post there.
+	if !p.API.HasPermissionToChannel(payload.UserId, payload.ChannelId, model.PermissionCreatePost) {
+		respond("You cannot post in this channel.")
+		return
+	}
+
+	cfg := p.getConfiguration()
+	mm := newMMClient(p.API, cfg.EnableDebugLog, p.kvNS(), p.botUserID)
+
+	user, appErr := mm.GetUser(payload.UserId)
+	if appErr != nil {
+		http.Error(w, "invalid user", http.StatusBadRequest)
+		return
+	}
+
+	key := errorPostKVKey(projectID, errorID)
+	var mapping ErrorPostMapping
+	found, appErr := mm.LoadJSON(key, &mapping)
+	if appErr != nil {
+		mm.LogDebug("failed to load card mapping", "err", appErr.Error())
+	}
+	if found && mapping.ChannelID == payload.ChannelId {
+		respond(fmt.Sprintf("This error already has a card in this channel: %s", mm.Permalink(mapping.PostID)))
+		return
+	}
+
+	audit := store.AuditRecord{
+		Source:    store.AuditSourceCard,
+		Action:    actionPostCard,
+		UserID:    user.Id,
+		Username:  user.Username,
+		ProjectID: projectID,
+		ErrorID:   errorID,
+	}
+	fail := func(reason, text string) {
+		metrics.Actions.Inc(actionPostCard, metrics.ActionFailure)
+		audit.Response = reason
+		p.recordAudit(audit)
+		respond(text)
+	}
+
+	conn, _ := connection.Find(cfg.AllConnections(), connectionID)
+	token, personal := p.actionToken(cfg, user.Id, conn)
+	if token == "" {
+		fail("no Bugsnag token available", "Link your Bugsnag token with `/bugsnag token set <token>` to post Bugsnag errors.")
+		return
+	}
+	client, err := p.bugsnagClient(token, personal)
+	if err != nil {
+		fail("Bugsnag client init failed: "+err.Error(), "Could not create a Bugsnag client: "+err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 8*time.Second)
+	defer cancel()
+
+	details, err := client.GetError(ctx, projectID, errorID)
+	if err != nil {
+		p.API.LogWarn("failed to load Bugsnag error for card", "project_id", projectID, "error_id", errorID, "err", err.Error())
+		fail("Bugsnag fetch failed: "+err.Error(), "Could not load the error from Bugsnag: "+err.Error())
+		return
+	}
+
+	if details.ProjectID == "" {
+		details.ProjectID = projectID
+	}
+	mappings, err := loadUserMappings(mm)
+	if err != nil {
+		mm.LogDebug("failed to load user mappings", "err", err.Error())
+	}
+	data := errorDetailsCard(*details, projectName, userMappingsFor(mappings, conn.ID), mm, p.bugsnagClients())
+	data.TicketAvailable = len(cfg.issueTrackers()) > 0
+
+	templates, err := loadCardTemplates(mm)
+	if err != nil {
+		mm.LogDebug("failed to load card templates", "err", err.Error())
+	}
+	templateID := ""
+	if rules, err := loadChannelRules(mm); err == nil {
+		for _, rule := range rules {
+			if rule.ChannelID == payload.ChannelId && rule.ProjectID == projectID {
+				templateID = rule.TemplateID
+				break
+			}
+		}
+	}
+
+	card := &model.Post{ChannelId: payload.ChannelId}
+	if err := formatter.ApplyTemplatedCard(card, data, formatter.ErrorPostMapping{
+		ChannelID: payload.ChannelId,
+		ProjectID: projectID,
+		ErrorID:   errorID,
+	}, formatter.FindTemplate(templates, templateID)); err != nil {
+		p.API.LogWarn("card template failed, using built-in layout", "err", err.Error())
+	}
+	post, appErr := mm.CreateCardPost(card)
+	if appErr != nil {
+		fail("create post failed: "+appErr.Error(), "Could not post the card: "+appErr.Error())
+		return
+	}
+
+	if _, appErr := mm.CreateReply(payload.ChannelId, post.Id, fmt.Sprintf("@%s posted this error from a search.", user.Username)); appErr != nil {
+		mm.LogDebug("failed to add search reply", "err", appErr.Error())
+	}
+
+	// The first card of an error receives webhook updates and the status sync;
+	// a copy pulled into another channel is a snapshot.
+	posted := ErrorPostMapping{
+		ConnectionID: conn.ID,
+		ProjectID:    projectID,
+		ErrorID:      errorID,
+		ChannelID:    payload.ChannelId,
+		PostID:       post.Id,
+	}
+	if !found {
+		if err := mm.StoreJSON(key, posted); err != nil {
+			mm.LogDebug("failed to store error→post mapping", "err", err.Error())
+		}
+		p.registerActiveError(mm, posted)
+	}
+	p.emitEvent(mm, outbound.Event{Type: outbound.EventCardCreated, Source: outbound.SourceSearch, Actor: &outbound.Actor{UserID: user.Id, Username: user.Username}}, posted, &data)
+
+	metrics.Actions.Inc(actionPostCard, metrics.ActionSuccess)
+	audit.Success = true
+	audit.Response = "card posted"
+	p.recordAudit(audit)
+
+	respond(fmt.Sprintf("Posted the card for **%s** here.", formatter.BuildTitle(data)))
+}
+
+// errorDetailsCard converts an error fetched from the Bugsnag API into the
+// card model, resolving its assignee to a Mattermost username when mapped.
+func errorDetailsCard(details bugsnag.ErrorDetails, projectName string, userMappings []UserMapping, mm *MMClient, clients *bugsnag.Factory) formatter.ErrorData {
+	data := formatter.ErrorData{
+		ID:             details.ID,
+		ProjectID:      details.ProjectID,
+		ProjectName:    projectName,
+		ExceptionClass: details.ErrorClass,
+		Message:        details.Message,
+		Context:        details.Context,
+		Status:         details.Status,
+		Severity:       details.Severity,
+		Counts:         formatter.Counts{Events24h: details.EventsLast24h},
+		LastSeen:       details.LastSeen,
+		ErrorURL:       clients.DashboardURL(details.URL),
+	}
+
+	if details.AssigneeID != "" {
+		if mmUserID := mapBugsnagToMattermost(userMappings, details.AssigneeID, ""); mmUserID != "" {
+			if mmUser, appErr := mm.GetUser(mmUserID); appErr == nil {
+				data.AssigneeUsername = mmUser.Username
+			}
+		}
+	}
+
+	return data
+}
+
+// tableCell escapes text for a Markdown table cell.
+func tableCell(text string) string {
+	return strings.NewReplacer("|", `\|`, "\n", " ").Replace(text)
+}
+
+// truncateText shortens text to at most limit runes, marking the cut.
+func truncateText(text string, limit int) string {
+	runes := []rune(text)
+	if len(runes) <= limit {
+		return text
+	}
+	return string(runes[:limit-1]) + "…"
+}
+
+// formatSeen shortens an RFC 3339 timestamp for the results table.
+func formatSeen(value string) string {
+	seen, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return value
+	}
+	return seen.UTC().Format("2006-01-02 15:04")
+}
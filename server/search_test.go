@@ -0,0 +1,232 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/a-voronkov/mattermost-bugsnag/server/bugsnag"
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestParseErrorSearch(t *testing.T) {
+	now := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		params  string
+		want    errorSearch
+		wantErr string
+	}{
+		{
+			name:   "filters and text",
+			params: "project:Backend status:Open stage:production last-seen:24h first-seen:2024-05-01 Foo::Bar timeout",
+			want: errorSearch{
+				Project: "Backend",
+				Filter: bugsnag.ErrorFilter{
+					Status:         "open",
+					ReleaseStage:   "production",
+					LastSeenAfter:  now.Add(-24 * time.Hour),
+					FirstSeenAfter: time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC),
+					Query:          "Foo::Bar timeout",
+				},
+			},
+		},
+		{
+			name:   "days, assignee and connection",
+			params: "first-seen:7d assignee:me connection:mobile limit:5",
+			want: errorSearch{
+				ConnectionID: "mobile",
+				Assignee:     "me",
+				Filter:       bugsnag.ErrorFilter{FirstSeenAfter: now.AddDate(0, 0, -7), Limit: 5},
+			},
+		},
+		{name: "unknown status", params: "status:closed", wantErr: "unknown status"},
+		{name: "bad age", params: "last-seen:yesterday", wantErr: "last-seen"},
+		{name: "limit too high", params: "limit:100", wantErr: "limit must be between 1 and 25"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseErrorSearch(strings.Fields(tt.params), now)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseErrorSearch() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("parseErrorSearch() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// newBugsnagServer serves the given JSON bodies by request path.
+func newBugsnagServer(t *testing.T, responses map[string]string) *bugsnag.Factory {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := responses[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+
+	clients, err := bugsnag.NewFactory(bugsnag.Settings{APIURL: server.URL})
+	if err != nil {
+		t.Fatalf("NewFactory() error = %v", err)
+	}
+	return clients
+}
+
+func TestExecuteErrorsCommandUsesChannelProject(t *testing.T) {
+	clients := newBugsnagServer(t, map[string]string{
+		"/projects/proj-1/errors": `[{"id":"err-1","error_class":"NoMethodError","message":"undefined method | name","status":"open","severity":"error","events":42,"last_seen":"2024-05-01T11:30:00Z"}]`,
+	})
+
+	rules, _ := json.Marshal([]ChannelRule{
+		{ID: "r1", ProjectID: "proj-1", ProjectName: "Backend", ChannelID: "chan-1"},
+		{ID: "r2", ProjectID: "proj-2", ChannelID: "chan-2"},
+	})
+	api := &plugintest.API{}
+	api.On("KVGet", pluginID+":"+KVKeyProjectChannelMappings).Return(rules, nil)
+
+	p := &Plugin{}
+	p.SetAPI(api)
+	p.kvNamespace = pluginID
+	p.configuration.Store(&Configuration{BugsnagAPIToken: "shared-token"})
+	p.clients.Store(clients)
+
+	resp, appErr := p.ExecuteCommand(nil, &model.CommandArgs{UserId: "user-1", ChannelId: "chan-1", Command: "/bugsnag errors search status:open"})
+	if appErr != nil {
+		t.Fatalf("ExecuteCommand() error = %v", appErr)
+	}
+
+	if !strings.Contains(resp.Text, "#### Bugsnag errors in Backend matching `status:open`") {
+		t.Errorf("unexpected heading in %q", resp.Text)
+	}
+	if !strings.Contains(resp.Text, "| 1 | NoMethodError: undefined method \\| name | open | error | 42 | 2024-05-01 11:30 |") {
+		t.Errorf("unexpected table in %q", resp.Text)
+	}
+	if len(resp.Attachments) != 1 || len(resp.Attachments[0].Actions) != 1 {
+		t.Fatalf("expected one post button, got %+v", resp.Attachments)
+	}
+	context := resp.Attachments[0].Actions[0].Integration.Context
+	if context["action"] != actionPostCard || context["error_id"] != "err-1" || context["project_id"] != "proj-1" {
+		t.Errorf("unexpected button context %+v", context)
+	}
+
+	api.AssertExpectations(t)
+}
+
+func TestExecuteErrorsCommandNeedsProject(t *testing.T) {
+	api := &plugintest.API{}
+	api.On("KVGet", pluginID+":"+KVKeyProjectChannelMappings).Return(nil, nil)
+
+	p := &Plugin{}
+	p.SetAPI(api)
+	p.kvNamespace = pluginID
+	p.configuration.Store(&Configuration{BugsnagAPIToken: "shared-token"})
+
+	resp, _ := p.ExecuteCommand(nil, &model.CommandArgs{UserId: "user-1", ChannelId: "chan-1", Command: "/bugsnag errors search timeout"})
+	if !strings.Contains(resp.Text, "No Bugsnag project is routed to this channel") {
+		t.Errorf("unexpected response %q", resp.Text)
+	}
+}
+
+func TestHandlePostCardAction(t *testing.T) {
+	clients := newBugsnagServer(t, map[string]string{
+		"/projects/proj-1/errors/err-1": `{"id":"err-1","error_class":"NoMethodError","message":"undefined method","status":"open","severity":"error","url":"https://app.bugsnag.com/acme/backend/errors/err-1"}`,
+	})
+
+	userID := "user-1"
+	api := &plugintest.API{}
+	api.On("LogInfo", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return().Maybe()
+	api.On("HasPermissionToChannel", userID, "chan-9", model.PermissionCreatePost).Return(true)
+	api.On("GetUser", userID).Return(&model.User{Id: userID, Username: "jane"}, nil)
+	api.On("KVGet", mock.Anything).Return(nil, nil)
+	api.On("CreatePost", mock.MatchedBy(func(post *model.Post) bool {
+		return post.ChannelId == "chan-9" && post.RootId == "" && strings.Contains(post.Message, "NoMethodError")
+	})).Return(&model.Post{Id: "post-1", ChannelId: "chan-9"}, nil).Once()
+	api.On("CreatePost", mock.MatchedBy(func(post *model.Post) bool {
+		return post.RootId == "post-1" && post.Message == "@jane posted this error from a search."
+	})).Return(&model.Post{Id: "reply-1"}, nil).Once()
+	api.On("KVSet", pluginID+":"+errorPostKVKey("proj-1", "err-1"), mock.MatchedBy(func(data []byte) bool {
+		var mapping ErrorPostMapping
+		return json.Unmarshal(data, &mapping) == nil && mapping.PostID == "post-1" && mapping.ChannelID == "chan-9"
+	})).Return(nil).Once()
+	api.On("KVSet", pluginID+":"+KVKeyActiveErrors, mock.Anything).Return(nil).Once()
+	api.On("KVSet", pluginID+":"+KVKeyAuditLog, mock.Anything).Return(nil).Once()
+
+	p := &Plugin{}
+	p.SetAPI(api)
+	p.kvNamespace = pluginID
+	p.configuration.Store(&Configuration{BugsnagAPIToken: "shared-token"})
+	p.clients.Store(clients)
+
+	body, _ := json.Marshal(model.PostActionIntegrationRequest{
+		UserId:    userID,
+		ChannelId: "chan-9",
+		Context: map[string]any{
+			"action":     actionPostCard,
+			"error_id":   "err-1",
+			"project_id": "proj-1",
+		},
+	})
+
+	rr := httptest.NewRecorder()
+	p.handleActions(rr, actionRequest(body, userID))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	var resp model.PostActionIntegrationResponse
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if !strings.Contains(resp.EphemeralText, "Posted the card") {
+		t.Errorf("unexpected response %q", resp.EphemeralText)
+	}
+
+	api.AssertExpectations(t)
+}
+
+func TestHandlePostCardActionNeedsChannelPermission(t *testing.T) {
+	api := &plugintest.API{}
+	api.On("LogInfo", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return().Maybe()
+	api.On("HasPermissionToChannel", "user-1", "private-1", model.PermissionCreatePost).Return(false)
+
+	p := &Plugin{}
+	p.SetAPI(api)
+	p.kvNamespace = pluginID
+	p.configuration.Store(&Configuration{BugsnagAPIToken: "shared-token"})
+
+	body, _ := json.Marshal(model.PostActionIntegrationRequest{
+		UserId:    "user-1",
+		ChannelId: "private-1",
+		Context:   map[string]any{"action": actionPostCard, "error_id": "err-1", "project_id": "proj-1"},
+	})
+	rr := httptest.NewRecorder()
+	p.handleActions(rr, actionRequest(body, "user-1"))
+
+	var resp model.PostActionIntegrationResponse
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if resp.EphemeralText != "You cannot post in this channel." {
+		t.Errorf("unexpected response %q", resp.EphemeralText)
+	}
+	api.AssertNotCalled(t, "CreatePost", mock.Anything)
+}
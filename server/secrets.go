@@ -0,0 +1,290 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/a-voronkov/mattermost-bugsnag/server/store"
+)
+
+// secretSettings are the plugin settings sealed in place with the keyring.
+// Connection tokens inside the Connections JSON are sealed as well.
+var secretSettings = []string{"BugsnagAPIToken", "WebhookSecret", "WebhookToken", "MetricsToken"}
+
+// listSecretFields are the sealed fields of each entry in the settings that
+// hold JSON lists.
+var listSecretFields = map[string][]string{
+	"Connections":      {"api_token", "webhook_token"},
+	"IssueTrackers":    {"token"},
+	"OutboundWebhooks": {"secret"},
+}
+
+func (p *Plugin) kvStore() *store.Store {
+	return store.New(&pluginKVAdapter{api: p.API, namespace: p.kvNS()})
+}
+
+// getSealer returns the sealer of the loaded keyring, or nil before the
+// configuration has been loaded.
+func (p *Plugin) getSealer() *store.Sealer {
+	return p.sealer.Load()
+}
+
+// openSecrets returns a copy of the configuration with sealed settings
+// decrypted. Plaintext settings are returned as they are.
+func (c Configuration) openSecrets(sealer *store.Sealer) (Configuration, error) {
+	open := func(name, value string) (string, bool, error) {
+		if !store.IsSealed(value) {
+			return value, false, nil
+		}
+		plaintext, err := sealer.Open(value)
+		if err != nil {
+			return value, false, fmt.Errorf("decrypt %s: %w; enter the value again", name, err)
+		}
+		return plaintext, true, nil
+	}
+
+	var err error
+	if c.BugsnagAPIToken, _, err = open("BugsnagAPIToken", c.BugsnagAPIToken); err != nil {
+		return c, err
+	}
+	if c.WebhookSecret, _, err = open("WebhookSecret", c.WebhookSecret); err != nil {
+		return c, err
+	}
+	if c.WebhookToken, _, err = open("WebhookToken", c.WebhookToken); err != nil {
+		return c, err
+	}
+	if c.MetricsToken, _, err = open("MetricsToken", c.MetricsToken); err != nil {
+		return c, err
+	}
+	if c.Connections, _, err = mapListSecrets("Connections", c.Connections, open); err != nil {
+		return c, err
+	}
+	if c.IssueTrackers, _, err = mapListSecrets("IssueTrackers", c.IssueTrackers, open); err != nil {
+		return c, err
+	}
+	if c.OutboundWebhooks, _, err = mapListSecrets("OutboundWebhooks", c.OutboundWebhooks, open); err != nil {
+		return c, err
+	}
+
+	return c, nil
+}
+
+// needsSealing reports whether any secret setting is in plaintext or sealed
+// with a key other than the primary one.
+func (c Configuration) needsSealing(sealer *store.Sealer) bool {
+	for _, value := range []string{c.BugsnagAPIToken, c.WebhookSecret, c.WebhookToken, c.MetricsToken} {
+		if value != "" && !sealer.Current(value) {
+			return true
+		}
+	}
+
+	stale := false
+	check := func(_, value string) (string, bool, error) {
+		if value != "" && !sealer.Current(value) {
+			stale = true
+		}
+		return value, false, nil
+	}
+	_, _, _ = mapListSecrets("Connections", c.Connections, check)
+	_, _, _ = mapListSecrets("IssueTrackers", c.IssueTrackers, check)
+	_, _, _ = mapListSecrets("OutboundWebhooks", c.OutboundWebhooks, check)
+	return stale
+}
+
+// sealStoredSettings re-seals the secret settings in the saved plugin
+// configuration with the primary key and returns how many values changed.
+// Saving the configuration triggers OnConfigurationChange again.
+func (p *Plugin) sealStoredSettings(sealer *store.Sealer) (int, error) {
+	var settings map[string]any
+	if err := p.API.LoadPluginConfiguration(&settings); err != nil {
+		return 0, fmt.Errorf("load plugin settings: %w", err)
+	}
+
+	reseal := func(name, value string) (string, bool, error) {
+		resealed, changed, err := sealer.Reseal(value)
+		if err != nil {
+			return value, false, fmt.Errorf("seal %s: %w", name, err)
+		}
+		return resealed, changed, nil
+	}
+
+	changed := 0
+	for _, name := range secretSettings {
+		key, value := settingValue(settings, name)
+		sealed, ok, err := reseal(name, value)
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			settings[key] = sealed
+			changed++
+		}
+	}
+
+	for _, name := range []string{"Connections", "IssueTrackers", "OutboundWebhooks"} {
+		key, value := settingValue(settings, name)
+		list, count, err := mapListSecrets(name, value, reseal)
+		if err != nil {
+			return 0, err
+		}
+		if count > 0 {
+			settings[key] = list
+			changed += count
+		}
+	}
+
+	if changed == 0 {
+		return 0, nil
+	}
+	if appErr := p.API.SavePluginConfig(settings); appErr != nil {
+		return 0, fmt.Errorf("save plugin settings: %w", appErr)
+	}
+	return changed, nil
+}
+
+// settingValue looks a setting up by name. The System Console stores plugin
+// setting keys in lower case, so the match ignores case.
+func settingValue(settings map[string]any, name string) (string, string) {
+	for key, value := range settings {
+		if strings.EqualFold(key, name) {
+			s, _ := value.(string)
+			return key, s
+		}
+	}
+	return strings.ToLower(name), ""
+}
+
+// mapListSecrets applies fn to the secret fields of every entry in the JSON
+// list held by the named setting and returns how many values fn changed. The
+// JSON is only rewritten when a value changed; invalid JSON is left for
+// Configuration.Validate to report.
+func mapListSecrets(setting, raw string, fn func(name, value string) (string, bool, error)) (string, int, error) {
+	if strings.TrimSpace(raw) == "" {
+		return raw, 0, nil
+	}
+
+	var entries []map[string]any
+	if err := json.Unmarshal([]byte(raw), &entries); err != nil {
+		return raw, 0, nil
+	}
+
+	changed := 0
+	for i, entry := range entries {
+		id, _ := entry["id"].(string)
+		for _, field := range listSecretFields[setting] {
+			value, _ := entry[field].(string)
+			if value == "" {
+				continue
+			}
+			mapped, ok, err := fn(fmt.Sprintf("%s[%s].%s", setting, id, field), value)
+			if err != nil {
+				return raw, 0, err
+			}
+			if ok {
+				entries[i][field] = mapped
+				changed++
+			}
+		}
+	}
+
+	if changed == 0 {
+		return raw, 0, nil
+	}
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return raw, 0, fmt.Errorf("encode %s: %w", setting, err)
+	}
+	return string(data), changed, nil
+}
+
+// rotateEncryptionKey adds a new master secret, re-seals the stored settings
+// and user tokens with it, and retires the old keys once nothing uses them.
+// On failure the old keys are kept so every value stays readable.
+func (p *Plugin) rotateEncryptionKey() (store.KeyRotation, error) {
+	s := p.kvStore()
+
+	wrap := p.keyWrap(p.getConfiguration())
+	sealer, err := s.RotateKeyring(wrap)
+	if err != nil {
+		return store.KeyRotation{}, err
+	}
+	p.sealer.Store(sealer)
+	rotation := store.KeyRotation{KeyID: sealer.PrimaryKeyID()}
+
+	if rotation.ResealedSettings, err = p.sealStoredSettings(sealer); err != nil {
+		return rotation, err
+	}
+	if rotation.ResealedUserTokens, err = s.ResealUserTokens(sealer); err != nil {
+		return rotation, err
+	}
+
+	if sealer, err = s.RetireKeys(wrap); err != nil {
+		return rotation, err
+	}
+	p.sealer.Store(sealer)
+	rotation.RetiredOldKeys = true
+
+	p.API.LogInfo("encryption key rotated", "key_id", rotation.KeyID, "settings", rotation.ResealedSettings, "user_tokens", rotation.ResealedUserTokens)
+	return rotation, nil
+}
+
+// keyWrap returns how the keyring is wrapped for cfg. The EncryptionKey in
+// use until now is accepted as well, so the keyring is re-wrapped when the
+// setting changes.
+func (p *Plugin) keyWrap(cfg Configuration) store.KeyWrap {
+	wrap := store.KeyWrap{Secret: cfg.EncryptionKey}
+	if previous := p.getConfiguration().EncryptionKey; previous != "" && previous != cfg.EncryptionKey {
+		wrap.Previous = []string{previous}
+	}
+	return wrap
+}
+
+// generateEncryptionKey saves a random EncryptionKey to the plugin settings.
+// Saving triggers OnConfigurationChange, which wraps the keyring with it.
+func (p *Plugin) generateEncryptionKey() error {
+	var settings map[string]any
+	if err := p.API.LoadPluginConfiguration(&settings); err != nil {
+		return fmt.Errorf("load plugin settings: %w", err)
+	}
+	if settings == nil {
+		settings = map[string]any{}
+	}
+	key, value := settingValue(settings, "EncryptionKey")
+	if value != "" {
+		return nil
+	}
+
+	secret, err := store.NewWrappingSecret()
+	if err != nil {
+		return err
+	}
+	settings[key] = secret
+	if appErr := p.API.SavePluginConfig(settings); appErr != nil {
+		return fmt.Errorf("save plugin settings: %w", appErr)
+	}
+	return nil
+}
+
+// knownSecrets lists the configured secret values that must never appear in
+// logs or API responses.
+func (p *Plugin) knownSecrets() []string {
+	cfg := p.getConfiguration()
+	secrets := []string{cfg.WebhookSecret, cfg.WebhookToken, cfg.MetricsToken, cfg.EncryptionKey}
+	for _, conn := range cfg.AllConnections() {
+		secrets = append(secrets, conn.APIToken, conn.WebhookToken)
+	}
+	for _, t := range cfg.issueTrackers() {
+		secrets = append(secrets, t.Token)
+	}
+	for _, e := range cfg.outboundEndpoints() {
+		secrets = append(secrets, e.Secret)
+	}
+	if proxy, err := url.Parse(cfg.BugsnagProxyURL); err == nil && proxy.User != nil {
+		if password, ok := proxy.User.Password(); ok {
+			secrets = append(secrets, password)
+		}
+	}
+	return secrets
+}
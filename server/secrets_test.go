@@ -0,0 +1,119 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/a-voronkov/mattermost-bugsnag/server/connection"
+	"github.com/a-voronkov/mattermost-bugsnag/server/store"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestSealStoredSettings(t *testing.T) {
+	sealer := testSealer(t)
+	sealedSecret, _ := sealer.Seal("webhook-secret")
+
+	api := &plugintest.API{}
+	api.On("LoadPluginConfiguration", mock.Anything).Run(func(args mock.Arguments) {
+		*args.Get(0).(*map[string]any) = map[string]any{
+			// The System Console stores keys in lower case.
+			"bugsnagapitoken":  "api-token",
+			"webhooksecret":    sealedSecret,
+			"connections":      `[{"id":"mobile","api_token":"mobile-token","webhook_token":""}]`,
+			"issuetrackers":    `[{"id":"gh","type":"github","repository":"acme/app","token":"github-token"}]`,
+			"outboundwebhooks": `[{"id":"ops","url":"https://hooks.example.com","secret":"hook-secret"}]`,
+			"syncintervalsec":  300,
+		}
+	}).Return(nil)
+
+	var saved map[string]any
+	api.On("SavePluginConfig", mock.Anything).Run(func(args mock.Arguments) {
+		saved = args.Get(0).(map[string]any)
+	}).Return(nil).Once()
+
+	p := &Plugin{}
+	p.SetAPI(api)
+
+	changed, err := p.sealStoredSettings(sealer)
+	if err != nil {
+		t.Fatalf("sealStoredSettings() error = %v", err)
+	}
+	if changed != 4 {
+		t.Errorf("expected the API, connection and tracker tokens and the outbound secret to be sealed, got %d changes", changed)
+	}
+
+	token, _ := saved["bugsnagapitoken"].(string)
+	if !sealer.Current(token) {
+		t.Errorf("expected a sealed API token, got %q", token)
+	}
+	if saved["webhooksecret"] != sealedSecret {
+		t.Error("an already sealed value must be kept as it is")
+	}
+	if _, ok := saved["webhooktoken"]; ok {
+		t.Error("unset settings must not be added")
+	}
+	if connections, _ := saved["connections"].(string); strings.Contains(connections, "mobile-token") {
+		t.Errorf("connection token saved in plaintext: %s", connections)
+	}
+	if trackers, _ := saved["issuetrackers"].(string); strings.Contains(trackers, "github-token") {
+		t.Errorf("tracker token saved in plaintext: %s", trackers)
+	}
+	if webhooks, _ := saved["outboundwebhooks"].(string); strings.Contains(webhooks, "hook-secret") {
+		t.Errorf("outbound secret saved in plaintext: %s", webhooks)
+	}
+
+	// Reading the sealed settings back yields the plaintext values.
+	cfg := Configuration{
+		BugsnagAPIToken:  token,
+		WebhookSecret:    sealedSecret,
+		Connections:      saved["connections"].(string),
+		IssueTrackers:    saved["issuetrackers"].(string),
+		OutboundWebhooks: saved["outboundwebhooks"].(string),
+	}
+	if cfg.needsSealing(sealer) {
+		t.Error("expected nothing left to seal")
+	}
+	opened, err := cfg.openSecrets(sealer)
+	if err != nil {
+		t.Fatalf("openSecrets() error = %v", err)
+	}
+	if opened.BugsnagAPIToken != "api-token" || opened.WebhookSecret != "webhook-secret" {
+		t.Errorf("unexpected opened settings %+v", opened)
+	}
+	if conn, _ := connection.Find(opened.AllConnections(), "mobile"); conn.APIToken != "mobile-token" {
+		t.Errorf("expected the connection token to be opened, got %q", conn.APIToken)
+	}
+	if trackers := opened.issueTrackers(); len(trackers) != 1 || trackers[0].Token != "github-token" {
+		t.Errorf("expected the tracker token to be opened, got %+v", trackers)
+	}
+	if endpoints := opened.outboundEndpoints(); len(endpoints) != 1 || endpoints[0].Secret != "hook-secret" {
+		t.Errorf("expected the outbound secret to be opened, got %+v", endpoints)
+	}
+
+	api.AssertExpectations(t)
+}
+
+func TestOpenSecretsUnknownKey(t *testing.T) {
+	other, _ := store.NewSealer("other-secret")
+	sealed, _ := other.Seal("api-token")
+
+	cfg := Configuration{BugsnagAPIToken: sealed}
+	if _, err := cfg.openSecrets(testSealer(t)); err == nil || !strings.Contains(err.Error(), "BugsnagAPIToken") {
+		t.Errorf("expected an error naming the setting, got %v", err)
+	}
+
+	if !(Configuration{WebhookToken: "plain"}).needsSealing(testSealer(t)) {
+		t.Error("expected a plaintext setting to need sealing")
+	}
+}
+
+func TestRedactingAPI(t *testing.T) {
+	api := &plugintest.API{}
+	api.On("LogDebug", "request with [redacted]", "url", "https://example.com/webhook?token=[redacted]", "attempt", 2).Return().Once()
+
+	redacting := &redactingAPI{API: api, secrets: func() []string { return []string{"hook-token-123", ""} }}
+	redacting.LogDebug("request with hook-token-123", "url", "https://example.com/webhook?token=hook-token-123", "attempt", 2)
+
+	api.AssertExpectations(t)
+}
@@ -0,0 +1,153 @@
+// Package sourcelink builds links from stacktrace frames to the matching file
+// and line in a project's source repository.
+package sourcelink
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Supported repository providers.
+const (
+	ProviderGitHub    = "github"
+	ProviderGitLab    = "gitlab"
+	ProviderBitbucket = "bitbucket"
+	ProviderCustom    = "custom"
+)
+
+// providerTemplates are the file URL layouts of the hosted providers. The
+// placeholders are {repo}, {ref}, {path} and {line}.
+var providerTemplates = map[string]string{
+	ProviderGitHub:    "{repo}/blob/{ref}/{path}#L{line}",
+	ProviderGitLab:    "{repo}/-/blob/{ref}/{path}#L{line}",
+	ProviderBitbucket: "{repo}/src/{ref}/{path}#lines-{line}",
+}
+
+// Repository describes where a Bugsnag project's source code lives.
+type Repository struct {
+	ProjectID string `json:"project_id"`
+	Provider  string `json:"provider"`
+	// URL is the repository web URL, e.g. https://github.com/acme/checkout.
+	URL string `json:"url"`
+	// URLTemplate is required for the custom provider and overrides the
+	// provider layout otherwise.
+	URLTemplate string `json:"url_template,omitempty"`
+	// RefTemplate turns an app version into a git ref, e.g. "v{version}".
+	// Defaults to the version itself.
+	RefTemplate string `json:"ref_template,omitempty"`
+	// PathPrefixes rewrite frame paths (as reported by Bugsnag) into
+	// repository paths. The first matching prefix wins.
+	PathPrefixes []PathPrefix `json:"path_prefixes,omitempty"`
+}
+
+// PathPrefix replaces a leading From with To in frame file paths.
+type PathPrefix struct {
+	From string `json:"from"`
+	To   string `json:"to,omitempty"`
+}
+
+// Validate checks that the repository can produce links.
+func (r Repository) Validate() error {
+	if strings.TrimSpace(r.ProjectID) == "" {
+		return fmt.Errorf("project_id is required")
+	}
+
+	if strings.TrimSpace(r.URL) == "" {
+		return fmt.Errorf("url is required")
+	}
+	parsed, err := url.Parse(r.URL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("url must be an absolute http(s) URL")
+	}
+
+	if r.template() == "" {
+		if r.Provider == ProviderCustom {
+			return fmt.Errorf("url_template is required for the custom provider")
+		}
+		return fmt.Errorf("unsupported provider %q", r.Provider)
+	}
+	if !strings.Contains(r.template(), "{path}") {
+		return fmt.Errorf("url template must contain {path}")
+	}
+
+	return nil
+}
+
+// Ref returns the git ref to link to. A release revision wins over the app
+// version, which is passed through RefTemplate.
+func (r Repository) Ref(revision, version string) string {
+	if revision = strings.TrimSpace(revision); revision != "" {
+		return revision
+	}
+	version = strings.TrimSpace(version)
+	if version == "" {
+		return ""
+	}
+	if r.RefTemplate == "" {
+		return version
+	}
+	return strings.ReplaceAll(r.RefTemplate, "{version}", version)
+}
+
+// FileURL links to file at line for ref. It returns an empty string when the
+// file or ref is unknown.
+func (r Repository) FileURL(file, line, ref string) string {
+	path := r.repoPath(file)
+	tmpl := r.template()
+	if path == "" || ref == "" || tmpl == "" {
+		return ""
+	}
+
+	link := tmpl
+	if line == "" {
+		// Drop the line anchor rather than linking to an empty one.
+		if i := strings.Index(link, "#"); i >= 0 && strings.Contains(link[i:], "{line}") {
+			link = link[:i]
+		}
+	}
+
+	replacer := strings.NewReplacer(
+		"{repo}", strings.TrimRight(r.URL, "/"),
+		"{ref}", escapePath(ref),
+		"{path}", escapePath(path),
+		"{line}", line,
+	)
+	return replacer.Replace(link)
+}
+
+func (r Repository) template() string {
+	if r.URLTemplate != "" {
+		return r.URLTemplate
+	}
+	return providerTemplates[strings.ToLower(strings.TrimSpace(r.Provider))]
+}
+
+func (r Repository) repoPath(file string) string {
+	file = strings.TrimSpace(file)
+	for _, prefix := range r.PathPrefixes {
+		if prefix.From != "" && strings.HasPrefix(file, prefix.From) {
+			file = prefix.To + strings.TrimPrefix(file, prefix.From)
+			break
+		}
+	}
+	return strings.TrimLeft(file, "/")
+}
+
+func escapePath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// ForProject returns the repository configured for projectID, if any.
+func ForProject(repositories []Repository, projectID string) (Repository, bool) {
+	for _, repo := range repositories {
+		if repo.ProjectID == projectID {
+			return repo, true
+		}
+	}
+	return Repository{}, false
+}
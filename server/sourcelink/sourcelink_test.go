@@ -0,0 +1,100 @@
+package sourcelink
+
+import "testing"
+
+func TestFileURL(t *testing.T) {
+	tests := []struct {
+		name string
+		repo Repository
+		file string
+		line string
+		ref  string
+		want string
+	}{
+		{
+			name: "github",
+			repo: Repository{Provider: ProviderGitHub, URL: "https://github.com/acme/api/"},
+			file: "app/models/order.rb", line: "88", ref: "abc123",
+			want: "https://github.com/acme/api/blob/abc123/app/models/order.rb#L88",
+		},
+		{
+			name: "gitlab with prefix mapping",
+			repo: Repository{
+				Provider:     ProviderGitLab,
+				URL:          "https://gitlab.example.com/team/api",
+				PathPrefixes: []PathPrefix{{From: "/var/www/app/", To: "src/"}},
+			},
+			file: "/var/www/app/main.go", line: "12", ref: "v1.2.0",
+			want: "https://gitlab.example.com/team/api/-/blob/v1.2.0/src/main.go#L12",
+		},
+		{
+			name: "bitbucket without line",
+			repo: Repository{Provider: ProviderBitbucket, URL: "https://bitbucket.org/acme/api"},
+			file: "lib/a b.js", ref: "main",
+			want: "https://bitbucket.org/acme/api/src/main/lib/a%20b.js",
+		},
+		{
+			name: "branch ref with slash",
+			repo: Repository{Provider: ProviderGitHub, URL: "https://github.com/acme/api"},
+			file: "main.go", line: "7", ref: "release/1.2",
+			want: "https://github.com/acme/api/blob/release/1.2/main.go#L7",
+		},
+		{
+			name: "custom template",
+			repo: Repository{Provider: ProviderCustom, URL: "https://src.example.com/api", URLTemplate: "{repo}/view/{path}?at={ref}&line={line}"},
+			file: "main.go", line: "3", ref: "deadbeef",
+			want: "https://src.example.com/api/view/main.go?at=deadbeef&line=3",
+		},
+		{
+			name: "missing ref",
+			repo: Repository{Provider: ProviderGitHub, URL: "https://github.com/acme/api"},
+			file: "main.go", line: "3",
+			want: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.repo.FileURL(tt.file, tt.line, tt.ref); got != tt.want {
+				t.Errorf("FileURL() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRef(t *testing.T) {
+	repo := Repository{RefTemplate: "v{version}"}
+
+	if got := repo.Ref("abc123", "1.2.0"); got != "abc123" {
+		t.Errorf("expected revision to win, got %q", got)
+	}
+	if got := repo.Ref("", "1.2.0"); got != "v1.2.0" {
+		t.Errorf("expected templated version, got %q", got)
+	}
+	if got := (Repository{}).Ref("", "1.2.0"); got != "1.2.0" {
+		t.Errorf("expected raw version, got %q", got)
+	}
+	if got := repo.Ref("", ""); got != "" {
+		t.Errorf("expected empty ref, got %q", got)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := Repository{ProjectID: "p1", Provider: ProviderGitHub, URL: "https://github.com/acme/api"}
+	if err := valid.Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	invalid := []Repository{
+		{Provider: ProviderGitHub, URL: "https://github.com/acme/api"},
+		{ProjectID: "p1", Provider: ProviderGitHub, URL: "github.com/acme/api"},
+		{ProjectID: "p1", Provider: "svn", URL: "https://svn.example.com"},
+		{ProjectID: "p1", Provider: ProviderCustom, URL: "https://src.example.com"},
+		{ProjectID: "p1", Provider: ProviderCustom, URL: "https://src.example.com", URLTemplate: "{repo}/{ref}"},
+	}
+	for i, repo := range invalid {
+		if err := repo.Validate(); err == nil {
+			t.Errorf("case %d: expected validation error for %+v", i, repo)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/a-voronkov/mattermost-bugsnag/server/kvkeys"
+)
+
+// Values for AuditRecord.Source.
+const (
+	AuditSourceCard         = "card"
+	AuditSourceSlashCommand = "slash_command"
+	AuditSourceSync         = "sync"
+)
+
+// MaxAuditRecords caps the audit log; the oldest records are dropped first.
+const MaxAuditRecords = 5000
+
+// AuditRecord is one entry in the audit log: who did what to which error, when,
+// through which path, and what Bugsnag answered.
+type AuditRecord struct {
+	Time       time.Time `json:"time"`
+	Source     string    `json:"source"`
+	Action     string    `json:"action"`
+	UserID     string    `json:"user_id,omitempty"`
+	Username   string    `json:"username,omitempty"`
+	ProjectID  string    `json:"project_id"`
+	ErrorID    string    `json:"error_id"`
+	FromStatus string    `json:"from_status,omitempty"`
+	ToStatus   string    `json:"to_status,omitempty"`
+	Success    bool      `json:"success"`
+	Response   string    `json:"response,omitempty"`
+}
+
+// AuditFilter selects audit records. Zero values match everything.
+type AuditFilter struct {
+	ProjectID string
+	ErrorID   string
+	UserID    string
+	Action    string
+	Source    string
+	Since     time.Time
+	Until     time.Time
+	// Limit caps the number of records returned; 0 means no limit.
+	Limit int
+}
+
+// Matches reports whether the record passes the filter.
+func (f AuditFilter) Matches(record AuditRecord) bool {
+	switch {
+	case f.ProjectID != "" && record.ProjectID != f.ProjectID:
+		return false
+	case f.ErrorID != "" && record.ErrorID != f.ErrorID:
+		return false
+	case f.UserID != "" && record.UserID != f.UserID:
+		return false
+	case f.Action != "" && record.Action != f.Action:
+		return false
+	case f.Source != "" && record.Source != f.Source:
+		return false
+	case !f.Since.IsZero() && record.Time.Before(f.Since):
+		return false
+	case !f.Until.IsZero() && record.Time.After(f.Until):
+		return false
+	}
+	return true
+}
+
+// AppendAudit adds a record to the audit log, stamping the current time when
+// the record has none.
+func (s *Store) AppendAudit(record AuditRecord) error {
+	records, err := s.loadAuditLog()
+	if err != nil {
+		return err
+	}
+
+	if record.Time.IsZero() {
+		record.Time = time.Now().UTC()
+	}
+
+	records = append(records, record)
+	if len(records) > MaxAuditRecords {
+		records = records[len(records)-MaxAuditRecords:]
+	}
+
+	return s.saveAuditLog(records)
+}
+
+// ListAudit returns the records matching the filter, newest first.
+func (s *Store) ListAudit(filter AuditFilter) ([]AuditRecord, error) {
+	records, err := s.loadAuditLog()
+	if err != nil {
+		return nil, err
+	}
+
+	matching := []AuditRecord{}
+	for _, record := range records {
+		if filter.Matches(record) {
+			matching = append(matching, record)
+		}
+	}
+
+	sort.SliceStable(matching, func(i, j int) bool {
+		return matching[i].Time.After(matching[j].Time)
+	})
+
+	if filter.Limit > 0 && len(matching) > filter.Limit {
+		matching = matching[:filter.Limit]
+	}
+
+	return matching, nil
+}
+
+func (s *Store) loadAuditLog() ([]AuditRecord, error) {
+	data, err := s.kv.Get(kvkeys.AuditLog)
+	if err != nil {
+		return nil, fmt.Errorf("get audit log: %w", err)
+	}
+
+	if len(data) == 0 {
+		return []AuditRecord{}, nil
+	}
+
+	var records []AuditRecord
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, fmt.Errorf("decode audit log: %w", err)
+	}
+
+	return records, nil
+}
+
+func (s *Store) saveAuditLog(records []AuditRecord) error {
+	data, err := json.Marshal(records)
+	if err != nil {
+		return fmt.Errorf("encode audit log: %w", err)
+	}
+
+	if err := s.kv.Set(kvkeys.AuditLog, data); err != nil {
+		return fmt.Errorf("set audit log: %w", err)
+	}
+
+	return nil
+}
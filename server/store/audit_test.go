@@ -0,0 +1,91 @@
+package store
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAuditLogAppendAndFilter(t *testing.T) {
+	s := New(newMemoryKVStore())
+	base := time.Date(2025, 11, 28, 10, 0, 0, 0, time.UTC)
+
+	records := []AuditRecord{
+		{Time: base, Source: AuditSourceCard, Action: "resolve", UserID: "u1", ProjectID: "p1", ErrorID: "e1", ToStatus: "fixed", Success: true},
+		{Time: base.Add(time.Hour), Source: AuditSourceSync, Action: "status_change", ProjectID: "p1", ErrorID: "e1", FromStatus: "fixed", ToStatus: "open", Success: true},
+		{Time: base.Add(2 * time.Hour), Source: AuditSourceCard, Action: "ignore", UserID: "u2", ProjectID: "p2", ErrorID: "e2", Success: false},
+	}
+	for _, record := range records {
+		if err := s.AppendAudit(record); err != nil {
+			t.Fatalf("AppendAudit() error = %v", err)
+		}
+	}
+
+	all, err := s.ListAudit(AuditFilter{})
+	if err != nil {
+		t.Fatalf("ListAudit() error = %v", err)
+	}
+	if len(all) != 3 || all[0].Action != "ignore" || all[2].Action != "resolve" {
+		t.Fatalf("expected all records newest first, got %+v", all)
+	}
+
+	tests := []struct {
+		name   string
+		filter AuditFilter
+		want   []string
+	}{
+		{"by project", AuditFilter{ProjectID: "p1"}, []string{"status_change", "resolve"}},
+		{"by source", AuditFilter{Source: AuditSourceCard}, []string{"ignore", "resolve"}},
+		{"by user", AuditFilter{UserID: "u2"}, []string{"ignore"}},
+		{"by error and action", AuditFilter{ErrorID: "e1", Action: "resolve"}, []string{"resolve"}},
+		{"since", AuditFilter{Since: base.Add(30 * time.Minute)}, []string{"ignore", "status_change"}},
+		{"until", AuditFilter{Until: base.Add(30 * time.Minute)}, []string{"resolve"}},
+		{"limit", AuditFilter{Limit: 1}, []string{"ignore"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.ListAudit(tt.filter)
+			if err != nil {
+				t.Fatalf("ListAudit() error = %v", err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("expected %d records, got %+v", len(tt.want), got)
+			}
+			for i, action := range tt.want {
+				if got[i].Action != action {
+					t.Errorf("record %d action = %q, want %q", i, got[i].Action, action)
+				}
+			}
+		})
+	}
+}
+
+func TestAuditLogStampsTimeAndCaps(t *testing.T) {
+	s := New(newMemoryKVStore())
+
+	full := make([]AuditRecord, MaxAuditRecords)
+	for i := range full {
+		full[i] = AuditRecord{Time: time.Unix(int64(i), 0).UTC(), Action: "old"}
+	}
+	if err := s.saveAuditLog(full); err != nil {
+		t.Fatalf("saveAuditLog() error = %v", err)
+	}
+
+	if err := s.AppendAudit(AuditRecord{Action: "resolve", ErrorID: "e"}); err != nil {
+		t.Fatalf("AppendAudit() error = %v", err)
+	}
+
+	records, err := s.ListAudit(AuditFilter{})
+	if err != nil {
+		t.Fatalf("ListAudit() error = %v", err)
+	}
+	if len(records) != MaxAuditRecords {
+		t.Fatalf("expected %d records, got %d", MaxAuditRecords, len(records))
+	}
+	if records[0].Action != "resolve" || records[0].Time.IsZero() {
+		t.Errorf("expected the stamped new record first, got %+v", records[0])
+	}
+	if records[len(records)-1].Time.Equal(time.Unix(0, 0).UTC()) {
+		t.Error("expected the oldest record to be dropped")
+	}
+}
@@ -0,0 +1,119 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/a-voronkov/mattermost-bugsnag/server/kvkeys"
+)
+
+// ErrDeliveryNotFound is returned when a delivery is no longer in the log.
+var ErrDeliveryNotFound = errors.New("delivery not found")
+
+// ErrDeliveryTruncated is returned when replaying a delivery whose payload was
+// too large to keep in full.
+var ErrDeliveryTruncated = errors.New("delivery payload was truncated and cannot be replayed")
+
+// Delivery is one raw webhook delivery with what the plugin made of it.
+type Delivery struct {
+	ID         string    `json:"id"`
+	ReceivedAt time.Time `json:"received_at"`
+	// ReplayOf is the ID of the delivery this one replayed, if any.
+	ReplayOf string `json:"replay_of,omitempty"`
+	// Connection is the ID of the Bugsnag connection the delivery was routed to.
+	Connection string            `json:"connection,omitempty"`
+	Remote     string            `json:"remote,omitempty"`
+	Headers    map[string]string `json:"headers"`
+	Query      map[string]string `json:"query,omitempty"`
+	Body       string            `json:"body"`
+	// Redacted is set when personal data was removed from Body.
+	Redacted bool `json:"redacted,omitempty"`
+	// Truncated is set when Body was cut short; such deliveries cannot be replayed.
+	Truncated bool `json:"truncated,omitempty"`
+	// Validation is "ok" or the reason the delivery was rejected.
+	Validation   string   `json:"validation"`
+	MatchedRules []string `json:"matched_rules"`
+	Outcome      string   `json:"outcome"`
+	Processed    int      `json:"processed"`
+	Errors       []string `json:"errors,omitempty"`
+}
+
+// AppendDelivery adds a delivery to the log, keeping only the newest limit
+// entries.
+func (s *Store) AppendDelivery(delivery Delivery, limit int) error {
+	deliveries, err := s.loadDeliveries()
+	if err != nil {
+		return err
+	}
+
+	deliveries = append(deliveries, delivery)
+	if limit > 0 && len(deliveries) > limit {
+		deliveries = deliveries[len(deliveries)-limit:]
+	}
+
+	return s.saveDeliveries(deliveries)
+}
+
+// ListDeliveries returns the logged deliveries, newest first.
+func (s *Store) ListDeliveries() ([]Delivery, error) {
+	deliveries, err := s.loadDeliveries()
+	if err != nil {
+		return nil, err
+	}
+
+	newestFirst := make([]Delivery, 0, len(deliveries))
+	for i := len(deliveries) - 1; i >= 0; i-- {
+		newestFirst = append(newestFirst, deliveries[i])
+	}
+
+	return newestFirst, nil
+}
+
+// GetDelivery returns the delivery with the given ID, or ErrDeliveryNotFound.
+func (s *Store) GetDelivery(id string) (Delivery, error) {
+	deliveries, err := s.loadDeliveries()
+	if err != nil {
+		return Delivery{}, err
+	}
+
+	for _, delivery := range deliveries {
+		if delivery.ID == id {
+			return delivery, nil
+		}
+	}
+
+	return Delivery{}, ErrDeliveryNotFound
+}
+
+func (s *Store) loadDeliveries() ([]Delivery, error) {
+	data, err := s.kv.Get(kvkeys.Deliveries)
+	if err != nil {
+		return nil, fmt.Errorf("get delivery log: %w", err)
+	}
+
+	if len(data) == 0 {
+		return []Delivery{}, nil
+	}
+
+	var deliveries []Delivery
+	if err := json.Unmarshal(data, &deliveries); err != nil {
+		return nil, fmt.Errorf("decode delivery log: %w", err)
+	}
+
+	return deliveries, nil
+}
+
+func (s *Store) saveDeliveries(deliveries []Delivery) error {
+	data, err := json.Marshal(deliveries)
+	if err != nil {
+		return fmt.Errorf("encode delivery log: %w", err)
+	}
+
+	if err := s.kv.Set(kvkeys.Deliveries, data); err != nil {
+		return fmt.Errorf("set delivery log: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,35 @@
+package store
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDeliveryLog(t *testing.T) {
+	s := New(newMemoryKVStore())
+
+	for _, id := range []string{"d1", "d2", "d3", "d4"} {
+		if err := s.AppendDelivery(Delivery{ID: id, Outcome: "processed"}, 3); err != nil {
+			t.Fatalf("AppendDelivery(%s) error = %v", id, err)
+		}
+	}
+
+	deliveries, err := s.ListDeliveries()
+	if err != nil {
+		t.Fatalf("ListDeliveries() error = %v", err)
+	}
+	if len(deliveries) != 3 {
+		t.Fatalf("expected the log to keep 3 deliveries, got %d", len(deliveries))
+	}
+	if deliveries[0].ID != "d4" || deliveries[2].ID != "d2" {
+		t.Errorf("expected newest first from d4 to d2, got %s..%s", deliveries[0].ID, deliveries[2].ID)
+	}
+
+	delivery, err := s.GetDelivery("d3")
+	if err != nil || delivery.ID != "d3" {
+		t.Errorf("GetDelivery(d3) = %+v, %v", delivery, err)
+	}
+	if _, err := s.GetDelivery("d1"); !errors.Is(err, ErrDeliveryNotFound) {
+		t.Errorf("expected the oldest delivery to be dropped, got %v", err)
+	}
+}
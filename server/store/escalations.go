@@ -0,0 +1,162 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/a-voronkov/mattermost-bugsnag/server/kvkeys"
+)
+
+// EscalationLevel is one step of an escalation policy: who is notified once a
+// card has gone unacknowledged for AfterMinutes.
+type EscalationLevel struct {
+	AfterMinutes int `json:"after_minutes"`
+	// Mentions are usernames or group names mentioned in the card thread.
+	Mentions []string `json:"mentions,omitempty"`
+	// DirectMessages are usernames sent a direct message by the bot.
+	DirectMessages []string `json:"direct_messages,omitempty"`
+}
+
+// Escalation tracks a card that escalates until someone acknowledges it. The
+// levels are copied from the rule when the card is posted, so editing the rule
+// only affects new cards.
+type Escalation struct {
+	ProjectID string            `json:"project_id"`
+	ErrorID   string            `json:"error_id"`
+	ChannelID string            `json:"channel_id"`
+	PostID    string            `json:"post_id"`
+	RuleID    string            `json:"rule_id,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+	Levels    []EscalationLevel `json:"levels"`
+	// Notified is how many levels have been notified so far.
+	Notified int `json:"notified"`
+	// Assignee is the assignee the card was posted with, such as the on-call
+	// user. Only a different assignee acknowledges the card.
+	Assignee string `json:"assignee,omitempty"`
+}
+
+// Due returns the next level once it is due at now.
+func (e Escalation) Due(now time.Time) (EscalationLevel, bool) {
+	if e.Notified >= len(e.Levels) {
+		return EscalationLevel{}, false
+	}
+	level := e.Levels[e.Notified]
+	return level, !now.Before(e.CreatedAt.Add(time.Duration(level.AfterMinutes) * time.Minute))
+}
+
+// escalationWriteAttempts bounds how often a write that raced with another
+// writer is retried.
+const escalationWriteAttempts = 5
+
+// UpsertEscalation starts or replaces the escalation of an error.
+func (s *Store) UpsertEscalation(escalation Escalation) error {
+	return s.updateEscalations(func(escalations []Escalation) ([]Escalation, bool) {
+		for i, existing := range escalations {
+			if existing.ProjectID == escalation.ProjectID && existing.ErrorID == escalation.ErrorID {
+				escalations[i] = escalation
+				return escalations, true
+			}
+		}
+		return append(escalations, escalation), true
+	})
+}
+
+// SetEscalationNotified records how many levels of an error's escalation have
+// been notified. It does nothing when the escalation was stopped meanwhile.
+func (s *Store) SetEscalationNotified(projectID, errorID string, notified int) error {
+	return s.updateEscalations(func(escalations []Escalation) ([]Escalation, bool) {
+		for i, existing := range escalations {
+			if existing.ProjectID == projectID && existing.ErrorID == errorID {
+				escalations[i].Notified = notified
+				return escalations, true
+			}
+		}
+		return escalations, false
+	})
+}
+
+// RemoveEscalation stops the escalation of an error and reports whether there
+// was one.
+func (s *Store) RemoveEscalation(projectID, errorID string) (bool, error) {
+	removed := false
+	err := s.updateEscalations(func(escalations []Escalation) ([]Escalation, bool) {
+		kept := escalations[:0]
+		for _, existing := range escalations {
+			if existing.ProjectID != projectID || existing.ErrorID != errorID {
+				kept = append(kept, existing)
+			}
+		}
+		removed = len(kept) != len(escalations)
+		return kept, removed
+	})
+	if err != nil {
+		return false, err
+	}
+	return removed, nil
+}
+
+// ListEscalations returns the escalations in progress.
+func (s *Store) ListEscalations() ([]Escalation, error) {
+	data, err := s.kv.Get(kvkeys.Escalations)
+	if err != nil {
+		return nil, fmt.Errorf("get escalations: %w", err)
+	}
+	return decodeEscalations(data)
+}
+
+// updateEscalations applies change to the stored escalations. The webhook,
+// card actions and the escalator all write them, so when the KV store
+// supports it the write only succeeds if nobody else wrote them in between,
+// and change runs again on the fresh value otherwise. change reports whether
+// it changed anything.
+func (s *Store) updateEscalations(change func([]Escalation) ([]Escalation, bool)) error {
+	cas, atomic := s.kv.(KVCompareAndSetter)
+	for attempt := 0; attempt < escalationWriteAttempts; attempt++ {
+		old, err := s.kv.Get(kvkeys.Escalations)
+		if err != nil {
+			return fmt.Errorf("get escalations: %w", err)
+		}
+		escalations, err := decodeEscalations(old)
+		if err != nil {
+			return err
+		}
+
+		escalations, changed := change(escalations)
+		if !changed {
+			return nil
+		}
+		data, err := json.Marshal(escalations)
+		if err != nil {
+			return fmt.Errorf("encode escalations: %w", err)
+		}
+
+		if !atomic {
+			if err := s.kv.Set(kvkeys.Escalations, data); err != nil {
+				return fmt.Errorf("set escalations: %w", err)
+			}
+			return nil
+		}
+		ok, err := cas.CompareAndSet(kvkeys.Escalations, old, data)
+		if err != nil {
+			return fmt.Errorf("set escalations: %w", err)
+		}
+		if ok {
+			return nil
+		}
+	}
+	return fmt.Errorf("set escalations: kept changing after %d attempts", escalationWriteAttempts)
+}
+
+func decodeEscalations(data []byte) ([]Escalation, error) {
+	if len(data) == 0 {
+		return []Escalation{}, nil
+	}
+
+	var escalations []Escalation
+	if err := json.Unmarshal(data, &escalations); err != nil {
+		return nil, fmt.Errorf("decode escalations: %w", err)
+	}
+
+	return escalations, nil
+}
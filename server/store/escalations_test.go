@@ -0,0 +1,85 @@
+package store
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEscalations(t *testing.T) {
+	s := New(newMemoryKVStore())
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	levels := []EscalationLevel{{AfterMinutes: 15, Mentions: []string{"oncall"}}, {AfterMinutes: 45, DirectMessages: []string{"jane"}}}
+
+	if err := s.UpsertEscalation(Escalation{ProjectID: "proj-1", ErrorID: "err-1", PostID: "post-1", CreatedAt: created, Levels: levels}); err != nil {
+		t.Fatalf("UpsertEscalation() error = %v", err)
+	}
+	if err := s.UpsertEscalation(Escalation{ProjectID: "proj-1", ErrorID: "err-2", PostID: "post-2", CreatedAt: created, Levels: levels}); err != nil {
+		t.Fatalf("UpsertEscalation() error = %v", err)
+	}
+	if err := s.SetEscalationNotified("proj-1", "err-1", 1); err != nil {
+		t.Fatalf("SetEscalationNotified() error = %v", err)
+	}
+
+	escalations, err := s.ListEscalations()
+	if err != nil || len(escalations) != 2 || escalations[0].Notified != 1 {
+		t.Fatalf("ListEscalations() = %+v, %v", escalations, err)
+	}
+
+	if _, due := escalations[0].Due(created.Add(44 * time.Minute)); due {
+		t.Error("second level due before 45 minutes")
+	}
+	if level, due := escalations[0].Due(created.Add(45 * time.Minute)); !due || level.DirectMessages[0] != "jane" {
+		t.Errorf("Due(45m) = %+v, %v", level, due)
+	}
+
+	if removed, err := s.RemoveEscalation("proj-1", "err-1"); err != nil || !removed {
+		t.Fatalf("RemoveEscalation() = %v, %v", removed, err)
+	}
+	if removed, _ := s.RemoveEscalation("proj-1", "err-1"); removed {
+		t.Error("expected a second removal to find nothing")
+	}
+	// Marking a stopped escalation must not bring it back.
+	if err := s.SetEscalationNotified("proj-1", "err-1", 2); err != nil {
+		t.Fatalf("SetEscalationNotified() error = %v", err)
+	}
+	if escalations, _ := s.ListEscalations(); len(escalations) != 1 || escalations[0].ErrorID != "err-2" {
+		t.Errorf("unexpected escalations %+v", escalations)
+	}
+}
+
+// racingKVStore lets another writer change the store right before the next
+// compare-and-set.
+type racingKVStore struct {
+	*memoryKVStore
+	race func()
+}
+
+func (kv *racingKVStore) CompareAndSet(key string, old, value []byte) (bool, error) {
+	if race := kv.race; race != nil {
+		kv.race = nil
+		race()
+	}
+	return kv.memoryKVStore.CompareAndSet(key, old, value)
+}
+
+func TestEscalationsConcurrentWriters(t *testing.T) {
+	kv := &racingKVStore{memoryKVStore: newMemoryKVStore()}
+	s := New(kv)
+	if err := s.UpsertEscalation(Escalation{ProjectID: "proj-1", ErrorID: "err-1"}); err != nil {
+		t.Fatalf("UpsertEscalation() error = %v", err)
+	}
+
+	// A webhook starts err-2 while err-1 is acknowledged.
+	kv.race = func() {
+		if err := New(kv.memoryKVStore).UpsertEscalation(Escalation{ProjectID: "proj-1", ErrorID: "err-2"}); err != nil {
+			t.Errorf("concurrent UpsertEscalation() error = %v", err)
+		}
+	}
+	if removed, err := s.RemoveEscalation("proj-1", "err-1"); err != nil || !removed {
+		t.Fatalf("RemoveEscalation() = %v, %v", removed, err)
+	}
+
+	if escalations, _ := s.ListEscalations(); len(escalations) != 1 || escalations[0].ErrorID != "err-2" {
+		t.Errorf("expected only the concurrent escalation to remain, got %+v", escalations)
+	}
+}
@@ -0,0 +1,85 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/a-voronkov/mattermost-bugsnag/server/kvkeys"
+)
+
+// SyncStatus describes the most recent status sync tick.
+type SyncStatus struct {
+	At           time.Time `json:"at"`
+	Duration     string    `json:"duration"`
+	ActiveErrors int       `json:"active_errors"`
+	Failures     int       `json:"failures"`
+	// Error is the last error of the tick, if any.
+	Error string `json:"error,omitempty"`
+}
+
+// Health is the operational state reported by the health endpoint.
+type Health struct {
+	// LastWebhook maps a project ID to the last delivery that posted or
+	// updated a card for it.
+	LastWebhook map[string]time.Time `json:"last_webhook"`
+	LastSync    *SyncStatus          `json:"last_sync,omitempty"`
+}
+
+// GetHealth returns the recorded health state.
+func (s *Store) GetHealth() (Health, error) {
+	data, err := s.kv.Get(kvkeys.Health)
+	if err != nil {
+		return Health{}, fmt.Errorf("get health: %w", err)
+	}
+
+	health := Health{LastWebhook: map[string]time.Time{}}
+	if len(data) == 0 {
+		return health, nil
+	}
+
+	if err := json.Unmarshal(data, &health); err != nil {
+		return Health{}, fmt.Errorf("decode health: %w", err)
+	}
+	if health.LastWebhook == nil {
+		health.LastWebhook = map[string]time.Time{}
+	}
+
+	return health, nil
+}
+
+// RecordWebhook stores the time of the last successful delivery for a project.
+func (s *Store) RecordWebhook(projectID string, at time.Time) error {
+	health, err := s.GetHealth()
+	if err != nil {
+		return err
+	}
+
+	health.LastWebhook[projectID] = at.UTC()
+	return s.saveHealth(health)
+}
+
+// RecordSync stores the outcome of a status sync tick.
+func (s *Store) RecordSync(status SyncStatus) error {
+	health, err := s.GetHealth()
+	if err != nil {
+		return err
+	}
+
+	status.At = status.At.UTC()
+	health.LastSync = &status
+	return s.saveHealth(health)
+}
+
+func (s *Store) saveHealth(health Health) error {
+	data, err := json.Marshal(health)
+	if err != nil {
+		return fmt.Errorf("encode health: %w", err)
+	}
+
+	if err := s.kv.Set(kvkeys.Health, data); err != nil {
+		return fmt.Errorf("set health: %w", err)
+	}
+
+	return nil
+}
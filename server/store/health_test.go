@@ -0,0 +1,43 @@
+package store
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHealthRecords(t *testing.T) {
+	s := New(newMemoryKVStore())
+
+	health, err := s.GetHealth()
+	if err != nil {
+		t.Fatalf("GetHealth() error = %v", err)
+	}
+	if len(health.LastWebhook) != 0 || health.LastSync != nil {
+		t.Fatalf("expected empty health, got %+v", health)
+	}
+
+	first := time.Date(2025, 11, 28, 10, 0, 0, 0, time.UTC)
+	if err := s.RecordWebhook("p1", first); err != nil {
+		t.Fatalf("RecordWebhook() error = %v", err)
+	}
+	if err := s.RecordWebhook("p2", first.Add(time.Minute)); err != nil {
+		t.Fatalf("RecordWebhook() error = %v", err)
+	}
+	if err := s.RecordWebhook("p1", first.Add(time.Hour)); err != nil {
+		t.Fatalf("RecordWebhook() error = %v", err)
+	}
+	if err := s.RecordSync(SyncStatus{At: first, ActiveErrors: 3}); err != nil {
+		t.Fatalf("RecordSync() error = %v", err)
+	}
+
+	health, err = s.GetHealth()
+	if err != nil {
+		t.Fatalf("GetHealth() error = %v", err)
+	}
+	if !health.LastWebhook["p1"].Equal(first.Add(time.Hour)) || !health.LastWebhook["p2"].Equal(first.Add(time.Minute)) {
+		t.Errorf("unexpected webhook times %+v", health.LastWebhook)
+	}
+	if health.LastSync == nil || health.LastSync.ActiveErrors != 3 {
+		t.Errorf("unexpected sync status %+v", health.LastSync)
+	}
+}
@@ -0,0 +1,480 @@
+package store
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/a-voronkov/mattermost-bugsnag/server/kvkeys"
+)
+
+// ErrNoEncryptionKey is returned when secrets must be stored but no keyring
+// has been loaded.
+var ErrNoEncryptionKey = errors.New("encryption key is not configured")
+
+// ErrUnknownKey is returned when a value was sealed with a key that is no
+// longer in the keyring.
+var ErrUnknownKey = errors.New("value was sealed with an unknown key")
+
+// ErrUnknownWrappingKey is returned when the stored master secrets are
+// wrapped with a secret that is not configured.
+var ErrUnknownWrappingKey = errors.New("keyring is wrapped with an unknown encryption key")
+
+// Sealed values look like "enc:v2:<key id>:<base64 nonce+ciphertext>".
+// Values written before the keyring existed use "v1:<base64>" and were
+// encrypted with the SHA-256 of the master secret.
+const (
+	sealedPrefix       = "enc:v2:"
+	legacySealedPrefix = "v1:"
+)
+
+// keyDerivationLabel separates the AES key from other uses of a master secret.
+const keyDerivationLabel = "mattermost-bugsnag secrets v2"
+
+// Wrapped master secrets look like "wrap:v1:<wrapping key id>:<base64
+// nonce+ciphertext>". The wrapping key is derived from a secret kept outside
+// the KV store, so the stored keyring alone cannot decrypt anything.
+const (
+	wrappedPrefix       = "wrap:v1:"
+	wrapDerivationLabel = "mattermost-bugsnag keyring wrap v1"
+)
+
+// KeyWrap names the secrets that wrap the master secrets in the KV store.
+// Secret wraps them from now on; Previous lists secrets they may still be
+// wrapped with, such as the value of a setting before it changed. An empty
+// Secret stores the master secrets as they are.
+type KeyWrap struct {
+	Secret   string
+	Previous []string
+}
+
+// KeyringKey is one master secret the plugin generated. Secret is wrapped
+// while stored.
+type KeyringKey struct {
+	ID        string    `json:"id"`
+	Secret    string    `json:"secret"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Keyring holds the master secrets, newest (primary) first. Older keys are
+// kept only until every value sealed with them has been re-sealed.
+type Keyring struct {
+	Keys []KeyringKey `json:"keys"`
+}
+
+// KeyRotation reports the outcome of rotating the master secret.
+type KeyRotation struct {
+	KeyID              string `json:"key_id"`
+	ResealedSettings   int    `json:"resealed_settings"`
+	ResealedUserTokens int    `json:"resealed_user_tokens"`
+	// RetiredOldKeys is false when re-sealing failed part way; the old keys
+	// are then kept so nothing becomes unreadable.
+	RetiredOldKeys bool `json:"retired_old_keys"`
+}
+
+// KVCompareAndSetter is implemented by KV stores that can write a key only if
+// it still holds an expected value. A nil old value means the key is unset.
+type KVCompareAndSetter interface {
+	CompareAndSet(key string, old, value []byte) (bool, error)
+}
+
+// Sealer encrypts secrets with AES-256-GCM using the primary key of a keyring
+// and decrypts values sealed with any of its keys.
+type Sealer struct {
+	primary string
+	keys    map[string]cipher.AEAD
+	legacy  []cipher.AEAD
+}
+
+// NewSealer builds a sealer from master secrets, primary first.
+func NewSealer(primary string, previous ...string) (*Sealer, error) {
+	if strings.TrimSpace(primary) == "" {
+		return nil, ErrNoEncryptionKey
+	}
+
+	s := &Sealer{primary: KeyID(primary), keys: map[string]cipher.AEAD{}}
+	for _, secret := range append([]string{primary}, previous...) {
+		if strings.TrimSpace(secret) == "" {
+			continue
+		}
+
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(keyDerivationLabel))
+		aead, err := newAEAD(mac.Sum(nil))
+		if err != nil {
+			return nil, err
+		}
+		s.keys[KeyID(secret)] = aead
+
+		legacyKey := sha256.Sum256([]byte(secret))
+		legacy, err := newAEAD(legacyKey[:])
+		if err != nil {
+			return nil, err
+		}
+		s.legacy = append(s.legacy, legacy)
+	}
+
+	return s, nil
+}
+
+// KeyID returns the public identifier of a master secret.
+func KeyID(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:4])
+}
+
+// PrimaryKeyID returns the ID of the key new values are sealed with.
+func (s *Sealer) PrimaryKeyID() string {
+	return s.primary
+}
+
+// Seal encrypts plaintext with the primary key and a random nonce.
+func (s *Sealer) Seal(plaintext string) (string, error) {
+	aead := s.keys[s.primary]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", fmt.Errorf("generate nonce: %w", err)
+	}
+
+	sealed := aead.Seal(nonce, nonce, []byte(plaintext), nil)
+	return sealedPrefix + s.primary + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Open decrypts a value produced by Seal with any key of the keyring.
+func (s *Sealer) Open(sealed string) (string, error) {
+	if legacy, ok := strings.CutPrefix(sealed, legacySealedPrefix); ok {
+		for _, aead := range s.legacy {
+			if plaintext, err := open(aead, legacy); err == nil {
+				return plaintext, nil
+			}
+		}
+		return "", ErrUnknownKey
+	}
+
+	rest, ok := strings.CutPrefix(sealed, sealedPrefix)
+	if !ok {
+		return "", errors.New("value is not sealed")
+	}
+	keyID, encoded, ok := strings.Cut(rest, ":")
+	if !ok {
+		return "", errors.New("sealed value has no key ID")
+	}
+	aead, ok := s.keys[keyID]
+	if !ok {
+		return "", ErrUnknownKey
+	}
+
+	return open(aead, encoded)
+}
+
+// Current reports whether value is sealed with the primary key, so it needs
+// no re-sealing after a rotation.
+func (s *Sealer) Current(value string) bool {
+	return strings.HasPrefix(value, sealedPrefix+s.primary+":")
+}
+
+// IsSealed reports whether value looks like the output of Seal.
+func IsSealed(value string) bool {
+	return strings.HasPrefix(value, sealedPrefix) || strings.HasPrefix(value, legacySealedPrefix)
+}
+
+// Reseal returns value sealed with the primary key, sealing plaintext and
+// re-encrypting values sealed with older keys. changed is false when value was
+// already current.
+func (s *Sealer) Reseal(value string) (resealed string, changed bool, err error) {
+	if value == "" || s.Current(value) {
+		return value, false, nil
+	}
+
+	plaintext := value
+	if IsSealed(value) {
+		if plaintext, err = s.Open(value); err != nil {
+			return value, false, err
+		}
+	}
+
+	resealed, err = s.Seal(plaintext)
+	if err != nil {
+		return value, false, err
+	}
+	return resealed, true, nil
+}
+
+// LoadKeyring returns a sealer for the stored keyring, creating the keyring
+// with a new random master secret on first use. A non-empty seed is used as
+// the first master secret instead, so values sealed before the keyring
+// existed stay readable. A keyring not wrapped with wrap.Secret yet is
+// re-wrapped with it.
+func (s *Store) LoadKeyring(seed string, wrap KeyWrap) (*Sealer, error) {
+	keyring, stale, err := s.loadKeyring(wrap)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(keyring.Keys) == 0 {
+		secret := strings.TrimSpace(seed)
+		if secret == "" {
+			if secret, err = newMasterSecret(); err != nil {
+				return nil, err
+			}
+		}
+		keyring.Keys = []KeyringKey{{ID: KeyID(secret), Secret: secret, CreatedAt: time.Now().UTC()}}
+
+		created, err := s.createKeyring(keyring, wrap.Secret)
+		if err != nil {
+			return nil, err
+		}
+		if !created {
+			// Another server created the keyring first; use theirs.
+			if keyring, _, err = s.loadKeyring(wrap); err != nil {
+				return nil, err
+			}
+		}
+	} else if stale {
+		if err := s.saveKeyring(keyring, wrap.Secret); err != nil {
+			return nil, err
+		}
+	}
+
+	return keyring.sealer()
+}
+
+// RotateKeyring adds a new primary master secret. Older keys stay in the
+// keyring so existing values remain readable until RetireKeys is called.
+func (s *Store) RotateKeyring(wrap KeyWrap) (*Sealer, error) {
+	keyring, _, err := s.loadKeyring(wrap)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := newMasterSecret()
+	if err != nil {
+		return nil, err
+	}
+	keyring.Keys = append([]KeyringKey{{ID: KeyID(secret), Secret: secret, CreatedAt: time.Now().UTC()}}, keyring.Keys...)
+
+	if err := s.saveKeyring(keyring, wrap.Secret); err != nil {
+		return nil, err
+	}
+	return keyring.sealer()
+}
+
+// RetireKeys drops every key but the primary one. Call it only once all
+// values have been re-sealed.
+func (s *Store) RetireKeys(wrap KeyWrap) (*Sealer, error) {
+	keyring, _, err := s.loadKeyring(wrap)
+	if err != nil {
+		return nil, err
+	}
+	if len(keyring.Keys) > 1 {
+		keyring.Keys = keyring.Keys[:1]
+		if err := s.saveKeyring(keyring, wrap.Secret); err != nil {
+			return nil, err
+		}
+	}
+	return keyring.sealer()
+}
+
+func (k Keyring) sealer() (*Sealer, error) {
+	if len(k.Keys) == 0 {
+		return nil, ErrNoEncryptionKey
+	}
+
+	previous := make([]string, 0, len(k.Keys)-1)
+	for _, key := range k.Keys[1:] {
+		previous = append(previous, key.Secret)
+	}
+	return NewSealer(k.Keys[0].Secret, previous...)
+}
+
+// loadKeyring returns the stored keyring with its master secrets unwrapped.
+// stale reports whether any of them is not wrapped with wrap.Secret.
+func (s *Store) loadKeyring(wrap KeyWrap) (keyring Keyring, stale bool, err error) {
+	data, err := s.kv.Get(kvkeys.Keyring)
+	if err != nil {
+		return Keyring{}, false, fmt.Errorf("get keyring: %w", err)
+	}
+
+	if len(data) == 0 {
+		return keyring, false, nil
+	}
+	if err := json.Unmarshal(data, &keyring); err != nil {
+		return Keyring{}, false, fmt.Errorf("decode keyring: %w", err)
+	}
+
+	aeads := map[string]cipher.AEAD{}
+	for _, secret := range append([]string{wrap.Secret}, wrap.Previous...) {
+		if strings.TrimSpace(secret) == "" {
+			continue
+		}
+		aead, err := wrappingAEAD(secret)
+		if err != nil {
+			return Keyring{}, false, err
+		}
+		aeads[KeyID(secret)] = aead
+	}
+
+	current := ""
+	if strings.TrimSpace(wrap.Secret) != "" {
+		current = KeyID(wrap.Secret)
+	}
+	for i, key := range keyring.Keys {
+		rest, ok := strings.CutPrefix(key.Secret, wrappedPrefix)
+		if !ok {
+			stale = stale || current != ""
+			continue
+		}
+		wrapID, encoded, _ := strings.Cut(rest, ":")
+		aead, ok := aeads[wrapID]
+		if !ok {
+			return Keyring{}, false, ErrUnknownWrappingKey
+		}
+		if keyring.Keys[i].Secret, err = open(aead, encoded); err != nil {
+			return Keyring{}, false, fmt.Errorf("unwrap key %s: %w", key.ID, err)
+		}
+		stale = stale || wrapID != current
+	}
+
+	return keyring, stale, nil
+}
+
+// saveKeyring stores the keyring with its master secrets wrapped with
+// wrapSecret.
+func (s *Store) saveKeyring(keyring Keyring, wrapSecret string) error {
+	data, err := marshalKeyring(keyring, wrapSecret)
+	if err != nil {
+		return err
+	}
+
+	if err := s.kv.Set(kvkeys.Keyring, data); err != nil {
+		return fmt.Errorf("set keyring: %w", err)
+	}
+
+	return nil
+}
+
+// createKeyring stores a new keyring unless one already exists, using an
+// atomic write when the KV store supports it.
+func (s *Store) createKeyring(keyring Keyring, wrapSecret string) (bool, error) {
+	cas, ok := s.kv.(KVCompareAndSetter)
+	if !ok {
+		return true, s.saveKeyring(keyring, wrapSecret)
+	}
+
+	data, err := marshalKeyring(keyring, wrapSecret)
+	if err != nil {
+		return false, err
+	}
+
+	created, err := cas.CompareAndSet(kvkeys.Keyring, nil, data)
+	if err != nil {
+		return false, fmt.Errorf("create keyring: %w", err)
+	}
+	return created, nil
+}
+
+// marshalKeyring encodes the keyring with its master secrets wrapped with
+// wrapSecret, or as they are when wrapSecret is empty.
+func marshalKeyring(keyring Keyring, wrapSecret string) ([]byte, error) {
+	if strings.TrimSpace(wrapSecret) != "" {
+		aead, err := wrappingAEAD(wrapSecret)
+		if err != nil {
+			return nil, err
+		}
+		wrapped := make([]KeyringKey, len(keyring.Keys))
+		for i, key := range keyring.Keys {
+			nonce := make([]byte, aead.NonceSize())
+			if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+				return nil, fmt.Errorf("generate nonce: %w", err)
+			}
+			key.Secret = wrappedPrefix + KeyID(wrapSecret) + ":" + base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, []byte(key.Secret), nil))
+			wrapped[i] = key
+		}
+		keyring.Keys = wrapped
+	}
+
+	data, err := json.Marshal(keyring)
+	if err != nil {
+		return nil, fmt.Errorf("encode keyring: %w", err)
+	}
+	return data, nil
+}
+
+func wrappingAEAD(secret string) (cipher.AEAD, error) {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(wrapDerivationLabel))
+	return newAEAD(mac.Sum(nil))
+}
+
+// NewWrappingSecret returns a random secret to wrap the keyring with.
+func NewWrappingSecret() (string, error) {
+	return newMasterSecret()
+}
+
+func newMasterSecret() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, secret); err != nil {
+		return "", fmt.Errorf("generate master secret: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(secret), nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("create cipher: %w", err)
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("create GCM: %w", err)
+	}
+
+	return aead, nil
+}
+
+func open(aead cipher.AEAD, encoded string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", fmt.Errorf("decode sealed value: %w", err)
+	}
+	if len(data) < aead.NonceSize() {
+		return "", errors.New("sealed value is too short")
+	}
+
+	nonce, ciphertext := data[:aead.NonceSize()], data[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", fmt.Errorf("decrypt sealed value: %w", err)
+	}
+
+	return string(plaintext), nil
+}
+
+// redactedSecret replaces secrets removed by RedactSecrets.
+const redactedSecret = "[redacted]"
+
+// minRedactedLength keeps short values such as "1" or "true" from being
+// scrubbed out of unrelated text.
+const minRedactedLength = 6
+
+// RedactSecrets replaces every occurrence of the given secrets in text.
+func RedactSecrets(text string, secrets []string) string {
+	for _, secret := range secrets {
+		if len(secret) < minRedactedLength {
+			continue
+		}
+		text = strings.ReplaceAll(text, secret, redactedSecret)
+	}
+	return text
+}
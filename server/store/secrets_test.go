@@ -0,0 +1,185 @@
+package store
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/a-voronkov/mattermost-bugsnag/server/kvkeys"
+)
+
+func TestSealer(t *testing.T) {
+	if _, err := NewSealer(" "); !errors.Is(err, ErrNoEncryptionKey) {
+		t.Fatalf("expected ErrNoEncryptionKey for an empty secret, got %v", err)
+	}
+
+	sealer, err := NewSealer("master-secret")
+	if err != nil {
+		t.Fatalf("NewSealer() error = %v", err)
+	}
+
+	first, err := sealer.Seal("token-123")
+	if err != nil {
+		t.Fatalf("Seal() error = %v", err)
+	}
+	second, _ := sealer.Seal("token-123")
+	if first == second {
+		t.Error("expected a fresh nonce for every Seal call")
+	}
+	if strings.Contains(first, "token-123") || !IsSealed(first) || !sealer.Current(first) {
+		t.Errorf("unexpected sealed value %q", first)
+	}
+
+	plaintext, err := sealer.Open(first)
+	if err != nil || plaintext != "token-123" {
+		t.Errorf("Open() = %q, %v", plaintext, err)
+	}
+
+	other, _ := NewSealer("other-secret")
+	if _, err := other.Open(first); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("expected ErrUnknownKey for a different key, got %v", err)
+	}
+}
+
+func TestSealerRotation(t *testing.T) {
+	old, _ := NewSealer("old-secret")
+	sealedOld, _ := old.Seal("token-123")
+
+	rotated, _ := NewSealer("new-secret", "old-secret")
+	if rotated.Current(sealedOld) {
+		t.Fatal("a value sealed with the previous key must not count as current")
+	}
+	if plaintext, err := rotated.Open(sealedOld); err != nil || plaintext != "token-123" {
+		t.Fatalf("Open() with a previous key = %q, %v", plaintext, err)
+	}
+
+	resealed, changed, err := rotated.Reseal(sealedOld)
+	if err != nil || !changed || !rotated.Current(resealed) {
+		t.Fatalf("Reseal() = %q, %v, %v", resealed, changed, err)
+	}
+	if _, changed, _ := rotated.Reseal(resealed); changed {
+		t.Error("expected a current value to be left alone")
+	}
+
+	fromPlaintext, changed, err := rotated.Reseal("plain-token")
+	if err != nil || !changed || !rotated.Current(fromPlaintext) {
+		t.Errorf("Reseal(plaintext) = %q, %v, %v", fromPlaintext, changed, err)
+	}
+}
+
+func TestSealerOpensLegacyValues(t *testing.T) {
+	// Values written before the keyring existed were encrypted with the
+	// SHA-256 of the master secret and a "v1:" prefix.
+	key := sha256.Sum256([]byte("legacy-secret"))
+	block, _ := aes.NewCipher(key[:])
+	aead, _ := cipher.NewGCM(block)
+	nonce := make([]byte, aead.NonceSize())
+	legacy := "v1:" + base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, []byte("token-123"), nil))
+
+	sealer, _ := NewSealer("new-secret", "legacy-secret")
+	if plaintext, err := sealer.Open(legacy); err != nil || plaintext != "token-123" {
+		t.Fatalf("Open(legacy) = %q, %v", plaintext, err)
+	}
+
+	resealed, changed, err := sealer.Reseal(legacy)
+	if err != nil || !changed || !strings.HasPrefix(resealed, "enc:v2:") {
+		t.Errorf("Reseal(legacy) = %q, %v, %v", resealed, changed, err)
+	}
+}
+
+func TestKeyring(t *testing.T) {
+	kv := newMemoryKVStore()
+	s := New(kv)
+
+	sealer, err := s.LoadKeyring("", KeyWrap{})
+	if err != nil {
+		t.Fatalf("LoadKeyring() error = %v", err)
+	}
+	sealed, _ := sealer.Seal("token-123")
+
+	again, err := s.LoadKeyring("ignored-seed", KeyWrap{})
+	if err != nil || again.PrimaryKeyID() != sealer.PrimaryKeyID() {
+		t.Fatalf("expected the stored keyring to be reused, got %v, %v", again, err)
+	}
+
+	rotated, err := s.RotateKeyring(KeyWrap{})
+	if err != nil {
+		t.Fatalf("RotateKeyring() error = %v", err)
+	}
+	if rotated.PrimaryKeyID() == sealer.PrimaryKeyID() {
+		t.Fatal("expected a new primary key after rotation")
+	}
+	if plaintext, err := rotated.Open(sealed); err != nil || plaintext != "token-123" {
+		t.Fatalf("expected the old key to stay readable until retired, got %q, %v", plaintext, err)
+	}
+
+	retired, err := s.RetireKeys(KeyWrap{})
+	if err != nil {
+		t.Fatalf("RetireKeys() error = %v", err)
+	}
+	if _, err := retired.Open(sealed); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("expected the retired key to be gone, got %v", err)
+	}
+	if strings.Contains(string(kv.data[kvkeys.Keyring]), sealer.PrimaryKeyID()) {
+		t.Error("retired key still stored in the keyring")
+	}
+}
+
+func TestKeyringSeed(t *testing.T) {
+	s := New(newMemoryKVStore())
+
+	sealer, err := s.LoadKeyring("legacy-secret", KeyWrap{})
+	if err != nil {
+		t.Fatalf("LoadKeyring() error = %v", err)
+	}
+	if sealer.PrimaryKeyID() != KeyID("legacy-secret") {
+		t.Errorf("expected the seed to become the first master secret, got key %s", sealer.PrimaryKeyID())
+	}
+}
+
+func TestKeyringWrap(t *testing.T) {
+	kv := newMemoryKVStore()
+	s := New(kv)
+
+	unwrapped, err := s.LoadKeyring("legacy-secret", KeyWrap{})
+	if err != nil {
+		t.Fatalf("LoadKeyring() error = %v", err)
+	}
+
+	sealer, err := s.LoadKeyring("", KeyWrap{Secret: "old"})
+	if err != nil || sealer.PrimaryKeyID() != unwrapped.PrimaryKeyID() {
+		t.Fatalf("expected the unwrapped keyring to be kept, got %v, %v", sealer, err)
+	}
+	stored := string(kv.data[kvkeys.Keyring])
+	if strings.Contains(stored, "legacy-secret") || !strings.Contains(stored, wrappedPrefix+KeyID("old")+":") {
+		t.Fatalf("expected the master secret to be wrapped, got %s", stored)
+	}
+
+	for _, wrap := range []KeyWrap{{}, {Secret: "wrong"}} {
+		if _, err := s.LoadKeyring("", wrap); !errors.Is(err, ErrUnknownWrappingKey) {
+			t.Errorf("LoadKeyring(%+v) error = %v, want ErrUnknownWrappingKey", wrap, err)
+		}
+	}
+
+	rewrapped, err := s.LoadKeyring("", KeyWrap{Secret: "new", Previous: []string{"old"}})
+	if err != nil || rewrapped.PrimaryKeyID() != sealer.PrimaryKeyID() {
+		t.Fatalf("expected the keyring to be re-wrapped, got %v, %v", rewrapped, err)
+	}
+	if !strings.Contains(string(kv.data[kvkeys.Keyring]), wrappedPrefix+KeyID("new")+":") {
+		t.Errorf("expected the keyring to be wrapped with the new secret, got %s", kv.data[kvkeys.Keyring])
+	}
+	if _, err := s.LoadKeyring("", KeyWrap{Secret: "new"}); err != nil {
+		t.Errorf("LoadKeyring() with the new secret error = %v", err)
+	}
+}
+
+func TestRedactSecrets(t *testing.T) {
+	got := RedactSecrets(`{"token":"abc123secret","n":"1"}`, []string{"abc123secret", "1", ""})
+	if got != `{"token":"[redacted]","n":"1"}` {
+		t.Errorf("RedactSecrets() = %s", got)
+	}
+}
@@ -19,8 +19,10 @@ type ProjectChannelMapping struct {
 }
 
 // ActiveError represents the latest state of a Bugsnag error that should remain
-// in sync with Mattermost.
+// in sync with Mattermost. Errors seeded by a backfill have no PostID until a
+// webhook posts their card.
 type ActiveError struct {
+	ConnectionID string    `json:"connection_id,omitempty"`
 	ErrorID      string    `json:"error_id"`
 	ProjectID    string    `json:"project_id"`
 	PostID       string    `json:"post_id"`
@@ -110,6 +112,27 @@ func (s *Store) UpsertActiveError(active ActiveError) error {
 	return s.saveActiveErrors(activeErrors)
 }
 
+// RemoveActiveError stops tracking an error. Removing an untracked error is
+// not an error.
+func (s *Store) RemoveActiveError(projectID, errorID string) error {
+	activeErrors, err := s.loadActiveErrors()
+	if err != nil {
+		return err
+	}
+
+	kept := activeErrors[:0]
+	for _, existing := range activeErrors {
+		if existing.ProjectID != projectID || existing.ErrorID != errorID {
+			kept = append(kept, existing)
+		}
+	}
+	if len(kept) == len(activeErrors) {
+		return nil
+	}
+
+	return s.saveActiveErrors(kept)
+}
+
 // ListActiveErrors returns all active error records.
 func (s *Store) ListActiveErrors() ([]ActiveError, error) {
 	return s.loadActiveErrors()
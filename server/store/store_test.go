@@ -2,6 +2,8 @@ package store
 
 import (
 	"bytes"
+	"sort"
+	"strings"
 	"testing"
 	"time"
 
@@ -31,6 +33,24 @@ func (kv *memoryKVStore) Set(key string, value []byte) error {
 	return nil
 }
 
+func (kv *memoryKVStore) ListKeys(prefix string) ([]string, error) {
+	var keys []string
+	for key := range kv.data {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (kv *memoryKVStore) CompareAndSet(key string, old, value []byte) (bool, error) {
+	if current, ok := kv.data[key]; ok != (old != nil) || !bytes.Equal(current, old) {
+		return false, nil
+	}
+	return true, kv.Set(key, value)
+}
+
 func TestProjectChannelMappings(t *testing.T) {
 	kv := newMemoryKVStore()
 	s := New(kv)
@@ -149,6 +169,21 @@ func TestActiveErrors(t *testing.T) {
 	if !found {
 		t.Fatalf("updated active error was not found")
 	}
+
+	if err := s.RemoveActiveError("proj2", "err2"); err != nil {
+		t.Fatalf("remove active error: %v", err)
+	}
+	if err := s.RemoveActiveError("proj2", "missing"); err != nil {
+		t.Fatalf("remove untracked error: %v", err)
+	}
+
+	activeErrors, err = s.ListActiveErrors()
+	if err != nil {
+		t.Fatalf("list active errors after removal: %v", err)
+	}
+	if len(activeErrors) != 1 || activeErrors[0].ErrorID != "err1" {
+		t.Fatalf("expected only err1 to remain, got %+v", activeErrors)
+	}
 }
 
 func TestStoreSerializationIsolation(t *testing.T) {
@@ -0,0 +1,197 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/a-voronkov/mattermost-bugsnag/server/kvkeys"
+)
+
+// UserToken is a Bugsnag personal token a Mattermost user linked for one
+// connection, so card actions run under their own Bugsnag identity.
+type UserToken struct {
+	ConnectionID string `json:"connection_id"`
+	// Token is sealed in KV and only decrypted by GetUserToken.
+	Token        string    `json:"token,omitempty"`
+	BugsnagID    string    `json:"bugsnag_id,omitempty"`
+	BugsnagName  string    `json:"bugsnag_name,omitempty"`
+	BugsnagEmail string    `json:"bugsnag_email,omitempty"`
+	SavedAt      time.Time `json:"saved_at"`
+}
+
+// SaveUserToken encrypts token.Token and stores it for the user, replacing any
+// token saved for the same connection.
+func (s *Store) SaveUserToken(sealer *Sealer, userID string, token UserToken) error {
+	if sealer == nil {
+		return ErrNoEncryptionKey
+	}
+
+	sealed, err := sealer.Seal(token.Token)
+	if err != nil {
+		return err
+	}
+	token.Token = sealed
+
+	tokens, err := s.loadUserTokens(userID)
+	if err != nil {
+		return err
+	}
+
+	updated := false
+	for i, existing := range tokens {
+		if existing.ConnectionID == token.ConnectionID {
+			tokens[i] = token
+			updated = true
+			break
+		}
+	}
+	if !updated {
+		tokens = append(tokens, token)
+	}
+
+	return s.saveUserTokens(userID, tokens)
+}
+
+// GetUserToken returns the user's decrypted token for a connection. The
+// boolean is false when the user has not saved one.
+func (s *Store) GetUserToken(sealer *Sealer, userID, connectionID string) (UserToken, bool, error) {
+	tokens, err := s.loadUserTokens(userID)
+	if err != nil {
+		return UserToken{}, false, err
+	}
+
+	for _, token := range tokens {
+		if token.ConnectionID != connectionID {
+			continue
+		}
+		if sealer == nil {
+			return UserToken{}, false, ErrNoEncryptionKey
+		}
+		plaintext, err := sealer.Open(token.Token)
+		if err != nil {
+			return UserToken{}, false, fmt.Errorf("open user token: %w", err)
+		}
+		token.Token = plaintext
+		return token, true, nil
+	}
+
+	return UserToken{}, false, nil
+}
+
+// ListUserTokens returns the connections the user linked a token for, without
+// the token values.
+func (s *Store) ListUserTokens(userID string) ([]UserToken, error) {
+	tokens, err := s.loadUserTokens(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range tokens {
+		tokens[i].Token = ""
+	}
+
+	return tokens, nil
+}
+
+// DeleteUserToken removes the user's token for a connection and reports
+// whether one was stored.
+func (s *Store) DeleteUserToken(userID, connectionID string) (bool, error) {
+	tokens, err := s.loadUserTokens(userID)
+	if err != nil {
+		return false, err
+	}
+
+	kept := tokens[:0]
+	for _, token := range tokens {
+		if token.ConnectionID != connectionID {
+			kept = append(kept, token)
+		}
+	}
+	if len(kept) == len(tokens) {
+		return false, nil
+	}
+
+	return true, s.saveUserTokens(userID, kept)
+}
+
+// KVLister is implemented by KV stores that can enumerate their keys.
+type KVLister interface {
+	ListKeys(prefix string) ([]string, error)
+}
+
+// ResealUserTokens re-encrypts every stored user token that is not sealed with
+// the sealer's primary key and returns how many were rewritten.
+func (s *Store) ResealUserTokens(sealer *Sealer) (int, error) {
+	lister, ok := s.kv.(KVLister)
+	if !ok {
+		return 0, fmt.Errorf("KV store cannot list user tokens")
+	}
+
+	keys, err := lister.ListKeys(kvkeys.UserTokenPrefix)
+	if err != nil {
+		return 0, fmt.Errorf("list user tokens: %w", err)
+	}
+
+	resealed := 0
+	for _, key := range keys {
+		userID := strings.TrimPrefix(key, kvkeys.UserTokenPrefix)
+		tokens, err := s.loadUserTokens(userID)
+		if err != nil {
+			return resealed, err
+		}
+
+		changed := 0
+		for i, token := range tokens {
+			sealed, ok, err := sealer.Reseal(token.Token)
+			if err != nil {
+				return resealed, fmt.Errorf("reseal token of user %s: %w", userID, err)
+			}
+			if ok {
+				tokens[i].Token = sealed
+				changed++
+			}
+		}
+
+		if changed > 0 {
+			if err := s.saveUserTokens(userID, tokens); err != nil {
+				return resealed, err
+			}
+			resealed += changed
+		}
+	}
+
+	return resealed, nil
+}
+
+func (s *Store) loadUserTokens(userID string) ([]UserToken, error) {
+	data, err := s.kv.Get(kvkeys.UserTokenPrefix + userID)
+	if err != nil {
+		return nil, fmt.Errorf("get user tokens: %w", err)
+	}
+
+	if len(data) == 0 {
+		return []UserToken{}, nil
+	}
+
+	var tokens []UserToken
+	if err := json.Unmarshal(data, &tokens); err != nil {
+		return nil, fmt.Errorf("decode user tokens: %w", err)
+	}
+
+	return tokens, nil
+}
+
+func (s *Store) saveUserTokens(userID string, tokens []UserToken) error {
+	data, err := json.Marshal(tokens)
+	if err != nil {
+		return fmt.Errorf("encode user tokens: %w", err)
+	}
+
+	if err := s.kv.Set(kvkeys.UserTokenPrefix+userID, data); err != nil {
+		return fmt.Errorf("set user tokens: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,88 @@
+package store
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/a-voronkov/mattermost-bugsnag/server/kvkeys"
+)
+
+func TestUserTokens(t *testing.T) {
+	kv := newMemoryKVStore()
+	s := New(kv)
+	sealer, _ := NewSealer("master-secret")
+
+	if err := s.SaveUserToken(nil, "user-1", UserToken{ConnectionID: "default", Token: "t"}); err != ErrNoEncryptionKey {
+		t.Fatalf("expected ErrNoEncryptionKey without a sealer, got %v", err)
+	}
+
+	for _, token := range []UserToken{
+		{ConnectionID: "default", Token: "old", BugsnagEmail: "jane@example.com"},
+		{ConnectionID: "default", Token: "personal-token", BugsnagEmail: "jane@example.com"},
+		{ConnectionID: "mobile", Token: "mobile-token"},
+	} {
+		if err := s.SaveUserToken(sealer, "user-1", token); err != nil {
+			t.Fatalf("SaveUserToken() error = %v", err)
+		}
+	}
+
+	if raw := string(kv.data[kvkeys.UserTokenPrefix+"user-1"]); strings.Contains(raw, "personal-token") {
+		t.Fatalf("token stored in plaintext: %s", raw)
+	}
+
+	token, ok, err := s.GetUserToken(sealer, "user-1", "default")
+	if err != nil || !ok || token.Token != "personal-token" || token.BugsnagEmail != "jane@example.com" {
+		t.Errorf("GetUserToken(default) = %+v, %v, %v", token, ok, err)
+	}
+	if _, ok, _ := s.GetUserToken(sealer, "user-2", "default"); ok {
+		t.Error("expected no token for another user")
+	}
+
+	listed, err := s.ListUserTokens("user-1")
+	if err != nil || len(listed) != 2 {
+		t.Fatalf("ListUserTokens() = %+v, %v", listed, err)
+	}
+	for _, token := range listed {
+		if token.Token != "" {
+			t.Errorf("ListUserTokens must not return token values, got %+v", token)
+		}
+	}
+
+	if removed, err := s.DeleteUserToken("user-1", "mobile"); err != nil || !removed {
+		t.Errorf("DeleteUserToken(mobile) = %v, %v", removed, err)
+	}
+	if removed, _ := s.DeleteUserToken("user-1", "mobile"); removed {
+		t.Error("expected a second delete to report nothing removed")
+	}
+	if _, ok, _ := s.GetUserToken(sealer, "user-1", "mobile"); ok {
+		t.Error("expected the mobile token to be gone")
+	}
+}
+
+func TestResealUserTokens(t *testing.T) {
+	s := New(newMemoryKVStore())
+	old, _ := NewSealer("old-secret")
+
+	if err := s.SaveUserToken(old, "user-1", UserToken{ConnectionID: "default", Token: "personal-token"}); err != nil {
+		t.Fatalf("SaveUserToken() error = %v", err)
+	}
+	if err := s.SaveUserToken(old, "user-2", UserToken{ConnectionID: "default", Token: "other-token"}); err != nil {
+		t.Fatalf("SaveUserToken() error = %v", err)
+	}
+
+	rotated, _ := NewSealer("new-secret", "old-secret")
+	resealed, err := s.ResealUserTokens(rotated)
+	if err != nil || resealed != 2 {
+		t.Fatalf("ResealUserTokens() = %d, %v; want 2", resealed, err)
+	}
+
+	current, _ := NewSealer("new-secret")
+	token, ok, err := s.GetUserToken(current, "user-1", "default")
+	if err != nil || !ok || token.Token != "personal-token" {
+		t.Errorf("expected the token to open with the new key only, got %+v, %v, %v", token, ok, err)
+	}
+
+	if resealed, _ := s.ResealUserTokens(rotated); resealed != 0 {
+		t.Errorf("expected nothing left to reseal, got %d", resealed)
+	}
+}
@@ -0,0 +1,386 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/a-voronkov/mattermost-bugsnag/server/bugsnag"
+	"github.com/a-voronkov/mattermost-bugsnag/server/connection"
+	"github.com/a-voronkov/mattermost-bugsnag/server/formatter"
+	"github.com/a-voronkov/mattermost-bugsnag/server/metrics"
+	"github.com/a-voronkov/mattermost-bugsnag/server/store"
+	"github.com/a-voronkov/mattermost-bugsnag/server/tracker"
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// actionCreateTicket is the card action that opens the ticket dialog.
+const actionCreateTicket = "create_ticket"
+
+// ticketDialogPath receives submitted ticket dialogs.
+const ticketDialogPath = "/dialogs/ticket"
+
+const (
+	// ticketTitleMaxRunes is the Jira summary limit, which GitHub titles
+	// comfortably fit in as well.
+	ticketTitleMaxRunes = 255
+	// ticketDescriptionMaxRunes is the size of the dialog's description box.
+	ticketDescriptionMaxRunes = 6000
+	// ticketStacktraceFrames limits the prefilled stacktrace per exception.
+	ticketStacktraceFrames = 20
+	// ticketClaimTTL bounds how long a submission holds an error while it
+	// creates the ticket, in case it never releases it.
+	ticketClaimTTL = time.Minute
+)
+
+// ticketDialogState travels with the dialog to identify the error.
+type ticketDialogState struct {
+	ProjectID string `json:"project_id"`
+	ErrorID   string `json:"error_id"`
+}
+
+// handleCreateTicketAction opens the ticket dialog, prefilled from the card.
+func (p *Plugin) handleCreateTicketAction(w http.ResponseWriter, payload model.PostActionIntegrationRequest) {
+	errorID, _ := payload.Context["error_id"].(string)
+	projectID, _ := payload.Context["project_id"].(string)
+
+	respond := func(text string) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(model.PostActionIntegrationResponse{EphemeralText: text})
+	}
+
+	cfg := p.getConfiguration()
+	mm := newMMClient(p.API, cfg.EnableDebugLog, p.kvNS(), p.botUserID)
+
+	trackers := cfg.issueTrackers()
+	if len(trackers) == 0 {
+		respond("No issue tracker is configured.")
+		return
+	}
+
+	var mapping ErrorPostMapping
+	if found, _ := mm.LoadJSON(errorPostKVKey(projectID, errorID), &mapping); !found {
+		respond("This card is no longer tracked, so a ticket cannot be created from it.")
+		return
+	}
+	if mapping.TicketURL != "" {
+		respond(fmt.Sprintf("A ticket is already linked to this error: %s", mapping.TicketURL))
+		return
+	}
+
+	post, appErr := mm.GetPost(mapping.PostID)
+	if appErr != nil {
+		http.Error(w, "card not found", http.StatusNotFound)
+		return
+	}
+	data, _ := formatter.CardData(post)
+
+	defaultTracker := ""
+	if rules, err := loadChannelRules(mm); err == nil {
+		if rule, ok := ruleForCard(rules, mapping); ok {
+			defaultTracker = rule.IssueTracker
+		}
+	}
+	if t, ok := tracker.Find(trackers, defaultTracker); ok {
+		defaultTracker = t.ID
+	} else {
+		defaultTracker = trackers[0].ID
+	}
+
+	state, _ := json.Marshal(ticketDialogState{ProjectID: projectID, ErrorID: errorID})
+	request := model.OpenDialogRequest{
+		TriggerId: payload.TriggerId,
+		URL:       fmt.Sprintf("/plugins/%s%s", pluginID, ticketDialogPath),
+		Dialog: ticketDialog(trackers, defaultTracker, ticketTitle(data),
+			p.ticketDescription(cfg, mm, payload.UserId, mapping, data), string(state)),
+	}
+	if appErr := p.API.OpenInteractiveDialog(request); appErr != nil {
+		p.API.LogWarn("failed to open ticket dialog", "error_id", errorID, "err", appErr.Error())
+		respond("Could not open the ticket dialog: " + appErr.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(model.PostActionIntegrationResponse{})
+}
+
+// ticketDialog builds the dialog that creates a ticket.
+func ticketDialog(trackers []tracker.Tracker, defaultTracker, title, description, state string) model.Dialog {
+	options := make([]*model.PostActionOptions, 0, len(trackers))
+	for _, t := range trackers {
+		kind := "Jira"
+		if t.Type == tracker.TypeGitHub {
+			kind = "GitHub"
+		}
+		options = append(options, &model.PostActionOptions{Text: fmt.Sprintf("%s (%s)", t.DisplayName(), kind), Value: t.ID})
+	}
+
+	return model.Dialog{
+		CallbackId:  actionCreateTicket,
+		Title:       "Create ticket",
+		SubmitLabel: "Create",
+		State:       state,
+		Elements: []model.DialogElement{
+			{DisplayName: "Tracker", Name: "tracker", Type: "select", Options: options, Default: defaultTracker},
+			{DisplayName: "Title", Name: "title", Type: "text", Default: title, MaxLength: ticketTitleMaxRunes},
+			{DisplayName: "Description", Name: "description", Type: "textarea", Default: description, Optional: true, MaxLength: ticketDescriptionMaxRunes},
+		},
+	}
+}
+
+// ticketTitle prefills the ticket title from the card.
+func ticketTitle(data formatter.ErrorData) string {
+	title := strings.TrimSpace(data.ExceptionClass)
+	if message := strings.TrimSpace(data.Message); message != "" {
+		if title != "" {
+			title += ": "
+		}
+		title += message
+	}
+	if title == "" {
+		title = strings.TrimSpace(data.Summary)
+	}
+	return truncateText(strings.Join(strings.Fields(title), " "), ticketTitleMaxRunes)
+}
+
+// ticketDescription prefills the ticket description with the error message,
+// the stacktrace of the latest event and links back to Bugsnag and the card.
+func (p *Plugin) ticketDescription(cfg Configuration, mm *MMClient, userID string, mapping ErrorPostMapping, data formatter.ErrorData) string {
+	var links []string
+	if data.ErrorURL != "" {
+		links = append(links, fmt.Sprintf("[Open in Bugsnag](%s)", data.ErrorURL))
+	}
+	if link := mm.Permalink(mapping.PostID); link != "" {
+		links = append(links, fmt.Sprintf("[Mattermost thread](%s)", link))
+	}
+
+	var sections []string
+	if message := strings.TrimSpace(data.Message); message != "" {
+		sections = append(sections, message)
+	}
+	footer := strings.Join(links, "\n")
+
+	if trace := p.latestStacktrace(cfg, userID, mapping); trace != "" {
+		budget := ticketDescriptionMaxRunes - len([]rune(strings.Join(sections, "\n\n")+footer)) - 16
+		if budget > 0 {
+			sections = append(sections, "```\n"+truncateText(trace, budget)+"\n```")
+		}
+	}
+	if footer != "" {
+		sections = append(sections, footer)
+	}
+
+	return truncateText(strings.Join(sections, "\n\n"), ticketDescriptionMaxRunes)
+}
+
+// latestStacktrace renders the stacktrace of the error's latest event, or
+// returns an empty string when Bugsnag cannot be reached.
+func (p *Plugin) latestStacktrace(cfg Configuration, userID string, mapping ErrorPostMapping) string {
+	client, err := p.ticketBugsnagClient(cfg, userID, mapping)
+	if err != nil {
+		return ""
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	event, err := client.GetLatestEvent(ctx, mapping.ProjectID, mapping.ErrorID)
+	if err != nil {
+		p.API.LogDebug("failed to load latest event for ticket", "error_id", mapping.ErrorID, "err", err.Error())
+		return ""
+	}
+
+	exceptions := make([]exceptionInfo, 0, len(event.Exceptions))
+	for _, exception := range event.Exceptions {
+		frames := make([]stackFrame, 0, len(exception.Stacktrace))
+		for _, frame := range exception.Stacktrace {
+			frames = append(frames, stackFrame{
+				File:         frame.File,
+				Method:       frame.Method,
+				LineNumber:   frame.LineNumber,
+				ColumnNumber: frame.ColumnNumber,
+				InProject:    frame.InProject,
+				Code:         frame.Code,
+			})
+		}
+		exceptions = append(exceptions, exceptionInfo{ErrorClass: exception.ErrorClass, Message: exception.Message, Stacktrace: frames})
+	}
+	return formatter.StacktraceText(toFormatterExceptions(exceptions), ticketStacktraceFrames)
+}
+
+// ticketBugsnagClient returns the client that acts for userID on the card's
+// connection.
+func (p *Plugin) ticketBugsnagClient(cfg Configuration, userID string, mapping ErrorPostMapping) (*bugsnag.Client, error) {
+	conn, _ := connection.Find(cfg.AllConnections(), mapping.ConnectionID)
+	token, personal := p.actionToken(cfg, userID, conn)
+	if token == "" {
+		return nil, fmt.Errorf("no Bugsnag token for user")
+	}
+	return p.bugsnagClient(token, personal)
+}
+
+// handleTicketDialog creates the ticket submitted from the dialog, links it
+// to the card and to the Bugsnag error.
+func (p *Plugin) handleTicketDialog(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request model.SubmitDialogRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "invalid dialog submission", http.StatusBadRequest)
+		return
+	}
+	// The server sets the header on submissions it routes to the plugin, so
+	// the submission cannot create tickets for someone else.
+	if userID := r.Header.Get("Mattermost-User-ID"); userID == "" || userID != request.UserId {
+		http.Error(w, "not authorized", http.StatusUnauthorized)
+		return
+	}
+	if request.Cancelled {
+		return
+	}
+
+	respond := func(response model.SubmitDialogResponse) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(response)
+	}
+
+	var state ticketDialogState
+	if err := json.Unmarshal([]byte(request.State), &state); err != nil || state.ErrorID == "" {
+		http.Error(w, "invalid dialog state", http.StatusBadRequest)
+		return
+	}
+	trackerID, _ := request.Submission["tracker"].(string)
+	title, _ := request.Submission["title"].(string)
+	description, _ := request.Submission["description"].(string)
+	if strings.TrimSpace(title) == "" {
+		respond(model.SubmitDialogResponse{Errors: map[string]string{"title": "A title is required."}})
+		return
+	}
+
+	cfg := p.getConfiguration()
+	mm := newMMClient(p.API, cfg.EnableDebugLog, p.kvNS(), p.botUserID)
+
+	t, ok := tracker.Find(cfg.issueTrackers(), trackerID)
+	if !ok || trackerID == "" {
+		respond(model.SubmitDialogResponse{Errors: map[string]string{"tracker": "This tracker is no longer configured."}})
+		return
+	}
+
+	user, appErr := mm.GetUser(request.UserId)
+	if appErr != nil {
+		http.Error(w, "invalid user", http.StatusBadRequest)
+		return
+	}
+
+	// Claim the error before creating the issue so two submissions cannot
+	// both create a ticket for it.
+	claimKey := KVKeyTicketClaimPrefix + state.ProjectID + ":" + state.ErrorID
+	claimed, appErr := mm.Claim(claimKey, ticketClaimTTL)
+	if appErr != nil {
+		http.Error(w, "failed to claim the error", http.StatusInternalServerError)
+		return
+	}
+	if !claimed {
+		respond(model.SubmitDialogResponse{Error: "A ticket is already being created for this error."})
+		return
+	}
+	defer mm.Release(claimKey)
+
+	var mapping ErrorPostMapping
+	if found, _ := mm.LoadJSON(errorPostKVKey(state.ProjectID, state.ErrorID), &mapping); !found {
+		respond(model.SubmitDialogResponse{Error: "This card is no longer tracked."})
+		return
+	}
+	if mapping.TicketURL != "" {
+		respond(model.SubmitDialogResponse{Error: "A ticket is already linked to this error: " + mapping.TicketURL})
+		return
+	}
+
+	audit := store.AuditRecord{
+		Source:    store.AuditSourceCard,
+		Action:    actionCreateTicket,
+		UserID:    user.Id,
+		Username:  user.Username,
+		ProjectID: state.ProjectID,
+		ErrorID:   state.ErrorID,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	issue, err := p.trackerClient().CreateIssue(ctx, t, user.Id, tracker.NewIssue{
+		Title:       strings.TrimSpace(title),
+		Description: strings.TrimSpace(description),
+		PostID:      mapping.PostID,
+		ChannelID:   mapping.ChannelID,
+		TeamID:      request.TeamId,
+	})
+	if err != nil {
+		p.API.LogWarn("failed to create ticket", "tracker_id", t.ID, "error_id", state.ErrorID, "err", err.Error())
+		metrics.Actions.Inc(actionCreateTicket, metrics.ActionFailure)
+		audit.Response = err.Error()
+		p.recordAudit(audit)
+		respond(model.SubmitDialogResponse{Error: "Could not create the ticket: " + err.Error()})
+		return
+	}
+
+	// The mapping may have changed while the issue was being created, so only
+	// the ticket is written back.
+	if _, appErr := mm.UpdateJSON(errorPostKVKey(mapping.ProjectID, mapping.ErrorID), &mapping, func() {
+		mapping.TicketKey = issue.Key
+		mapping.TicketURL = issue.URL
+	}); appErr != nil {
+		p.API.LogWarn("failed to store ticket", "error_id", state.ErrorID, "ticket", issue.Key, "err", appErr.Error())
+	}
+	mapping = p.acknowledgeEscalation(mm, mapping, "acknowledged by @"+user.Username)
+
+	p.updateCardData(mm, mapping, func(data *formatter.ErrorData) {
+		data.Ticket = &formatter.Ticket{Key: issue.Key, URL: issue.URL}
+	})
+
+	reply := fmt.Sprintf("🎫 **Ticket**: @%s created [%s](%s) in %s.", user.Username, issue.Key, issue.URL, t.DisplayName())
+	if _, appErr := mm.CreateReply(mapping.ChannelID, mapping.PostID, reply); appErr != nil {
+		mm.LogDebug("failed to link ticket from the card", "err", appErr.Error())
+	}
+
+	p.linkBugsnagIssue(cfg, user.Id, mapping, issue)
+
+	metrics.Actions.Inc(actionCreateTicket, metrics.ActionSuccess)
+	audit.Success = true
+	audit.Response = "created ticket " + issue.Key
+	p.recordAudit(audit)
+	p.API.LogInfo("ticket created", "tracker_id", t.ID, "ticket", issue.Key, "error_id", state.ErrorID)
+}
+
+// linkBugsnagIssue links the ticket to the Bugsnag error. When Bugsnag
+// refuses the link, for example because the project has no issue tracker
+// integration, the ticket is added as a comment instead.
+func (p *Plugin) linkBugsnagIssue(cfg Configuration, userID string, mapping ErrorPostMapping, issue *tracker.Issue) {
+	client, err := p.ticketBugsnagClient(cfg, userID, mapping)
+	if err != nil {
+		p.API.LogDebug("cannot link ticket in Bugsnag", "error_id", mapping.ErrorID, "err", err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err = client.LinkIssue(ctx, mapping.ProjectID, mapping.ErrorID, issue.URL)
+	if err == nil {
+		return
+	}
+	p.API.LogDebug("failed to link ticket in Bugsnag, adding a comment", "error_id", mapping.ErrorID, "err", err.Error())
+
+	comment := fmt.Sprintf("Tracked in %s: %s", issue.Key, issue.URL)
+	if err := client.CreateComment(ctx, mapping.ProjectID, mapping.ErrorID, comment); err != nil {
+		p.API.LogWarn("failed to add ticket to Bugsnag error", "error_id", mapping.ErrorID, "err", err.Error())
+	}
+}
+
+// trackerClient returns the client that creates tickets.
+func (p *Plugin) trackerClient() *tracker.Client {
+	return &tracker.Client{HTTPClient: &http.Client{Timeout: 15 * time.Second}, Plugins: p.API}
+}
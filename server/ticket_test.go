@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/a-voronkov/mattermost-bugsnag/server/bugsnag"
+	"github.com/a-voronkov/mattermost-bugsnag/server/formatter"
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/stretchr/testify/mock"
+)
+
+// ticketRule preselects the "app" tracker.
+var ticketRule = ChannelRule{ID: "r1", ProjectID: "proj-1", ChannelID: "chan-1", IssueTracker: "app"}
+
+// newTicketBugsnag starts a Bugsnag API that serves the latest event and
+// records every request.
+func newTicketBugsnag(t *testing.T) (*bugsnag.Factory, *[]string) {
+	t.Helper()
+
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests = append(requests, r.Method+" "+r.URL.Path+" "+strings.TrimSpace(string(body)))
+		if r.URL.Path == "/projects/proj-1/errors/err-1/latest_event" {
+			_, _ = w.Write([]byte(`{"id":"event-1","exceptions":[{"errorClass":"NoMethodError","message":"undefined method","stacktrace":[{"file":"app/models/user.rb","method":"name","lineNumber":12,"inProject":true}]}]}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	t.Cleanup(server.Close)
+
+	clients, err := bugsnag.NewFactory(bugsnag.Settings{APIURL: server.URL})
+	if err != nil {
+		t.Fatalf("NewFactory() error = %v", err)
+	}
+	return clients, &requests
+}
+
+const ticketTrackers = `[{"id":"ops","type":"jira","url":"https://acme.atlassian.net","project":"OPS","token":"jira-token"},{"id":"app","name":"App","type":"github","repository":"acme/app","plugin":true}]`
+
+func TestHandleCreateTicketActionOpensDialog(t *testing.T) {
+	clients, _ := newTicketBugsnag(t)
+
+	api := cardAPI(t, ErrorPostMapping{ProjectID: "proj-1", ErrorID: "err-1", ChannelID: "chan-1", PostID: "post-1"}, ticketRule)
+	api.On("OpenInteractiveDialog", mock.MatchedBy(func(request model.OpenDialogRequest) bool {
+		dialog := request.Dialog
+		description := dialog.Elements[2].Default
+		return request.TriggerId == "trigger-1" && request.URL == "/plugins/"+pluginID+ticketDialogPath &&
+			dialog.State == `{"project_id":"proj-1","error_id":"err-1"}` &&
+			dialog.Elements[0].Default == "app" && len(dialog.Elements[0].Options) == 2 &&
+			dialog.Elements[1].Default == "NoMethodError: undefined method `name' for nil" &&
+			strings.Contains(description, "```\nNoMethodError: undefined method\n→ app/models/user.rb:12 in name") &&
+			strings.Contains(description, "[Open in Bugsnag](https://app.bugsnag.com/acme/app/errors/err-1)") &&
+			strings.Contains(description, "[Mattermost thread](https://mm.example.com/_redirect/pl/post-1)")
+	})).Return(nil).Once()
+
+	p := &Plugin{}
+	p.SetAPI(api)
+	p.kvNamespace = pluginID
+	p.configuration.Store(&Configuration{BugsnagAPIToken: "shared-token", IssueTrackers: ticketTrackers})
+	p.clients.Store(clients)
+
+	body, _ := json.Marshal(model.PostActionIntegrationRequest{
+		UserId:    "user-1",
+		ChannelId: "chan-1",
+		TriggerId: "trigger-1",
+		Context:   map[string]any{"action": actionCreateTicket, "error_id": "err-1", "project_id": "proj-1"},
+	})
+	rr := httptest.NewRecorder()
+	p.handleActions(rr, actionRequest(body, "user-1"))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d: %s", rr.Code, rr.Body.String())
+	}
+	api.AssertExpectations(t)
+}
+
+func TestHandleTicketDialogCreatesTicket(t *testing.T) {
+	clients, bugsnagRequests := newTicketBugsnag(t)
+	github := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		if r.URL.Path != "/repos/acme/app/issues" || body["title"] != "NoMethodError in User#name" {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		_, _ = w.Write([]byte(`{"number":7,"html_url":"https://github.com/acme/app/issues/7"}`))
+	}))
+	t.Cleanup(github.Close)
+
+	api := cardAPI(t, ErrorPostMapping{ProjectID: "proj-1", ErrorID: "err-1", ChannelID: "chan-1", PostID: "post-1"}, ticketRule)
+	api.On("GetUser", "user-1").Return(&model.User{Id: "user-1", Username: "jane"}, nil)
+	claimKey := pluginID + ":" + KVKeyTicketClaimPrefix + "proj-1:err-1"
+	api.On("KVSetWithOptions", claimKey, []byte("1"), model.PluginKVSetOptions{Atomic: true, ExpireInSeconds: 60}).Return(true, nil).Once()
+	api.On("KVDelete", claimKey).Return(nil).Once()
+	api.On("KVSetWithOptions", pluginID+":"+errorPostKVKey("proj-1", "err-1"), mock.MatchedBy(func(data []byte) bool {
+		var mapping ErrorPostMapping
+		return json.Unmarshal(data, &mapping) == nil && mapping.TicketKey == "acme/app#7" && mapping.TicketURL == "https://github.com/acme/app/issues/7"
+	}), mock.MatchedBy(func(options model.PluginKVSetOptions) bool {
+		return options.Atomic && options.OldValue != nil
+	})).Return(true, nil).Once()
+	api.On("UpdatePost", mock.MatchedBy(func(post *model.Post) bool {
+		data, _ := formatter.CardData(post)
+		return data.Ticket != nil && data.Ticket.Key == "acme/app#7"
+	})).Return(&model.Post{Id: "post-1"}, nil).Once()
+	api.On("CreatePost", mock.MatchedBy(func(post *model.Post) bool {
+		return post.RootId == "post-1" && strings.Contains(post.Message, "@jane created [acme/app#7](https://github.com/acme/app/issues/7) in GitHub")
+	})).Return(&model.Post{Id: "reply-1"}, nil).Once()
+	api.On("KVGet", pluginID+":"+KVKeyAuditLog).Return(nil, nil)
+	api.On("KVSet", pluginID+":"+KVKeyAuditLog, mock.Anything).Return(nil).Once()
+	api.On("LogInfo", "ticket created", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return().Once()
+
+	p := &Plugin{}
+	p.SetAPI(api)
+	p.kvNamespace = pluginID
+	trackers := `[{"id":"gh","name":"GitHub","type":"github","url":"` + github.URL + `","repository":"acme/app","token":"ghp"}]`
+	p.configuration.Store(&Configuration{BugsnagAPIToken: "shared-token", IssueTrackers: trackers})
+	p.clients.Store(clients)
+
+	submit := func(userHeader string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(model.SubmitDialogRequest{
+			UserId:     "user-1",
+			TeamId:     "team-1",
+			State:      `{"project_id":"proj-1","error_id":"err-1"}`,
+			Submission: map[string]any{"tracker": "gh", "title": "NoMethodError in User#name", "description": "Boom"},
+		})
+		req := httptest.NewRequest(http.MethodPost, ticketDialogPath, bytes.NewReader(body))
+		req.Header.Set("Mattermost-User-ID", userHeader)
+		rr := httptest.NewRecorder()
+		p.ServeHTTP(nil, rr, req)
+		return rr
+	}
+
+	if rr := submit("someone-else"); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected a submission for another user to be rejected, got %d", rr.Code)
+	}
+
+	rr := submit("user-1")
+	if rr.Code != http.StatusOK || strings.Contains(rr.Body.String(), "error") {
+		t.Fatalf("unexpected response %d: %s", rr.Code, rr.Body.String())
+	}
+
+	want := `PATCH /projects/proj-1/errors/err-1 {"issue_url":"https://github.com/acme/app/issues/7","operation":"link_issue","verify_issue_url":false}`
+	if len(*bugsnagRequests) != 1 || (*bugsnagRequests)[0] != want {
+		t.Errorf("Bugsnag requests = %v, want [%s]", *bugsnagRequests, want)
+	}
+	api.AssertExpectations(t)
+}
+
+func TestHandleTicketDialogRefusesClaimedError(t *testing.T) {
+	api := cardAPI(t, ErrorPostMapping{ProjectID: "proj-1", ErrorID: "err-1", ChannelID: "chan-1", PostID: "post-1"}, ticketRule)
+	api.On("GetUser", "user-1").Return(&model.User{Id: "user-1", Username: "jane"}, nil)
+	api.On("KVSetWithOptions", pluginID+":"+KVKeyTicketClaimPrefix+"proj-1:err-1", []byte("1"), mock.Anything).Return(false, nil).Once()
+
+	p := &Plugin{}
+	p.SetAPI(api)
+	p.kvNamespace = pluginID
+	p.configuration.Store(&Configuration{IssueTrackers: `[{"id":"gh","type":"github","repository":"acme/app","token":"ghp"}]`})
+
+	body, _ := json.Marshal(model.SubmitDialogRequest{
+		UserId:     "user-1",
+		State:      `{"project_id":"proj-1","error_id":"err-1"}`,
+		Submission: map[string]any{"tracker": "gh", "title": "NoMethodError in User#name"},
+	})
+	req := httptest.NewRequest(http.MethodPost, ticketDialogPath, bytes.NewReader(body))
+	req.Header.Set("Mattermost-User-ID", "user-1")
+	rr := httptest.NewRecorder()
+	p.ServeHTTP(nil, rr, req)
+
+	if !strings.Contains(rr.Body.String(), "already being created") {
+		t.Fatalf("expected the second submission to be refused, got %d: %s", rr.Code, rr.Body.String())
+	}
+	api.AssertNotCalled(t, "KVSetWithOptions", pluginID+":"+errorPostKVKey("proj-1", "err-1"), mock.Anything, mock.Anything)
+}
@@ -0,0 +1,231 @@
+package tracker
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/a-voronkov/mattermost-bugsnag/server/interplugin"
+)
+
+// Plugin IDs of the Mattermost Jira and GitHub plugins.
+const (
+	JiraPluginID   = "jira"
+	GitHubPluginID = "github"
+)
+
+// NewIssue is an issue to create. Description is markdown; it is converted
+// for trackers that use another markup.
+type NewIssue struct {
+	Title       string
+	Description string
+	// PostID, ChannelID and TeamID are the card the issue is created from,
+	// which the Mattermost plugins link the issue to.
+	PostID    string
+	ChannelID string
+	TeamID    string
+}
+
+// Issue is a created issue.
+type Issue struct {
+	// Key is the Jira issue key, or owner/name#number on GitHub.
+	Key string `json:"key"`
+	URL string `json:"url"`
+}
+
+// Client creates issues. HTTPClient calls the tracker REST APIs and Plugins
+// reaches the Mattermost plugins.
+type Client struct {
+	HTTPClient *http.Client
+	Plugins    interplugin.HTTPer
+}
+
+// CreateIssue creates an issue in t. userID is the Mattermost user the issue
+// is created for; the Mattermost plugins create it with their account.
+func (c *Client) CreateIssue(ctx context.Context, t Tracker, userID string, issue NewIssue) (*Issue, error) {
+	switch {
+	case t.Type == TypeJira && t.Plugin:
+		return c.createJiraPluginIssue(ctx, t, userID, issue)
+	case t.Type == TypeJira:
+		return c.createJiraIssue(ctx, t, issue)
+	case t.Type == TypeGitHub && t.Plugin:
+		return c.createGitHubPluginIssue(ctx, t, userID, issue)
+	case t.Type == TypeGitHub:
+		return c.createGitHubIssue(ctx, t, issue)
+	default:
+		return nil, fmt.Errorf("unknown issue tracker type %q", t.Type)
+	}
+}
+
+// jiraFields are the fields of a Jira issue the plugin sets.
+func jiraFields(t Tracker, issue NewIssue) map[string]any {
+	fields := map[string]any{
+		"project":     map[string]string{"key": t.Project},
+		"issuetype":   map[string]string{"name": t.IssueType},
+		"summary":     issue.Title,
+		"description": jiraMarkup(issue.Description),
+	}
+	if len(t.Labels) > 0 {
+		fields["labels"] = t.Labels
+	}
+	return fields
+}
+
+func (c *Client) createJiraIssue(ctx context.Context, t Tracker, issue NewIssue) (*Issue, error) {
+	header := http.Header{}
+	if t.Username != "" {
+		header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(t.Username+":"+t.Token)))
+	} else {
+		header.Set("Authorization", "Bearer "+t.Token)
+	}
+
+	var created struct {
+		Key string `json:"key"`
+	}
+	body := map[string]any{"fields": jiraFields(t, issue)}
+	if err := c.do(ctx, c.HTTPClient, http.MethodPost, t.URL+"/rest/api/2/issue", header, body, &created); err != nil {
+		return nil, fmt.Errorf("create Jira issue: %w", err)
+	}
+	return &Issue{Key: created.Key, URL: t.URL + "/browse/" + created.Key}, nil
+}
+
+func (c *Client) createJiraPluginIssue(ctx context.Context, t Tracker, userID string, issue NewIssue) (*Issue, error) {
+	var created struct {
+		Key string `json:"key"`
+	}
+	body := map[string]any{
+		"fields":       jiraFields(t, issue),
+		"instance_id":  t.URL,
+		"post_id":      issue.PostID,
+		"channel_id":   issue.ChannelID,
+		"current_team": issue.TeamID,
+	}
+	if err := c.do(ctx, c.pluginClient(), http.MethodPost, "/"+JiraPluginID+"/api/v2/create-issue", userHeader(userID), body, &created); err != nil {
+		return nil, fmt.Errorf("create Jira issue with the Jira plugin: %w", err)
+	}
+	return &Issue{Key: created.Key, URL: t.URL + "/browse/" + created.Key}, nil
+}
+
+// gitHubIssue is the part of a GitHub issue the plugin reads.
+type gitHubIssue struct {
+	Number  int    `json:"number"`
+	HTMLURL string `json:"html_url"`
+}
+
+func (t Tracker) gitHubIssue(created gitHubIssue) *Issue {
+	return &Issue{Key: t.Repository + "#" + strconv.Itoa(created.Number), URL: created.HTMLURL}
+}
+
+func (c *Client) createGitHubIssue(ctx context.Context, t Tracker, issue NewIssue) (*Issue, error) {
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+t.Token)
+	header.Set("Accept", "application/vnd.github+json")
+
+	body := map[string]any{
+		"title": issue.Title,
+		"body":  issue.Description,
+	}
+	if len(t.Labels) > 0 {
+		body["labels"] = t.Labels
+	}
+
+	var created gitHubIssue
+	if err := c.do(ctx, c.HTTPClient, http.MethodPost, t.URL+"/repos/"+t.Repository+"/issues", header, body, &created); err != nil {
+		return nil, fmt.Errorf("create GitHub issue: %w", err)
+	}
+	return t.gitHubIssue(created), nil
+}
+
+func (c *Client) createGitHubPluginIssue(ctx context.Context, t Tracker, userID string, issue NewIssue) (*Issue, error) {
+	body := map[string]any{
+		"title":      issue.Title,
+		"body":       issue.Description,
+		"repo":       t.Repository,
+		"post_id":    issue.PostID,
+		"channel_id": issue.ChannelID,
+		"labels":     t.Labels,
+	}
+
+	var created gitHubIssue
+	if err := c.do(ctx, c.pluginClient(), http.MethodPost, "/"+GitHubPluginID+"/api/v1/createissue", userHeader(userID), body, &created); err != nil {
+		return nil, fmt.Errorf("create GitHub issue with the GitHub plugin: %w", err)
+	}
+	return t.gitHubIssue(created), nil
+}
+
+func userHeader(userID string) http.Header {
+	header := http.Header{}
+	header.Set("Mattermost-User-ID", userID)
+	return header
+}
+
+// pluginClient sends requests through the inter-plugin HTTP API.
+func (c *Client) pluginClient() *http.Client {
+	return interplugin.NewClient(c.Plugins)
+}
+
+func (c *Client) do(ctx context.Context, httpClient *http.Client, method, endpoint string, header http.Header, body any, out any) error {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(body); err != nil {
+		return fmt.Errorf("encode request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, &buf)
+	if err != nil {
+		return fmt.Errorf("build request: %w", err)
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if req.Header.Get("Accept") == "" {
+		req.Header.Set("Accept", "application/json")
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("execute request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("status %d: %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decode response: %w", err)
+	}
+	return nil
+}
+
+var markdownLink = regexp.MustCompile(`\[([^\]]+)\]\(([^)\s]+)\)`)
+
+// jiraMarkup converts the markdown the plugin writes to Jira wiki markup:
+// code fences and links.
+func jiraMarkup(markdown string) string {
+	lines := strings.Split(markdown, "\n")
+	inCode := false
+	for i, line := range lines {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			lines[i] = "{noformat}"
+			inCode = !inCode
+			continue
+		}
+		if !inCode {
+			lines[i] = markdownLink.ReplaceAllString(line, "[$1|$2]")
+		}
+	}
+	return strings.Join(lines, "\n")
+}
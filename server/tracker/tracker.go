@@ -0,0 +1,125 @@
+// Package tracker creates issues in the issue trackers teams file Bugsnag
+// errors in: Jira and GitHub, either through their REST APIs or by handing
+// off to the Mattermost Jira and GitHub plugins.
+package tracker
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Tracker types.
+const (
+	TypeJira   = "jira"
+	TypeGitHub = "github"
+)
+
+// DefaultJiraIssueType is the Jira issue type used when none is configured.
+const DefaultJiraIssueType = "Bug"
+
+// DefaultGitHubAPIURL is the GitHub REST API used when no URL is configured.
+const DefaultGitHubAPIURL = "https://api.github.com"
+
+// Tracker is one configured issue tracker, as entered in the IssueTrackers
+// plugin setting.
+type Tracker struct {
+	ID   string `json:"id"`
+	Name string `json:"name,omitempty"`
+	Type string `json:"type"`
+	// Plugin hands issues to the Mattermost Jira or GitHub plugin, which
+	// creates them with the connected account of the user submitting the
+	// dialog. Otherwise the plugin calls the REST API with Token.
+	Plugin bool `json:"plugin,omitempty"`
+	// URL is the Jira site, or the GitHub API for GitHub Enterprise.
+	URL string `json:"url,omitempty"`
+	// Username is the Jira account an API token belongs to. Without it the
+	// token is sent as a bearer token, as Jira Data Center expects.
+	Username string `json:"username,omitempty"`
+	Token    string `json:"token,omitempty"`
+	// Project is the Jira project key and IssueType the Jira issue type.
+	Project   string `json:"project,omitempty"`
+	IssueType string `json:"issue_type,omitempty"`
+	// Repository is the GitHub repository, as owner/name.
+	Repository string   `json:"repository,omitempty"`
+	Labels     []string `json:"labels,omitempty"`
+}
+
+// DisplayName returns the name, falling back to the ID.
+func (t Tracker) DisplayName() string {
+	if name := strings.TrimSpace(t.Name); name != "" {
+		return name
+	}
+	return t.ID
+}
+
+// Parse decodes and validates a JSON list of trackers. An empty string yields
+// no trackers.
+func Parse(raw string) ([]Tracker, error) {
+	if strings.TrimSpace(raw) == "" {
+		return nil, nil
+	}
+
+	var trackers []Tracker
+	if err := json.Unmarshal([]byte(raw), &trackers); err != nil {
+		return nil, fmt.Errorf("decode issue trackers: %w", err)
+	}
+
+	seen := map[string]bool{}
+	for i := range trackers {
+		t := &trackers[i]
+		t.ID = strings.TrimSpace(t.ID)
+		t.Type = strings.ToLower(strings.TrimSpace(t.Type))
+		t.URL = strings.TrimRight(strings.TrimSpace(t.URL), "/")
+		t.Token = strings.TrimSpace(t.Token)
+		t.Project = strings.TrimSpace(t.Project)
+		t.Repository = strings.Trim(strings.TrimSpace(t.Repository), "/")
+
+		switch {
+		case t.ID == "":
+			return nil, fmt.Errorf("issue tracker %d: id is required", i+1)
+		case strings.ContainsAny(t.ID, "/?#: "):
+			return nil, fmt.Errorf("issue tracker %q: id must not contain '/', '?', '#', ':' or spaces", t.ID)
+		case seen[t.ID]:
+			return nil, fmt.Errorf("issue tracker %q is defined more than once", t.ID)
+		}
+		seen[t.ID] = true
+
+		switch t.Type {
+		case TypeJira:
+			if t.URL == "" || t.Project == "" {
+				return nil, fmt.Errorf("issue tracker %q: url and project are required for Jira", t.ID)
+			}
+			if t.IssueType == "" {
+				t.IssueType = DefaultJiraIssueType
+			}
+		case TypeGitHub:
+			if owner, name, ok := strings.Cut(t.Repository, "/"); !ok || owner == "" || name == "" || strings.Contains(name, "/") {
+				return nil, fmt.Errorf("issue tracker %q: repository must be owner/name", t.ID)
+			}
+			if t.URL == "" {
+				t.URL = DefaultGitHubAPIURL
+			}
+		default:
+			return nil, fmt.Errorf("issue tracker %q: unknown type %q, use jira or github", t.ID, t.Type)
+		}
+
+		if !t.Plugin && t.Token == "" {
+			return nil, fmt.Errorf("issue tracker %q: token is required unless plugin is set", t.ID)
+		}
+	}
+
+	return trackers, nil
+}
+
+// Find returns the tracker with the given ID. An empty ID selects the first
+// tracker.
+func Find(trackers []Tracker, id string) (Tracker, bool) {
+	id = strings.TrimSpace(id)
+	for _, t := range trackers {
+		if id == "" || t.ID == id {
+			return t, true
+		}
+	}
+	return Tracker{}, false
+}
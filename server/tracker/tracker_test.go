@@ -0,0 +1,132 @@
+package tracker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/a-voronkov/mattermost-bugsnag/server/interplugin"
+)
+
+func TestParse(t *testing.T) {
+	trackers, err := Parse(`[
+		{"id":"jira","type":"Jira","url":"https://acme.atlassian.net/","project":"OPS","username":"bot@acme.com","token":"t1"},
+		{"id":"gh","name":"App issues","type":"github","repository":"acme/app","plugin":true}
+	]`)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if len(trackers) != 2 || trackers[0].URL != "https://acme.atlassian.net" || trackers[0].IssueType != DefaultJiraIssueType || trackers[1].URL != DefaultGitHubAPIURL {
+		t.Fatalf("unexpected trackers %+v", trackers)
+	}
+	if found, ok := Find(trackers, "gh"); !ok || found.DisplayName() != "App issues" {
+		t.Errorf("Find(gh) = %+v, %v", found, ok)
+	}
+	if found, ok := Find(trackers, ""); !ok || found.ID != "jira" {
+		t.Errorf("Find(\"\") = %+v, %v", found, ok)
+	}
+
+	invalid := map[string]string{
+		`[{"type":"jira"}]`: "id is required",
+		`[{"id":"a","type":"jira","url":"https://x","project":"P","token":"t"},{"id":"a","type":"jira","url":"https://x","project":"P","token":"t"}]`: "more than once",
+		`[{"id":"a","type":"jira","project":"P","token":"t"}]`:        "url and project",
+		`[{"id":"a","type":"github","repository":"app","token":"t"}]`: "owner/name",
+		`[{"id":"a","type":"github","repository":"acme/app"}]`:        "token is required",
+		`[{"id":"a","type":"gitlab"}]`:                                "unknown type",
+	}
+	for raw, want := range invalid {
+		if _, err := Parse(raw); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Parse(%s) error = %v, want %q", raw, err, want)
+		}
+	}
+}
+
+func TestCreateIssueREST(t *testing.T) {
+	var requests []string
+	var bodies []map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path+" "+r.Header.Get("Authorization"))
+		var body map[string]any
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		bodies = append(bodies, body)
+		switch r.URL.Path {
+		case "/rest/api/2/issue":
+			_, _ = w.Write([]byte(`{"id":"10001","key":"OPS-12"}`))
+		case "/repos/acme/app/issues":
+			_, _ = w.Write([]byte(`{"number":7,"html_url":"https://github.com/acme/app/issues/7"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	client := &Client{HTTPClient: server.Client()}
+	issue := NewIssue{Title: "NoMethodError", Description: "Boom\n```\napp.rb:12\n```\n[Open in Bugsnag](https://app.bugsnag.com/e/1)"}
+
+	jira, err := client.CreateIssue(context.Background(), Tracker{Type: TypeJira, URL: server.URL, Project: "OPS", IssueType: "Bug", Username: "bot", Token: "secret"}, "user-1", issue)
+	if err != nil {
+		t.Fatalf("CreateIssue(jira) error = %v", err)
+	}
+	if jira.Key != "OPS-12" || jira.URL != server.URL+"/browse/OPS-12" {
+		t.Errorf("unexpected Jira issue %+v", jira)
+	}
+	fields := bodies[0]["fields"].(map[string]any)
+	if want := "Boom\n{noformat}\napp.rb:12\n{noformat}\n[Open in Bugsnag|https://app.bugsnag.com/e/1]"; fields["description"] != want {
+		t.Errorf("Jira description = %q, want %q", fields["description"], want)
+	}
+
+	github, err := client.CreateIssue(context.Background(), Tracker{Type: TypeGitHub, URL: server.URL, Repository: "acme/app", Token: "ghp", Labels: []string{"bug"}}, "user-1", issue)
+	if err != nil {
+		t.Fatalf("CreateIssue(github) error = %v", err)
+	}
+	if github.Key != "acme/app#7" || github.URL != "https://github.com/acme/app/issues/7" {
+		t.Errorf("unexpected GitHub issue %+v", github)
+	}
+	if bodies[1]["body"] != issue.Description {
+		t.Errorf("GitHub body = %q", bodies[1]["body"])
+	}
+
+	want := []string{"POST /rest/api/2/issue Basic Ym90OnNlY3JldA==", "POST /repos/acme/app/issues Bearer ghp"}
+	if strings.Join(requests, ",") != strings.Join(want, ",") {
+		t.Errorf("requests = %v, want %v", requests, want)
+	}
+}
+
+func TestCreateIssueWithPlugins(t *testing.T) {
+	var requests []string
+	client := &Client{Plugins: interplugin.HTTPFunc(func(req *http.Request) *http.Response {
+		var body map[string]any
+		_ = json.NewDecoder(req.Body).Decode(&body)
+		requests = append(requests, req.URL.Path+" as "+req.Header.Get("Mattermost-User-ID")+" from "+body["post_id"].(string))
+		response := `{"key":"OPS-3"}`
+		if strings.HasPrefix(req.URL.Path, "/github/") {
+			response = `{"number":9,"html_url":"https://github.com/acme/app/issues/9"}`
+		}
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewBufferString(response))}
+	})}
+	issue := NewIssue{Title: "NoMethodError", PostID: "post-1", ChannelID: "chan-1", TeamID: "team-1"}
+
+	jira, err := client.CreateIssue(context.Background(), Tracker{Type: TypeJira, Plugin: true, URL: "https://acme.atlassian.net", Project: "OPS"}, "user-1", issue)
+	if err != nil || jira.URL != "https://acme.atlassian.net/browse/OPS-3" {
+		t.Fatalf("CreateIssue(jira plugin) = %+v, %v", jira, err)
+	}
+	github, err := client.CreateIssue(context.Background(), Tracker{Type: TypeGitHub, Plugin: true, Repository: "acme/app"}, "user-1", issue)
+	if err != nil || github.Key != "acme/app#9" {
+		t.Fatalf("CreateIssue(github plugin) = %+v, %v", github, err)
+	}
+
+	want := []string{"/jira/api/v2/create-issue as user-1 from post-1", "/github/api/v1/createissue as user-1 from post-1"}
+	if strings.Join(requests, ",") != strings.Join(want, ",") {
+		t.Errorf("requests = %v, want %v", requests, want)
+	}
+
+	missing := &Client{Plugins: interplugin.HTTPFunc(func(*http.Request) *http.Response { return nil })}
+	if _, err := missing.CreateIssue(context.Background(), Tracker{Type: TypeGitHub, Plugin: true, Repository: "acme/app"}, "user-1", issue); err == nil || !strings.Contains(err.Error(), "installed") {
+		t.Errorf("expected a missing-plugin error, got %v", err)
+	}
+}
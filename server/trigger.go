@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/a-voronkov/mattermost-bugsnag/server/formatter"
+)
+
+// TriggerType is the kind of event that caused Bugsnag to send a webhook.
+// See https://docs.bugsnag.com/product/integrations/data-forwarding/webhook/
+type TriggerType string
+
+const (
+	TriggerFirstException         TriggerType = "firstException"
+	TriggerException              TriggerType = "exception"
+	TriggerReopened               TriggerType = "reopened"
+	TriggerProjectSpiking         TriggerType = "projectSpiking"
+	TriggerPowerTen               TriggerType = "powerTen"
+	TriggerErrorEventFrequency    TriggerType = "errorEventFrequency"
+	TriggerErrorStateManualChange TriggerType = "errorStateManualChange"
+	TriggerComment                TriggerType = "comment"
+	TriggerCollaboratorAssigned   TriggerType = "collaboratorAssigned"
+	TriggerRelease                TriggerType = "release"
+)
+
+// triggerType returns the payload's trigger as a TriggerType.
+func (t triggerInfo) triggerType() TriggerType {
+	return TriggerType(strings.TrimSpace(t.Type))
+}
+
+// Known reports whether the plugin has tailored handling for the trigger.
+func (t TriggerType) Known() bool {
+	switch t {
+	case TriggerFirstException, TriggerException, TriggerReopened, TriggerProjectSpiking,
+		TriggerPowerTen, TriggerErrorEventFrequency, TriggerErrorStateManualChange,
+		TriggerComment, TriggerCollaboratorAssigned, TriggerRelease:
+		return true
+	}
+	return false
+}
+
+// mentionsAssignee reports whether the thread reply for the trigger should
+// notify the error's assignee.
+func (t TriggerType) mentionsAssignee() bool {
+	switch t {
+	case TriggerReopened, TriggerProjectSpiking, TriggerErrorEventFrequency,
+		TriggerComment, TriggerCollaboratorAssigned:
+		return true
+	}
+	return false
+}
+
+// applyTrigger updates freshly built card data for an existing card. previous
+// is the data stored on the card, if any; fields the payload does not carry
+// are kept from it. Event counts only come from Bugsnag through the status
+// sync: counting deliveries would never forget old events.
+func applyTrigger(trigger triggerInfo, data formatter.ErrorData, previous *formatter.ErrorData) formatter.ErrorData {
+	if previous != nil {
+		data.Counts = previous.Counts
+		if data.AssigneeUsername == "" && data.AssigneeEmail == "" && trigger.triggerType() != TriggerCollaboratorAssigned {
+			data.AssigneeUsername = previous.AssigneeUsername
+			data.AssigneeEmail = previous.AssigneeEmail
+		}
+		if data.Request.IsEmpty() {
+			data.Request = previous.Request
+		}
+		data.Playbook = previous.Playbook
+		data.Ticket = previous.Ticket
+	}
+
+	switch trigger.triggerType() {
+	case TriggerReopened:
+		data.Status = "open"
+	case TriggerErrorStateManualChange:
+		if status := stateChangeStatus(trigger.StateChange); status != "" {
+			data.Status = status
+		}
+	}
+
+	return data
+}
+
+// stateChangeStatus maps a Bugsnag state change ("fixed", "snoozed",
+// "ignored", "open", "reopened") to the status shown on the card.
+func stateChangeStatus(stateChange string) string {
+	stateChange = strings.ToLower(strings.TrimSpace(stateChange))
+	switch stateChange {
+	case "reopened", "unfixed", "unsnoozed", "unignored":
+		return "open"
+	default:
+		return stateChange
+	}
+}
+
+// triggerReply returns the thread reply for an update to an existing card, or
+// an empty string when the trigger only mutates the card.
+func triggerReply(trigger triggerInfo, data formatter.ErrorData) string {
+	message := strings.TrimSpace(trigger.Message)
+
+	var reply string
+	switch trigger.triggerType() {
+	case TriggerFirstException, TriggerException:
+		return ""
+	case TriggerReopened:
+		reply = "🔁 **Reopened**: this error has occurred again"
+	case TriggerProjectSpiking:
+		if trigger.Rate > 0 {
+			reply = fmt.Sprintf("📈 **Spike**: the project is receiving %d events per minute", trigger.Rate)
+		} else {
+			reply = "📈 **Spike**: the project is spiking"
+		}
+	case TriggerErrorEventFrequency:
+		reply = "📈 **Frequent**: this error is occurring frequently"
+	case TriggerPowerTen:
+		reply = "🔟 **Milestone**: this error has reached a new power of ten"
+	case TriggerErrorStateManualChange:
+		if status := stateChangeStatus(trigger.StateChange); status != "" {
+			reply = fmt.Sprintf("✏️ **Status changed** to `%s`", status)
+		} else {
+			reply = "✏️ **Status changed**"
+		}
+	case TriggerComment:
+		if message == "" {
+			return "💬 **Comment** added in Bugsnag" + mentionSuffix(trigger, data)
+		}
+		return fmt.Sprintf("💬 **Comment**: %s", message) + mentionSuffix(trigger, data)
+	case TriggerCollaboratorAssigned:
+		if assignee := data.Assignee(); assignee != "" {
+			return fmt.Sprintf("👤 **Assigned** to %s", assignee)
+		}
+		return "👤 **Unassigned**"
+	default:
+		if message == "" {
+			return ""
+		}
+		return fmt.Sprintf("🔄 **Update**: %s", message)
+	}
+
+	if message != "" {
+		reply += " — " + message
+	}
+	return reply + mentionSuffix(trigger, data)
+}
+
+// mentionSuffix @-mentions the assignee for triggers that warrant it.
+func mentionSuffix(trigger triggerInfo, data formatter.ErrorData) string {
+	if !trigger.triggerType().mentionsAssignee() || data.AssigneeUsername == "" {
+		return ""
+	}
+	return " (@" + data.AssigneeUsername + ")"
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/a-voronkov/mattermost-bugsnag/server/formatter"
+)
+
+func TestApplyTrigger(t *testing.T) {
+	previous := &formatter.ErrorData{
+		Status:           "fixed",
+		AssigneeUsername: "alice",
+		Counts:           formatter.Counts{Users: 3, Events1h: 2, Events24h: 10},
+	}
+
+	tests := []struct {
+		name         string
+		trigger      triggerInfo
+		fresh        formatter.ErrorData
+		wantStatus   string
+		wantAssignee string
+		wantCounts   formatter.Counts
+	}{
+		{
+			name:         "exception keeps the counts from Bugsnag",
+			trigger:      triggerInfo{Type: string(TriggerException)},
+			fresh:        formatter.ErrorData{Status: "fixed"},
+			wantStatus:   "fixed",
+			wantAssignee: "@alice",
+			wantCounts:   previous.Counts,
+		},
+		{
+			name:         "reopened sets status open",
+			trigger:      triggerInfo{Type: string(TriggerReopened)},
+			fresh:        formatter.ErrorData{Status: "fixed"},
+			wantStatus:   "open",
+			wantAssignee: "@alice",
+			wantCounts:   previous.Counts,
+		},
+		{
+			name:         "manual state change uses the new state",
+			trigger:      triggerInfo{Type: string(TriggerErrorStateManualChange), StateChange: "ignored"},
+			fresh:        formatter.ErrorData{Status: "open"},
+			wantStatus:   "ignored",
+			wantAssignee: "@alice",
+			wantCounts:   previous.Counts,
+		},
+		{
+			name:         "manual unsnooze reopens",
+			trigger:      triggerInfo{Type: string(TriggerErrorStateManualChange), StateChange: "unsnoozed"},
+			fresh:        formatter.ErrorData{Status: "snoozed"},
+			wantStatus:   "open",
+			wantAssignee: "@alice",
+			wantCounts:   previous.Counts,
+		},
+		{
+			name:         "collaborator assigned replaces assignee",
+			trigger:      triggerInfo{Type: string(TriggerCollaboratorAssigned)},
+			fresh:        formatter.ErrorData{Status: "open", AssigneeUsername: "bob"},
+			wantStatus:   "open",
+			wantAssignee: "@bob",
+			wantCounts:   previous.Counts,
+		},
+		{
+			name:         "collaborator unassigned clears assignee",
+			trigger:      triggerInfo{Type: string(TriggerCollaboratorAssigned)},
+			fresh:        formatter.ErrorData{Status: "open"},
+			wantStatus:   "open",
+			wantAssignee: "",
+			wantCounts:   previous.Counts,
+		},
+		{
+			name:         "comment keeps card state",
+			trigger:      triggerInfo{Type: string(TriggerComment), Message: "looking into it"},
+			fresh:        formatter.ErrorData{Status: "fixed"},
+			wantStatus:   "fixed",
+			wantAssignee: "@alice",
+			wantCounts:   previous.Counts,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := applyTrigger(tt.trigger, tt.fresh, previous)
+			if got.Status != tt.wantStatus {
+				t.Errorf("Status = %q, want %q", got.Status, tt.wantStatus)
+			}
+			if got.Assignee() != tt.wantAssignee {
+				t.Errorf("Assignee() = %q, want %q", got.Assignee(), tt.wantAssignee)
+			}
+			if got.Counts != tt.wantCounts {
+				t.Errorf("Counts = %+v, want %+v", got.Counts, tt.wantCounts)
+			}
+		})
+	}
+}
+
+func TestApplyTriggerWithoutPreviousCard(t *testing.T) {
+	got := applyTrigger(triggerInfo{Type: string(TriggerException)}, formatter.ErrorData{Status: "open"}, nil)
+	if got.Counts != (formatter.Counts{}) {
+		t.Errorf("Counts = %+v, want them unset until Bugsnag reports them", got.Counts)
+	}
+}
+
+func TestTriggerReply(t *testing.T) {
+	assigned := formatter.ErrorData{AssigneeUsername: "alice"}
+
+	tests := []struct {
+		name    string
+		trigger triggerInfo
+		data    formatter.ErrorData
+		want    string
+	}{
+		{"first exception", triggerInfo{Type: string(TriggerFirstException), Message: "New error"}, assigned, ""},
+		{"exception", triggerInfo{Type: string(TriggerException), Message: "Error occurred"}, assigned, ""},
+		{"reopened", triggerInfo{Type: string(TriggerReopened)}, assigned, "🔁 **Reopened**: this error has occurred again (@alice)"},
+		{"reopened unassigned", triggerInfo{Type: string(TriggerReopened)}, formatter.ErrorData{}, "🔁 **Reopened**: this error has occurred again"},
+		{"project spiking", triggerInfo{Type: string(TriggerProjectSpiking), Rate: 120}, assigned, "📈 **Spike**: the project is receiving 120 events per minute (@alice)"},
+		{"project spiking without rate", triggerInfo{Type: string(TriggerProjectSpiking)}, formatter.ErrorData{}, "📈 **Spike**: the project is spiking"},
+		{"frequency", triggerInfo{Type: string(TriggerErrorEventFrequency), Message: "10 events in 1 minute"}, assigned, "📈 **Frequent**: this error is occurring frequently — 10 events in 1 minute (@alice)"},
+		{"power ten", triggerInfo{Type: string(TriggerPowerTen), Message: "1,000 occurrences"}, assigned, "🔟 **Milestone**: this error has reached a new power of ten — 1,000 occurrences"},
+		{"manual state change", triggerInfo{Type: string(TriggerErrorStateManualChange), StateChange: "fixed"}, assigned, "✏️ **Status changed** to `fixed`"},
+		{"comment", triggerInfo{Type: string(TriggerComment), Message: "Deploying a fix"}, assigned, "💬 **Comment**: Deploying a fix (@alice)"},
+		{"comment without text", triggerInfo{Type: string(TriggerComment)}, formatter.ErrorData{}, "💬 **Comment** added in Bugsnag"},
+		{"collaborator assigned", triggerInfo{Type: string(TriggerCollaboratorAssigned)}, assigned, "👤 **Assigned** to @alice"},
+		{"collaborator assigned by email", triggerInfo{Type: string(TriggerCollaboratorAssigned)}, formatter.ErrorData{AssigneeEmail: "bob@example.com"}, "👤 **Assigned** to bob@example.com"},
+		{"collaborator unassigned", triggerInfo{Type: string(TriggerCollaboratorAssigned)}, formatter.ErrorData{}, "👤 **Unassigned**"},
+		{"unknown trigger", triggerInfo{Type: "linkedIssue", Message: "Issue linked"}, assigned, "🔄 **Update**: Issue linked"},
+		{"unknown trigger without message", triggerInfo{Type: "linkedIssue"}, assigned, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := triggerReply(tt.trigger, tt.data); got != tt.want {
+				t.Errorf("triggerReply() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTriggerTypeKnown(t *testing.T) {
+	if !TriggerProjectSpiking.Known() {
+		t.Error("expected projectSpiking to be known")
+	}
+	if TriggerType("linkedIssue").Known() {
+		t.Error("expected linkedIssue to be unknown")
+	}
+}
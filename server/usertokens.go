@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/a-voronkov/mattermost-bugsnag/server/connection"
+	"github.com/a-voronkov/mattermost-bugsnag/server/store"
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+const tokenCommandUsage = "Usage: `/bugsnag token set <token> [connection]`, `/bugsnag token clear [connection]` or `/bugsnag token status`."
+
+// actionToken returns the Bugsnag token a card action by userID runs with on
+// conn: the user's own linked token when there is one, otherwise the shared
+// connection token unless RequireUserTokens forbids it. personal reports
+// whether the user's own token was picked.
+func (p *Plugin) actionToken(cfg Configuration, userID string, conn connection.Connection) (token string, personal bool) {
+	if sealer := p.getSealer(); sealer != nil {
+		linked, ok, err := p.kvStore().GetUserToken(sealer, userID, conn.ID)
+		if err != nil {
+			p.API.LogWarn("failed to load personal Bugsnag token", "user_id", userID, "connection_id", conn.ID, "err", err.Error())
+		}
+		if ok {
+			return linked.Token, true
+		}
+	}
+
+	if cfg.RequireUserTokens {
+		return "", false
+	}
+	return conn.APIToken, false
+}
+
+// executeTokenCommand handles /bugsnag token.
+func (p *Plugin) executeTokenCommand(args *model.CommandArgs, params []string) string {
+	if len(params) == 0 {
+		return tokenCommandUsage
+	}
+
+	switch params[0] {
+	case "set":
+		if len(params) < 2 || len(params) > 3 {
+			return "Usage: `/bugsnag token set <token> [connection]`."
+		}
+		connectionID := ""
+		if len(params) == 3 {
+			connectionID = params[2]
+		}
+		return p.setUserToken(args.UserId, params[1], connectionID)
+	case "clear":
+		if len(params) > 2 {
+			return "Usage: `/bugsnag token clear [connection]`."
+		}
+		connectionID := ""
+		if len(params) == 2 {
+			connectionID = params[1]
+		}
+		return p.clearUserToken(args.UserId, connectionID)
+	case "status":
+		return p.userTokenStatus(args.UserId)
+	default:
+		return tokenCommandUsage
+	}
+}
+
+func (p *Plugin) setUserToken(userID, token, connectionID string) string {
+	cfg := p.getConfiguration()
+	conn, msg := findCommandConnection(cfg, connectionID)
+	if msg != "" {
+		return msg
+	}
+
+	sealer := p.getSealer()
+	if sealer == nil {
+		p.API.LogWarn("cannot store personal Bugsnag token", "err", store.ErrNoEncryptionKey.Error())
+		return "Personal tokens are unavailable because the plugin has no encryption key yet. Ask a system admin to check the plugin logs."
+	}
+
+	// Check the token against Bugsnag so typos are caught now rather than on
+	// the first card action.
+	client, err := p.bugsnagClients().NewClient(token)
+	if err != nil {
+		return fmt.Sprintf("Invalid token: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	bugsnagUser, err := client.GetCurrentUser(ctx)
+	if err != nil {
+		p.API.LogDebug("personal Bugsnag token rejected", "user_id", userID, "err", err.Error())
+		return fmt.Sprintf("Bugsnag did not accept the token: %v", err)
+	}
+
+	if err := p.kvStore().SaveUserToken(sealer, userID, store.UserToken{
+		ConnectionID: conn.ID,
+		Token:        token,
+		BugsnagID:    bugsnagUser.ID,
+		BugsnagName:  bugsnagUser.Name,
+		BugsnagEmail: bugsnagUser.Email,
+		SavedAt:      time.Now().UTC(),
+	}); err != nil {
+		p.API.LogError("failed to save personal Bugsnag token", "user_id", userID, "err", err.Error())
+		return "Failed to save your token, please try again."
+	}
+
+	return fmt.Sprintf("Saved your Bugsnag token for **%s**. Card actions now run as %s in Bugsnag.", conn.DisplayName(), bugsnagIdentity(bugsnagUser.Name, bugsnagUser.Email))
+}
+
+func (p *Plugin) clearUserToken(userID, connectionID string) string {
+	cfg := p.getConfiguration()
+	conn, msg := findCommandConnection(cfg, connectionID)
+	if msg != "" {
+		return msg
+	}
+
+	removed, err := p.kvStore().DeleteUserToken(userID, conn.ID)
+	if err != nil {
+		p.API.LogError("failed to remove personal Bugsnag token", "user_id", userID, "err", err.Error())
+		return "Failed to remove your token, please try again."
+	}
+	if !removed {
+		return fmt.Sprintf("You have no Bugsnag token linked for **%s**.", conn.DisplayName())
+	}
+
+	return fmt.Sprintf("Removed your Bugsnag token for **%s**.", conn.DisplayName())
+}
+
+func (p *Plugin) userTokenStatus(userID string) string {
+	cfg := p.getConfiguration()
+	fallback := "card actions use the shared token"
+	if cfg.RequireUserTokens {
+		fallback = "card actions need your own token, linked with `/bugsnag token set <token>`"
+	}
+
+	tokens, err := p.kvStore().ListUserTokens(userID)
+	if err != nil {
+		p.API.LogError("failed to list personal Bugsnag tokens", "user_id", userID, "err", err.Error())
+		return "Failed to load your tokens, please try again."
+	}
+	if len(tokens) == 0 {
+		return fmt.Sprintf("You have not linked a Bugsnag token, so %s.", fallback)
+	}
+
+	connections := cfg.AllConnections()
+	lines := []string{"Your linked Bugsnag tokens:"}
+	for _, token := range tokens {
+		name := token.ConnectionID
+		if conn, ok := connection.Find(connections, token.ConnectionID); ok && token.ConnectionID != "" {
+			name = conn.DisplayName()
+		}
+		lines = append(lines, fmt.Sprintf("* **%s**: %s, saved %s", name, bugsnagIdentity(token.BugsnagName, token.BugsnagEmail), token.SavedAt.Format("2006-01-02")))
+	}
+	if len(connections) > len(tokens) {
+		lines = append(lines, "", fmt.Sprintf("On other connections %s.", fallback))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// findCommandConnection resolves the connection named in a command, or the
+// first connection when none is given. It returns a reply for the user when
+// the connection does not exist.
+func findCommandConnection(cfg Configuration, connectionID string) (connection.Connection, string) {
+	connections := cfg.AllConnections()
+	if len(connections) == 0 {
+		return connection.Connection{}, "Bugsnag is not configured yet."
+	}
+
+	conn, ok := connection.Find(connections, connectionID)
+	if !ok {
+		ids := make([]string, 0, len(connections))
+		for _, c := range connections {
+			ids = append(ids, "`"+c.ID+"`")
+		}
+		return connection.Connection{}, fmt.Sprintf("Unknown connection `%s`. Available connections: %s.", connectionID, strings.Join(ids, ", "))
+	}
+
+	return conn, ""
+}
+
+func bugsnagIdentity(name, email string) string {
+	switch {
+	case name != "" && email != "":
+		return fmt.Sprintf("%s (%s)", name, email)
+	case email != "":
+		return email
+	case name != "":
+		return name
+	default:
+		return "your Bugsnag user"
+	}
+}
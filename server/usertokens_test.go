@@ -0,0 +1,274 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/a-voronkov/mattermost-bugsnag/server/connection"
+	"github.com/a-voronkov/mattermost-bugsnag/server/store"
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/mock"
+)
+
+const testEncryptionKey = "test-encryption-key"
+
+func testSealer(t *testing.T) *store.Sealer {
+	t.Helper()
+
+	sealer, err := store.NewSealer(testEncryptionKey)
+	if err != nil {
+		t.Fatalf("NewSealer() error = %v", err)
+	}
+	return sealer
+}
+
+// storedUserTokens mocks the user's token key, sealing the given plaintext
+// tokens (connection ID → token) with testEncryptionKey.
+func storedUserTokens(t *testing.T, api *plugintest.API, userID string, tokens map[string]string) {
+	t.Helper()
+
+	sealer := testSealer(t)
+
+	var stored []store.UserToken
+	for connectionID, token := range tokens {
+		sealed, err := sealer.Seal(token)
+		if err != nil {
+			t.Fatalf("Seal() error = %v", err)
+		}
+		stored = append(stored, store.UserToken{
+			ConnectionID: connectionID,
+			Token:        sealed,
+			BugsnagName:  "Jane Doe",
+			BugsnagEmail: "jane@example.com",
+			SavedAt:      time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC),
+		})
+	}
+
+	data, _ := json.Marshal(stored)
+	api.On("KVGet", pluginID+":"+KVKeyUserTokenPrefix+userID).Return(data, nil)
+}
+
+func TestActionToken(t *testing.T) {
+	conn := connection.Connection{ID: connection.DefaultID, APIToken: "shared-token"}
+
+	tests := []struct {
+		name         string
+		cfg          Configuration
+		noKeyring    bool
+		linked       map[string]string
+		wantToken    string
+		wantPersonal bool
+	}{
+		{
+			name:         "linked token wins",
+			linked:       map[string]string{"default": "personal-token"},
+			wantToken:    "personal-token",
+			wantPersonal: true,
+		},
+		{
+			name:      "falls back to shared token",
+			linked:    map[string]string{"mobile": "mobile-token"},
+			wantToken: "shared-token",
+		},
+		{
+			name:   "no fallback when personal tokens are required",
+			cfg:    Configuration{RequireUserTokens: true},
+			linked: map[string]string{},
+		},
+		{
+			name:      "no keyring loaded",
+			noKeyring: true,
+			wantToken: "shared-token",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &plugintest.API{}
+			if tt.linked != nil {
+				storedUserTokens(t, api, "user-1", tt.linked)
+			}
+
+			p := &Plugin{}
+			p.SetAPI(api)
+			p.kvNamespace = pluginID
+			if !tt.noKeyring {
+				p.sealer.Store(testSealer(t))
+			}
+
+			token, personal := p.actionToken(tt.cfg, "user-1", conn)
+			if token != tt.wantToken || personal != tt.wantPersonal {
+				t.Errorf("actionToken() = %q, %v; want %q, %v", token, personal, tt.wantToken, tt.wantPersonal)
+			}
+		})
+	}
+}
+
+func TestHandleActionsRequiresPersonalToken(t *testing.T) {
+	userID := "user-123"
+
+	api := &plugintest.API{}
+	api.On("LogInfo", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return().Maybe()
+	api.On("LogError", mock.Anything, mock.Anything, mock.Anything).Return().Maybe()
+	api.On("LogWarn", mock.Anything, mock.Anything, mock.Anything).Return().Maybe()
+	api.On("KVGet", mock.Anything).Return(nil, nil).Maybe()
+	api.On("GetUser", userID).Return(&model.User{Id: userID, Username: "testuser"}, nil)
+	api.On("KVSet", pluginID+":"+KVKeyAuditLog, mock.MatchedBy(func(data []byte) bool {
+		var records []store.AuditRecord
+		if err := json.Unmarshal(data, &records); err != nil || len(records) != 1 {
+			return false
+		}
+		r := records[0]
+		return r.Action == "resolve" && r.Username == "testuser" && !r.Success && r.Response == "no personal Bugsnag token linked"
+	})).Return(nil).Once()
+
+	p := &Plugin{}
+	p.SetAPI(api)
+	p.kvNamespace = pluginID
+	p.configuration.Store(&Configuration{BugsnagAPIToken: "shared-token", RequireUserTokens: true})
+	p.sealer.Store(testSealer(t))
+
+	body, _ := json.Marshal(model.PostActionIntegrationRequest{
+		UserId: userID,
+		Context: map[string]any{
+			"action":     "resolve",
+			"error_id":   "err-123",
+			"project_id": "proj-1",
+		},
+	})
+
+	rr := httptest.NewRecorder()
+	p.handleActions(rr, actionRequest(body, userID))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rr.Code)
+	}
+
+	var resp model.PostActionIntegrationResponse
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if !strings.Contains(resp.EphemeralText, "/bugsnag token set") {
+		t.Errorf("expected a hint to link a token, got %q", resp.EphemeralText)
+	}
+
+	api.AssertExpectations(t)
+}
+
+func TestExecuteTokenCommand(t *testing.T) {
+	cfg := &Configuration{
+		BugsnagAPIToken: "shared-token",
+		Connections:     `[{"id":"mobile","name":"Mobile","api_token":"mobile-token","webhook_token":"w"}]`,
+	}
+
+	tests := []struct {
+		name    string
+		cfg     *Configuration
+		command string
+		setup   func(*plugintest.API)
+		want    string
+	}{
+		{
+			name:    "help",
+			cfg:     cfg,
+			command: "/bugsnag",
+			want:    "/bugsnag token set <token> [connection]",
+		},
+		{
+			name:    "unknown subcommand",
+			cfg:     cfg,
+			command: "/bugsnag frobnicate",
+			want:    "Unknown command `frobnicate`",
+		},
+		{
+			name:    "token usage",
+			cfg:     cfg,
+			command: "/bugsnag token",
+			want:    "Usage:",
+		},
+		{
+			name:    "set before the keyring is loaded",
+			cfg:     cfg,
+			command: "/bugsnag token set abc",
+			setup: func(api *plugintest.API) {
+				api.On("LogWarn", "cannot store personal Bugsnag token", "err", store.ErrNoEncryptionKey.Error()).Return()
+			},
+			want: "no encryption key",
+		},
+		{
+			name:    "set on unknown connection",
+			cfg:     cfg,
+			command: "/bugsnag token set abc web",
+			want:    "Unknown connection `web`. Available connections: `default`, `mobile`.",
+		},
+		{
+			name:    "status without tokens",
+			cfg:     cfg,
+			command: "/bugsnag token status",
+			setup: func(api *plugintest.API) {
+				api.On("KVGet", pluginID+":"+KVKeyUserTokenPrefix+"user-1").Return(nil, nil)
+			},
+			want: "You have not linked a Bugsnag token, so card actions use the shared token.",
+		},
+		{
+			name:    "status with a token",
+			cfg:     cfg,
+			command: "/bugsnag token status",
+			setup: func(api *plugintest.API) {
+				storedUserTokens(t, api, "user-1", map[string]string{"mobile": "mobile-personal"})
+			},
+			want: "* **Mobile**: Jane Doe (jane@example.com), saved 2024-05-01\n\nOn other connections card actions use the shared token.",
+		},
+		{
+			name:    "clear",
+			cfg:     cfg,
+			command: "/bugsnag token clear mobile",
+			setup: func(api *plugintest.API) {
+				storedUserTokens(t, api, "user-1", map[string]string{"mobile": "mobile-personal"})
+				api.On("KVSet", pluginID+":"+KVKeyUserTokenPrefix+"user-1", []byte("[]")).Return(nil)
+			},
+			want: "Removed your Bugsnag token for **Mobile**.",
+		},
+		{
+			name:    "clear without a token",
+			cfg:     cfg,
+			command: "/bugsnag token clear",
+			setup: func(api *plugintest.API) {
+				api.On("KVGet", pluginID+":"+KVKeyUserTokenPrefix+"user-1").Return(nil, nil)
+			},
+			want: "You have no Bugsnag token linked for **Default**.",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &plugintest.API{}
+			if tt.setup != nil {
+				tt.setup(api)
+			}
+
+			p := &Plugin{}
+			p.SetAPI(api)
+			p.kvNamespace = pluginID
+			p.configuration.Store(tt.cfg)
+
+			resp, appErr := p.ExecuteCommand(nil, &model.CommandArgs{UserId: "user-1", Command: tt.command})
+			if appErr != nil {
+				t.Fatalf("ExecuteCommand() error = %v", appErr)
+			}
+			if resp.ResponseType != model.CommandResponseTypeEphemeral {
+				t.Errorf("expected an ephemeral response, got %q", resp.ResponseType)
+			}
+			if !strings.Contains(resp.Text, tt.want) {
+				t.Errorf("response %q does not contain %q", resp.Text, tt.want)
+			}
+
+			api.AssertExpectations(t)
+		})
+	}
+}
@@ -1,12 +1,24 @@
 package main
 
 import (
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/a-voronkov/mattermost-bugsnag/server/bugsnag"
+	"github.com/a-voronkov/mattermost-bugsnag/server/connection"
+	"github.com/a-voronkov/mattermost-bugsnag/server/formatter"
+	"github.com/a-voronkov/mattermost-bugsnag/server/metrics"
+	"github.com/a-voronkov/mattermost-bugsnag/server/oncall"
+	"github.com/a-voronkov/mattermost-bugsnag/server/outbound"
+	"github.com/a-voronkov/mattermost-bugsnag/server/sourcelink"
 	"github.com/a-voronkov/mattermost-bugsnag/server/store"
 	"github.com/mattermost/mattermost/server/public/model"
 )
@@ -76,6 +88,8 @@ type appInfo struct {
 
 type deviceInfo struct {
 	Hostname       string `json:"hostname,omitempty"`
+	Manufacturer   string `json:"manufacturer,omitempty"`
+	Model          string `json:"model,omitempty"`
 	OSName         string `json:"osName,omitempty"`
 	OSVersion      string `json:"osVersion,omitempty"`
 	BrowserName    string `json:"browserName,omitempty"`
@@ -105,10 +119,17 @@ type stackFrame struct {
 }
 
 type releaseInfo struct {
-	ID           string `json:"id"`
-	Version      string `json:"version"`
-	ReleaseStage string `json:"releaseStage"`
-	URL          string `json:"url"`
+	ID            string             `json:"id"`
+	Version       string             `json:"version"`
+	ReleaseStage  string             `json:"releaseStage"`
+	URL           string             `json:"url"`
+	SourceControl *sourceControlInfo `json:"sourceControl,omitempty"`
+}
+
+type sourceControlInfo struct {
+	Provider   string `json:"provider,omitempty"`
+	Repository string `json:"repository,omitempty"`
+	Revision   string `json:"revision,omitempty"`
 }
 
 // Helper methods to extract common fields from the nested payload structure
@@ -133,6 +154,23 @@ func (p webhookPayload) getErrorID() string {
 	return ""
 }
 
+// rewriteDashboardURLs points the payload's dashboard links at the configured
+// Bugsnag dashboard, for on-premise and regional instances.
+func (p *webhookPayload) rewriteDashboardURLs(clients *bugsnag.Factory) {
+	if p.Error != nil {
+		p.Error.URL = clients.DashboardURL(p.Error.URL)
+	}
+	if p.Project != nil {
+		p.Project.URL = clients.DashboardURL(p.Project.URL)
+	}
+	if p.Account != nil {
+		p.Account.URL = clients.DashboardURL(p.Account.URL)
+	}
+	if p.Release != nil {
+		p.Release.URL = clients.DashboardURL(p.Release.URL)
+	}
+}
+
 func (p webhookPayload) getErrorURL() string {
 	if p.Error != nil {
 		return p.Error.URL
@@ -203,29 +241,106 @@ func (p webhookPayload) getAppVersion() string {
 	return ""
 }
 
-func (p webhookPayload) getStacktrace() []stackFrame {
-	if p.Error != nil {
-		// Try exceptions first (newer format)
-		if len(p.Error.Exceptions) > 0 && len(p.Error.Exceptions[0].Stacktrace) > 0 {
-			return p.Error.Exceptions[0].Stacktrace
-		}
-		// Fall back to deprecated stackTrace field
-		if len(p.Error.StackTrace) > 0 {
-			return p.Error.StackTrace
-		}
+// getRevision returns the source revision of the release, if Bugsnag sent one.
+func (p webhookPayload) getRevision() string {
+	if p.Release != nil && p.Release.SourceControl != nil {
+		return p.Release.SourceControl.Revision
+	}
+	return ""
+}
+
+// getReleaseVersion returns the app version, falling back to the release block.
+func (p webhookPayload) getReleaseVersion() string {
+	if version := p.getAppVersion(); version != "" {
+		return version
+	}
+	if p.Release != nil {
+		return p.Release.Version
+	}
+	return ""
+}
+
+// getExceptions returns the error's cause chain, falling back to the deprecated
+// top-level stackTrace field for older payloads.
+func (p webhookPayload) getExceptions() []exceptionInfo {
+	if p.Error == nil {
+		return nil
+	}
+	if len(p.Error.Exceptions) > 0 {
+		return p.Error.Exceptions
+	}
+	if len(p.Error.StackTrace) > 0 {
+		return []exceptionInfo{{
+			ErrorClass: p.Error.ExceptionClass,
+			Message:    p.Error.Message,
+			Stacktrace: p.Error.StackTrace,
+		}}
 	}
 	return nil
 }
 
+// toFormatterExceptions converts the payload exceptions for the stacktrace renderer.
+func toFormatterExceptions(exceptions []exceptionInfo) []formatter.Exception {
+	result := make([]formatter.Exception, 0, len(exceptions))
+	for _, exception := range exceptions {
+		frames := make([]formatter.StackFrame, 0, len(exception.Stacktrace))
+		for _, frame := range exception.Stacktrace {
+			frames = append(frames, formatter.StackFrame{
+				File:         frame.File,
+				Method:       frame.Method,
+				LineNumber:   frameNumber(frame.LineNumber),
+				ColumnNumber: frameNumber(frame.ColumnNumber),
+				InProject:    frame.InProject,
+				Code:         frame.Code,
+			})
+		}
+		result = append(result, formatter.Exception{
+			ErrorClass: exception.ErrorClass,
+			Message:    exception.Message,
+			Stacktrace: frames,
+		})
+	}
+	return result
+}
+
+// frameNumber normalizes line and column numbers, which Bugsnag may send as
+// numbers or strings.
+func frameNumber(v interface{}) string {
+	switch n := v.(type) {
+	case nil:
+		return ""
+	case float64:
+		return strconv.FormatFloat(n, 'f', -1, 64)
+	default:
+		return strings.TrimSpace(fmt.Sprint(n))
+	}
+}
+
 func (p *Plugin) handleWebhook(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
+		metrics.WebhookDeliveries.Inc(metrics.OutcomeRejected)
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
 	cfg := p.getConfiguration()
-	if err := validateWebhookToken(cfg, r); err != nil {
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxWebhookBodyBytes))
+	if err != nil {
+		metrics.WebhookDeliveries.Inc(metrics.OutcomeRejected)
+		http.Error(w, "cannot read payload", http.StatusBadRequest)
+		return
+	}
+	delivery := newDelivery(r, body, cfg.DeliveryLogRedact)
+
+	conn, err := webhookConnection(cfg, r)
+	delivery.Connection = conn.ID
+	if err != nil {
+		metrics.WebhookDeliveries.Inc(metrics.OutcomeRejected)
 		p.API.LogWarn("webhook rejected", "err", err.Error(), "remote", r.RemoteAddr)
+		delivery.Validation = err.Error()
+		delivery.Outcome = metrics.OutcomeRejected
+		p.recordDelivery(cfg, delivery)
 		http.Error(w, err.Error(), http.StatusUnauthorized)
 		return
 	}
@@ -233,328 +348,344 @@ func (p *Plugin) handleWebhook(w http.ResponseWriter, r *http.Request) {
 	p.API.LogInfo("received webhook", "remote", r.RemoteAddr)
 
 	mm := newMMClient(p.API, cfg.EnableDebugLog, p.kvNS(), p.botUserID)
+	result := p.processWebhook(mm, cfg, conn, body, r.URL.Query().Get("channel_id"))
 
-	var payload webhookPayload
-	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
-		http.Error(w, "invalid payload", http.StatusBadRequest)
+	delivery.Validation = deliveryValidationOK
+	result.apply(&delivery)
+	p.recordDelivery(cfg, delivery)
+
+	if result.status != http.StatusAccepted {
+		http.Error(w, result.message, result.status)
 		return
 	}
 
+	// Placeholder response to keep Bugsnag happy while the full workflow is developed.
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"status":    "accepted",
+		"processed": result.processed,
+	})
+}
+
+// webhookResult is what the pipeline did with one delivery.
+type webhookResult struct {
+	status int
+	// message is returned to the sender when status is not 202.
+	message                       string
+	outcome                       string
+	processed, duplicates, failed int
+	matchedRules                  []string
+	errors                        []string
+}
+
+// fail marks the delivery as rejected or failed with the given HTTP status.
+func (res *webhookResult) fail(status int, outcome, message string) webhookResult {
+	res.status = status
+	res.outcome = outcome
+	res.message = message
+	return *res
+}
+
+// processWebhook runs a validated payload through channel rule matching and
+// posts or updates the cards. Only rules for the delivery's connection apply.
+// channelID is the provisional channel_id query parameter. It is shared by
+// live deliveries and replays.
+func (p *Plugin) processWebhook(mm *MMClient, cfg Configuration, conn connection.Connection, body []byte, channelID string) (result webhookResult) {
+	result.matchedRules = []string{}
+	defer func() { metrics.WebhookDeliveries.Inc(result.outcome) }()
+
+	var payload webhookPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return result.fail(http.StatusBadRequest, metrics.OutcomeRejected, "invalid payload")
+	}
+	payload.rewriteDashboardURLs(p.bugsnagClients())
+
+	if trigger := payload.Trigger.triggerType(); trigger != "" && !trigger.Known() {
+		mm.LogDebug("unknown trigger type, posting generic update", "trigger", string(trigger))
+	}
+
 	allRules, err := loadChannelRules(mm)
 	if err != nil {
 		p.API.LogError("failed to load channel rules", "err", err.Error())
-		http.Error(w, "cannot load channel mappings", http.StatusInternalServerError)
-		return
+		result.errors = append(result.errors, "load channel rules: "+err.Error())
+		return result.fail(http.StatusInternalServerError, metrics.OutcomeError, "cannot load channel mappings")
 	}
 
 	projectID := payload.getProjectID()
 	errorID := payload.getErrorID()
 
-	channelRules := getRulesForProject(allRules, projectID)
-	processed := 0
+	connections := cfg.AllConnections()
+	for _, rule := range getRulesForProject(allRules, projectID) {
+		if !connection.Same(connections, rule.ConnectionID, conn.ID) {
+			continue
+		}
+		rule.ConnectionID = conn.ID
+
+		if payload.isReleaseOnly() {
+			if !matchesReleaseRule(rule, payload) {
+				continue
+			}
+			metrics.RuleMatches.Inc(rule.ID)
+			result.matchedRules = append(result.matchedRules, rule.ID)
+			if err := p.upsertReleaseCard(mm, rule.ChannelID, payload); errors.Is(err, errReleaseAlreadyPosted) {
+				result.duplicates++
+				continue
+			} else if err != nil {
+				result.failed++
+				result.errors = append(result.errors, fmt.Sprintf("rule %s: %s", rule.ID, err.Error()))
+				p.API.LogError("failed to post release card", "channel", rule.ChannelID, "project_id", projectID, "version", payload.Release.Version, "err", err.Error())
+				continue
+			}
+			result.processed++
+			continue
+		}
 
-	for _, rule := range channelRules {
 		if !matchesRule(rule, payload) {
 			continue
 		}
+		metrics.RuleMatches.Inc(rule.ID)
+		result.matchedRules = append(result.matchedRules, rule.ID)
 
-		if err := p.upsertErrorCard(mm, rule.ChannelID, payload, cfg); err != nil {
+		if err := p.upsertErrorCard(mm, rule, payload, cfg); err != nil {
+			result.failed++
+			result.errors = append(result.errors, fmt.Sprintf("rule %s: %s", rule.ID, err.Error()))
 			p.API.LogError("failed to upsert webhook card", "channel", rule.ChannelID, "error_id", errorID, "project_id", projectID, "err", err.Error())
 			continue
 		}
 
-		processed++
+		result.processed++
 	}
 
 	// For early testing, allow an explicit channel_id query parameter to render a
 	// provisional card. This will be replaced by project→channel mappings.
-	channelID := strings.TrimSpace(r.URL.Query().Get("channel_id"))
-	if channelID != "" {
+	if channelID = strings.TrimSpace(channelID); channelID != "" {
 		if _, appErr := mm.GetChannel(channelID); appErr != nil {
-			http.Error(w, "invalid channel_id", http.StatusBadRequest)
-			return
+			return result.fail(http.StatusBadRequest, metrics.OutcomeRejected, "invalid channel_id")
 		}
 
-		if err := p.upsertErrorCard(mm, channelID, payload, cfg); err != nil {
+		var err error
+		if payload.isReleaseOnly() {
+			err = p.upsertReleaseCard(mm, channelID, payload)
+		} else {
+			err = p.upsertErrorCard(mm, ChannelRule{ChannelID: channelID, ConnectionID: conn.ID}, payload, cfg)
+		}
+		switch {
+		case errors.Is(err, errReleaseAlreadyPosted):
+			result.duplicates++
+		case err != nil:
 			p.API.LogError("failed to create provisional webhook post", "err", err.Error())
-			http.Error(w, "failed to create post", http.StatusInternalServerError)
-			return
+			result.errors = append(result.errors, "channel_id: "+err.Error())
+			return result.fail(http.StatusInternalServerError, metrics.OutcomeError, "failed to create post")
+		default:
+			result.processed++
 		}
+	}
 
-		processed++
+	result.status = http.StatusAccepted
+	result.outcome = deliveryOutcome(result.processed, result.duplicates, result.failed)
+	if result.processed > 0 {
+		p.emitSpike(mm, conn, payload)
+		s := store.New(&pluginKVAdapter{api: p.API, namespace: p.kvNS()})
+		if err := s.RecordWebhook(projectID, time.Now()); err != nil {
+			mm.LogDebug("failed to record webhook health", "err", err.Error())
+		}
 	}
 
-	// Placeholder response to keep Bugsnag happy while the full workflow is developed.
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusAccepted)
-	_ = json.NewEncoder(w).Encode(map[string]any{
-		"status":    "accepted",
-		"processed": processed,
-	})
+	return result
 }
 
-func validateWebhookToken(cfg Configuration, r *http.Request) error {
-	expected := strings.TrimSpace(cfg.WebhookToken)
-	if expected == "" {
-		expected = strings.TrimSpace(cfg.WebhookSecret)
+// deliveryOutcome summarizes a delivery for the webhook metrics.
+func deliveryOutcome(processed, duplicates, failed int) string {
+	switch {
+	case processed > 0:
+		return metrics.OutcomeProcessed
+	case failed > 0:
+		return metrics.OutcomeError
+	case duplicates > 0:
+		return metrics.OutcomeDuplicate
+	default:
+		return metrics.OutcomeUnmatched
 	}
+}
 
-	if expected == "" {
-		return nil
-	}
+// webhookConnection authenticates a delivery and returns the connection it
+// belongs to. Deliveries are routed by a /webhook/{connection_id} URL, or else
+// by whichever connection's webhook token they carry; anything else goes to
+// the first connection and must carry the global token.
+func webhookConnection(cfg Configuration, r *http.Request) (connection.Connection, error) {
+	connections := cfg.AllConnections()
 
 	provided := strings.TrimSpace(r.URL.Query().Get("token"))
 	if provided == "" {
 		provided = strings.TrimSpace(r.Header.Get("X-Bugsnag-Token"))
 	}
 
-	if provided == "" {
-		return fmt.Errorf("missing webhook token")
+	if id, ok := strings.CutPrefix(r.URL.Path, "/webhook/"); ok {
+		conn, found := connection.Find(connections, id)
+		if strings.TrimSpace(id) == "" || !found {
+			return connection.Connection{}, fmt.Errorf("unknown connection %q", id)
+		}
+		expected := conn.WebhookToken
+		if expected == "" {
+			expected = cfg.webhookToken()
+		}
+		return conn, checkWebhookToken(expected, provided)
 	}
 
-	if provided != expected {
-		return fmt.Errorf("invalid webhook token")
+	if provided != "" {
+		for _, conn := range connections {
+			if conn.WebhookToken != "" && subtle.ConstantTimeCompare([]byte(conn.WebhookToken), []byte(provided)) == 1 {
+				return conn, nil
+			}
+		}
 	}
 
-	return nil
+	conn, _ := connection.Find(connections, "")
+	return conn, checkWebhookToken(cfg.webhookToken(), provided)
 }
 
-func buildCardTitle(payload webhookPayload) string {
-	exceptionClass := payload.getExceptionClass()
-	message := payload.getMessage()
-
-	if exceptionClass != "" && message != "" {
-		return fmt.Sprintf(":rotating_light: **%s**: %s", exceptionClass, message)
-	}
-	if exceptionClass != "" {
-		return ":rotating_light: **" + exceptionClass + "**"
-	}
-	if message != "" {
-		return ":rotating_light: " + message
-	}
-	if payload.Trigger.Message != "" {
-		return ":rotating_light: " + payload.Trigger.Message
+func checkWebhookToken(expected, provided string) error {
+	if expected == "" {
+		return nil
 	}
-	return ":rotating_light: Bugsnag error"
-}
 
-func buildCardAttachment(payload webhookPayload, cfg Configuration, userMappings []UserMapping, mm *MMClient) *model.SlackAttachment {
-	errorID := payload.getErrorID()
-	projectID := payload.getProjectID()
-	projectName := payload.getProjectName()
-	errorURL := payload.getErrorURL()
-	severity := payload.getSeverity()
-	environment := payload.getEnvironment()
-	context := payload.getContext()
-	status := payload.getStatus()
-	appVersion := payload.getAppVersion()
-
-	// Build fields with short=true for compact display
-	var attachmentFields []*model.SlackAttachmentField
-
-	if severity != "" {
-		attachmentFields = append(attachmentFields, &model.SlackAttachmentField{
-			Title: "Severity",
-			Value: severityEmoji(severity) + " " + severity,
-			Short: true,
-		})
+	if provided == "" {
+		return fmt.Errorf("missing webhook token")
 	}
 
-	if environment != "" {
-		attachmentFields = append(attachmentFields, &model.SlackAttachmentField{
-			Title: "Environment",
-			Value: environment,
-			Short: true,
-		})
+	if subtle.ConstantTimeCompare([]byte(provided), []byte(expected)) != 1 {
+		return fmt.Errorf("invalid webhook token")
 	}
 
-	if status != "" {
-		attachmentFields = append(attachmentFields, &model.SlackAttachmentField{
-			Title: "Status",
-			Value: status,
-			Short: true,
-		})
+	return nil
+}
+
+// cardData converts a webhook payload into the formatter's card model, resolving
+// the assigned collaborator to a Mattermost username when a mapping exists.
+func cardData(payload webhookPayload, userMappings []UserMapping, mm *MMClient) formatter.ErrorData {
+	data := formatter.ErrorData{
+		ID:             payload.getErrorID(),
+		ProjectID:      payload.getProjectID(),
+		ProjectName:    payload.getProjectName(),
+		ExceptionClass: payload.getExceptionClass(),
+		Message:        payload.getMessage(),
+		Summary:        payload.Trigger.Message,
+		Context:        payload.getContext(),
+		Status:         payload.getStatus(),
+		Environment:    payload.getEnvironment(),
+		Severity:       payload.getSeverity(),
+		AppVersion:     payload.getAppVersion(),
+		ErrorURL:       payload.getErrorURL(),
 	}
 
-	// Show assigned user instead of "Handled" field
 	if assignee := payload.getAssignedCollaborator(); assignee != nil {
-		assignedValue := assignee.Email // Default to email
-		// Try to find Mattermost user
-		if mmUserID := mapBugsnagToMattermost(userMappings, assignee.ID, assignee.Email); mmUserID != "" {
+		data.AssigneeEmail = assignee.Email
+		if mmUserID := mapBugsnagToMattermost(userMappings, assignee.ID, assignee.Email); mmUserID != "" && mm != nil {
 			if mmUser, appErr := mm.GetUser(mmUserID); appErr == nil {
-				assignedValue = "@" + mmUser.Username
+				data.AssigneeUsername = mmUser.Username
 			}
 		}
-		attachmentFields = append(attachmentFields, &model.SlackAttachmentField{
-			Title: "Assigned",
-			Value: assignedValue,
-			Short: true,
-		})
-	}
-
-	if context != "" {
-		attachmentFields = append(attachmentFields, &model.SlackAttachmentField{
-			Title: "Context",
-			Value: context,
-			Short: true,
-		})
-	}
-
-	if appVersion != "" {
-		attachmentFields = append(attachmentFields, &model.SlackAttachmentField{
-			Title: "App Version",
-			Value: appVersion,
-			Short: true,
-		})
 	}
 
-	if projectName != "" {
-		attachmentFields = append(attachmentFields, &model.SlackAttachmentField{
-			Title: "Project",
-			Value: projectName,
-			Short: true,
-		})
-	}
-
-	// Trigger info is shown in update comments, not in the card fields
-
-	footer := "Bugsnag"
-	if cfg.OrganizationID != "" {
-		footer = fmt.Sprintf("Bugsnag • org %s", cfg.OrganizationID)
-	}
-
-	actionURL := fmt.Sprintf("/plugins/%s/actions", pluginID)
-	actions := []*model.PostAction{
-		{
-			Id:    "assign_me",
-			Name:  "Assign to me",
-			Style: "primary",
-			Type:  model.PostActionTypeButton,
-			Integration: &model.PostActionIntegration{
-				URL: actionURL,
-				Context: map[string]any{
-					"action":     "assign_me",
-					"error_id":   errorID,
-					"project_id": projectID,
-					"error_url":  errorURL,
-				},
-			},
-		},
-		{
-			Id:    "resolve",
-			Name:  "Resolve",
-			Style: "primary",
-			Type:  model.PostActionTypeButton,
-			Integration: &model.PostActionIntegration{
-				URL: actionURL,
-				Context: map[string]any{
-					"action":     "resolve",
-					"error_id":   errorID,
-					"project_id": projectID,
-				},
-			},
-		},
-		{
-			Id:    "ignore",
-			Name:  "Ignore",
-			Style: "default",
-			Type:  model.PostActionTypeButton,
-			Integration: &model.PostActionIntegration{
-				URL: actionURL,
-				Context: map[string]any{
-					"action":     "ignore",
-					"error_id":   errorID,
-					"project_id": projectID,
-				},
-			},
-		},
-	}
-
-	// Note: "Open in Bugsnag" link is available via TitleLink on the attachment title
-
-	// Set color based on severity
-	color := "#4949E4" // Bugsnag purple default
-	switch severity {
-	case "error":
-		color = "#D9534F" // red
-	case "warning":
-		color = "#F0AD4E" // yellow/orange
-	case "info":
-		color = "#5BC0DE" // blue
-	}
-
-	return &model.SlackAttachment{
-		Color:     color,
-		Title:     payload.getExceptionClass(),
-		TitleLink: errorURL,
-		Text:      payload.getMessage(),
-		Fields:    attachmentFields,
-		Footer:    footer,
-		Actions:   actions,
-	}
-}
-
-func severityEmoji(severity string) string {
-	switch severity {
-	case "error":
-		return "🔴"
-	case "warning":
-		return "🟡"
-	case "info":
-		return "🔵"
-	default:
-		return "⚪"
-	}
+	return data
 }
 
-// formatStacktrace formats the stacktrace for display in a comment
-func formatStacktrace(frames []stackFrame, maxFrames int) string {
-	if len(frames) == 0 {
-		return ""
+// requestContext builds the card context section from the payload's request,
+// device and user blocks, applying the rule's redactions.
+func requestContext(payload webhookPayload, redact []string) formatter.RequestContext {
+	if payload.Error == nil {
+		return formatter.RequestContext{}
 	}
 
-	var sb strings.Builder
-	sb.WriteString("**Stacktrace:**\n```\n")
+	redacted := func(field string) bool { return containsValue(redact, field) }
+	var ctx formatter.RequestContext
 
-	limit := len(frames)
-	if maxFrames > 0 && limit > maxFrames {
-		limit = maxFrames
+	if requestURL := strings.TrimSpace(payload.Error.RequestURL); requestURL != "" {
+		switch {
+		case redacted(redactURL):
+			ctx.URL = redactedValue
+		case redacted(redactURLQuery):
+			ctx.URL = stripURLQuery(requestURL)
+		default:
+			ctx.URL = requestURL
+		}
 	}
 
-	for i := 0; i < limit; i++ {
-		frame := frames[i]
-		prefix := "  "
-		if frame.InProject {
-			prefix = "→ " // highlight in-project frames
+	if device := payload.Error.Device; device != nil {
+		ctx.Browser = joinNonEmpty(" ", device.BrowserName, device.BrowserVersion)
+		if ctx.Browser != "" && redacted(redactBrowser) {
+			ctx.Browser = redactedValue
 		}
-
-		file := frame.File
-		if file == "" {
-			file = "<unknown>"
+		_, ctx.OS = deviceDetails(payload, redact)
+		ctx.Hostname = strings.TrimSpace(device.Hostname)
+		if ctx.Hostname != "" && redacted(redactHostname) {
+			ctx.Hostname = redactedValue
 		}
+	}
 
-		method := frame.Method
-		if method == "" {
-			method = "<anonymous>"
+	if user := payload.Error.User; user != nil {
+		var parts []string
+		if name := strings.TrimSpace(user.Name); name != "" && !redacted(redactUserName) {
+			parts = append(parts, name)
 		}
-
-		line := ""
-		if frame.LineNumber != nil {
-			line = fmt.Sprintf(":%v", frame.LineNumber)
+		if email := strings.TrimSpace(user.Email); email != "" && !redacted(redactUserEmail) {
+			parts = append(parts, "<"+email+">")
+		}
+		if id := strings.TrimSpace(user.ID); id != "" && !redacted(redactUserID) {
+			parts = append(parts, "("+id+")")
 		}
+		ctx.User = strings.Join(parts, " ")
+		if ctx.User == "" && (user.ID != "" || user.Name != "" || user.Email != "") {
+			ctx.User = redactedValue
+		}
+	}
+
+	return ctx
+}
 
-		sb.WriteString(fmt.Sprintf("%s%s%s in %s\n", prefix, file, line, method))
+// deviceDetails returns the device model and operating system of the
+// payload, applying the rule's OS redaction. Cards carry them whatever the
+// rule's context setting, so templates can show them.
+func deviceDetails(payload webhookPayload, redact []string) (device, os string) {
+	if payload.Error == nil || payload.Error.Device == nil {
+		return "", ""
 	}
 
-	if len(frames) > limit {
-		sb.WriteString(fmt.Sprintf("  ... and %d more frames\n", len(frames)-limit))
+	info := payload.Error.Device
+	device = joinNonEmpty(" ", info.Manufacturer, info.Model)
+	os = joinNonEmpty(" ", info.OSName, info.OSVersion)
+	if os != "" && containsValue(redact, redactOS) {
+		os = redactedValue
 	}
+	return device, os
+}
 
-	sb.WriteString("```")
-	return sb.String()
+// stripURLQuery keeps the scheme, host and path of a URL but hides its query.
+func stripURLQuery(raw string) string {
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		return redactedValue
+	}
+	if parsed.RawQuery != "" {
+		parsed.RawQuery = "redacted"
+	}
+	parsed.Fragment = ""
+	return parsed.String()
+}
+
+func joinNonEmpty(sep string, values ...string) string {
+	var parts []string
+	for _, v := range values {
+		if v = strings.TrimSpace(v); v != "" {
+			parts = append(parts, v)
+		}
+	}
+	return strings.Join(parts, sep)
 }
 
-func (p *Plugin) upsertErrorCard(mm *MMClient, channelID string, payload webhookPayload, cfg Configuration) error {
+func (p *Plugin) upsertErrorCard(mm *MMClient, rule ChannelRule, payload webhookPayload, cfg Configuration) error {
+	channelID := rule.ChannelID
 	if strings.TrimSpace(channelID) == "" {
 		return fmt.Errorf("channelID is required")
 	}
@@ -571,79 +702,193 @@ func (p *Plugin) upsertErrorCard(mm *MMClient, channelID string, payload webhook
 
 	// Load user mappings for Assigned field
 	userMappings, _ := loadUserMappings(mm)
+	userMappings = userMappingsFor(userMappings, rule.ConnectionID)
 
-	attachments := []*model.SlackAttachment{buildCardAttachment(payload, cfg, userMappings, mm)}
-	title := buildCardTitle(payload)
+	data := cardData(payload, userMappings, mm)
+	data.Device, data.OS = deviceDetails(payload, rule.RedactFields)
+	if rule.ShowContext == contextModeCard {
+		data.Request = requestContext(payload, rule.RedactFields)
+	}
+	data.PlaybookAvailable = rule.Playbook.playbookFor(data.Severity) != ""
+	data.TicketAvailable = len(cfg.issueTrackers()) > 0
+
+	templates, err := loadCardTemplates(mm)
+	if err != nil {
+		mm.LogDebug("failed to load card templates", "err", err.Error())
+	}
+	tmpl := formatter.FindTemplate(templates, rule.TemplateID)
 
 	if found {
+		resolvedBy := mapping.ResolvedBy
 		post, appErr := mm.GetPost(mapping.PostID)
 		if appErr != nil {
 			return fmt.Errorf("load post: %w", appErr)
 		}
 
-		post.Message = title
-		if post.Props == nil {
-			post.Props = map[string]any{}
+		var previous *formatter.ErrorData
+		if stored, ok := formatter.CardData(post); ok {
+			previous = &stored
+		}
+		data = applyTrigger(payload.Trigger, data, previous)
+
+		if err := formatter.ApplyTemplatedCard(post, data, formatter.ErrorPostMapping{
+			ChannelID: mapping.ChannelID,
+			ProjectID: projectID,
+			ErrorID:   errorID,
+		}, tmpl); err != nil {
+			p.API.LogWarn("card template failed, using built-in layout", "err", err.Error())
 		}
-		post.Props["attachments"] = attachments
 
 		if _, appErr := mm.UpdatePost(post); appErr != nil {
 			return fmt.Errorf("update post: %w", appErr)
 		}
 
-		// Add update comment with trigger info
-		if payload.Trigger.Type != "" {
-			replyMsg := fmt.Sprintf("🔄 **Update**: %s", payload.Trigger.Message)
+		if isRegression(payload.Trigger, previous, data) && p.resurfaces(rule) {
+			resurfaced, err := p.resurfaceError(mm, rule, mapping, payload, data, tmpl, cfg)
+			if err != nil {
+				return err
+			}
+			if err := mm.StoreJSON(key, resurfaced); err != nil {
+				mm.LogDebug("failed to store error→post mapping", "err", err.Error())
+			}
+			p.emitWebhookChanges(mm, payload, previous, data, resurfaced)
+			p.openIncidentIfNeeded(mm, rule, payload, data, resurfaced, resolvedBy, tmpl, cfg)
+			return nil
+		}
+
+		if replyMsg := triggerReply(payload.Trigger, data); replyMsg != "" {
 			if _, appErr := mm.CreateReply(mapping.ChannelID, mapping.PostID, replyMsg); appErr != nil {
 				mm.LogDebug("failed to append webhook reply", "err", appErr.Error())
 			}
 		}
 
+		if previous == nil || previous.Status != data.Status {
+			mapping = p.errorStatusChanged(mm, mapping, data.Status)
+		}
+		p.emitWebhookChanges(mm, payload, previous, data, mapping)
+		p.openIncidentIfNeeded(mm, rule, payload, data, mapping, resolvedBy, tmpl, cfg)
+
 		return nil
 	}
 
+	// New errors of a rule with a rotation go to whoever is on call, unless
+	// Bugsnag already has an assignee.
+	var onCall string
+	var rotation oncall.Rotation
+	if data.Assignee() == "" {
+		if onCall, rotation = p.assignOnCall(mm, rule, projectID, errorID, userMappings, cfg); onCall != "" {
+			data.AssigneeUsername = onCall
+		}
+	}
+
 	// Create new post
-	post, appErr := mm.CreatePost(channelID, title, attachments)
+	card := &model.Post{ChannelId: channelID}
+	if err := formatter.ApplyTemplatedCard(card, data, formatter.ErrorPostMapping{
+		ChannelID: channelID,
+		ProjectID: projectID,
+		ErrorID:   errorID,
+	}, tmpl); err != nil {
+		p.API.LogWarn("card template failed, using built-in layout", "err", err.Error())
+	}
+	post, appErr := mm.CreateCardPost(card)
 	if appErr != nil {
 		return fmt.Errorf("create post: %w", appErr)
 	}
 
 	// Store mapping for future updates
 	mapping = ErrorPostMapping{
-		ProjectID: projectID,
-		ErrorID:   errorID,
-		ChannelID: channelID,
-		PostID:    post.Id,
+		ConnectionID: rule.ConnectionID,
+		ProjectID:    projectID,
+		ErrorID:      errorID,
+		ChannelID:    channelID,
+		PostID:       post.Id,
+		Escalating:   rule.Escalation.escalates(data.Severity),
 	}
 
 	if err := mm.StoreJSON(key, mapping); err != nil {
 		mm.LogDebug("failed to store error→post mapping", "err", err.Error())
 	}
+	p.startEscalation(mm, rule, mapping, data.Assignee())
 
-	// Add stacktrace as first reply if available
-	stacktrace := payload.getStacktrace()
-	if len(stacktrace) > 0 {
-		traceComment := formatStacktrace(stacktrace, 15) // limit to 15 frames
-		if traceComment != "" {
-			if _, appErr := mm.CreateReply(channelID, post.Id, traceComment); appErr != nil {
-				mm.LogDebug("failed to add stacktrace reply", "err", appErr.Error())
+	if onCall != "" {
+		reply := fmt.Sprintf("📟 @%s is on call for **%s** and has been assigned this error.", onCall, rotation.DisplayName())
+		if _, appErr := mm.CreateReply(channelID, post.Id, reply); appErr != nil {
+			mm.LogDebug("failed to mention on-call user", "err", appErr.Error())
+		}
+	}
+
+	if rule.ShowContext == contextModeThread {
+		if reply := formatter.RenderContext(requestContext(payload, rule.RedactFields)); reply != "" {
+			if _, appErr := mm.CreateReply(channelID, post.Id, reply); appErr != nil {
+				mm.LogDebug("failed to add context reply", "err", appErr.Error())
 			}
 		}
 	}
 
+	// Add stacktrace as first reply if available
+	p.postStacktraceReply(mm, channelID, post.Id, payload, cfg)
+
+	p.threadUnderRelease(mm, channelID, post.Id, payload, data)
+
 	// Register error for periodic sync
-	kvStore := &pluginKVAdapter{api: p.API, namespace: p.kvNS()}
-	s := store.New(kvStore)
-	activeErr := store.ActiveError{
-		ErrorID:      errorID,
-		ProjectID:    projectID,
-		PostID:       post.Id,
-		ChannelID:    channelID,
-		LastSyncedAt: time.Now().UTC(),
-	}
-	if err := s.UpsertActiveError(activeErr); err != nil {
-		mm.LogDebug("failed to register active error for sync", "err", err.Error())
+	p.registerActiveError(mm, mapping)
+
+	mapping = p.openIncidentIfNeeded(mm, rule, payload, data, mapping, "", tmpl, cfg)
+	p.autoStartPlaybook(mm, rule, payload, data, mapping)
+	p.emitEvent(mm, outbound.Event{Type: outbound.EventCardCreated, Source: outbound.SourceWebhook}, mapping, &data)
+	if onCall != "" {
+		p.emitEvent(mm, outbound.Event{Type: outbound.EventAssigned, Source: outbound.SourceWebhook, Assignee: onCall}, mapping, &data)
 	}
 
 	return nil
 }
+
+// postStacktraceReply renders the error's exceptions into the card thread. When
+// the trace does not fit, the full text is attached as a file.
+func (p *Plugin) postStacktraceReply(mm *MMClient, channelID, rootID string, payload webhookPayload, cfg Configuration) {
+	exceptions := toFormatterExceptions(payload.getExceptions())
+	if len(exceptions) == 0 {
+		return
+	}
+
+	repositories, err := loadRepositories(mm)
+	if err != nil {
+		mm.LogDebug("failed to load repositories", "err", err.Error())
+	}
+	if repo, ok := sourcelink.ForProject(repositories, payload.getProjectID()); ok {
+		linkFrames(exceptions, repo, repo.Ref(payload.getRevision(), payload.getReleaseVersion()))
+	}
+
+	trace := formatter.RenderStacktrace(exceptions, formatter.StacktraceOptions{
+		MaxFrames:             cfg.StacktraceMaxFrames,
+		CollapseLibraryFrames: cfg.StacktraceCollapseLibraryFrames,
+	})
+	if trace.Reply == "" {
+		return
+	}
+
+	if trace.Full == "" {
+		if _, appErr := mm.CreateReply(channelID, rootID, trace.Reply); appErr != nil {
+			mm.LogDebug("failed to add stacktrace reply", "err", appErr.Error())
+		}
+		return
+	}
+
+	if _, appErr := mm.CreateReplyWithFile(channelID, rootID, trace.Reply, formatter.StacktraceFilename, []byte(trace.Full)); appErr != nil {
+		mm.LogDebug("failed to add stacktrace reply with attachment", "err", appErr.Error())
+	}
+}
+
+// linkFrames points in-project frames at their source file for ref.
+func linkFrames(exceptions []formatter.Exception, repo sourcelink.Repository, ref string) {
+	if ref == "" {
+		return
+	}
+	for i := range exceptions {
+		for j, frame := range exceptions[i].Stacktrace {
+			if frame.InProject {
+				exceptions[i].Stacktrace[j].URL = repo.FileURL(frame.File, frame.LineNumber, ref)
+			}
+		}
+	}
+}
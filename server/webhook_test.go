@@ -7,6 +7,11 @@ import (
 	"net/http/httptest"
 	"testing"
 
+	"github.com/a-voronkov/mattermost-bugsnag/server/bugsnag"
+	"github.com/a-voronkov/mattermost-bugsnag/server/connection"
+	"github.com/a-voronkov/mattermost-bugsnag/server/formatter"
+	"github.com/a-voronkov/mattermost-bugsnag/server/metrics"
+	"github.com/a-voronkov/mattermost-bugsnag/server/sourcelink"
 	"github.com/mattermost/mattermost/server/public/model"
 	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
 	"github.com/stretchr/testify/mock"
@@ -16,6 +21,7 @@ func TestHandleWebhookMethodNotAllowed(t *testing.T) {
 	api := &plugintest.API{}
 	p := &Plugin{}
 	p.SetAPI(api)
+	rejected := metrics.WebhookDeliveries.Value(metrics.OutcomeRejected)
 
 	req := httptest.NewRequest(http.MethodGet, "/webhook", nil)
 	rr := httptest.NewRecorder()
@@ -25,6 +31,27 @@ func TestHandleWebhookMethodNotAllowed(t *testing.T) {
 	if rr.Code != http.StatusMethodNotAllowed {
 		t.Fatalf("expected status %d, got %d", http.StatusMethodNotAllowed, rr.Code)
 	}
+	if got := metrics.WebhookDeliveries.Value(metrics.OutcomeRejected); got != rejected+1 {
+		t.Errorf("expected the rejected delivery to be counted, got %v after %v", got, rejected)
+	}
+}
+
+func TestDeliveryOutcome(t *testing.T) {
+	tests := []struct {
+		processed, duplicates, failed int
+		want                          string
+	}{
+		{1, 0, 1, metrics.OutcomeProcessed},
+		{0, 1, 1, metrics.OutcomeError},
+		{0, 1, 0, metrics.OutcomeDuplicate},
+		{0, 0, 0, metrics.OutcomeUnmatched},
+	}
+
+	for _, tt := range tests {
+		if got := deliveryOutcome(tt.processed, tt.duplicates, tt.failed); got != tt.want {
+			t.Errorf("deliveryOutcome(%d, %d, %d) = %q, want %q", tt.processed, tt.duplicates, tt.failed, got, tt.want)
+		}
+	}
 }
 
 func TestHandleWebhookMissingToken(t *testing.T) {
@@ -111,11 +138,14 @@ func TestHandleWebhookWithChannelID(t *testing.T) {
 	api.On("KVGet", pluginID+":"+KVKeyUserMappings).Return(nil, nil)
 	api.On("GetChannel", channelID).Return(&model.Channel{Id: channelID}, nil)
 	api.On("KVGet", pluginID+":"+KVKeyErrorPostPrefix+"proj-1:err-123").Return(nil, nil)
+	api.On("KVGet", pluginID+":"+KVKeyCardTemplates).Return(nil, nil)
 	api.On("CreatePost", mock.AnythingOfType("*model.Post")).Return(&model.Post{Id: postID, ChannelId: channelID}, nil)
 	api.On("KVSet", pluginID+":"+KVKeyErrorPostPrefix+"proj-1:err-123", mock.Anything).Return(nil)
 	// ActiveErrors for sync scheduler
 	api.On("KVGet", pluginID+":"+KVKeyActiveErrors).Return(nil, nil)
 	api.On("KVSet", pluginID+":"+KVKeyActiveErrors, mock.Anything).Return(nil)
+	api.On("KVGet", pluginID+":"+KVKeyHealth).Return(nil, nil)
+	api.On("KVSet", pluginID+":"+KVKeyHealth, mock.Anything).Return(nil)
 	api.On("LogDebug", mock.Anything, mock.Anything, mock.Anything).Return().Maybe()
 
 	p := &Plugin{}
@@ -141,13 +171,109 @@ func TestHandleWebhookWithChannelID(t *testing.T) {
 	}
 }
 
-func TestValidateWebhookToken(t *testing.T) {
+func TestHandleWebhookAppliesRuleTemplate(t *testing.T) {
+	channelID := "channel-123"
+
+	rules, _ := json.Marshal([]ChannelRule{{ID: "r1", ProjectID: "proj-1", ChannelID: channelID, TemplateID: "mobile"}})
+	templates, _ := json.Marshal([]formatter.CardTemplate{
+		{ID: formatter.DefaultTemplateID, Title: "default {{.ExceptionClass}}"},
+		{ID: "mobile", Title: "📱 {{.ExceptionClass}} on {{.Environment}}"},
+	})
+
+	api := &plugintest.API{}
+	api.On("LogInfo", "received webhook", "remote", mock.Anything).Return()
+	api.On("KVGet", pluginID+":"+KVKeyProjectChannelMappings).Return(rules, nil)
+	api.On("KVGet", pluginID+":"+KVKeyUserMappings).Return(nil, nil)
+	api.On("KVGet", pluginID+":"+KVKeyErrorPostPrefix+"proj-1:err-123").Return(nil, nil)
+	api.On("KVGet", pluginID+":"+KVKeyCardTemplates).Return(templates, nil)
+	api.On("KVGet", pluginID+":"+KVKeyHealth).Return(nil, nil)
+	api.On("CreatePost", mock.MatchedBy(func(post *model.Post) bool {
+		return post.Message == "📱 Crash on production" && formatter.CardTemplateID(post) == "mobile"
+	})).Return(&model.Post{Id: "post-1", ChannelId: channelID}, nil).Once()
+	api.On("KVSet", mock.Anything, mock.Anything).Return(nil)
+	api.On("KVGet", pluginID+":"+KVKeyActiveErrors).Return(nil, nil)
+	api.On("LogDebug", mock.Anything, mock.Anything, mock.Anything).Return().Maybe()
+
+	p := &Plugin{}
+	p.SetAPI(api)
+	p.kvNamespace = pluginID
+	p.configuration.Store(&Configuration{})
+
+	payload := webhookPayload{
+		Trigger: triggerInfo{Type: "firstException"},
+		Error:   &errorInfo{ErrorID: "err-123", ExceptionClass: "Crash", App: &appInfo{ReleaseStage: "production"}},
+		Project: &projectInfo{ID: "proj-1"},
+	}
+	body, _ := json.Marshal(payload)
+
+	req := httptest.NewRequest(http.MethodPost, "/webhook", bytes.NewReader(body))
+	rr := httptest.NewRecorder()
+
+	p.handleWebhook(rr, req)
+
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusAccepted, rr.Code, rr.Body.String())
+	}
+	api.AssertExpectations(t)
+}
+
+func TestHandleWebhookRoutesByConnection(t *testing.T) {
+	rules, _ := json.Marshal([]ChannelRule{
+		{ID: "web-rule", ProjectID: "proj-1", ChannelID: "web-channel"},
+		{ID: "mobile-rule", ConnectionID: "mobile", ProjectID: "proj-1", ChannelID: "mobile-channel"},
+	})
+
+	api := &plugintest.API{}
+	api.On("LogInfo", "received webhook", "remote", mock.Anything).Return()
+	api.On("KVGet", pluginID+":"+KVKeyProjectChannelMappings).Return(rules, nil)
+	api.On("KVGet", mock.Anything).Return(nil, nil)
+	api.On("CreatePost", mock.MatchedBy(func(post *model.Post) bool {
+		return post.ChannelId == "mobile-channel"
+	})).Return(&model.Post{Id: "post-1", ChannelId: "mobile-channel"}, nil).Once()
+	api.On("KVSet", pluginID+":"+KVKeyErrorPostPrefix+"proj-1:err-123", mock.MatchedBy(func(data []byte) bool {
+		var mapping ErrorPostMapping
+		return json.Unmarshal(data, &mapping) == nil && mapping.ConnectionID == "mobile"
+	})).Return(nil).Once()
+	api.On("KVSet", mock.Anything, mock.Anything).Return(nil)
+	api.On("LogDebug", mock.Anything, mock.Anything, mock.Anything).Return().Maybe()
+
+	p := &Plugin{}
+	p.SetAPI(api)
+	p.kvNamespace = pluginID
+	p.configuration.Store(&Configuration{
+		BugsnagAPIToken: "t-default",
+		WebhookToken:    "secret",
+		Connections:     `[{"id":"mobile","api_token":"t-mobile","webhook_token":"mobile-secret"}]`,
+	})
+
+	body, _ := json.Marshal(webhookPayload{
+		Trigger: triggerInfo{Type: "firstException"},
+		Error:   &errorInfo{ErrorID: "err-123", ExceptionClass: "Crash"},
+		Project: &projectInfo{ID: "proj-1"},
+	})
+	req := httptest.NewRequest(http.MethodPost, "/webhook?token=mobile-secret", bytes.NewReader(body))
+	rr := httptest.NewRecorder()
+
+	p.handleWebhook(rr, req)
+
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusAccepted, rr.Code, rr.Body.String())
+	}
+	api.AssertExpectations(t)
+}
+
+func TestWebhookConnection(t *testing.T) {
+	connections := `[{"id":"mobile","api_token":"t-mobile","webhook_token":"mobile-secret"},{"id":"web","api_token":"t-web"}]`
+	multi := Configuration{BugsnagAPIToken: "t-default", WebhookToken: "secret", Connections: connections}
+
 	tests := []struct {
-		name        string
-		cfg         Configuration
-		queryToken  string
-		headerToken string
-		wantErr     bool
+		name           string
+		cfg            Configuration
+		path           string
+		queryToken     string
+		headerToken    string
+		wantErr        bool
+		wantConnection string
 	}{
 		{
 			name:    "no token configured, no token provided",
@@ -183,11 +309,48 @@ func TestValidateWebhookToken(t *testing.T) {
 			queryToken: "fallback-secret",
 			wantErr:    false,
 		},
+		{
+			name:           "global token routes to the default connection",
+			cfg:            multi,
+			queryToken:     "secret",
+			wantConnection: connection.DefaultID,
+		},
+		{
+			name:           "connection token routes to its connection",
+			cfg:            multi,
+			queryToken:     "mobile-secret",
+			wantConnection: "mobile",
+		},
+		{
+			name:           "connection path with the global token",
+			cfg:            multi,
+			path:           "/webhook/web",
+			queryToken:     "secret",
+			wantConnection: "web",
+		},
+		{
+			name:           "connection path requires the connection's own token",
+			cfg:            multi,
+			path:           "/webhook/mobile",
+			queryToken:     "secret",
+			wantErr:        true,
+			wantConnection: "mobile",
+		},
+		{
+			name:       "unknown connection path",
+			cfg:        multi,
+			path:       "/webhook/desktop",
+			queryToken: "secret",
+			wantErr:    true,
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			url := "/webhook"
+			if tt.path != "" {
+				url = tt.path
+			}
 			if tt.queryToken != "" {
 				url += "?token=" + tt.queryToken
 			}
@@ -196,53 +359,199 @@ func TestValidateWebhookToken(t *testing.T) {
 				req.Header.Set("X-Bugsnag-Token", tt.headerToken)
 			}
 
-			err := validateWebhookToken(tt.cfg, req)
+			conn, err := webhookConnection(tt.cfg, req)
 			if (err != nil) != tt.wantErr {
-				t.Errorf("validateWebhookToken() error = %v, wantErr %v", err, tt.wantErr)
+				t.Errorf("webhookConnection() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if conn.ID != tt.wantConnection {
+				t.Errorf("webhookConnection() connection = %q, want %q", conn.ID, tt.wantConnection)
 			}
 		})
 	}
 }
 
-func TestBuildCardTitle(t *testing.T) {
-	tests := []struct {
-		name    string
-		payload webhookPayload
-		want    string
-	}{
-		{
-			name:    "with exception class only",
-			payload: webhookPayload{Error: &errorInfo{ExceptionClass: "NullReferenceException"}},
-			want:    ":rotating_light: **NullReferenceException**",
+func TestCardData(t *testing.T) {
+	payload := webhookPayload{
+		Trigger: triggerInfo{Type: "firstException", Message: "New error"},
+		Error: &errorInfo{
+			ErrorID:              "err-123",
+			ExceptionClass:       "NullReferenceException",
+			Message:              "Object reference not set",
+			Context:              "CheckoutController",
+			URL:                  "https://app.bugsnag.com/org/proj/errors/err-123",
+			Severity:             "error",
+			Status:               "open",
+			App:                  &appInfo{Version: "1.2.3", ReleaseStage: "production"},
+			AssignedCollaborator: &collaborator{ID: "bs-1", Email: "dev@example.com"},
 		},
-		{
-			name:    "with exception class and message",
-			payload: webhookPayload{Error: &errorInfo{ExceptionClass: "NullReferenceException", Message: "Object reference not set"}},
-			want:    ":rotating_light: **NullReferenceException**: Object reference not set",
+		Project: &projectInfo{ID: "proj-1", Name: "backend"},
+	}
+
+	got := cardData(payload, nil, nil)
+	want := formatter.ErrorData{
+		ID:             "err-123",
+		ProjectID:      "proj-1",
+		ProjectName:    "backend",
+		ExceptionClass: "NullReferenceException",
+		Message:        "Object reference not set",
+		Summary:        "New error",
+		Context:        "CheckoutController",
+		Status:         "open",
+		Environment:    "production",
+		Severity:       "error",
+		AppVersion:     "1.2.3",
+		AssigneeEmail:  "dev@example.com",
+		ErrorURL:       "https://app.bugsnag.com/org/proj/errors/err-123",
+	}
+
+	if got != want {
+		t.Fatalf("cardData() = %+v, want %+v", got, want)
+	}
+}
+
+func TestRewriteDashboardURLs(t *testing.T) {
+	clients, err := bugsnag.NewFactory(bugsnag.Settings{DashboardURL: "https://bugsnag.example.com"})
+	if err != nil {
+		t.Fatalf("NewFactory() error = %v", err)
+	}
+
+	payload := webhookPayload{
+		Error:   &errorInfo{URL: "https://app.bugsnag.com/org/proj/errors/err-123"},
+		Release: &releaseInfo{URL: "https://app.bugsnag.com/org/proj/releases/1"},
+	}
+	payload.rewriteDashboardURLs(clients)
+
+	if payload.Error.URL != "https://bugsnag.example.com/org/proj/errors/err-123" {
+		t.Errorf("unexpected error URL %s", payload.Error.URL)
+	}
+	if payload.Release.URL != "https://bugsnag.example.com/org/proj/releases/1" {
+		t.Errorf("unexpected release URL %s", payload.Release.URL)
+	}
+}
+
+func TestGetExceptions(t *testing.T) {
+	legacy := webhookPayload{Error: &errorInfo{
+		ExceptionClass: "RuntimeError",
+		Message:        "boom",
+		StackTrace:     []stackFrame{{File: "app.rb", LineNumber: float64(12), ColumnNumber: "4", InProject: true}},
+	}}
+
+	exceptions := toFormatterExceptions(legacy.getExceptions())
+	if len(exceptions) != 1 || exceptions[0].ErrorClass != "RuntimeError" {
+		t.Fatalf("unexpected exceptions: %+v", exceptions)
+	}
+	frame := exceptions[0].Stacktrace[0]
+	if frame.LineNumber != "12" || frame.ColumnNumber != "4" {
+		t.Fatalf("unexpected frame numbers: %+v", frame)
+	}
+
+	chained := webhookPayload{Error: &errorInfo{
+		Exceptions: []exceptionInfo{{ErrorClass: "Outer"}, {ErrorClass: "Inner"}},
+		StackTrace: []stackFrame{{File: "ignored.rb"}},
+	}}
+	if got := chained.getExceptions(); len(got) != 2 || got[1].ErrorClass != "Inner" {
+		t.Fatalf("expected full cause chain, got %+v", got)
+	}
+}
+
+func TestLinkFrames(t *testing.T) {
+	payload := webhookPayload{
+		Error: &errorInfo{
+			App: &appInfo{Version: "1.2.0"},
+			Exceptions: []exceptionInfo{{
+				ErrorClass: "Crash",
+				Stacktrace: []stackFrame{
+					{File: "/srv/app/main.go", LineNumber: float64(10), InProject: true},
+					{File: "/usr/lib/go/runtime.go", LineNumber: float64(5)},
+				},
+			}},
 		},
+		Release: &releaseInfo{SourceControl: &sourceControlInfo{Revision: "abc123"}},
+	}
+	repo := sourcelink.Repository{
+		Provider:     sourcelink.ProviderGitHub,
+		URL:          "https://github.com/acme/api",
+		PathPrefixes: []sourcelink.PathPrefix{{From: "/srv/app/"}},
+	}
+
+	exceptions := toFormatterExceptions(payload.getExceptions())
+	linkFrames(exceptions, repo, repo.Ref(payload.getRevision(), payload.getReleaseVersion()))
+
+	if got := exceptions[0].Stacktrace[0].URL; got != "https://github.com/acme/api/blob/abc123/main.go#L10" {
+		t.Fatalf("unexpected in-project frame URL: %q", got)
+	}
+	if got := exceptions[0].Stacktrace[1].URL; got != "" {
+		t.Fatalf("library frames must not be linked, got %q", got)
+	}
+}
+
+func TestRequestContext(t *testing.T) {
+	payload := webhookPayload{Error: &errorInfo{
+		RequestURL: "https://shop.example.com/checkout?token=secret",
+		Device:     &deviceInfo{Hostname: "web-3", OSName: "iOS", OSVersion: "17.1", BrowserName: "Safari"},
+		User:       &userInfo{ID: "u-42", Name: "Jane Doe", Email: "jane@example.com"},
+	}}
+
+	tests := []struct {
+		name   string
+		redact []string
+		want   formatter.RequestContext
+	}{
 		{
-			name:    "message only",
-			payload: webhookPayload{Error: &errorInfo{Message: "Something went wrong"}},
-			want:    ":rotating_light: Something went wrong",
+			name: "no redaction",
+			want: formatter.RequestContext{
+				URL:      "https://shop.example.com/checkout?token=secret",
+				Browser:  "Safari",
+				OS:       "iOS 17.1",
+				Hostname: "web-3",
+				User:     "Jane Doe <jane@example.com> (u-42)",
+			},
 		},
 		{
-			name:    "trigger message fallback",
-			payload: webhookPayload{Trigger: triggerInfo{Message: "New error"}},
-			want:    ":rotating_light: New error",
+			name:   "redact email and query",
+			redact: []string{"user_email", "url_query"},
+			want: formatter.RequestContext{
+				URL:      "https://shop.example.com/checkout?redacted",
+				Browser:  "Safari",
+				OS:       "iOS 17.1",
+				Hostname: "web-3",
+				User:     "Jane Doe (u-42)",
+			},
 		},
 		{
-			name:    "empty payload",
-			payload: webhookPayload{},
-			want:    ":rotating_light: Bugsnag error",
+			name:   "redact everything",
+			redact: []string{"url", "browser", "os", "hostname", "user_id", "user_name", "user_email"},
+			want: formatter.RequestContext{
+				URL:      redactedValue,
+				Browser:  redactedValue,
+				OS:       redactedValue,
+				Hostname: redactedValue,
+				User:     redactedValue,
+			},
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := buildCardTitle(tt.payload)
-			if got != tt.want {
-				t.Errorf("buildCardTitle() = %q, want %q", got, tt.want)
+			if got := requestContext(payload, tt.redact); got != tt.want {
+				t.Errorf("requestContext() = %+v, want %+v", got, tt.want)
 			}
 		})
 	}
 }
+
+func TestDeviceDetails(t *testing.T) {
+	payload := webhookPayload{Error: &errorInfo{
+		Device: &deviceInfo{Manufacturer: "Google", Model: "Pixel 8", OSName: "Android", OSVersion: "14"},
+	}}
+
+	if device, os := deviceDetails(payload, nil); device != "Google Pixel 8" || os != "Android 14" {
+		t.Errorf("deviceDetails() = %q, %q", device, os)
+	}
+	if device, os := deviceDetails(payload, []string{"os"}); device != "Google Pixel 8" || os != redactedValue {
+		t.Errorf("deviceDetails() with OS redacted = %q, %q", device, os)
+	}
+	if device, os := deviceDetails(webhookPayload{Error: &errorInfo{}}, nil); device != "" || os != "" {
+		t.Errorf("deviceDetails() without a device = %q, %q", device, os)
+	}
+}
@@ -2,6 +2,7 @@ import React, {useState, useEffect, useCallback} from 'react';
 
 interface ChannelRule {
     id: string;
+    connection_id?: string;
     project_id: string;
     project_name: string;
     channel_id: string;
@@ -10,6 +11,7 @@ interface ChannelRule {
 
 interface Channel { id: string; display_name: string; name: string; }
 interface Project { id: string; name: string; }
+interface Connection { id: string; name: string; }
 interface Props { id: string; value: string; onChange: (id: string, value: string) => void; setSaveNeeded: () => void; }
 
 const styles: {[key: string]: React.CSSProperties} = {
@@ -29,20 +31,21 @@ const ChannelMappings: React.FC<Props> = ({setSaveNeeded}) => {
     const [rules, setRules] = useState<ChannelRule[]>([]);
     const [projects, setProjects] = useState<Project[]>([]);
     const [channels, setChannels] = useState<Channel[]>([]);
+    const [connections, setConnections] = useState<Connection[]>([]);
     const [loading, setLoading] = useState(true);
     const [error, setError] = useState<string | null>(null);
-    const [newRule, setNewRule] = useState({projectId: '', channelId: ''});
+    const [newRule, setNewRule] = useState({connectionId: '', projectId: '', channelId: ''});
 
     const fetchData = useCallback(async () => {
         try {
             setLoading(true);
-            const [rulesRes, projectsRes, channelsRes] = await Promise.all([
+            const [rulesRes, connectionsRes, channelsRes] = await Promise.all([
                 fetch('/plugins/com.mattermost.bugsnag/api/v1/channel-rules'),
-                fetch('/plugins/com.mattermost.bugsnag/api/v1/projects'),
+                fetch('/plugins/com.mattermost.bugsnag/api/v1/connections'),
                 fetch('/api/v4/channels'),
             ]);
             if (rulesRes.ok) { const d = await rulesRes.json(); setRules(d.rules || []); }
-            if (projectsRes.ok) { const d = await projectsRes.json(); setProjects(d.projects || []); }
+            if (connectionsRes.ok) { const d = await connectionsRes.json(); setConnections(d.connections || []); }
             if (channelsRes.ok) { setChannels(await channelsRes.json() || []); }
             setError(null);
         } catch (e) { setError('Failed to load data'); }
@@ -51,6 +54,16 @@ const ChannelMappings: React.FC<Props> = ({setSaveNeeded}) => {
 
     useEffect(() => { fetchData(); }, [fetchData]);
 
+    useEffect(() => {
+        const query = newRule.connectionId ? `?connection_id=${encodeURIComponent(newRule.connectionId)}` : '';
+        fetch(`/plugins/com.mattermost.bugsnag/api/v1/projects${query}`).
+            then((res) => (res.ok ? res.json() : {projects: []})).
+            then((d) => setProjects(d.projects || [])).
+            catch(() => setProjects([]));
+    }, [newRule.connectionId]);
+
+    const connectionName = (id?: string) => connections.find((c) => c.id === (id || connections[0]?.id))?.name || id || '';
+
     const saveRules = async (updated: ChannelRule[]) => {
         const res = await fetch('/plugins/com.mattermost.bugsnag/api/v1/channel-rules', {
             method: 'POST', headers: {'Content-Type': 'application/json'}, body: JSON.stringify({rules: updated}),
@@ -63,10 +76,11 @@ const ChannelMappings: React.FC<Props> = ({setSaveNeeded}) => {
         const proj = projects.find((p) => p.id === newRule.projectId);
         const chan = channels.find((c) => c.id === newRule.channelId);
         await saveRules([...rules, {
-            id: `${Date.now()}`, project_id: newRule.projectId, project_name: proj?.name || newRule.projectId,
+            id: `${Date.now()}`, connection_id: newRule.connectionId || undefined,
+            project_id: newRule.projectId, project_name: proj?.name || newRule.projectId,
             channel_id: newRule.channelId, channel_name: chan?.display_name || chan?.name || newRule.channelId,
         }]);
-        setNewRule({projectId: '', channelId: ''});
+        setNewRule({...newRule, projectId: '', channelId: ''});
     };
 
     if (loading) return <div style={styles.container}>Loading...</div>;
@@ -75,6 +89,11 @@ const ChannelMappings: React.FC<Props> = ({setSaveNeeded}) => {
         <div style={styles.container}>
             {error && <div style={styles.error}>{error}</div>}
             <div style={styles.addForm}>
+                {connections.length > 1 && (
+                    <select style={styles.select} value={newRule.connectionId} onChange={(e) => setNewRule({...newRule, connectionId: e.target.value, projectId: ''})}>
+                        {connections.map((c, i) => <option key={c.id} value={i === 0 ? '' : c.id}>{c.name}</option>)}
+                    </select>
+                )}
                 <select style={styles.select} value={newRule.projectId} onChange={(e) => setNewRule({...newRule, projectId: e.target.value})}>
                     <option value="">Select Bugsnag Project</option>
                     {projects.map((p) => <option key={p.id} value={p.id}>{p.name}</option>)}
@@ -86,16 +105,17 @@ const ChannelMappings: React.FC<Props> = ({setSaveNeeded}) => {
                 <button style={styles.button} onClick={addRule}>Add Mapping</button>
             </div>
             <table style={styles.table}>
-                <thead><tr><th style={styles.th}>Bugsnag Project</th><th style={styles.th}>Mattermost Channel</th><th style={styles.th}>Actions</th></tr></thead>
+                <thead><tr>{connections.length > 1 && <th style={styles.th}>Connection</th>}<th style={styles.th}>Bugsnag Project</th><th style={styles.th}>Mattermost Channel</th><th style={styles.th}>Actions</th></tr></thead>
                 <tbody>
                     {rules.map((r) => (
                         <tr key={r.id}>
+                            {connections.length > 1 && <td style={styles.td}>{connectionName(r.connection_id)}</td>}
                             <td style={styles.td}>{r.project_name}</td>
                             <td style={styles.td}>{r.channel_name}</td>
                             <td style={styles.td}><button style={styles.deleteBtn} onClick={() => saveRules(rules.filter((x) => x.id !== r.id))}>Remove</button></td>
                         </tr>
                     ))}
-                    {rules.length === 0 && <tr><td colSpan={3} style={styles.empty}>No mappings configured</td></tr>}
+                    {rules.length === 0 && <tr><td colSpan={connections.length > 1 ? 4 : 3} style={styles.empty}>No mappings configured</td></tr>}
                 </tbody>
             </table>
         </div>
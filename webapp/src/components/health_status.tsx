@@ -0,0 +1,113 @@
+import React, {useState, useEffect, useCallback} from 'react';
+
+interface SyncStatus { at: string; duration: string; active_errors: number; failures: number; error?: string; }
+interface TokenHealth { connection?: string; name?: string; configured: boolean; valid: boolean; scopes: string[]; organization?: string; project_count: number; error?: string; }
+interface HealthReport {
+    status: string;
+    token: TokenHealth;
+    connections: TokenHealth[];
+    last_webhook: {[projectId: string]: string};
+    last_sync: SyncStatus | null;
+    queue_depth: number;
+    kv_sizes: {[key: string]: number};
+    problems: string[];
+}
+
+const styles: {[key: string]: React.CSSProperties} = {
+    container: {padding: '10px 0'},
+    error: {color: '#d24b4e', marginBottom: '10px'},
+    badge: {display: 'inline-block', padding: '2px 8px', borderRadius: '10px', color: '#fff', fontWeight: 600, marginRight: '10px'},
+    button: {padding: '4px 12px', borderRadius: '4px', border: '1px solid #166de0', backgroundColor: '#fff', color: '#166de0', cursor: 'pointer'},
+    section: {marginTop: '15px'},
+    heading: {fontWeight: 600, marginBottom: '5px'},
+    table: {width: '100%', borderCollapse: 'collapse'},
+    td: {padding: '6px 10px', borderBottom: '1px solid #eee'},
+    problem: {color: '#d24b4e'},
+    muted: {color: '#888'},
+};
+
+const formatTime = (value?: string) => (value ? new Date(value).toLocaleString() : 'never');
+
+const HealthStatus: React.FC = () => {
+    const [report, setReport] = useState<HealthReport | null>(null);
+    const [loading, setLoading] = useState(true);
+    const [error, setError] = useState<string | null>(null);
+
+    const fetchHealth = useCallback(async () => {
+        try {
+            setLoading(true);
+            const res = await fetch('/plugins/com.mattermost.bugsnag/api/v1/health');
+            if (!res.ok) { throw new Error(`HTTP ${res.status}`); }
+            setReport(await res.json());
+            setError(null);
+        } catch (e) { setError('Failed to load plugin health'); }
+        finally { setLoading(false); }
+    }, []);
+
+    useEffect(() => { fetchHealth(); }, [fetchHealth]);
+
+    if (loading) return <div style={styles.container}>Loading...</div>;
+    if (error || !report) return <div style={styles.container}><div style={styles.error}>{error}</div></div>;
+
+    const healthy = report.status === 'ok';
+    const projects = Object.keys(report.last_webhook || {});
+
+    return (
+        <div style={styles.container}>
+            <span style={{...styles.badge, backgroundColor: healthy ? '#3db887' : '#d24b4e'}}>{healthy ? 'Healthy' : 'Degraded'}</span>
+            <button style={styles.button} onClick={fetchHealth}>Refresh</button>
+
+            {report.problems.length > 0 && (
+                <div style={styles.section}>
+                    <div style={styles.heading}>Problems</div>
+                    <ul>{report.problems.map((p) => <li key={p} style={styles.problem}>{p}</li>)}</ul>
+                </div>
+            )}
+
+            <div style={styles.section}>
+                <div style={styles.heading}>Bugsnag API tokens</div>
+                {report.connections.length === 0 && <div style={styles.muted}>Not configured</div>}
+                {report.connections.map((token) => (
+                    <div key={token.connection}>
+                        {report.connections.length > 1 && <strong>{token.name}: </strong>}
+                        {token.valid ? (
+                            <span>Valid for {token.organization} ({token.project_count} projects) · scopes: {token.scopes.join(', ')}</span>
+                        ) : <span style={styles.problem}>{token.error}</span>}
+                    </div>
+                ))}
+            </div>
+
+            <div style={styles.section}>
+                <div style={styles.heading}>Last webhook per project</div>
+                <table style={styles.table}>
+                    <tbody>
+                        {projects.map((id) => <tr key={id}><td style={styles.td}>{id}</td><td style={styles.td}>{formatTime(report.last_webhook[id])}</td></tr>)}
+                        {projects.length === 0 && <tr><td style={{...styles.td, ...styles.muted}}>No webhooks received yet</td></tr>}
+                    </tbody>
+                </table>
+            </div>
+
+            <div style={styles.section}>
+                <div style={styles.heading}>Status sync</div>
+                {report.last_sync ? (
+                    <div>
+                        Last run {formatTime(report.last_sync.at)} ({report.last_sync.duration}) · {report.last_sync.active_errors} active errors · {report.last_sync.failures} failures
+                        {report.last_sync.error && <div style={styles.problem}>{report.last_sync.error}</div>}
+                    </div>
+                ) : <div style={styles.muted}>Not run yet</div>}
+                <div>Queue depth: {report.queue_depth}</div>
+            </div>
+
+            <div style={styles.section}>
+                <div style={styles.heading}>KV usage</div>
+                <table style={styles.table}>
+                    <tbody>
+                        {Object.entries(report.kv_sizes).map(([key, size]) => <tr key={key}><td style={styles.td}>{key}</td><td style={styles.td}>{size} bytes</td></tr>)}
+                    </tbody>
+                </table>
+            </div>
+        </div>
+    );
+};
+
+export default HealthStatus;
@@ -1,6 +1,7 @@
 import manifest from './manifest';
 import ChannelMappings from './components/channel_mappings';
 import UserMappings from './components/user_mappings';
+import HealthStatus from './components/health_status';
 
 // Plugin registry interface for Mattermost plugins
 interface PluginRegistry {
@@ -16,8 +17,9 @@ class Plugin {
         if (registry.registerAdminConsoleCustomSetting) {
             registry.registerAdminConsoleCustomSetting('ChannelMappings', ChannelMappings);
             registry.registerAdminConsoleCustomSetting('UserMappings', UserMappings);
+            registry.registerAdminConsoleCustomSetting('HealthStatus', HealthStatus);
             // eslint-disable-next-line no-console
-            console.log(`[${manifest.id}] Registered custom admin settings: ChannelMappings, UserMappings`);
+            console.log(`[${manifest.id}] Registered custom admin settings: ChannelMappings, UserMappings, HealthStatus`);
         } else {
             // eslint-disable-next-line no-console
             console.warn(`[${manifest.id}] registerAdminConsoleCustomSetting not available in registry`);